@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of accelerators which Beaker can't allocate. Only NVIDIA GPUs are
+// allocated to tasks and sessions, so requests for these are rejected rather
+// than silently given NVIDIA GPUs.
+var unsupportedAcceleratorType = regexp.MustCompile(`^(amd|mi\d+|instinct|tpu)`)
+
+// nodeAccelerator describes GPUs of one type installed on a node.
+type nodeAccelerator struct {
+	Type  string `yaml:"type" json:"type"`
+	Count int    `yaml:"count" json:"count"`
+}
+
+func (a nodeAccelerator) String() string {
+	return fmt.Sprintf("%s:%d", a.Type, a.Count)
+}
+
+// acceleratorRequest asks for a number of NVIDIA GPUs of a type, such as
+// "a100", or of any type if the type is "gpu".
+type acceleratorRequest struct {
+	Type  string
+	Count int
+}
+
+// parseAcceleratorFlag parses an accelerator written as <type>[:<count>].
+// The count defaults to 1.
+func parseAcceleratorFlag(s string) (*acceleratorRequest, error) {
+	parts := strings.SplitN(s, ":", 2)
+	request := &acceleratorRequest{Type: strings.ToLower(strings.TrimSpace(parts[0])), Count: 1}
+	if request.Type == "" {
+		return nil, fmt.Errorf("invalid accelerator %q; must be <type>[:<count>]", s)
+	}
+	if unsupportedAcceleratorType.MatchString(request.Type) {
+		return nil, fmt.Errorf("invalid accelerator %q; only NVIDIA GPUs are supported", s)
+	}
+	if len(parts) == 2 {
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid accelerator %q; count must be a positive integer", s)
+		}
+		request.Count = count
+	}
+	return request, nil
+}
+
+// matches returns whether a node's GPUs are of the requested type.
+func (r *acceleratorRequest) matches(a nodeAccelerator) bool {
+	return r.Type == "gpu" || strings.Contains(a.Type, r.Type)
+}
+
+// checkAccelerators returns an error unless a node has enough GPUs to satisfy
+// a request.
+func checkAccelerators(node string, available []nodeAccelerator, request *acceleratorRequest) error {
+	var found []string
+	for _, a := range available {
+		if request.matches(a) && a.Count >= request.Count {
+			return nil
+		}
+		found = append(found, a.String())
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("node %s has no accelerators", node)
+	}
+	return fmt.Errorf("node %s has no %d %s GPUs; it has %s",
+		node, request.Count, request.Type, strings.Join(found, ", "))
+}
+
+// parseNodeAccelerators parses GPUs declared as <type>:<count>, such as
+// "a100:8".
+func parseNodeAccelerators(flags []string) ([]nodeAccelerator, error) {
+	var accelerators []nodeAccelerator
+	for _, flag := range flags {
+		request, err := parseAcceleratorFlag(flag)
+		if err != nil {
+			return nil, err
+		}
+		accelerators = append(accelerators, nodeAccelerator{Type: request.Type, Count: request.Count})
+	}
+	return accelerators, nil
+}
+
+// detectAccelerators finds the NVIDIA GPUs installed on this machine. Types
+// are derived from product names, such as "a100-sxm4-40gb" for an A100.
+func detectAccelerators() ([]nodeAccelerator, error) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return nil, nil
+	}
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=name", "--format=csv,noheader").Output()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query NVIDIA GPUs: %w", commandError(err))
+	}
+
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			counts[acceleratorType(name)]++
+		}
+	}
+	var accelerators []nodeAccelerator
+	for t, count := range counts {
+		accelerators = append(accelerators, nodeAccelerator{Type: t, Count: count})
+	}
+	sort.Slice(accelerators, func(i, j int) bool {
+		return accelerators[i].Type < accelerators[j].Type
+	})
+	return accelerators, nil
+}
+
+// acceleratorType normalizes a product name, e.g. "NVIDIA A100-SXM4-40GB"
+// becomes "a100-sxm4-40gb".
+func acceleratorType(name string) string {
+	name = strings.ToLower(name)
+	for _, prefix := range []string{"nvidia ", "tesla "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	return strings.Join(strings.Fields(name), "-")
+}
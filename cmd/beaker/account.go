@@ -52,11 +52,11 @@ Are you sure want to generate a new token?`)
 			return nil
 		}
 
-		beakerConfig.UserToken = token
-		if err := config.WriteConfig(beakerConfig, config.GetFilePath()); err != nil {
+		if err := config.SaveUserToken(config.GetFilePath(), beakerConfig.Context(), beakerConfig.BeakerAddress, token); err != nil {
 			return err
 		}
-		fmt.Println("New token written to config")
+		beakerConfig.UserToken = token
+		fmt.Println("New token saved")
 		return nil
 	}
 	return cmd
@@ -64,26 +64,25 @@ Are you sure want to generate a new token?`)
 
 func newAccountListCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:    "list",
-		Short:  "List all accounts",
-		Args:   cobra.NoArgs,
-		Hidden: true,
+		Use:         "list",
+		Short:       "List all accounts",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{streamedFormats: ""},
+		Hidden:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var users []api.UserDetail
 			var cursor string
-			for {
+			return printPages(&users, func() (interface{}, bool, error) {
 				var page []api.UserDetail
 				var err error
 				page, cursor, err = beaker.ListUsers(ctx, cursor)
 				if err != nil {
-					return err
-				}
-				users = append(users, page...)
-				if cursor == "" {
-					break
+					return nil, false, err
 				}
-			}
-			return printUsers(users)
+				return page, cursor != "", nil
+			}, func() error {
+				return printUsers(users)
+			})
 		},
 	}
 }
@@ -115,6 +114,11 @@ func newAccountTokenCommand() *cobra.Command {
 	}
 }
 
+// newWhoAmICommand is a top-level shortcut for "account whoami".
+func newWhoAmICommand() *cobra.Command {
+	return newAccountWhoAmICommand()
+}
+
 func newAccountWhoAmICommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "whoami",
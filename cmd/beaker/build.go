@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ignorePattern is a single line of a .dockerignore file.
+type ignorePattern struct {
+	pattern string
+	exclude bool // Set for patterns beginning with "!".
+}
+
+// readDockerignore reads patterns from a build context's .dockerignore file.
+// A missing file means nothing is ignored.
+func readDockerignore(contextDir string) ([]ignorePattern, error) {
+	file, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.exclude = true
+			line = strings.TrimSpace(line[1:])
+		}
+		p.pattern = filepath.Clean(strings.TrimPrefix(line, "/"))
+		if _, err := filepath.Match(p.pattern, ""); err != nil {
+			return nil, errors.Errorf(".dockerignore: invalid pattern %q", line)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, errors.WithStack(scanner.Err())
+}
+
+// ignored returns whether a path relative to the build context is excluded.
+// As with Docker, later patterns override earlier ones and a pattern matching
+// a directory applies to everything within it.
+func ignored(patterns []ignorePattern, relPath string) bool {
+	var result bool
+	for _, p := range patterns {
+		for candidate := relPath; candidate != "." && candidate != "/"; candidate = filepath.Dir(candidate) {
+			if matched, _ := filepath.Match(p.pattern, candidate); matched {
+				result = !p.exclude
+				break
+			}
+		}
+	}
+	return result
+}
+
+// tarBuildContext streams a directory as a tar archive suitable for a Docker
+// build, skipping files excluded by .dockerignore. The Dockerfile and
+// .dockerignore are always included, as the daemon needs them.
+func tarBuildContext(contextDir, dockerfile string) (io.ReadCloser, error) {
+	patterns, err := readDockerignore(contextDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := filepath.Walk(contextDir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(contextDir, filePath)
+			if err != nil || relPath == "." {
+				return err
+			}
+			if relPath != dockerfile && relPath != ".dockerignore" && ignored(patterns, relPath) {
+				if info.IsDir() {
+					// Docker allows exceptions within ignored directories, so
+					// only skip directories which no exception could match.
+					for _, p := range patterns {
+						if p.exclude && strings.HasPrefix(p.pattern, relPath+string(filepath.Separator)) {
+							return nil
+						}
+					}
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			var link string
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(filePath); err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(relPath)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			file, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(tw, file)
+			return err
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(errors.WithStack(err))
+	}()
+	return pr, nil
+}
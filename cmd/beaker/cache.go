@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of objects kept in the local cache.
+const (
+	cacheManifests = "manifests"
+	cacheImages    = "images"
+	cacheResponses = "responses"
+)
+
+// Image names and descriptions may change after commit, so cached images are
+// refreshed periodically. Manifests of committed datasets never change.
+const imageCacheTTL = time.Hour
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache <command>",
+		Short: "Manage the local cache",
+		Long: `Manage the local cache
+
+Dataset manifests and images are cached to speed up repeated commands, and
+recently used experiments, sessions, and datasets are remembered for @last and
+@-N. If the cache_ttl setting is set, responses to reads from Beaker are also
+cached and reused for that long, such as:
+
+    beaker config set cache_ttl 30s
+
+Pass --no-cache to any command to ask Beaker for current data.`,
+	}
+	cmd.AddCommand(newCacheClearCommand())
+	return cmd
+}
+
+func newCacheClearCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear",
+		Short: "Remove cached manifests, images, responses, and recent history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Only known kinds are removed in case the cache directory is
+			// shared with anything else.
+			for _, kind := range []string{cacheManifests, cacheImages, cacheResponses, recentCacheKind} {
+				filePath, err := cachePath(kind, "")
+				if err != nil {
+					return err
+				}
+				dir := filepath.Dir(filePath)
+				if err := os.RemoveAll(dir); err != nil {
+					return err
+				}
+				if !quiet {
+					fmt.Println("Cleared cache " + dir)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// cachePath returns where an object is cached, keyed by its kind and ID.
+func cachePath(kind, id string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "beaker", kind, id+".json"), nil
+}
+
+// readCache reads a cached object into v. It returns false if the object isn't
+// cached or its entry is older than maxAge. A zero maxAge never expires.
+func readCache(kind, id string, maxAge time.Duration, v interface{}) bool {
+	filePath, err := cachePath(kind, id)
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil || (maxAge != 0 && time.Since(info.ModTime()) > maxAge) {
+		return false
+	}
+
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// writeCache stores an object in the cache. The cache is best-effort, so
+// failures are ignored.
+func writeCache(kind, id string, v interface{}) {
+	filePath, err := cachePath(kind, id)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so concurrent readers never see a
+	// partially written entry.
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// getImage gets an image, using the cache if the reference is the ID of a
+// committed image seen recently.
+func getImage(ref string) (*api.Image, error) {
+	var image api.Image
+	if readCache(cacheImages, ref, imageCacheTTL, &image) {
+		return &image, nil
+	}
+
+	info, err := beaker.Image(ref).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.ID == ref && !info.Committed.IsZero() {
+		writeCache(cacheImages, info.ID, info)
+	}
+	return info, nil
+}
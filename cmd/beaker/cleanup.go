@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// cleanupCategory is a kind of resource which may be cleaned up, along with
+// the candidates found in that category.
+type cleanupCategory struct {
+	name   string // Plural noun, e.g. "uncommitted datasets".
+	action string // Verb applied to each candidate, e.g. "Delete".
+	items  []cleanupItem
+	clean  func(id string) error
+}
+
+// cleanupItem is a resource which may be cleaned up.
+type cleanupItem struct {
+	Category string    `json:"category"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Created  time.Time `json:"created"`
+	Reason   string    `json:"reason"`
+}
+
+// Sessions are idle if, since --idle, they've written no output and they're
+// using less CPU than this and no GPU.
+const idleCPUPercent = 1.0
+
+func newCleanupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Find and remove your stale datasets, experiments, images, and sessions",
+		Long: `Find resources you created which are likely no longer needed:
+
+  - Uncommitted datasets created before --older-than
+  - Failed experiments created before --older-than
+  - Images created before --older-than which none of your experiments since
+    then have used
+  - Sessions on this machine's node which have written no output since --idle
+    and are using almost no CPU and no GPU
+
+By default the candidates are only listed. With --interactive, each category
+is listed in turn and you're asked to confirm deleting or canceling all of its
+candidates at once. Images may still be used by other users' experiments.
+
+A session's activity can only be checked on its node, so sessions on other
+nodes are skipped. Run cleanup on those nodes to check them.`,
+		Args: cobra.NoArgs,
+	}
+
+	var interactive bool
+	var olderThan string
+	var idle string
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Confirm cleaning up each category of resources")
+	cmd.Flags().StringVar(&olderThan, "older-than", "30d",
+		"Consider datasets, experiments, and images created before this date, time, or duration ago")
+	cmd.Flags().StringVar(&idle, "idle", "24h", "Consider sessions with no activity since this date, time, or duration ago")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		now := time.Now()
+		cutoff, err := parseTimeFlag(olderThan, now)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		idleSince, err := parseTimeFlag(idle, now)
+		if err != nil {
+			return fmt.Errorf("invalid --idle: %w", err)
+		}
+
+		user, err := beaker.WhoAmI(ctx)
+		if err != nil {
+			return err
+		}
+
+		categories, err := findCleanupCandidates(user.Identity, cutoff, idleSince)
+		if err != nil {
+			return err
+		}
+
+		if format != "" && format != formatTable {
+			if interactive {
+				return usageError{fmt.Errorf("--interactive can't be used with --format %s", format)}
+			}
+			return printCleanupCandidates(categories)
+		}
+
+		var found int
+		for _, category := range categories {
+			if len(category.items) == 0 {
+				continue
+			}
+			found += len(category.items)
+
+			fmt.Printf("%s (%d):\n", color.BlueString(category.name), len(category.items))
+			if err := printTableRow("ID", "NAME", "CREATED", "REASON"); err != nil {
+				return err
+			}
+			for _, item := range category.items {
+				if err := printTableRow(item.ID, item.Name, item.Created, item.Reason); err != nil {
+					return err
+				}
+			}
+			if err := tableOut.Flush(); err != nil {
+				return err
+			}
+
+			if !interactive {
+				fmt.Println()
+				continue
+			}
+			confirmed, err := confirm(fmt.Sprintf("\n%s %d %s?", category.action, len(category.items), category.name))
+			if err != nil {
+				return err
+			}
+			if confirmed {
+				for _, item := range category.items {
+					if err := category.clean(item.ID); err != nil {
+						return fmt.Errorf("couldn't clean up %s: %w", item.ID, err)
+					}
+				}
+				if !quiet {
+					fmt.Printf("Cleaned up %d %s\n", len(category.items), category.name)
+				}
+			}
+			fmt.Println()
+		}
+
+		switch {
+		case found == 0:
+			fmt.Println("Nothing to clean up.")
+		case !interactive:
+			fmt.Println("Run with --interactive to clean these up.")
+		}
+		return nil
+	}
+	return cmd
+}
+
+// printCleanupCandidates prints the candidates of every category as a single
+// list in a machine-readable format.
+func printCleanupCandidates(categories []cleanupCategory) error {
+	items := []cleanupItem{}
+	for _, category := range categories {
+		items = append(items, category.items...)
+	}
+
+	switch format {
+	case formatJSON:
+		return printJSON(items)
+	case formatYAML:
+		return printYAML(items)
+	case formatNDJSON:
+		return printNDJSON(items)
+	default:
+		if err := printTableRow("CATEGORY", "ID", "NAME", "CREATED", "REASON"); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := printTableRow(item.Category, item.ID, item.Name, item.Created, item.Reason); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// findCleanupCandidates finds a user's resources in each cleanup category.
+func findCleanupCandidates(user api.Identity, cutoff, idleSince time.Time) ([]cleanupCategory, error) {
+	datasets, err := uncommittedDatasets(user, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't search datasets: %w", err)
+	}
+
+	experiments, err := searchUserExperiments(user)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't search experiments: %w", err)
+	}
+	failed, usedImages := failedExperiments(experiments, cutoff)
+
+	images, err := unusedImages(user, cutoff, usedImages)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't search images: %w", err)
+	}
+
+	sessions, err := idleSessions(user, idleSince)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list sessions: %w", err)
+	}
+
+	categories := []cleanupCategory{
+		{
+			name:   "uncommitted datasets",
+			action: "Delete",
+			items:  datasets,
+			clean:  func(id string) error { return beaker.Dataset(id).Delete(ctx) },
+		},
+		{
+			name:   "failed experiments",
+			action: "Delete",
+			items:  failed,
+			clean:  func(id string) error { return beaker.Experiment(id).Delete(ctx) },
+		},
+		{
+			name:   "unused images",
+			action: "Delete",
+			items:  images,
+			clean:  func(id string) error { return beaker.Image(id).Delete(ctx) },
+		},
+		{
+			name:   "idle sessions",
+			action: "Cancel",
+			items:  sessions,
+			clean: func(id string) error {
+				_, err := beaker.Session(id).Patch(ctx, api.SessionPatch{
+					State: &api.ExecStatusUpdate{Canceled: true},
+				})
+				return err
+			},
+		},
+	}
+	for _, category := range categories {
+		for i := range category.items {
+			category.items[i].Category = category.name
+		}
+	}
+	return categories, nil
+}
+
+// uncommittedDatasets finds a user's datasets which were never committed.
+func uncommittedDatasets(user api.Identity, cutoff time.Time) ([]cleanupItem, error) {
+	var items []cleanupItem
+	for page := 0; ; page++ {
+		datasets, err := beaker.SearchDatasets(ctx, api.DatasetSearchOptions{
+			FilterClauses: []api.DatasetFilterClause{
+				{Field: api.DatasetAuthor, Operator: api.OpEqual, Value: user.Name},
+				{Field: api.DatasetCreated, Operator: api.OpLessThan, Value: cutoff},
+			},
+			IncludeUncommitted: true,
+		}, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(datasets) == 0 {
+			return items, nil
+		}
+		for _, dataset := range datasets {
+			if dataset.Author.ID != user.ID || !dataset.Committed.IsZero() || !dataset.Created.Before(cutoff) {
+				continue
+			}
+			items = append(items, cleanupItem{
+				ID:      dataset.ID,
+				Name:    dataset.Name,
+				Created: dataset.Created,
+				Reason:  "never committed",
+			})
+		}
+	}
+}
+
+// searchUserExperiments lists all of a user's experiments.
+func searchUserExperiments(user api.Identity) ([]api.Experiment, error) {
+	var experiments []api.Experiment
+	for page := 0; ; page++ {
+		results, err := beaker.SearchExperiments(ctx, api.ExperimentSearchOptions{
+			FilterClauses: []api.ExperimentFilterClause{
+				{Field: api.ExperimentAuthor, Operator: api.OpEqual, Value: user.Name},
+			},
+		}, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return experiments, nil
+		}
+		for _, experiment := range results {
+			if experiment.Author.ID == user.ID {
+				experiments = append(experiments, experiment)
+			}
+		}
+	}
+}
+
+// failedExperiments finds experiments created before the cutoff with a failed
+// execution and none still in progress. It also returns the images used by
+// experiments created since the cutoff.
+func failedExperiments(experiments []api.Experiment, cutoff time.Time) ([]cleanupItem, map[string]bool) {
+	var items []cleanupItem
+	used := make(map[string]bool)
+	for _, experiment := range experiments {
+		if !experiment.Created.Before(cutoff) {
+			for _, execution := range experiment.Executions {
+				if image := execution.Spec.Image.Beaker; image != "" {
+					used[image] = true
+				}
+			}
+			continue
+		}
+
+		var failed, finished int
+		for _, execution := range experiment.Executions {
+			switch executionStatus(execution.State) {
+			case "failed":
+				failed++
+				finished++
+			case "succeeded":
+				finished++
+			}
+		}
+		if failed == 0 || finished < len(experiment.Executions) {
+			continue
+		}
+		items = append(items, cleanupItem{
+			ID:      experiment.ID,
+			Name:    experiment.Name,
+			Created: experiment.Created,
+			Reason:  fmt.Sprintf("%d of %d task(s) failed", failed, len(experiment.Executions)),
+		})
+	}
+	return items, used
+}
+
+// unusedImages finds a user's images created before the cutoff which aren't
+// in the set of used images, keyed by ID or full name.
+func unusedImages(user api.Identity, cutoff time.Time, used map[string]bool) ([]cleanupItem, error) {
+	var items []cleanupItem
+	for page := 0; ; page++ {
+		images, err := beaker.SearchImages(ctx, api.ImageSearchOptions{
+			FilterClauses: []api.ImageFilterClause{
+				{Field: api.ImageAuthor, Operator: api.OpEqual, Value: user.Name},
+				{Field: api.ImageCreated, Operator: api.OpLessThan, Value: cutoff},
+			},
+		}, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) == 0 {
+			return items, nil
+		}
+		for _, image := range images {
+			if image.Author.ID != user.ID || !image.Created.Before(cutoff) ||
+				used[image.ID] || (image.FullName != "" && used[image.FullName]) {
+				continue
+			}
+			items = append(items, cleanupItem{
+				ID:      image.ID,
+				Name:    image.Name,
+				Created: image.Created,
+				Reason:  "not used since " + cutoff.Format("2006-01-02"),
+			})
+		}
+	}
+}
+
+// idleSessions finds a user's sessions on this machine's node which started
+// before idleSince and haven't been active since. Sessions which haven't started
+// yet are still waiting for a node and aren't idle.
+func idleSessions(user api.Identity, idleSince time.Time) ([]cleanupItem, error) {
+	finalized := false
+	sessions, err := beaker.ListSessions(ctx, &client.ListSessionOpts{Finalized: &finalized})
+	if err != nil {
+		return nil, err
+	}
+
+	// Without an executor on this machine, no session can be checked.
+	node, _ := getCurrentNode()
+
+	var items []cleanupItem
+	var skipped int
+	for _, session := range sessions {
+		started := session.State.Started
+		if session.Author.ID != user.ID || started == nil || !started.Before(idleSince) {
+			continue
+		}
+		if session.Node != node {
+			skipped++
+			continue
+		}
+
+		active, err := sessionActive(session, idleSince)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check activity of session %s: %w", session.ID, err)
+		}
+		if active {
+			continue
+		}
+		items = append(items, cleanupItem{
+			ID:      session.ID,
+			Name:    session.Name,
+			Created: session.State.Created,
+			Reason:  fmt.Sprintf("started %s; no output or usage since %s", formatTime(*started), formatTime(idleSince)),
+		})
+	}
+
+	if skipped != 0 && !quiet {
+		fmt.Fprintf(os.Stderr, "Skipped %d sessions on other nodes; run cleanup on their nodes to check them\n", skipped)
+	}
+	return items, nil
+}
+
+// sessionActive reports whether a session on this node has written output
+// since a time or is currently using CPU or GPU.
+func sessionActive(session api.Session, since time.Time) (bool, error) {
+	container, err := findRunningContainer(session.ID)
+	if err != nil {
+		return false, err
+	}
+	var output bool
+	if err := readSessionLogs(container, since, func(time.Time, string) bool {
+		output = true
+		return false
+	}); err != nil {
+		return false, err
+	}
+	if output {
+		return true, nil
+	}
+
+	var gpus []string
+	if session.Limits != nil {
+		gpus = session.Limits.GPUs
+	}
+	var busy bool
+	err = streamContainerStats(statsTarget{
+		kind:  "session",
+		id:    session.ID,
+		node:  session.Node,
+		label: sessionContainerLabel,
+		gpus:  gpus,
+	}, false, func(stats containerStats) error {
+		busy = stats.CPUPercent >= idleCPUPercent
+		for _, gpu := range stats.GPUs {
+			busy = busy || gpu.Utilization > 0
+		}
+		return nil
+	})
+	return busy, err
+}
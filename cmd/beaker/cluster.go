@@ -3,7 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
+	"io"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +13,7 @@ import (
 	"github.com/beaker/client/client"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 func newClusterCommand() *cobra.Command {
@@ -27,16 +28,74 @@ func newClusterCommand() *cobra.Command {
 	cmd.AddCommand(newClusterListCommand())
 	cmd.AddCommand(newClusterNodesCommand())
 	cmd.AddCommand(newClusterUpdateCommand())
+	cmd.AddCommand(newClusterUtilizationCommand())
 	return cmd
 }
 
+// clusterConfig describes a cluster's autoscaling settings, as read from a
+// file by 'cluster create' and 'cluster update'. Unset fields are left to flags
+// or their defaults.
+type clusterConfig struct {
+	MaxSize     *int               `yaml:"maxSize"`
+	Preemptible *bool              `yaml:"preemptible"`
+	Protected   *bool              `yaml:"protected"`
+	Node        *clusterNodeConfig `yaml:"node"`
+}
+
+// clusterNodeConfig describes the minimum shape of each node in a cluster.
+type clusterNodeConfig struct {
+	CPUs    float64 `yaml:"cpus"`
+	GPUs    int     `yaml:"gpus"`
+	GPUType string  `yaml:"gpuType"`
+	Memory  string  `yaml:"memory"`
+}
+
+// readClusterConfig reads a cluster config from a file, or from STDIN if the
+// path is "-".
+func readClusterConfig(configPath string) (*clusterConfig, error) {
+	r, err := openPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config clusterConfig
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid cluster config %s: %w", configPath, err)
+	}
+	if config.MaxSize != nil && *config.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid cluster config %s: maxSize must not be negative", configPath)
+	}
+	return &config, nil
+}
+
 func newClusterCreateCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "create <name>",
 		Short: "Create a new cluster",
-		Args:  cobra.ExactArgs(1),
+		Long: `Create a new cluster
+
+A cluster with a node shape autoscales between zero and --max-size nodes as
+tasks are queued. Nodes may exceed the requested shape to optimize cost.
+Clusters without a node shape don't autoscale; nodes join them by running an
+executor.
+
+Settings may also be read from a YAML file with --file. Flags override the file.
+
+    maxSize: 8
+    preemptible: true
+    node:
+      cpus: 7.5
+      gpus: 4
+      gpuType: v100
+      memory: 60GiB
+
+The cluster API has no minimum size or idle timeout, so neither can be set.`,
+		Args: cobra.ExactArgs(1),
 	}
 
+	var configPath string
 	var maxSize int
 	var preemptible bool
 	var protected bool
@@ -45,6 +104,7 @@ func newClusterCreateCommand() *cobra.Command {
 	var gpuType string
 	var memory string
 
+	cmd.Flags().StringVarP(&configPath, "file", "f", "", "YAML file of cluster settings, or \"-\" for STDIN")
 	cmd.Flags().IntVar(&maxSize, "max-size", 0, "Maximum number of nodes")
 	cmd.Flags().BoolVar(&preemptible, "preemptible", false, "Enable cheaper but more volatile nodes")
 	cmd.Flags().BoolVar(&protected, "protected", false, "Mark cluster as protected")
@@ -65,6 +125,40 @@ func newClusterCreateCommand() *cobra.Command {
 			return fmt.Errorf("cluster names must be fully scoped in the form %s", color.GreenString("account/cluster"))
 		}
 
+		if configPath != "" {
+			config, err := readClusterConfig(configPath)
+			if err != nil {
+				return err
+			}
+			flags := cmd.Flags()
+			if config.MaxSize != nil && !flags.Changed("max-size") {
+				maxSize = *config.MaxSize
+			}
+			if config.Preemptible != nil && !flags.Changed("preemptible") {
+				preemptible = *config.Preemptible
+			}
+			if config.Protected != nil && !flags.Changed("protected") {
+				protected = *config.Protected
+			}
+			if node := config.Node; node != nil {
+				if !flags.Changed("cpus") && !flags.Changed("cpu-count") {
+					cpuCount = node.CPUs
+				}
+				if !flags.Changed("gpus") && !flags.Changed("gpu-count") {
+					gpuCount = node.GPUs
+				}
+				if !flags.Changed("gpu-type") {
+					gpuType = node.GPUType
+				}
+				if !flags.Changed("memory") {
+					memory = node.Memory
+				}
+			}
+		}
+		if maxSize < 0 {
+			return errors.New("--max-size must not be negative")
+		}
+
 		var memorySize *bytefmt.Size
 		if memory != "" {
 			var err error
@@ -107,7 +201,7 @@ func newClusterCreateCommand() *cobra.Command {
 			select {
 			case <-ctx.Done():
 				fmt.Println(" canceled")
-				os.Exit(1)
+				return ctx.Err()
 
 			case <-ticker.C:
 				cluster, err = beaker.Cluster(cluster.ID).Get(ctx)
@@ -192,23 +286,31 @@ func newClusterGetCommand() *cobra.Command {
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var clusters []api.Cluster
+			var utilization []*clusterUtilization
 			for _, id := range args {
 				info, err := beaker.Cluster(id).Get(ctx)
 				if err != nil {
 					return err
 				}
 				clusters = append(clusters, *info)
+
+				util, err := getClusterUtilization(info.FullName, "")
+				if err != nil {
+					return err
+				}
+				utilization = append(utilization, util)
 			}
-			return printClusters(clusters)
+			return printClusterDetails(clusters, utilization)
 		},
 	}
 }
 
 func newClusterListCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "list <account>",
-		Short: "List clusters under an account",
-		Args:  cobra.ExactArgs(1),
+		Use:         "list <account>",
+		Short:       "List clusters under an account",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var cloud bool
@@ -223,16 +325,17 @@ func newClusterListCommand() *cobra.Command {
 
 		var clusters []api.Cluster
 		var cursor string
-		for {
+		return printPages(&clusters, func() (interface{}, bool, error) {
 			var page []api.Cluster
 			var err error
 			page, cursor, err = beaker.ListClusters(ctx, args[0], &client.ListClusterOptions{
 				Cursor: cursor,
 			})
 			if err != nil {
-				return err
+				return nil, false, err
 			}
 
+			var matched []api.Cluster
 			for _, cluster := range page {
 				if cloud {
 					if !cluster.Autoscale {
@@ -244,13 +347,12 @@ func newClusterListCommand() *cobra.Command {
 						continue
 					}
 				}
-				clusters = append(clusters, cluster)
-			}
-			if cursor == "" {
-				break
+				matched = append(matched, cluster)
 			}
-		}
-		return printClusters(clusters)
+			return matched, cursor != "", nil
+		}, func() error {
+			return printClusters(clusters)
+		})
 	}
 	return cmd
 }
@@ -274,13 +376,33 @@ func newClusterUpdateCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "update <cluster>",
 		Short: "Modify a cluster",
-		Args:  cobra.ExactArgs(1),
+		Long: `Modify a cluster
+
+Only a cluster's maximum size may be changed; create a new cluster to change
+its node shape or preemptibility. The size may also be read from a YAML file
+with --file, in the format used by 'beaker cluster create'.`,
+		Args: cobra.ExactArgs(1),
 	}
 
+	var configPath string
 	var maxSize int
+	cmd.Flags().StringVarP(&configPath, "file", "f", "", "YAML file of cluster settings, or \"-\" for STDIN")
 	cmd.Flags().IntVar(&maxSize, "max-size", -1, "Maximum number of nodes")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if configPath != "" {
+			config, err := readClusterConfig(configPath)
+			if err != nil {
+				return err
+			}
+			if config.Preemptible != nil || config.Protected != nil || config.Node != nil {
+				return fmt.Errorf("%s: only maxSize can be changed after a cluster is created", configPath)
+			}
+			if config.MaxSize != nil && !cmd.Flags().Changed("max-size") {
+				maxSize = *config.MaxSize
+			}
+		}
+
 		patch := api.ClusterPatch{}
 		if maxSize >= 0 {
 			patch.Capacity = &maxSize
@@ -301,3 +423,192 @@ func newClusterUpdateCommand() *cobra.Command {
 	}
 	return cmd
 }
+
+func newClusterUtilizationCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "utilization <cluster>",
+		Short: "Show free GPUs, CPUs, and memory on each node of a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			utilization, err := getClusterUtilization(args[0], "")
+			if err != nil {
+				return err
+			}
+			return printClusterUtilization(utilization)
+		},
+	}
+}
+
+// clusterUtilization describes the capacity of a cluster and what remains unallocated.
+type clusterUtilization struct {
+	Cluster string            `json:"cluster"`
+	Total   api.NodeResources `json:"total"`
+	Free    api.NodeResources `json:"free"`
+	Nodes   []nodeUtilization `json:"nodes"`
+}
+
+// nodeUtilization describes the capacity of a node and what remains unallocated.
+type nodeUtilization struct {
+	Node api.Node          `json:"node"`
+	Free api.NodeResources `json:"free"`
+
+	// Running work occupying the node's resources.
+	Executions []api.Execution `json:"executions"`
+	Sessions   []api.Session   `json:"sessions"`
+}
+
+// getClusterUtilization subtracts the resources of all running executions and
+// sessions from the capacity of each node in a cluster. The session with ID
+// excludeSession, if any, isn't counted, so a session waiting to be scheduled
+// doesn't take up its own capacity.
+func getClusterUtilization(cluster, excludeSession string) (*clusterUtilization, error) {
+	cl := beaker.Cluster(cluster)
+
+	nodes, err := cl.ListClusterNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list cluster nodes: %w", err)
+	}
+
+	nodesByID := make(map[string]*nodeUtilization, len(nodes))
+	utilization := &clusterUtilization{Cluster: cluster}
+	for _, node := range nodes {
+		utilization.Nodes = append(utilization.Nodes, newNodeUtilization(node))
+	}
+	for i := range utilization.Nodes {
+		nodesByID[utilization.Nodes[i].Node.ID] = &utilization.Nodes[i]
+	}
+
+	execs, err := cl.ListExecutions(ctx, &client.ExecutionFilters{
+		Scheduled: api.BoolPtr(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list cluster workloads: %w", err)
+	}
+
+	// Subtract each running execution from its node's capacity.
+	for _, exec := range execs {
+		node, ok := nodesByID[exec.Node]
+		if !ok || exec.State.Finalized != nil {
+			continue
+		}
+
+		node.addExecution(exec)
+	}
+
+	sessions, err := beaker.ListSessions(ctx, &client.ListSessionOpts{
+		Cluster:   api.StringPtr(cluster),
+		Finalized: api.BoolPtr(false),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list cluster sessions: %w", err)
+	}
+
+	// Subtract each running session from its node's capacity.
+	// TODO allenai/beaker-service#1426: This is duplicative of executions above.
+	for _, session := range sessions {
+		node, ok := nodesByID[session.Node]
+		if !ok || session.ID == excludeSession || session.Limits == nil {
+			// Ignore sessions which haven't fully scheduled yet, including
+			// the one being started.
+			continue
+		}
+
+		node.addSession(session)
+	}
+
+	// Cordoned nodes contribute to the cluster's capacity but have nothing free.
+	var totalMemory, freeMemory bytefmt.Size
+	for _, node := range utilization.Nodes {
+		if node.Node.Limits == nil {
+			continue
+		}
+
+		utilization.Total.CPUCount += node.Node.Limits.CPUCount
+		utilization.Total.GPUCount += node.Node.Limits.GPUCount
+		if node.Node.Limits.Memory != nil {
+			totalMemory.Add(*node.Node.Limits.Memory)
+		}
+
+		if node.Node.Cordoned != nil {
+			continue
+		}
+		utilization.Free.CPUCount += node.Free.CPUCount
+		utilization.Free.GPUCount += node.Free.GPUCount
+		if node.Free.Memory != nil {
+			freeMemory.Add(*node.Free.Memory)
+		}
+	}
+	utilization.Total.Memory = &totalMemory
+	utilization.Free.Memory = &freeMemory
+	return utilization, nil
+}
+
+// newNodeUtilization returns a node's utilization with nothing allocated.
+func newNodeUtilization(node api.Node) nodeUtilization {
+	nodeUtil := nodeUtilization{Node: node}
+	if node.Limits != nil {
+		nodeUtil.Free = *node.Limits
+		if node.Limits.Memory != nil {
+			memory := *node.Limits.Memory
+			nodeUtil.Free.Memory = &memory
+		}
+	}
+	return nodeUtil
+}
+
+// addExecution allocates a running execution's resources on the node.
+func (n *nodeUtilization) addExecution(exec api.Execution) {
+	n.Executions = append(n.Executions, exec)
+	subtractLimits(&n.Free, &exec.Limits)
+}
+
+// addSession allocates a running session's resources on the node.
+func (n *nodeUtilization) addSession(session api.Session) {
+	n.Sessions = append(n.Sessions, session)
+	subtractLimits(&n.Free, session.Limits)
+}
+
+// getNodeUtilization subtracts the resources of all running executions and
+// sessions from the capacity of a single node.
+func getNodeUtilization(nodeID string) (*nodeUtilization, error) {
+	node, err := beaker.Node(nodeID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	utilization := newNodeUtilization(*node)
+
+	execs, err := beaker.Node(node.ID).ListExecutions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list node executions: %w", err)
+	}
+	for _, exec := range execs.Data {
+		if exec.State.Scheduled == nil || exec.State.Finalized != nil {
+			continue
+		}
+		utilization.addExecution(exec)
+	}
+
+	sessions, err := beaker.ListSessions(ctx, &client.ListSessionOpts{
+		Node:      api.StringPtr(node.ID),
+		Finalized: api.BoolPtr(false),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list node sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.Limits == nil {
+			continue // Not fully scheduled yet.
+		}
+		utilization.addSession(session)
+	}
+	return &utilization, nil
+}
+
+// subtractLimits removes resources assigned to a process from available resources.
+func subtractLimits(available *api.NodeResources, limits *api.ResourceLimits) {
+	available.CPUCount -= limits.CPUCount
+	available.GPUCount -= len(limits.GPUs)
+	if available.Memory != nil && limits.Memory != nil {
+		available.Memory.Sub(*limits.Memory)
+	}
+}
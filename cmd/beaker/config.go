@@ -2,14 +2,17 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/allenai/beaker/config"
 	"github.com/fatih/color"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 const userTokenHelp = "Login on the Beaker website and follow the instructions to configure this Beaker CLI client."
@@ -19,13 +22,73 @@ func newConfigCommand() *cobra.Command {
 		Use:   "config <command>",
 		Short: "Manage Beaker configuration",
 	}
+	cmd.AddCommand(newConfigGetContextsCommand())
 	cmd.AddCommand(newConfigListCommand())
+	cmd.AddCommand(newConfigMigrateCommand())
 	cmd.AddCommand(newConfigSetCommand())
+	cmd.AddCommand(newConfigSetContextCommand())
 	cmd.AddCommand(newConfigTestCommand())
 	cmd.AddCommand(newConfigUnsetCommand())
+	cmd.AddCommand(newConfigUseContextCommand())
 	return cmd
 }
 
+// contextInfo describes a context for display.
+type contextInfo struct {
+	Name             string `json:"name"`
+	Address          string `json:"address"`
+	DefaultWorkspace string `json:"defaultWorkspace,omitempty"`
+	Current          bool   `json:"current"`
+}
+
+func newConfigGetContextsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get-contexts",
+		Short: "List the contexts in the config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileConfig, err := config.ReadConfigFromFile(config.GetFilePath())
+			if os.IsNotExist(err) {
+				fileConfig, err = &config.Config{}, nil
+			}
+			if err != nil {
+				return err
+			}
+
+			current := beakerConfig.Context()
+			if current == "" {
+				current = config.DefaultContext
+			}
+			address := fileConfig.BeakerAddress
+			if address == "" {
+				address = "(default)"
+			}
+			contexts := []contextInfo{{
+				Name:             config.DefaultContext,
+				Address:          address,
+				DefaultWorkspace: fileConfig.DefaultWorkspace,
+				Current:          current == config.DefaultContext,
+			}}
+
+			var names []string
+			for name := range fileConfig.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				profile := fileConfig.Profiles[name]
+				contexts = append(contexts, contextInfo{
+					Name:             name,
+					Address:          profile.BeakerAddress,
+					DefaultWorkspace: profile.DefaultWorkspace,
+					Current:          current == name,
+				})
+			}
+			return printContexts(contexts)
+		},
+	}
+}
+
 func newConfigListCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "list",
@@ -35,6 +98,10 @@ func newConfigListCommand() *cobra.Command {
 			t := reflect.TypeOf(*beakerConfig)
 			for i := 0; i < t.NumField(); i++ {
 				field := t.Field(i)
+				if field.Type.Kind() != reflect.String {
+					// Structured properties such as profiles can't be shown as a single value.
+					continue
+				}
 				propertyKey := field.Tag.Get("yaml")
 				value := reflect.ValueOf(beakerConfig).Elem().FieldByName(field.Name).String()
 				if value == "" {
@@ -42,11 +109,117 @@ func newConfigListCommand() *cobra.Command {
 				}
 				fmt.Printf("%s = %s\n", propertyKey, color.BlueString(value))
 			}
+
+			var resources []string
+			for resource := range beakerConfig.Columns {
+				resources = append(resources, resource)
+			}
+			sort.Strings(resources)
+			for _, resource := range resources {
+				fmt.Printf("%s%s = %s\n", columnsPropertyPrefix, resource, color.BlueString(beakerConfig.Columns[resource]))
+			}
+
+			var commands []string
+			for command := range beakerConfig.Defaults {
+				commands = append(commands, command)
+			}
+			sort.Strings(commands)
+			for _, command := range commands {
+				var flags []string
+				for flag := range beakerConfig.Defaults[command] {
+					flags = append(flags, flag)
+				}
+				sort.Strings(flags)
+				for _, flag := range flags {
+					values := strings.Join(beakerConfig.Defaults[command][flag], ", ")
+					fmt.Printf("defaults[%s].%s = %s\n", command, flag, color.BlueString(values))
+				}
+			}
 			return nil
 		},
 	}
 }
 
+func newConfigMigrateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Update the config file to the current format",
+		Long: `Update the config file to the current format
+
+Config files written by older versions of Beaker are migrated automatically
+when read, but the file itself is only rewritten by this command. Comments
+and ordering are preserved. Use --dry-run to print the migrated file instead.`,
+		Args: cobra.NoArgs,
+	}
+
+	var dryRun bool
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the migrated config without writing it")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		configFilePath := config.GetFilePath()
+		original, err := ioutil.ReadFile(configFilePath)
+		if err != nil {
+			return err
+		}
+
+		var doc yaml.Node
+		var header struct {
+			Version int `yaml:"version"`
+		}
+		if err := yaml.Unmarshal(original, &doc); err != nil {
+			return errors.Wrap(err, "failed to read config")
+		}
+		if err := doc.Decode(&header); err != nil {
+			return errors.Wrap(err, "failed to read config")
+		}
+
+		changes, err := config.Migrate(&doc)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 && header.Version == config.CurrentVersion {
+			fmt.Printf("%s is already up to date\n", configFilePath)
+			return nil
+		}
+
+		migrated, err := yaml.Marshal(&doc)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if dryRun {
+			fmt.Println()
+			_, err := os.Stdout.Write(migrated)
+			return err
+		}
+
+		if err := ioutil.WriteFile(configFilePath, migrated, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Migrated %s to version %d\n", configFilePath, config.CurrentVersion)
+		return nil
+	}
+	return cmd
+}
+
+// Prefix of properties which set a resource's default table columns, e.g.
+// "columns.experiments".
+const columnsPropertyPrefix = "columns."
+
+// currentPropertyKey returns the current name of a property which may have
+// been renamed, warning if it was.
+func currentPropertyKey(key string) string {
+	replacement, ok := config.ReplacementKey(key)
+	if !ok {
+		return key
+	}
+	fmt.Fprintf(os.Stderr, "%s %s is deprecated; use %s\n", color.YellowString("Warning:"), key, replacement)
+	return replacement
+}
+
 func newConfigSetCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "set <property> <value>",
@@ -63,11 +236,25 @@ func newConfigSetCommand() *cobra.Command {
 				}
 			}
 
+			property := currentPropertyKey(args[0])
+			if strings.HasPrefix(property, columnsPropertyPrefix) {
+				resource := strings.TrimPrefix(property, columnsPropertyPrefix)
+				value := strings.TrimSpace(args[1])
+				if resource == "" || value == "" {
+					return fmt.Errorf("usage: beaker config set %s<resource> <columns>", columnsPropertyPrefix)
+				}
+				if beakerCfg.Columns == nil {
+					beakerCfg.Columns = make(map[string]string)
+				}
+				beakerCfg.Columns[resource] = value
+				return config.WriteConfig(beakerCfg, configFilePath)
+			}
+
 			t := reflect.TypeOf(*beakerCfg)
 			found := false
 			for i := 0; i < t.NumField(); i++ {
 				field := t.Field(i)
-				if field.Tag.Get("yaml") == args[0] {
+				if field.Type.Kind() == reflect.String && field.Tag.Get("yaml") == property {
 					found = true
 					// The following code assumes all values are strings and will not work with non-string values.
 					reflect.ValueOf(beakerCfg).Elem().FieldByName(field.Name).SetString(strings.TrimSpace(args[1]))
@@ -82,6 +269,78 @@ func newConfigSetCommand() *cobra.Command {
 	}
 }
 
+func newConfigSetContextCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-context <name>",
+		Short: "Create or update a context",
+		Long: `Create or update a context
+
+A context is a named profile holding the address of a Beaker deployment, a
+token for it, and a default workspace. Switch to a context with 'beaker config
+use-context', or use one for a single command with the --context flag.
+
+Instead of passing --token, run 'beaker login --context <name>' to log in.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var address string
+	var token string
+	var workspace string
+	cmd.Flags().StringVar(&address, "address", "", "Address of the Beaker deployment")
+	cmd.Flags().StringVar(&token, "token", "", "User token for the deployment")
+	cmd.Flags().StringVar(&workspace, "workspace", "", "Default workspace")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if name == config.DefaultContext {
+			return fmt.Errorf("the %s context can't be changed; use 'beaker config set' instead", name)
+		}
+
+		configFilePath := config.GetFilePath()
+		fileConfig, err := config.ReadConfigFromFile(configFilePath)
+		if os.IsNotExist(err) {
+			fileConfig, err = &config.Config{Version: config.CurrentVersion}, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		profile, exists := fileConfig.Profiles[name]
+		if cmd.Flags().Changed("address") {
+			profile.BeakerAddress = strings.TrimSpace(address)
+		}
+		if cmd.Flags().Changed("workspace") {
+			profile.DefaultWorkspace = strings.TrimSpace(workspace)
+		}
+		if profile.BeakerAddress == "" {
+			return fmt.Errorf("context %q needs an address; pass the --address flag", name)
+		}
+		if fileConfig.Profiles == nil {
+			fileConfig.Profiles = map[string]config.Profile{}
+		}
+		fileConfig.Profiles[name] = profile
+		if err := config.WriteConfig(fileConfig, configFilePath); err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("token") {
+			if err := config.SaveUserToken(configFilePath, name, profile.BeakerAddress, strings.TrimSpace(token)); err != nil {
+				return err
+			}
+		}
+
+		if !quiet {
+			if exists {
+				fmt.Printf("Updated context %s\n", color.BlueString(name))
+			} else {
+				fmt.Printf("Created context %s\n", color.BlueString(name))
+			}
+		}
+		return nil
+	}
+	return cmd
+}
+
 func newConfigTestCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "test",
@@ -156,11 +415,22 @@ func newConfigUnsetCommand() *cobra.Command {
 				return err
 			}
 
+			property := currentPropertyKey(args[0])
+			if strings.HasPrefix(property, columnsPropertyPrefix) {
+				resource := strings.TrimPrefix(property, columnsPropertyPrefix)
+				if _, ok := beakerCfg.Columns[resource]; !ok {
+					return errors.New(fmt.Sprintf("Unknown config property: %q", args[0]))
+				}
+				delete(beakerCfg.Columns, resource)
+				fmt.Printf("Unset %s\n", property)
+				return config.WriteConfig(beakerCfg, configFilePath)
+			}
+
 			t := reflect.TypeOf(*beakerCfg)
 			found := false
 			for i := 0; i < t.NumField(); i++ {
 				field := t.Field(i)
-				if field.Tag.Get("yaml") == args[0] {
+				if field.Tag.Get("yaml") == property {
 					found = true
 					reflect.ValueOf(beakerCfg).Elem().FieldByName(field.Name).Set(reflect.Zero(field.Type))
 				}
@@ -169,9 +439,91 @@ func newConfigUnsetCommand() *cobra.Command {
 				return errors.New(fmt.Sprintf("Unknown config property: %q", args[0]))
 			}
 
-			fmt.Printf("Unset %s\n", args[0])
+			fmt.Printf("Unset %s\n", property)
 
 			return config.WriteConfig(beakerCfg, configFilePath)
 		},
 	}
 }
+
+func newConfigUseContextCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "use-context <name>",
+		Short: "Switch to a context",
+		Long: `Switch to a context
+
+Commands use the connection settings of the current context unless the
+--context flag is passed. Use the "default" context for the settings at the top
+of the config file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			configFilePath := config.GetFilePath()
+			fileConfig, err := config.ReadConfigFromFile(configFilePath)
+			if os.IsNotExist(err) {
+				fileConfig, err = &config.Config{Version: config.CurrentVersion}, nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if name == config.DefaultContext {
+				fileConfig.CurrentContext = ""
+			} else {
+				if _, ok := fileConfig.Profiles[name]; !ok {
+					return fmt.Errorf("context %q is not configured; create it with 'beaker config set-context'", name)
+				}
+				fileConfig.CurrentContext = name
+			}
+			if err := config.WriteConfig(fileConfig, configFilePath); err != nil {
+				return err
+			}
+
+			if !quiet {
+				fmt.Printf("Switched to context %s\n", color.BlueString(name))
+			}
+			return nil
+		},
+	}
+}
+
+// applyFlagDefaults sets flags which weren't given to their configured
+// defaults. Defaults for the command itself take precedence over those of its
+// parents, which take precedence over those for every command. Only flags
+// named for the command itself must exist.
+func applyFlagDefaults(cmd *cobra.Command, defaults map[string]map[string]config.FlagValues) error {
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	path := strings.Fields(cmd.CommandPath())[1:]
+	keys := []string{strings.Join(path, " ")}
+	for i := len(path) - 1; i > 0; i-- {
+		keys = append(keys, strings.Join(path[:i], " "))
+	}
+	keys = append(keys, "*")
+
+	for i, key := range keys {
+		for name, values := range defaults[key] {
+			flag := cmd.Flags().Lookup(name)
+			if flag == nil {
+				if i == 0 {
+					return fmt.Errorf("config defaults for %q: unknown flag --%s", key, name)
+				}
+				continue
+			}
+			if name == "context" {
+				return fmt.Errorf("config defaults for %q: --context can't have a default; use 'beaker config use-context'", key)
+			}
+			if flag.Changed {
+				continue
+			}
+			for _, value := range values {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					return fmt.Errorf("config defaults for %q: invalid value %q for --%s: %w", key, value, name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
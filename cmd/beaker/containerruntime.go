@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/beaker/runtime/docker"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// Container runtimes which sessions may be created and attached through.
+const (
+	runtimeDocker = "docker"
+	runtimePodman = "podman"
+)
+
+// Socket of Podman's Docker-compatible API when Podman runs as root, as it
+// does for the executor.
+const podmanSocket = "/run/podman/podman.sock"
+
+// containerRuntime is the runtime chosen with --runtime, if any.
+var containerRuntime string
+
+// selectContainerRuntime points the Docker client at the chosen runtime. The
+// runtime is taken from --runtime, then from the executor's config, and is
+// Docker by default.
+//
+// Podman serves a Docker-compatible API, so containers created through it are
+// still managed through the Docker runtime. An explicit DOCKER_HOST is kept.
+func selectContainerRuntime() error {
+	name := containerRuntime
+	if name == "" {
+		config, err := getExecutorConfig()
+		switch {
+		case err == nil:
+			name = config.Runtime
+		case !os.IsNotExist(err):
+			return fmt.Errorf("failed to read executor config: %w", err)
+		}
+	}
+
+	switch name {
+	case "", runtimeDocker:
+		return nil
+	case runtimePodman:
+		if os.Getenv("DOCKER_HOST") != "" {
+			return nil
+		}
+		return os.Setenv("DOCKER_HOST", runtimeDockerHost(name))
+	default:
+		return usageError{fmt.Errorf("invalid runtime %q; must be %q or %q",
+			name, runtimeDocker, runtimePodman)}
+	}
+}
+
+// runtimeDockerHost returns the DOCKER_HOST through which a runtime is
+// reached, or "" for Docker's default.
+func runtimeDockerHost(name string) string {
+	if name == runtimePodman {
+		return "unix://" + podmanSocket
+	}
+	return ""
+}
+
+// newContainerRuntime connects to the selected container runtime.
+func newContainerRuntime() (*docker.Runtime, error) {
+	if err := selectContainerRuntime(); err != nil {
+		return nil, err
+	}
+	return docker.NewRuntime()
+}
+
+// newContainerClient connects a Docker API client to the selected container
+// runtime.
+func newContainerClient() (*dockerclient.Client, error) {
+	if err := selectContainerRuntime(); err != nil {
+		return nil, err
+	}
+	return dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/allenai/bytefmt"
 	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
 	fileheapAPI "github.com/beaker/fileheap/api"
+	"github.com/beaker/fileheap/async"
 	"github.com/beaker/fileheap/cli"
 	fileheap "github.com/beaker/fileheap/client"
 	"github.com/fatih/color"
+	"github.com/moby/term"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -25,31 +32,127 @@ func newDatasetCommand() *cobra.Command {
 	cmd.AddCommand(newDatasetCommitCommand())
 	cmd.AddCommand(newDatasetCreateCommand())
 	cmd.AddCommand(newDatasetDeleteCommand())
+	cmd.AddCommand(newDatasetExportCommand())
 	cmd.AddCommand(newDatasetFetchCommand())
 	cmd.AddCommand(newDatasetGetCommand())
+	cmd.AddCommand(newDatasetImportCommand())
 	cmd.AddCommand(newDatasetLsCommand())
+	cmd.AddCommand(newDatasetMirrorCommand())
+	cmd.AddCommand(newDatasetMountCommand())
 	cmd.AddCommand(newDatasetRenameCommand())
 	cmd.AddCommand(newDatasetSizeCommand())
 	cmd.AddCommand(newDatasetStreamFileCommand())
+	cmd.AddCommand(newDatasetSyncCommand())
+	cmd.AddCommand(newDatasetUnmountCommand())
 	return cmd
 }
 
 func newDatasetCommitCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "commit <dataset>",
 		Short: "Commit a dataset preventing further modification",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := beaker.Dataset(args[0]).Commit(ctx); err != nil {
+		Long: `Commit a dataset preventing further modification
+
+Before committing, prints the dataset's file count, total size, and a digest of
+its manifest which changes if any file's path or contents change.
+
+With --source, the dataset is first checked against the local file or
+directory it was uploaded from and isn't committed unless their file counts and
+total sizes match. With --wait, uploads still landing are waited for: until the
+dataset matches --source or, without it, until its manifest stops changing.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var source string
+	var wait bool
+	var timeout time.Duration
+	var interval time.Duration
+	cmd.Flags().StringVar(&source, "source", "", "Local file or directory the dataset was uploaded from")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Wait for pending uploads to land before committing")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum time to wait, e.g. 10m. Waits indefinitely if unset")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "Time between checks of the dataset's files")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+
+		var expected *uploadEstimate
+		if source != "" {
+			var err error
+			if expected, err = scanUpload(source); err != nil {
+				return err
+			}
+		}
+
+		dataset := beaker.Dataset(args[0])
+		storage, _, err := dataset.Storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		ctx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		timedOut := func() error {
+			return fmt.Errorf("timed out after %v waiting for uploads to %s; not committed", timeout, args[0])
+		}
+
+		var summary *manifestSummary
+		for {
+			manifest, err := readManifest(ctx, storage, "")
+			if err != nil {
+				if ctx.Err() == context.DeadlineExceeded {
+					return timedOut()
+				}
 				return err
 			}
+			previous := summary
+			summary = summarizeManifest(manifest)
+			if !wait || summary.matches(expected) ||
+				(expected == nil && previous != nil && previous.Digest == summary.Digest) {
+				break
+			}
 
 			if !quiet {
-				fmt.Printf("Committed %s\n", color.BlueString(args[0]))
+				fmt.Fprintf(os.Stderr, "Waiting for uploads: %d files (%s) so far\n",
+					summary.Files, formatSize(bytefmt.New(summary.Bytes, bytefmt.Binary)))
 			}
-			return nil
-		},
+			select {
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					return timedOut()
+				}
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		if !quiet {
+			fmt.Printf("Files:  %d\n", summary.Files)
+			fmt.Printf("Size:   %s\n", formatSize(bytefmt.New(summary.Bytes, bytefmt.Binary)))
+			fmt.Printf("Digest: %s\n", summary.Digest)
+		}
+		if expected != nil && !summary.matches(expected) {
+			return fmt.Errorf("dataset has %d files (%s) but %s has %d files (%s); not committed",
+				summary.Files, bytefmt.New(summary.Bytes, bytefmt.Binary),
+				source, expected.Files, bytefmt.New(expected.Bytes, bytefmt.Binary))
+		}
+
+		if err := dataset.Commit(ctx); err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("Committed %s\n", color.BlueString(args[0]))
+		}
+		return nil
 	}
+	return cmd
 }
 
 func newDatasetCreateCommand() *cobra.Command {
@@ -63,6 +166,7 @@ func newDatasetCreateCommand() *cobra.Command {
 	var name string
 	var workspace string
 	var concurrency int
+	var estimate bool
 	cmd.Flags().StringVar(&description, "desc", "", "Assign a description to the dataset")
 	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the dataset")
 	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace where the dataset will be placed")
@@ -71,6 +175,7 @@ func newDatasetCreateCommand() *cobra.Command {
 		"concurrency",
 		defaultConcurrency,
 		"Number of files to upload at a time")
+	cmd.Flags().BoolVar(&estimate, "estimate", false, "Scan the source and estimate upload time without creating a dataset")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		source := args[0]
@@ -83,11 +188,26 @@ func newDatasetCreateCommand() *cobra.Command {
 			return errors.Errorf("%s is a %s", source, modeToString(info.Mode()))
 		}
 
-		workspace, err = ensureWorkspace(workspace)
+		workspace, err = resolveWorkspace(workspace, api.Write)
 		if err != nil {
 			return err
 		}
 
+		if estimate {
+			result, err := scanUpload(source)
+			if err != nil {
+				return err
+			}
+			if !quiet {
+				fmt.Fprintln(os.Stderr, "Measuring upload bandwidth...")
+			}
+			if result.BytesPerSecond, err = measureUploadRate(workspace, concurrency); err != nil {
+				return errors.WithMessage(err, "failed to measure bandwidth")
+			}
+			result.Duration = time.Duration(float64(result.Bytes) / result.BytesPerSecond * float64(time.Second)).Round(time.Second)
+			return printUploadEstimate(result)
+		}
+
 		spec := api.DatasetSpec{
 			Description: description,
 			Workspace:   workspace,
@@ -98,6 +218,7 @@ func newDatasetCreateCommand() *cobra.Command {
 		if err != nil {
 			return err
 		}
+		recordRecent(recentDataset, dataset.Ref())
 
 		if !quiet {
 			if name == "" {
@@ -114,12 +235,12 @@ func newDatasetCreateCommand() *cobra.Command {
 
 		if info.IsDir() {
 			var tracker cli.ProgressTracker = cli.NoTracker
-			if !quiet {
+			if !quiet || progressJSON {
 				files, bytes, err := cli.UploadStats(source)
 				if err != nil {
 					return err
 				}
-				tracker = cli.BoundedTracker(ctx, files, bytes)
+				tracker = newBoundedTracker("upload", "Uploaded", files, bytes)
 			}
 			if err := cli.Upload(ctx, source, storage, "", tracker, concurrency); err != nil {
 				return err
@@ -151,63 +272,319 @@ func newDatasetCreateCommand() *cobra.Command {
 }
 
 func newDatasetDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "delete <dataset>",
-		Short: "Permanently delete a dataset",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := beaker.Dataset(args[0]).Delete(ctx); err != nil {
+	cmd := &cobra.Command{
+		Use:   "delete [dataset...]",
+		Short: "Permanently delete datasets",
+		Long: `Permanently delete datasets
+
+Deletes the given datasets, or every dataset matching the filter flags. If both
+are given, only the given datasets which match the filters are deleted. Filters
+combine, so --older-than 90d --uncommitted selects datasets which are more than
+90 days old and were never committed. When searching, only your own datasets
+are deleted unless --author names someone else.
+
+Unless --yes is set, the datasets are listed and you're asked to confirm before
+more than one is deleted; --yes is required when stdin isn't a terminal. Use
+--dry-run to only list them.`,
+		Args: cobra.ArbitraryArgs,
+	}
+
+	var filter datasetFilter
+	var olderThan string
+	var dryRun bool
+	var yes bool
+	var concurrency int
+	cmd.Flags().StringVar(&filter.author, "author", "",
+		"Only delete datasets created by this user, or \"me\"; defaults to \"me\" without datasets given")
+	cmd.Flags().StringVar(&olderThan, "older-than", "",
+		"Only delete datasets created before this date, time, or duration ago, e.g. 90d")
+	cmd.Flags().StringVar(&filter.namePrefix, "name-prefix", "", "Only delete datasets whose names start with this prefix")
+	cmd.Flags().BoolVar(&filter.uncommitted, "uncommitted", false, "Only delete datasets which were never committed")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the datasets which would be deleted without deleting them")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Don't ask for confirmation")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of datasets to delete at once")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if olderThan != "" {
+			cutoff, err := parseTimeFlag(olderThan, time.Now())
+			if err != nil {
+				return fmt.Errorf("invalid --older-than: %w", err)
+			}
+			filter.createdBefore = cutoff
+		}
+		if len(args) == 0 && filter.empty() {
+			return usageError{fmt.Errorf("pass datasets to delete or at least one filter")}
+		}
+		if concurrency < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+		if len(args) == 0 && filter.author == "" {
+			// Never search every user's datasets for deletion.
+			filter.author = "me"
+		}
+		if filter.author == "me" {
+			user, err := beaker.WhoAmI(ctx)
+			if err != nil {
 				return err
 			}
+			filter.author = user.Name
+		}
 
+		var datasets []api.Dataset
+		if len(args) != 0 {
+			datasets = make([]api.Dataset, len(args))
+			if err := forEachConcurrently(len(args), func(i int) error {
+				dataset, err := beaker.Dataset(args[i]).Get(ctx)
+				if err != nil {
+					return err
+				}
+				datasets[i] = *dataset
+				return nil
+			}); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if datasets, err = searchDatasets(filter); err != nil {
+				return fmt.Errorf("couldn't search datasets: %w", err)
+			}
+		}
+
+		var matched []api.Dataset
+		for _, dataset := range datasets {
+			if filter.match(dataset) {
+				matched = append(matched, dataset)
+			}
+		}
+		if len(matched) == 0 {
 			if !quiet {
-				fmt.Printf("Deleted %s\n", color.BlueString(args[0]))
+				fmt.Println("No datasets to delete.")
 			}
 			return nil
-		},
+		}
+
+		if dryRun || (!yes && len(matched) > 1) {
+			if err := printDatasets(matched); err != nil {
+				return err
+			}
+			if err := tableOut.Flush(); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return nil
+		}
+		if !yes && len(matched) > 1 {
+			fmt.Println()
+			ok, err := confirmOrFail(fmt.Sprintf("Permanently delete %d datasets?", len(matched)), "--yes")
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		return deleteDatasets(matched, concurrency)
+	}
+	return cmd
+}
+
+// datasetFilter selects datasets to delete. Unset fields match every dataset.
+type datasetFilter struct {
+	author        string
+	createdBefore time.Time
+	namePrefix    string
+	uncommitted   bool
+}
+
+func (f *datasetFilter) empty() bool {
+	return f.author == "" && f.createdBefore.IsZero() && f.namePrefix == "" && !f.uncommitted
+}
+
+func (f *datasetFilter) match(dataset api.Dataset) bool {
+	switch {
+	case f.author != "" && dataset.Author.Name != f.author:
+		return false
+	case !f.createdBefore.IsZero() && !dataset.Created.Before(f.createdBefore):
+		return false
+	case f.namePrefix != "" && !strings.HasPrefix(dataset.Name, f.namePrefix):
+		return false
+	case f.uncommitted && !dataset.Committed.IsZero():
+		return false
 	}
+	return true
+}
+
+// searchDatasets finds datasets which may match a filter. Search is narrowed
+// by the filter where Beaker supports it; callers must still match results.
+func searchDatasets(filter datasetFilter) ([]api.Dataset, error) {
+	var clauses []api.DatasetFilterClause
+	if filter.author != "" {
+		clauses = append(clauses, api.DatasetFilterClause{Field: api.DatasetAuthor, Operator: api.OpEqual, Value: filter.author})
+	}
+	if !filter.createdBefore.IsZero() {
+		clauses = append(clauses, api.DatasetFilterClause{Field: api.DatasetCreated, Operator: api.OpLessThan, Value: filter.createdBefore})
+	}
+	if filter.namePrefix != "" {
+		clauses = append(clauses, api.DatasetFilterClause{Field: api.DatasetName, Operator: api.OpContains, Value: filter.namePrefix})
+	}
+
+	var datasets []api.Dataset
+	for page := 0; ; page++ {
+		results, err := beaker.SearchDatasets(ctx, api.DatasetSearchOptions{
+			FilterClauses:      clauses,
+			IncludeUncommitted: true,
+		}, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return datasets, nil
+		}
+		datasets = append(datasets, results...)
+	}
+}
+
+// deleteDatasets deletes datasets concurrently, showing how many are done.
+// Every dataset is attempted even if some fail.
+func deleteDatasets(datasets []api.Dataset, concurrency int) error {
+	var deleted int64
+	errs := make([]error, len(datasets))
+	limiter := async.NewLimiter(concurrency)
+	for i := range datasets {
+		i := i
+		limiter.Go(func() {
+			if errs[i] = beaker.Dataset(datasets[i].ID).Delete(ctx); errs[i] == nil {
+				atomic.AddInt64(&deleted, 1)
+			}
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		limiter.Wait()
+		close(done)
+	}()
+	_, interactive := term.GetFdInfo(os.Stdout)
+	if !quiet && interactive {
+		ticker := time.NewTicker(terminalRefresh)
+		defer ticker.Stop()
+	wait:
+		for {
+			select {
+			case <-done:
+				fmt.Print("\r\033[2K")
+				break wait
+			case <-ticker.C:
+				fmt.Printf("\r\033[2KDeleted %d/%d datasets", atomic.LoadInt64(&deleted), len(datasets))
+			}
+		}
+	}
+	<-done
+
+	if len(datasets) == 1 {
+		if errs[0] != nil {
+			return errs[0]
+		}
+		if !quiet {
+			fmt.Printf("Deleted %s\n", color.BlueString(datasets[0].ID))
+		}
+		return nil
+	}
+
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s couldn't delete %s: %v\n", color.RedString("Error:"), datasets[i].ID, err)
+		}
+	}
+	if !quiet {
+		fmt.Printf("Deleted %d datasets\n", deleted)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d dataset(s) couldn't be deleted", failed)
+	}
+	return nil
 }
 
 func newDatasetFetchCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "fetch <dataset>",
 		Short: "Download a dataset",
-		Args:  cobra.ExactArgs(1),
+		Long: `Download a dataset
+
+Files are downloaded in parallel, up to --concurrency at a time, and each
+file which fails is retried on its own a few times. Files which already exist
+locally with matching content are skipped, so an interrupted fetch can be
+resumed by running it again.
+
+Every downloaded file is checked against the digest recorded when it was
+uploaded. Use --verify to re-check a previously downloaded copy without
+downloading anything.`,
+		Args: cobra.ExactArgs(1),
 	}
 
 	var outputPath string
-	var prefix string
+	var filter fileFilter
 	var concurrency int
+	var verify bool
 	cmd.Flags().StringVarP(&outputPath, "output", "o", ".", "Target path for fetched data")
-	cmd.Flags().StringVar(&prefix, "prefix", "", "Only download files that start with the given prefix")
+	cmd.Flags().StringVar(&filter.Prefix, "prefix", "", "Only download files that start with the given prefix")
+	cmd.Flags().StringArrayVar(&filter.Include, "include", nil, "Only download files matching a glob pattern; may be repeated")
+	cmd.Flags().StringArrayVar(&filter.Exclude, "exclude", nil, "Skip files matching a glob pattern; may be repeated")
 	cmd.Flags().IntVar(
 		&concurrency,
 		"concurrency",
 		defaultConcurrency,
 		"Number of files to download at a time")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Verify local files against the dataset instead of downloading")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		storage, _, err := beaker.Dataset(args[0]).Storage(ctx)
-		if err != nil {
+		if err := filter.validate(); err != nil {
 			return err
 		}
 
-		info, err := storage.Info(ctx)
+		storage, _, err := beaker.Dataset(args[0]).Storage(ctx)
 		if err != nil {
 			return err
 		}
 
+		if verify {
+			files, err := listFiles(storage, filter)
+			if err != nil {
+				return err
+			}
+
+			missing, modified, err := verifyFiles(files, outputPath)
+			if err != nil {
+				return err
+			}
+			for _, file := range missing {
+				fmt.Println(color.YellowString("missing: ") + file)
+			}
+			for _, file := range modified {
+				fmt.Println(color.RedString("corrupt: ") + file)
+			}
+			if bad := len(missing) + len(modified); bad != 0 {
+				return fmt.Errorf("%d of %d files failed verification", bad, len(files))
+			}
+			if !quiet {
+				fmt.Printf("Verified %d files in %s\n", len(files), color.GreenString(outputPath))
+			}
+			return nil
+		}
+
 		fmt.Printf("Downloading %s to %s\n",
 			color.CyanString(args[0]),
 			color.GreenString(outputPath))
 
-		var tracker cli.ProgressTracker
-		if info.Size != nil && info.Size.Final {
-			tracker = cli.BoundedTracker(ctx, info.Size.Files, info.Size.Bytes)
-		} else {
-			tracker = cli.UnboundedTracker(ctx)
+		// Manifests of committed datasets are cached for later fetches.
+		files, err := listFiles(storage, filter)
+		if err != nil {
+			return err
 		}
-		return cli.Download(ctx, storage, prefix, outputPath, tracker, concurrency)
+		return downloadFiles(storage, files, outputPath, concurrency)
 	}
 	return cmd
 }
@@ -219,14 +596,16 @@ func newDatasetGetCommand() *cobra.Command {
 		Short:   "Display detailed information about one or more datasets",
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var datasets []api.Dataset
-			for _, name := range args {
-				info, err := beaker.Dataset(name).Get(ctx)
+			datasets := make([]api.Dataset, len(args))
+			if err := forEachConcurrently(len(args), func(i int) error {
+				info, err := beaker.Dataset(args[i]).Get(ctx)
 				if err != nil {
 					return err
 				}
-
-				datasets = append(datasets, *info)
+				datasets[i] = *info
+				return nil
+			}); err != nil {
+				return err
 			}
 			return printDatasets(datasets)
 		},
@@ -234,58 +613,195 @@ func newDatasetGetCommand() *cobra.Command {
 }
 
 func newDatasetLsCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "ls <dataset> [prefix]",
 		Short: "List files in a dataset",
-		Args:  cobra.RangeArgs(1, 2),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			storage, _, err := beaker.Dataset(args[0]).Storage(ctx)
+		Long: `List files in a dataset
+
+With --tree, files are shown as a directory tree in which each directory's
+size and file count include everything beneath it. The tree is rooted at the
+directory containing the prefix, if any.`,
+		Args: cobra.RangeArgs(1, 2),
+	}
+
+	var tree bool
+	var depth int
+	var sortBy string
+	cmd.Flags().BoolVar(&tree, "tree", false, "Show files as a directory tree with cumulative sizes")
+	cmd.Flags().IntVar(&depth, "depth", 0, "Levels of the tree to show, or 0 for all; implies --tree")
+	cmd.Flags().StringVar(&sortBy, "sort", sortByName, "Sort files by name or size")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if depth < 0 {
+			return fmt.Errorf("invalid depth %d; must be non-negative", depth)
+		}
+		if err := validateFileSort(sortBy); err != nil {
+			return err
+		}
+
+		storage, _, err := beaker.Dataset(args[0]).Storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		var files []*fileheapAPI.FileInfo
+		var prefix string
+		if len(args) > 1 {
+			prefix = args[1]
+		}
+
+		iterator := storage.Files(ctx, &fileheap.FileIteratorOptions{Prefix: prefix})
+		for {
+			info, err := iterator.Next()
+			if err == fileheap.ErrDone {
+				break
+			}
 			if err != nil {
 				return err
 			}
+			files = append(files, info)
+		}
 
-			var files []*fileheapAPI.FileInfo
-			var prefix string
-			if len(args) > 1 {
-				prefix = args[1]
-			}
+		if tree || depth != 0 {
+			root := buildDatasetTree(files, prefix)
+			root.sort(sortBy)
+			root.prune(depth)
+			return printDatasetTree(root)
+		}
 
-			iterator := storage.Files(ctx, &fileheap.FileIteratorOptions{Prefix: prefix})
-			for {
-				info, err := iterator.Next()
-				if err == fileheap.ErrDone {
-					break
-				}
-				if err != nil {
-					return err
-				}
-				files = append(files, info)
+		sortFiles(files, sortBy)
+		switch format {
+		case formatJSON:
+			return printJSON(files)
+		case formatYAML:
+			return printYAML(files)
+		default:
+			if err := printTableRow(
+				"PATH",
+				"SIZE",
+				"UPDATED",
+			); err != nil {
+				return err
 			}
-
-			switch format {
-			case formatJSON:
-				return printJSON(files)
-			default:
+			for _, file := range files {
 				if err := printTableRow(
-					"PATH",
-					"SIZE",
-					"UPDATED",
+					file.Path,
+					bytefmt.New(file.Size, bytefmt.Binary),
+					file.Updated,
 				); err != nil {
 					return err
 				}
-				for _, file := range files {
-					if err := printTableRow(
-						file.Path,
-						bytefmt.New(file.Size, bytefmt.Binary),
-						file.Updated,
-					); err != nil {
-						return err
-					}
-				}
 			}
-			return nil
-		},
+		}
+		return nil
 	}
+	return cmd
+}
+
+func newDatasetMirrorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mirror <dataset>",
+		Short: "Copy a dataset to another Beaker deployment",
+		Long: `Copy a dataset to another Beaker deployment.
+
+The target deployment is selected by a named profile in the Beaker config:
+
+    profiles:
+      staging:
+        address: https://staging.beaker.org
+        user_token: <token>
+        default_workspace: <account>/<workspace>`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var profile string
+	var name string
+	var workspace string
+	var concurrency int
+	cmd.Flags().StringVar(&profile, "to-profile", "", "Profile of the deployment to copy the dataset to")
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the copied dataset")
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace where the copied dataset will be placed")
+	cmd.Flags().IntVar(
+		&concurrency,
+		"concurrency",
+		defaultConcurrency,
+		"Number of files to copy at a time")
+	_ = cmd.MarkFlagRequired("to-profile")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		targetConfig, err := beakerConfig.Profile(profile)
+		if err != nil {
+			return err
+		}
+		target, err := client.NewClient(targetConfig.BeakerAddress, targetConfig.UserToken)
+		if err != nil {
+			return err
+		}
+
+		if workspace == "" {
+			if workspace = targetConfig.DefaultWorkspace; workspace == "" {
+				return errors.Errorf("profile %q has no default workspace; pass the --workspace flag", profile)
+			}
+		}
+		if _, err := target.Workspace(workspace).Get(ctx); err != nil {
+			return errors.WithMessagef(err, "couldn't find workspace %q in profile %q", workspace, profile)
+		}
+
+		source := beaker.Dataset(args[0])
+		info, err := source.Get(ctx)
+		if err != nil {
+			return err
+		}
+		sourceStorage, _, err := source.Storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		files, err := listFiles(sourceStorage, fileFilter{})
+		if err != nil {
+			return err
+		}
+
+		dataset, err := target.CreateDataset(ctx, api.DatasetSpec{
+			Description: info.Description,
+			Workspace:   workspace,
+			FileHeap:    true,
+		}, name)
+		if err != nil {
+			return err
+		}
+		recordRecent(recentDataset, dataset.Ref())
+		targetStorage, _, err := dataset.Storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("Copying %s to %s in %s\n",
+				color.CyanString(args[0]),
+				color.CyanString(dataset.Ref()),
+				color.GreenString(targetConfig.BeakerAddress))
+		}
+
+		var totalBytes int64
+		for _, file := range files {
+			totalBytes += file.Size
+		}
+		tracker := newBoundedTracker("copy", "Copied", int64(len(files)), totalBytes)
+		if err := copyFiles(sourceStorage, targetStorage, files, tracker, concurrency); err != nil {
+			return err
+		}
+
+		if err := dataset.Commit(ctx); err != nil {
+			return errors.WithMessage(err, "failed to commit dataset")
+		}
+
+		if quiet {
+			fmt.Println(dataset.Ref())
+		}
+		return nil
+	}
+	return cmd
 }
 
 func newDatasetRenameCommand() *cobra.Command {
@@ -344,16 +860,21 @@ func newDatasetSizeCommand() *cobra.Command {
 				totalBytes += info.Size
 			}
 
+			type size struct {
+				Files int64 `json:"files"`
+				Bytes int64 `json:"bytes"`
+			}
 			switch format {
 			case formatJSON:
-				type size struct {
-					Files int64 `json:"files"`
-					Bytes int64 `json:"bytes"`
-				}
 				return printJSON(size{
 					Files: totalFiles,
 					Bytes: totalBytes,
 				})
+			case formatYAML:
+				return printYAML(size{
+					Files: totalFiles,
+					Bytes: totalBytes,
+				})
 			default:
 				if err := printTableRow(
 					"FILES",
@@ -427,3 +948,101 @@ func modeToString(mode os.FileMode) string {
 		return "file"
 	}
 }
+
+func newDatasetSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync <source> <dataset>",
+		Short: "Upload changes in a local directory to an uncommitted dataset",
+		Long: `Upload changes in a local directory to an uncommitted dataset
+
+Only files which are new or changed are uploaded. A file is assumed unchanged
+if its size matches and it hasn't been modified since it was uploaded;
+otherwise its contents are compared. Use --checksum to compare the contents of
+every file, and --delete to remove files from the dataset which don't exist
+locally.`,
+		Args: cobra.ExactArgs(2),
+	}
+
+	var checksum bool
+	var concurrency int
+	var deleteMissing bool
+	var dryRun bool
+	cmd.Flags().BoolVar(&checksum, "checksum", false, "Compare the contents of every file instead of modification times")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of files to upload at a time")
+	cmd.Flags().BoolVar(&deleteMissing, "delete", false, "Delete files from the dataset which don't exist locally")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print changes without making them")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		source := args[0]
+		if info, err := os.Stat(source); err != nil {
+			return errors.WithStack(err)
+		} else if !info.IsDir() {
+			return errors.Errorf("%s is not a directory", source)
+		}
+
+		storage, _, err := beaker.Dataset(args[1]).Storage(ctx)
+		if err != nil {
+			return err
+		}
+		info, err := storage.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if info.ReadOnly {
+			return errors.Errorf("dataset %s is committed and can't be changed", args[1])
+		}
+
+		manifest, err := listFiles(storage, fileFilter{})
+		if err != nil {
+			return err
+		}
+		plan, err := planSync(source, manifest, checksum)
+		if err != nil {
+			return err
+		}
+		if !deleteMissing {
+			plan.Delete = nil
+		}
+
+		if dryRun {
+			switch format {
+			case formatJSON:
+				return printJSON(plan)
+			case formatYAML:
+				return printYAML(plan)
+			default:
+				for _, file := range plan.Upload {
+					fmt.Printf("upload %s (%s)\n", file.Path, bytefmt.New(file.Size, bytefmt.Binary))
+				}
+				for _, p := range plan.Delete {
+					fmt.Printf("delete %s\n", p)
+				}
+				fmt.Printf("%d to upload, %d to delete, %d unchanged\n",
+					len(plan.Upload), len(plan.Delete), plan.Unchanged)
+				return nil
+			}
+		}
+
+		var tracker cli.ProgressTracker = cli.NoTracker
+		if len(plan.Upload) != 0 {
+			var bytes int64
+			for _, file := range plan.Upload {
+				bytes += file.Size
+			}
+			tracker = newBoundedTracker("upload", "Uploaded", int64(len(plan.Upload)), bytes)
+		}
+		if err := uploadFiles(storage, source, plan.Upload, tracker, concurrency); err != nil {
+			return err
+		}
+		if err := deleteFiles(storage, plan.Delete); err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("Uploaded %d files, deleted %d files, %d unchanged\n",
+				len(plan.Upload), len(plan.Delete), plan.Unchanged)
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	fileheapAPI "github.com/beaker/fileheap/api"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newDatasetExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <dataset> <url>",
+		Short: "Copy a dataset to S3 or GCS",
+		Long: `Copy a dataset to S3 or GCS
+
+Files are streamed from the dataset to objects under an s3:// or gs:// URL,
+keeping their paths, without being written to local disk. Up to --concurrency
+files are copied at a time, and large files are uploaded in parts.
+
+Objects which already exist with the same size are skipped, so an interrupted
+export can be resumed by running it again. Use --overwrite to copy every file.
+
+Objects are written with the AWS CLI or gsutil, which must be installed and
+have access to the bucket.`,
+		Args: cobra.ExactArgs(2),
+	}
+
+	var filter fileFilter
+	var concurrency int
+	var overwrite bool
+	cmd.Flags().StringVar(&filter.Prefix, "prefix", "", "Only export files that start with the given prefix")
+	cmd.Flags().StringArrayVar(&filter.Include, "include", nil, "Only export files matching a glob pattern; may be repeated")
+	cmd.Flags().StringArrayVar(&filter.Exclude, "exclude", nil, "Skip files matching a glob pattern; may be repeated")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of files to copy at a time")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Copy files even if objects of the same size exist")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if concurrency < 1 {
+			return errors.New("concurrency must be positive")
+		}
+		if err := filter.validate(); err != nil {
+			return err
+		}
+		target, err := parseObjectStoreURL(args[1])
+		if err != nil {
+			return err
+		}
+
+		storage, _, err := beaker.Dataset(args[0]).Storage(ctx)
+		if err != nil {
+			return err
+		}
+		files, err := listFiles(storage, filter)
+		if err != nil {
+			return err
+		}
+
+		if !overwrite {
+			existing, err := listObjects(target)
+			if err != nil {
+				return err
+			}
+			sizes := make(map[string]int64, len(existing))
+			for _, object := range existing {
+				sizes[object.Key] = object.Size
+			}
+
+			pending := files[:0]
+			for _, file := range files {
+				if size, ok := sizes[target.key(file.Path)]; !ok || size != file.Size {
+					pending = append(pending, file)
+				}
+			}
+			if skipped := len(files) - len(pending); skipped != 0 && !quiet {
+				fmt.Printf("Skipping %d files which were already exported\n", skipped)
+			}
+			files = pending
+		}
+
+		if !quiet {
+			fmt.Printf("Exporting %d files from %s to %s\n",
+				len(files), color.CyanString(args[0]), color.GreenString(args[1]))
+		}
+		return exportFiles(storage, target, files, concurrency)
+	}
+	return cmd
+}
+
+// key returns the key of the object to which a dataset file is exported.
+func (u *objectStoreURL) key(filePath string) string {
+	if u.Prefix == "" {
+		return filePath
+	}
+	return path.Join(u.Prefix, filePath)
+}
+
+// exportFiles copies files from a dataset with a pool of workers.
+func exportFiles(
+	storage *fileheap.DatasetRef,
+	target *objectStoreURL,
+	files []fileheapAPI.FileInfo,
+	concurrency int,
+) error {
+	transfers := make([]fileTransfer, len(files))
+	for i, info := range files {
+		info := info
+		objectURL := target.object(target.key(info.Path))
+		transfers[i] = fileTransfer{
+			name: info.Path,
+			size: info.Size,
+			copy: func(ctx context.Context, counter io.Writer) error {
+				r, err := storage.ReadFile(ctx, info.Path)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+
+				var upload *exec.Cmd
+				if target.Scheme == "s3" {
+					// The expected size lets the CLI choose parts large
+					// enough for big files.
+					upload = exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors",
+						"--expected-size", strconv.FormatInt(info.Size, 10), "-", objectURL)
+				} else {
+					upload = exec.CommandContext(ctx, "gsutil", "-q", "cp", "-", objectURL)
+				}
+				var stderr bytes.Buffer
+				upload.Stdin = io.TeeReader(r, counter)
+				upload.Stderr = &stderr
+				if err := upload.Run(); err != nil {
+					if msg := strings.TrimSpace(stderr.String()); msg != "" {
+						return errors.New(msg)
+					}
+					return err
+				}
+				return nil
+			},
+		}
+	}
+	return transferFiles("export", "Exported", transfers, concurrency)
+}
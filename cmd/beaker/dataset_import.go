@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/beaker/client/api"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// objectStoreURL locates objects in S3 or GCS by bucket and key prefix.
+type objectStoreURL struct {
+	Scheme string // "s3" or "gs"
+	Bucket string
+	Prefix string
+}
+
+func parseObjectStoreURL(s string) (*objectStoreURL, error) {
+	parts := strings.SplitN(s, "://", 2)
+	if len(parts) != 2 || (parts[0] != "s3" && parts[0] != "gs") {
+		return nil, fmt.Errorf("%q must be an s3:// or gs:// URL", s)
+	}
+	bucketAndPrefix := strings.SplitN(parts[1], "/", 2)
+	u := &objectStoreURL{Scheme: parts[0], Bucket: bucketAndPrefix[0]}
+	if u.Bucket == "" {
+		return nil, fmt.Errorf("%q has no bucket", s)
+	}
+	if len(bucketAndPrefix) == 2 {
+		u.Prefix = bucketAndPrefix[1]
+	}
+	return u, nil
+}
+
+func (u *objectStoreURL) String() string {
+	return u.Scheme + "://" + u.Bucket + "/" + u.Prefix
+}
+
+// object returns the URL of an object in the same bucket.
+func (u *objectStoreURL) object(key string) string {
+	return u.Scheme + "://" + u.Bucket + "/" + key
+}
+
+// datasetPath returns where an object is placed in a dataset. Keys are made
+// relative to the prefix's directory, so importing s3://bucket/data/ places
+// data/a/b at a/b while s3://bucket/data places it at data/a/b.
+func (u *objectStoreURL) datasetPath(key string) string {
+	dir := u.Prefix[:strings.LastIndex(u.Prefix, "/")+1]
+	return strings.TrimPrefix(key, dir)
+}
+
+// storedObject is an object listed from S3 or GCS.
+type storedObject struct {
+	Key  string
+	Size int64
+}
+
+// listObjects lists objects under a URL with the cloud provider's CLI, which
+// uses whatever credentials it's configured with.
+func listObjects(u *objectStoreURL) ([]storedObject, error) {
+	var objects []storedObject
+	switch u.Scheme {
+	case "s3":
+		out, err := exec.CommandContext(ctx, "aws", "s3api", "list-objects-v2",
+			"--bucket", u.Bucket,
+			"--prefix", u.Prefix,
+			"--query", "Contents[].{Key: Key, Size: Size}",
+			"--output", "json").Output()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list %s with the AWS CLI: %w", u, commandError(err))
+		}
+		// The query yields null if there are no objects.
+		if err := json.Unmarshal(out, &objects); err != nil {
+			return nil, fmt.Errorf("couldn't parse objects in %s: %w", u, err)
+		}
+
+	case "gs":
+		out, err := exec.CommandContext(ctx, "gsutil", "ls", "-l", u.String()+"**").Output()
+		if exitErr, ok := err.(*exec.ExitError); ok && bytes.Contains(exitErr.Stderr, []byte("matched no objects")) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list %s with gsutil: %w", u, commandError(err))
+		}
+		// Each object is listed as "<size> <time> gs://<bucket>/<key>",
+		// followed by a total. Keys may contain spaces, so everything from
+		// the URL on is the key.
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			line := scanner.Text()
+			i := strings.Index(line, "gs://")
+			if i == -1 {
+				continue
+			}
+			fields := strings.Fields(line[:i])
+			if len(fields) != 2 {
+				continue
+			}
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			objects = append(objects, storedObject{
+				Key:  strings.TrimPrefix(line[i:], u.object("")),
+				Size: size,
+			})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Skip placeholders which consoles create for empty directories.
+	filtered := objects[:0]
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, "/") {
+			filtered = append(filtered, object)
+		}
+	}
+	return filtered, nil
+}
+
+// streamObject returns a command which writes an object to its stdout.
+func streamObject(ctx context.Context, u *objectStoreURL, key string) *exec.Cmd {
+	if u.Scheme == "s3" {
+		return exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", u.object(key), "-")
+	}
+	return exec.CommandContext(ctx, "gsutil", "-q", "cp", u.object(key), "-")
+}
+
+func newDatasetImportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <url>",
+		Short: "Create a dataset from objects in S3 or GCS",
+		Long: `Create a dataset from objects in S3 or GCS
+
+Every object under an s3:// or gs:// URL is copied into a new dataset. Objects
+are streamed from the object store into the dataset without being written to
+local disk. Paths are kept relative to the URL's last directory, so
+s3://bucket/data/ imports the contents of data while s3://bucket/data imports
+data itself.
+
+Objects are read with the AWS CLI or gsutil, which must be installed and have
+access to the bucket.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var description string
+	var name string
+	var workspace string
+	var concurrency int
+	cmd.Flags().StringVar(&description, "desc", "", "Assign a description to the dataset")
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the dataset")
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace where the dataset will be placed")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of objects to copy at a time")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if concurrency < 1 {
+			return errors.New("concurrency must be positive")
+		}
+		source, err := parseObjectStoreURL(args[0])
+		if err != nil {
+			return err
+		}
+
+		workspace, err = resolveWorkspace(workspace, api.Write)
+		if err != nil {
+			return err
+		}
+
+		objects, err := listObjects(source)
+		if err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			return fmt.Errorf("no objects found under %s", args[0])
+		}
+
+		dataset, err := beaker.CreateDataset(ctx, api.DatasetSpec{
+			Description: description,
+			Workspace:   workspace,
+			FileHeap:    true,
+		}, name)
+		if err != nil {
+			return err
+		}
+		recordRecent(recentDataset, dataset.Ref())
+
+		if !quiet {
+			fmt.Printf("Importing %d objects from %s to %s\n",
+				len(objects), color.GreenString(args[0]), color.CyanString(dataset.Ref()))
+		}
+
+		storage, _, err := dataset.Storage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := importObjects(storage, source, objects, concurrency); err != nil {
+			return err
+		}
+
+		if err := dataset.Commit(ctx); err != nil {
+			return errors.WithMessage(err, "failed to commit dataset")
+		}
+		if quiet {
+			fmt.Println(dataset.Ref())
+		}
+		return nil
+	}
+	return cmd
+}
+
+// importObjects copies objects into a dataset with a pool of workers.
+func importObjects(
+	storage *fileheap.DatasetRef,
+	source *objectStoreURL,
+	objects []storedObject,
+	concurrency int,
+) error {
+	transfers := make([]fileTransfer, len(objects))
+	for i, object := range objects {
+		object := object
+		filePath := source.datasetPath(object.Key)
+		if filePath == "" || path.Clean(filePath) != filePath {
+			return fmt.Errorf("object %s can't be stored at %q", source.object(object.Key), filePath)
+		}
+
+		transfers[i] = fileTransfer{
+			name: source.object(object.Key),
+			size: object.Size,
+			copy: func(ctx context.Context, counter io.Writer) error {
+				stream := streamObject(ctx, source, object.Key)
+				var stderr bytes.Buffer
+				stream.Stderr = &stderr
+				stdout, err := stream.StdoutPipe()
+				if err != nil {
+					return err
+				}
+				if err := stream.Start(); err != nil {
+					return err
+				}
+
+				writeErr := storage.WriteFile(ctx, filePath, io.TeeReader(stdout, counter), object.Size)
+				// Drain the stream so the command can exit if the write failed.
+				_, _ = io.Copy(ioutil.Discard, stdout)
+				if err := stream.Wait(); err != nil {
+					if msg := strings.TrimSpace(stderr.String()); msg != "" {
+						return errors.New(msg)
+					}
+					return err
+				}
+				return writeErr
+			},
+		}
+	}
+	return transferFiles("import", "Imported", transfers, concurrency)
+}
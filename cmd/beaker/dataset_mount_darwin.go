@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newDatasetMountCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "mount <dataset> <mountpoint>",
+		Short: "Mount a dataset as a read-only filesystem",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("mounting datasets is not supported on Mac")
+		},
+	}
+}
+
+func newDatasetUnmountCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unmount <mountpoint>",
+		Short: "Unmount a dataset mounted with 'dataset mount'",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("mounting datasets is not supported on Mac")
+		},
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/allenai/bytefmt"
+	fileheapAPI "github.com/beaker/fileheap/api"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/fatih/color"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/spf13/cobra"
+)
+
+// Size of the ranges in which mounted files are read and cached.
+const mountBlockSize = 4 << 20
+
+func newDatasetMountCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mount <dataset> <mountpoint>",
+		Short: "Mount a dataset as a read-only filesystem",
+		Long: `Mount a dataset as a read-only filesystem with FUSE.
+
+Files are streamed from the dataset as they're read rather than downloaded up
+front, and recently read blocks are cached in memory. The command runs until
+the dataset is unmounted with 'beaker dataset unmount' or interrupted.
+
+Requires FUSE, e.g. the fuse package on Debian and Ubuntu.`,
+		Args: cobra.ExactArgs(2),
+	}
+
+	var cacheSize string
+	var allowOther bool
+	cmd.Flags().StringVar(&cacheSize, "cache-size", "256MiB", "Memory used to cache file contents")
+	cmd.Flags().BoolVar(&allowOther, "allow-other", false, "Allow other users to read the mount")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		capacity, err := bytefmt.Parse(cacheSize)
+		if err != nil {
+			return fmt.Errorf("invalid cache size %q: %w", cacheSize, err)
+		}
+
+		dataset, err := beaker.Dataset(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		storage, _, err := beaker.Dataset(dataset.ID).Storage(ctx)
+		if err != nil {
+			return err
+		}
+		files, err := listFiles(storage, fileFilter{})
+		if err != nil {
+			return err
+		}
+
+		mountpoint, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		root := &datasetRoot{
+			files:   files,
+			storage: storage,
+			cache:   newBlockCache(capacity.Int64()),
+		}
+		server, err := fs.Mount(mountpoint, root, &fs.Options{
+			MountOptions: fuse.MountOptions{
+				AllowOther: allowOther,
+				FsName:     "beaker:" + dataset.ID,
+				Name:       "beaker",
+				Options:    []string{"ro"},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("Mounted %s at %s. Unmount it with 'beaker dataset unmount %s'\n",
+				color.BlueString(dataset.ID), mountpoint, args[1])
+		}
+
+		// The context is canceled on interrupt.
+		go func() {
+			<-ctx.Done()
+			_ = server.Unmount()
+		}()
+		server.Wait()
+		return nil
+	}
+	return cmd
+}
+
+func newDatasetUnmountCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unmount <mountpoint>",
+		Short: "Unmount a dataset mounted with 'dataset mount'",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mountpoint, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+
+			// Unprivileged users unmount FUSE filesystems with fusermount.
+			command := exec.CommandContext(ctx, "fusermount", "-u", mountpoint)
+			if _, err := exec.LookPath("fusermount"); err != nil {
+				command = exec.CommandContext(ctx, "umount", mountpoint)
+			}
+			if out, err := command.CombinedOutput(); err != nil {
+				return fmt.Errorf("couldn't unmount %s: %s", mountpoint, strings.TrimSpace(string(out)))
+			}
+
+			if !quiet {
+				fmt.Printf("Unmounted %s\n", mountpoint)
+			}
+			return nil
+		},
+	}
+}
+
+// datasetRoot is the root directory of a mounted dataset.
+type datasetRoot struct {
+	fs.Inode
+	files   []fileheapAPI.FileInfo
+	storage *fileheap.DatasetRef
+	cache   *blockCache
+}
+
+var _ = (fs.NodeOnAdder)((*datasetRoot)(nil))
+
+// OnAdd builds the whole tree from the dataset's manifest.
+func (r *datasetRoot) OnAdd(ctx context.Context) {
+	for _, info := range r.files {
+		dir, base := filepath.Split(info.Path)
+
+		parent := &r.Inode
+		for _, name := range strings.Split(dir, "/") {
+			if name == "" {
+				continue
+			}
+			child := parent.GetChild(name)
+			if child == nil {
+				child = parent.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: fuse.S_IFDIR})
+				parent.AddChild(name, child, true)
+			}
+			parent = child
+		}
+
+		file := &datasetFile{root: r, info: info}
+		parent.AddChild(base, parent.NewPersistentInode(ctx, file, fs.StableAttr{}), true)
+	}
+}
+
+// datasetFile is a file in a mounted dataset.
+type datasetFile struct {
+	fs.Inode
+	root *datasetRoot
+	info fileheapAPI.FileInfo
+}
+
+var (
+	_ = (fs.NodeGetattrer)((*datasetFile)(nil))
+	_ = (fs.NodeOpener)((*datasetFile)(nil))
+	_ = (fs.NodeReader)((*datasetFile)(nil))
+)
+
+func (f *datasetFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0444
+	out.Size = uint64(f.info.Size)
+	out.SetTimes(nil, &f.info.Updated, &f.info.Updated)
+	return fs.OK
+}
+
+func (f *datasetFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// Files never change, so the kernel may cache them too.
+	return nil, fuse.FOPEN_KEEP_CACHE, fs.OK
+}
+
+func (f *datasetFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	var n int
+	for n < len(dest) && off+int64(n) < f.info.Size {
+		pos := off + int64(n)
+		block, err := f.block(ctx, pos/mountBlockSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", f.info.Path, err)
+			return nil, syscall.EIO
+		}
+		n += copy(dest[n:], block[pos%mountBlockSize:])
+	}
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+// block returns a block of the file from the cache, reading it if necessary.
+func (f *datasetFile) block(ctx context.Context, index int64) ([]byte, error) {
+	key := blockKey{path: f.info.Path, index: index}
+	if block, ok := f.root.cache.get(key); ok {
+		return block, nil
+	}
+
+	offset := index * mountBlockSize
+	length := f.info.Size - offset
+	if length > mountBlockSize {
+		length = mountBlockSize
+	}
+	r, err := f.root.storage.ReadFileRange(ctx, f.info.Path, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, err
+	}
+	f.root.cache.add(key, block)
+	return block, nil
+}
+
+// blockKey identifies a block of a file.
+type blockKey struct {
+	path  string
+	index int64
+}
+
+// blockCache holds recently read blocks of files up to a total size, evicting
+// the least recently used blocks first. It's safe for concurrent use.
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	order    *list.List // Of *blockEntry, most recently used first.
+	entries  map[blockKey]*list.Element
+}
+
+type blockEntry struct {
+	key  blockKey
+	data []byte
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[blockKey]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key blockKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*blockEntry).data, true
+}
+
+func (c *blockCache) add(key blockKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&blockEntry{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.capacity && c.order.Len() > 1 {
+		oldest := c.order.Back()
+		entry := c.order.Remove(oldest).(*blockEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beaker/client/api"
+)
+
+func TestDatasetFilterMatch(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	dataset := api.Dataset{
+		Name:      "preprocess-train",
+		Author:    api.Identity{Name: "alice"},
+		Created:   now.Add(-48 * time.Hour),
+		Committed: now.Add(-47 * time.Hour),
+	}
+	uncommitted := dataset
+	uncommitted.Committed = time.Time{}
+
+	tests := []struct {
+		name    string
+		filter  datasetFilter
+		dataset api.Dataset
+		want    bool
+	}{
+		{name: "empty", dataset: dataset, want: true},
+		{name: "author", filter: datasetFilter{author: "alice"}, dataset: dataset, want: true},
+		{name: "other author", filter: datasetFilter{author: "bob"}, dataset: dataset},
+		{name: "created before", filter: datasetFilter{createdBefore: now}, dataset: dataset, want: true},
+		{name: "created at cutoff", filter: datasetFilter{createdBefore: dataset.Created}, dataset: dataset},
+		{name: "created after", filter: datasetFilter{createdBefore: now.Add(-72 * time.Hour)}, dataset: dataset},
+		{name: "prefix", filter: datasetFilter{namePrefix: "preprocess-"}, dataset: dataset, want: true},
+		{name: "other prefix", filter: datasetFilter{namePrefix: "train-"}, dataset: dataset},
+		{name: "uncommitted", filter: datasetFilter{uncommitted: true}, dataset: uncommitted, want: true},
+		{name: "committed", filter: datasetFilter{uncommitted: true}, dataset: dataset},
+		{
+			name:    "all",
+			filter:  datasetFilter{author: "alice", createdBefore: now, namePrefix: "pre", uncommitted: true},
+			dataset: uncommitted,
+			want:    true,
+		},
+		{
+			name:    "all but one",
+			filter:  datasetFilter{author: "bob", createdBefore: now, namePrefix: "pre", uncommitted: true},
+			dataset: uncommitted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.dataset); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	fileheapAPI "github.com/beaker/fileheap/api"
+)
+
+// Orders in which dataset files can be listed.
+const (
+	sortByName = "name"
+	sortBySize = "size"
+)
+
+// datasetTree is a file or directory in a dataset. A directory's size and
+// file count include everything beneath it.
+type datasetTree struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path"`
+	Size     int64          `json:"size"`
+	Files    int64          `json:"files"`
+	Children []*datasetTree `json:"children,omitempty"`
+
+	dir bool
+}
+
+// buildDatasetTree arranges files into a tree rooted at the directory
+// containing prefix, or the dataset's root if there's no prefix.
+func buildDatasetTree(files []*fileheapAPI.FileInfo, prefix string) *datasetTree {
+	rootPath := prefix[:strings.LastIndex(prefix, "/")+1]
+	root := &datasetTree{Name: rootPath, Path: rootPath, dir: true}
+	if root.Name == "" {
+		root.Name = "."
+	}
+
+	dirs := map[string]*datasetTree{rootPath: root}
+	for _, file := range files {
+		parent := root
+		parts := strings.Split(strings.TrimPrefix(file.Path, rootPath), "/")
+		dirPath := rootPath
+		for _, name := range parts[:len(parts)-1] {
+			dirPath += name + "/"
+			dir, ok := dirs[dirPath]
+			if !ok {
+				dir = &datasetTree{Name: name + "/", Path: dirPath, dir: true}
+				dirs[dirPath] = dir
+				parent.Children = append(parent.Children, dir)
+			}
+			parent = dir
+		}
+		parent.Children = append(parent.Children, &datasetTree{
+			Name:  parts[len(parts)-1],
+			Path:  file.Path,
+			Size:  file.Size,
+			Files: 1,
+		})
+	}
+	root.total()
+	return root
+}
+
+// total sums the sizes and file counts of a directory's contents.
+func (t *datasetTree) total() {
+	if !t.dir {
+		return
+	}
+	t.Size, t.Files = 0, 0
+	for _, child := range t.Children {
+		child.total()
+		t.Size += child.Size
+		t.Files += child.Files
+	}
+}
+
+// sort orders the contents of each directory by name, or by descending size
+// with ties broken by name.
+func (t *datasetTree) sort(by string) {
+	sort.SliceStable(t.Children, func(i, j int) bool {
+		a, b := t.Children[i], t.Children[j]
+		if by == sortBySize && a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range t.Children {
+		child.sort(by)
+	}
+}
+
+// prune drops everything more than depth levels below the tree's root.
+// Directories at the limit keep their totals. Zero means no limit.
+func (t *datasetTree) prune(depth int) {
+	if depth == 0 {
+		return
+	}
+	if depth == 1 {
+		for _, child := range t.Children {
+			child.Children = nil
+		}
+		return
+	}
+	for _, child := range t.Children {
+		child.prune(depth - 1)
+	}
+}
+
+// sortFiles orders a flat list of files by path, or by descending size.
+func sortFiles(files []*fileheapAPI.FileInfo, by string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if by == sortBySize && files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Path < files[j].Path
+	})
+}
+
+// validateFileSort checks the value of a --sort flag.
+func validateFileSort(by string) error {
+	switch by {
+	case sortByName, sortBySize:
+		return nil
+	default:
+		return fmt.Errorf("invalid sort %q; must be %q or %q", by, sortByName, sortBySize)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// doctorCheck is the outcome of one check of the session environment.
+type doctorCheck struct {
+	Name    string
+	Message string
+
+	// Problem is set if the check failed, along with a suggested fix.
+	Problem bool
+	Fix     string
+}
+
+func newSessionDoctorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that this machine can run sessions",
+		Long: `Check that this machine can run sessions
+
+Checks that the Docker daemon, or Podman if the executor uses it, is reachable,
+that GPUs can be passed into containers, that Docker doesn't remap user
+namespaces, and that this machine's executor is registered with Beaker. A fix is suggested for each problem found.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checks := checkSessionEnvironment()
+
+			var problems int
+			for _, check := range checks {
+				status := color.GreenString("ok")
+				if check.Problem {
+					status = color.RedString("problem")
+					problems++
+				}
+				fmt.Printf("[%s] %s: %s\n", status, check.Name, check.Message)
+				if check.Problem && check.Fix != "" {
+					fmt.Printf("    Fix: %s\n", check.Fix)
+				}
+			}
+
+			if problems != 0 {
+				return fmt.Errorf("found %d problem(s)", problems)
+			}
+			return nil
+		},
+	}
+}
+
+// checkSessionEnvironment runs all checks. Checks which depend on the Docker
+// daemon are skipped if it can't be reached.
+func checkSessionEnvironment() []doctorCheck {
+	daemon, info := checkDocker()
+	checks := []doctorCheck{daemon}
+	if info != nil {
+		checks = append(checks, checkGPURuntime(info), checkUserNamespaces(info))
+	}
+	return append(checks, checkExecutor())
+}
+
+func checkDocker() (doctorCheck, *types.Info) {
+	check := doctorCheck{Name: "Docker daemon"}
+
+	client, err := newContainerClient()
+	if err != nil {
+		check.Problem, check.Message = true, err.Error()
+		check.Fix = "Check the DOCKER_HOST environment variable"
+		return check, nil
+	}
+	defer client.Close()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		check.Problem, check.Message = true, err.Error()
+		if strings.Contains(err.Error(), "permission denied") {
+			check.Fix = `Add yourself to the docker group with "sudo usermod -aG docker $USER" and log in again`
+		} else {
+			check.Fix = `Start Docker with "sudo systemctl start docker"`
+		}
+		return check, nil
+	}
+
+	check.Message = "running version " + info.ServerVersion
+	return check, &info
+}
+
+func checkGPURuntime(info *types.Info) doctorCheck {
+	check := doctorCheck{Name: "GPU support"}
+
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		check.Message = "no NVIDIA driver found; sessions can't use GPUs on this machine"
+		return check
+	}
+
+	if _, ok := info.Runtimes["nvidia"]; ok {
+		check.Message = "the nvidia runtime is configured"
+		return check
+	}
+	// Docker 19.03 and later pass GPUs through the container toolkit's hook
+	// without a separate runtime.
+	if _, err := exec.LookPath("nvidia-container-runtime-hook"); err == nil {
+		check.Message = "the NVIDIA container toolkit is installed"
+		return check
+	}
+
+	check.Problem = true
+	check.Message = "Docker can't pass GPUs into containers"
+	check.Fix = "Install nvidia-container-toolkit and restart Docker"
+	return check
+}
+
+func checkUserNamespaces(info *types.Info) doctorCheck {
+	check := doctorCheck{Name: "User namespaces"}
+
+	opts, err := types.DecodeSecurityOptions(info.SecurityOptions)
+	if err != nil {
+		check.Problem, check.Message = true, err.Error()
+		return check
+	}
+	for _, opt := range opts {
+		if opt.Name == "userns" {
+			check.Problem = true
+			check.Message = "Docker remaps user IDs, so files in mounted home directories will have the wrong owner"
+			check.Fix = `Remove "userns-remap" from /etc/docker/daemon.json and restart Docker`
+			return check
+		}
+	}
+
+	check.Message = "user IDs are not remapped"
+	return check
+}
+
+func checkExecutor() doctorCheck {
+	check := doctorCheck{Name: "Executor"}
+
+	if _, err := getExecutorConfig(); err != nil {
+		check.Problem = true
+		if os.IsNotExist(err) {
+			check.Message = "no executor is configured on this machine"
+			check.Fix = `Install one with "beaker executor install", or run sessions elsewhere with "session create --node"`
+		} else {
+			check.Message = fmt.Sprintf("couldn't read %s: %v", executorConfigPath, err)
+		}
+		return check
+	}
+
+	nodeID, err := getCurrentNode()
+	if err != nil {
+		check.Problem = true
+		check.Message = "the executor hasn't registered this machine as a node"
+		check.Fix = "Check that the executor is running and can reach " + beaker.Address()
+		return check
+	}
+
+	node, err := beaker.Node(nodeID).Get(ctx)
+	if err != nil {
+		check.Problem = true
+		check.Message = fmt.Sprintf("couldn't get node %s: %v", nodeID, err)
+		check.Fix = "Check your connection to " + beaker.Address()
+		return check
+	}
+
+	switch {
+	case node.Expiry != nil:
+		check.Problem = true
+		check.Message = fmt.Sprintf("node %s has expired", node.ID)
+		check.Fix = "Restart the executor so it registers a new node"
+	case node.Cordoned != nil:
+		check.Problem = true
+		check.Message = fmt.Sprintf("node %s is cordoned, so new sessions won't start", node.ID)
+		check.Fix = fmt.Sprintf(`Uncordon it with "beaker node uncordon %s"`, node.ID)
+	default:
+		check.Message = fmt.Sprintf("registered as node %s", node.ID)
+	}
+	return check
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	fileheapAPI "github.com/beaker/fileheap/api"
+	"github.com/beaker/fileheap/async"
+	"github.com/beaker/fileheap/cli"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/pkg/errors"
+)
+
+// fileFilter selects files from a dataset's manifest.
+type fileFilter struct {
+	// Only files starting with the prefix are considered.
+	Prefix string
+
+	// If not empty, a file must match at least one include pattern.
+	Include []string
+
+	// A file matching any exclude pattern is skipped, even if it's included.
+	Exclude []string
+}
+
+// validate checks that all glob patterns are well formed.
+func (f *fileFilter) validate() error {
+	for _, patterns := range [][]string{f.Include, f.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hasGlobs returns whether the filter requires checking each file individually.
+func (f *fileFilter) hasGlobs() bool {
+	return len(f.Include) != 0 || len(f.Exclude) != 0
+}
+
+// match returns whether a file path passes the filter.
+func (f *fileFilter) match(filePath string) bool {
+	if !strings.HasPrefix(filePath, f.Prefix) {
+		return false
+	}
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, filePath) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchGlob(pattern, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a pattern against a file path. Patterns containing a slash
+// are matched against the full path; others are matched against the base name,
+// so "*.ckpt" matches checkpoints in any directory.
+func matchGlob(pattern, filePath string) bool {
+	var matched bool
+	if strings.Contains(pattern, "/") {
+		matched, _ = path.Match(strings.TrimPrefix(pattern, "/"), filePath)
+	} else {
+		matched, _ = path.Match(pattern, path.Base(filePath))
+	}
+	return matched
+}
+
+// listFiles returns all files in a dataset which pass a filter. Manifests of
+// read-only datasets are cached locally, keyed by dataset ID.
+func listFiles(storage *fileheap.DatasetRef, filter fileFilter) ([]fileheapAPI.FileInfo, error) {
+	var manifest []fileheapAPI.FileInfo
+	if !readCache(cacheManifests, storage.Name(), 0, &manifest) {
+		var readOnly bool
+		if filter.Prefix == "" {
+			// Only complete manifests are cached.
+			info, err := storage.Info(ctx)
+			if err != nil {
+				return nil, err
+			}
+			readOnly = info.ReadOnly
+		}
+
+		var err error
+		if manifest, err = readManifest(ctx, storage, filter.Prefix); err != nil {
+			return nil, err
+		}
+		if readOnly {
+			writeCache(cacheManifests, storage.Name(), manifest)
+		}
+	}
+
+	var files []fileheapAPI.FileInfo
+	for _, info := range manifest {
+		if filter.match(info.Path) {
+			files = append(files, info)
+		}
+	}
+	return files, nil
+}
+
+// readManifest lists all files in a dataset starting with a prefix.
+func readManifest(ctx context.Context, storage *fileheap.DatasetRef, prefix string) ([]fileheapAPI.FileInfo, error) {
+	var files []fileheapAPI.FileInfo
+	iterator := storage.Files(ctx, &fileheap.FileIteratorOptions{Prefix: prefix})
+	for {
+		info, err := iterator.Next()
+		if err == fileheap.ErrDone {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.URL = "" // Download URLs may expire.
+		files = append(files, *info)
+	}
+}
+
+// downloadFiles downloads a list of files from a dataset into targetPath.
+// Files which already exist locally with matching content are skipped.
+func downloadFiles(
+	storage *fileheap.DatasetRef,
+	files []fileheapAPI.FileInfo,
+	targetPath string,
+	concurrency int,
+) error {
+	if concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+
+	if err := os.MkdirAll(targetPath, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var pending []fileheapAPI.FileInfo
+	for _, info := range files {
+		unchanged, err := fileMatchesDigest(path.Join(targetPath, info.Path), &info)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			continue
+		}
+		pending = append(pending, info)
+	}
+	if skipped := len(files) - len(pending); skipped != 0 && !quiet {
+		fmt.Printf("Skipping %d files which are already up to date\n", skipped)
+	}
+
+	transfers := make([]fileTransfer, len(pending))
+	for i, info := range pending {
+		info := info
+		transfers[i] = fileTransfer{
+			name: info.Path,
+			size: info.Size,
+			copy: func(ctx context.Context, counter io.Writer) error {
+				r, err := storage.ReadFile(ctx, info.Path)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				return writeFile(path.Join(targetPath, info.Path), &info, io.TeeReader(r, counter))
+			},
+		}
+	}
+	return transferFiles("download", "Downloaded", transfers, concurrency)
+}
+
+// fileTransfer is a file copied by transferFiles.
+type fileTransfer struct {
+	name string // Named in errors, such as the file's path
+	size int64
+
+	// copy copies the file, writing its bytes to counter as they're written
+	// to the destination.
+	copy func(ctx context.Context, counter io.Writer) error
+}
+
+// transferFiles copies files with a pool of workers, showing their combined
+// progress as an operation such as "download" with a verb such as
+// "Downloaded". Files are copied independently, so large files don't hold up
+// small ones, and a file which fails is retried on its own a few times before
+// the transfer is abandoned.
+func transferFiles(operation, verb string, files []fileTransfer, concurrency int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var totalBytes int64
+	for _, file := range files {
+		totalBytes += file.size
+	}
+
+	tracker := newTransferProgress(operation, verb, int64(len(files)), totalBytes)
+	asyncErr := async.Error{}
+	limiter := async.NewLimiter(concurrency)
+	for _, file := range files {
+		if asyncErr.Err() != nil {
+			break
+		}
+
+		file := file
+		limiter.Go(func() {
+			tracker.Update(&cli.ProgressUpdate{FilesPending: 1, BytesPending: file.size})
+			err := transferFile(ctx, operation, file, tracker)
+			tracker.Update(&cli.ProgressUpdate{FilesPending: -1, BytesPending: -file.size})
+			if err != nil {
+				asyncErr.Report(err)
+				cancel()
+				return
+			}
+			tracker.Update(&cli.ProgressUpdate{FilesWritten: 1})
+		})
+	}
+	limiter.Wait()
+	if err := asyncErr.Err(); err != nil {
+		tracker.Close()
+		return err
+	}
+	return tracker.Close()
+}
+
+// transferFile copies a single file, retrying transient failures. Bytes are
+// reported to the tracker as they're written and taken back before a retry.
+func transferFile(ctx context.Context, operation string, file fileTransfer, tracker cli.ProgressTracker) error {
+	for attempt := 0; ; attempt++ {
+		counter := &progressCounter{tracker: tracker}
+		err := file.copy(ctx, counter)
+		if err == nil {
+			return nil
+		}
+
+		tracker.Update(&cli.ProgressUpdate{BytesWritten: -counter.written})
+		if attempt == transferRetries || ctx.Err() != nil {
+			return errors.WithMessagef(err, "failed to %s %s", operation, file.name)
+		}
+
+		timer := time.NewTimer(retryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithMessagef(err, "failed to %s %s", operation, file.name)
+		case <-timer.C:
+		}
+	}
+}
+
+// progressCounter reports bytes written through it to a progress tracker.
+type progressCounter struct {
+	tracker cli.ProgressTracker
+	written int64
+}
+
+func (c *progressCounter) Write(p []byte) (int, error) {
+	c.written += int64(len(p))
+	c.tracker.Update(&cli.ProgressUpdate{BytesWritten: int64(len(p))})
+	return len(p), nil
+}
+
+// copyFiles streams a list of files from one dataset to another.
+func copyFiles(
+	source *fileheap.DatasetRef,
+	target *fileheap.DatasetRef,
+	files []fileheapAPI.FileInfo,
+	tracker cli.ProgressTracker,
+	concurrency int,
+) error {
+	if concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	asyncErr := async.Error{}
+	limiter := async.NewLimiter(concurrency)
+	for _, info := range files {
+		if asyncErr.Err() != nil {
+			break
+		}
+
+		info := info
+		limiter.Go(func() {
+			tracker.Update(&cli.ProgressUpdate{FilesPending: 1, BytesPending: info.Size})
+			err := func() error {
+				r, err := source.ReadFile(ctx, info.Path)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				return target.WriteFile(ctx, info.Path, r, info.Size)
+			}()
+			if err != nil {
+				tracker.Update(&cli.ProgressUpdate{FilesPending: -1, BytesPending: -info.Size})
+				asyncErr.Report(errors.WithMessagef(err, "failed to copy %s", info.Path))
+				cancel()
+				return
+			}
+			tracker.Update(&cli.ProgressUpdate{
+				FilesWritten: 1,
+				FilesPending: -1,
+				BytesWritten: info.Size,
+				BytesPending: -info.Size,
+			})
+		})
+	}
+	limiter.Wait()
+	if err := asyncErr.Err(); err != nil {
+		return err
+	}
+	return tracker.Close()
+}
+
+// verifyFiles compares local copies of files in targetPath against their
+// digests. It returns the paths of files which are missing or differ.
+func verifyFiles(files []fileheapAPI.FileInfo, targetPath string) (missing, modified []string, err error) {
+	for _, info := range files {
+		filePath := path.Join(targetPath, info.Path)
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			missing = append(missing, info.Path)
+			continue
+		}
+
+		unchanged, err := fileMatchesDigest(filePath, &info)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !unchanged {
+			modified = append(modified, info.Path)
+		}
+	}
+	return missing, modified, nil
+}
+
+// writeFile writes a downloaded file to disk, verifying its digest. The file
+// is written under a temporary name and moved into place once verified, so an
+// interrupted download never leaves a partial file behind.
+func writeFile(filePath string, info *fileheapAPI.FileInfo, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.partial")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(file, io.TeeReader(r, hash)); err != nil {
+		return errors.WithStack(err)
+	}
+	if digest := hash.Sum(nil); !bytes.Equal(digest, info.Digest) {
+		return errors.Errorf(
+			"%s has incorrect digest: expected %s, got %s",
+			info.Path,
+			base64.StdEncoding.EncodeToString(info.Digest),
+			base64.StdEncoding.EncodeToString(digest))
+	}
+	if err := file.Chmod(0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(file.Name(), filePath))
+}
+
+// fileMatchesDigest returns whether a local file exists with the same content as a remote file.
+func fileMatchesDigest(filePath string, info *fileheapAPI.FileInfo) (bool, error) {
+	stat, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if stat.Size() != info.Size {
+		return false, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return bytes.Equal(hash.Sum(nil), info.Digest), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFileFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  fileFilter
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "valid", filter: fileFilter{Include: []string{"*.json", "logs/*"}, Exclude: []string{"*.tmp"}}},
+		{name: "invalid include", filter: fileFilter{Include: []string{"[a-"}}, wantErr: true},
+		{name: "invalid exclude", filter: fileFilter{Exclude: []string{"[a-"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.validate()
+			if tt.wantErr && err == nil {
+				t.Error("expected an error")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileFilterValidateKeepsPatterns(t *testing.T) {
+	// Include has spare capacity that appending Exclude would write into.
+	include := make([]string, 1, 2)
+	include[0] = "*.json"
+	filter := fileFilter{Include: include, Exclude: []string{"*.tmp"}}
+	if err := filter.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if spare := include[:2][1]; spare != "" {
+		t.Errorf("validate wrote %q past the include patterns", spare)
+	}
+}
+
+func TestFileFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter fileFilter
+		path   string
+		want   bool
+	}{
+		{name: "empty", path: "model/weights.bin", want: true},
+		{name: "prefix", filter: fileFilter{Prefix: "model/"}, path: "model/weights.bin", want: true},
+		{name: "other prefix", filter: fileFilter{Prefix: "logs/"}, path: "model/weights.bin"},
+		{name: "base name", filter: fileFilter{Include: []string{"*.bin"}}, path: "model/weights.bin", want: true},
+		{name: "not included", filter: fileFilter{Include: []string{"*.json"}}, path: "model/weights.bin"},
+		{name: "any include", filter: fileFilter{Include: []string{"*.json", "*.bin"}}, path: "weights.bin", want: true},
+		{name: "full path", filter: fileFilter{Include: []string{"model/*.bin"}}, path: "model/weights.bin", want: true},
+		{name: "leading slash", filter: fileFilter{Include: []string{"/model/*.bin"}}, path: "model/weights.bin", want: true},
+		{name: "full path elsewhere", filter: fileFilter{Include: []string{"model/*.bin"}}, path: "old/model/weights.bin"},
+		{name: "excluded", filter: fileFilter{Exclude: []string{"*.ckpt"}}, path: "step-1/model.ckpt"},
+		{
+			name:   "exclude over include",
+			filter: fileFilter{Include: []string{"*.ckpt"}, Exclude: []string{"step-1/*"}},
+			path:   "step-1/model.ckpt",
+		},
+		{
+			name:   "prefix and include",
+			filter: fileFilter{Prefix: "logs/", Include: []string{"*.bin"}},
+			path:   "model/weights.bin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
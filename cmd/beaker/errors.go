@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/beaker/client/api"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Formats of errors printed on exit.
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// Classes of errors, which scripts can tell apart by exit code or, with
+// --error-format json, by the error's "code".
+const (
+	errorGeneral     = "error"
+	errorUsage       = "usage"
+	errorNotFound    = "not_found"
+	errorPermission  = "permission_denied"
+	errorConflict    = "conflict"
+	errorInvalid     = "invalid"
+	errorUnavailable = "unavailable"
+	errorTimeout     = "timeout"
+	errorCanceled    = "canceled"
+)
+
+// exitCodes maps each class of error to the process's exit code.
+var exitCodes = map[string]int{
+	errorGeneral:     1,
+	errorUsage:       2,
+	errorNotFound:    3,
+	errorPermission:  4,
+	errorConflict:    5,
+	errorInvalid:     6,
+	errorUnavailable: 7,
+	errorTimeout:     8,
+	errorCanceled:    130, // 128 + SIGINT, as shells report an interrupt.
+}
+
+// usageError marks an error in how a command was invoked, such as a missing
+// argument or an unknown flag.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// markUsageErrors makes argument and flag errors of every command usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return usageError{err}
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markUsageErrors(sub)
+	}
+}
+
+// classifyError returns the class of an error. API errors are classified by
+// their status, wherever in a chain of wrapped errors they are.
+func classifyError(err error) string {
+	var usage usageError
+	var apiErr api.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorTimeout
+	case errors.As(err, &usage), strings.HasPrefix(err.Error(), "unknown command "):
+		return errorUsage
+	case errors.As(err, &apiErr):
+		switch apiErr.Code {
+		case http.StatusNotFound:
+			return errorNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return errorPermission
+		case http.StatusConflict:
+			return errorConflict
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return errorInvalid
+		case http.StatusRequestTimeout:
+			return errorTimeout
+		case http.StatusTooManyRequests:
+			return errorUnavailable
+		}
+	}
+	if isUnreachable(err) {
+		return errorUnavailable
+	}
+	return errorGeneral
+}
+
+// errorObject is the error printed with --error-format json.
+type errorObject struct {
+	Code     string `json:"code"`
+	ExitCode int    `json:"exitCode"`
+	Message  string `json:"message"`
+
+	// Set for errors returned by Beaker. The request ID identifies the
+	// request in Beaker's logs.
+	Status    int    `json:"status,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// reportError prints an error to STDERR in the chosen format and returns the
+// code the process should exit with.
+func reportError(err error, errorFormat string) int {
+	class := classifyError(err)
+	exitCode := exitCodes[class]
+
+	if errorFormat == errorFormatJSON {
+		obj := errorObject{Code: class, ExitCode: exitCode, Message: err.Error()}
+		var apiErr api.Error
+		if errors.As(err, &apiErr) {
+			obj.Status = apiErr.Code
+			obj.RequestID = apiErr.ErrorID
+		}
+		_ = json.NewEncoder(os.Stderr).Encode(&obj)
+		return exitCode
+	}
+
+	// Don't print "context canceled" error on Ctrl-C.
+	if class != errorCanceled {
+		fmt.Fprintf(os.Stderr, "%s %+v\n", color.RedString("Error:"), err)
+	}
+	var apiErr api.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		// Tokens may be scoped to some workspaces or to read-only access,
+		// in which case the message alone can be confusing.
+		fmt.Fprintln(os.Stderr, "The token in use isn't permitted to do this. "+
+			"It may be restricted to other workspaces or to read-only access.")
+	}
+	return exitCode
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/beaker/client/api"
+)
+
+// Pages of the current user's most recent experiments searched for tasks
+// similar to the ones being estimated.
+const estimateHistoryPages = 5
+
+// taskEstimate is the estimated cost and queue wait of one task of a spec.
+type taskEstimate struct {
+	// Index of the task's experiment, if the spec is a sweep.
+	Run int `json:"run"`
+
+	Task      string  `json:"task"`
+	Cluster   string  `json:"cluster"`
+	NodeShare float64 `json:"nodeShare"`
+
+	// Median run and queue times of similar past tasks. Unset without history.
+	RuntimeHours *float64 `json:"runtimeHours,omitempty"`
+	QueueHours   *float64 `json:"queueHours,omitempty"`
+	Samples      int      `json:"samples"`
+
+	// Cost is estimated in USD from the cluster's node cost and the task's
+	// runtime. It's only set if both are known.
+	Cost *float64 `json:"cost,omitempty"`
+}
+
+// estimateTotal sums the known costs of estimates, returning how many tasks
+// couldn't be priced.
+func estimateTotal(estimates []taskEstimate) (float64, int) {
+	var total float64
+	var unknown int
+	for _, estimate := range estimates {
+		if estimate.Cost == nil {
+			unknown++
+			continue
+		}
+		total += *estimate.Cost
+	}
+	return total, unknown
+}
+
+// estimateRuns estimates every task of each run from its cluster's pricing and
+// the runtime of similar tasks in the current user's recent experiments.
+// Tasks are similar if they ran on the same cluster with the same name or
+// image.
+func estimateRuns(runs []sweepRun) ([]taskEstimate, error) {
+	user, err := beaker.WhoAmI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	history, err := recentExecutions(user.Name, estimateHistoryPages)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := usageClusters{}
+	var estimates []taskEstimate
+	for i, run := range runs {
+		tasks, err := parseValidateTasks(run.Spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			estimate := taskEstimate{
+				Run:       i,
+				Task:      task.Name,
+				Cluster:   task.Cluster.Ref,
+				NodeShare: 1,
+			}
+
+			var runtimes, waits []time.Duration
+			var cluster *api.Cluster
+			if task.Cluster.Ref != "" {
+				cluster = clusters.get(task.Cluster.Ref)
+			}
+			if cluster != nil {
+				estimate.Cluster = cluster.FullName
+				if task.Requests != nil {
+					// Requests are compared as limits since that's what nodeShare
+					// measures; a task gets at least what it requests.
+					estimate.NodeShare = nodeShare(cluster, &api.ResourceLimits{
+						CPUCount: task.Requests.CPUCount,
+						GPUs:     make([]string, task.Requests.GPUCount),
+					})
+				}
+
+				for _, execution := range history {
+					ref := execution.Spec.Context.Cluster
+					if (ref != cluster.ID && ref != cluster.FullName) || execution.State.Started == nil {
+						continue
+					}
+					waits = append(waits, execution.State.Started.Sub(execution.State.Created))
+
+					image := imageURL("", execution.Spec.Image.Beaker, "", execution.Spec.Image.Docker).Ref
+					similar := (task.Name != "" && execution.Spec.Name == task.Name) ||
+						(task.ImageURL.Ref != "" && image == task.ImageURL.Ref)
+					if similar && execution.State.Exited != nil {
+						runtimes = append(runtimes, execution.State.Exited.Sub(*execution.State.Started))
+					}
+				}
+			}
+
+			estimate.Samples = len(runtimes)
+			if len(runtimes) != 0 {
+				hours := medianDuration(runtimes).Hours()
+				estimate.RuntimeHours = &hours
+				if cluster.NodeCost != nil {
+					nodeCost, _ := cluster.NodeCost.Float64()
+					cost := nodeCost * estimate.NodeShare * hours
+					estimate.Cost = &cost
+				}
+			}
+			if len(waits) != 0 {
+				hours := medianDuration(waits).Hours()
+				estimate.QueueHours = &hours
+			}
+			estimates = append(estimates, estimate)
+		}
+	}
+	return estimates, nil
+}
+
+// recentExecutions returns the executions of an author's most recent
+// experiments, searching up to the given number of pages.
+func recentExecutions(author string, pages int) ([]*api.Execution, error) {
+	opts := api.ExperimentSearchOptions{
+		SortClauses: []api.ExperimentSortClause{{
+			Field: api.ExperimentCreated, Order: api.SortDescending,
+		}},
+		FilterClauses: []api.ExperimentFilterClause{{
+			Field: api.ExperimentAuthor, Operator: api.OpEqual, Value: author,
+		}},
+	}
+
+	var executions []*api.Execution
+	for page := 0; page < pages; page++ {
+		experiments, err := beaker.SearchExperiments(ctx, opts, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(experiments) == 0 {
+			break
+		}
+		for _, experiment := range experiments {
+			executions = append(executions, experiment.Executions...)
+		}
+	}
+	return executions, nil
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckEstimatedCost(t *testing.T) {
+	cost := func(c float64) *float64 { return &c }
+
+	tests := []struct {
+		name          string
+		costs         []*float64
+		maxCost       float64
+		allowUnpriced bool
+		wantErr       bool
+	}{
+		{name: "no tasks", maxCost: 0},
+		{name: "under", costs: []*float64{cost(1), cost(2.5)}, maxCost: 4},
+		{name: "at limit", costs: []*float64{cost(1), cost(3)}, maxCost: 4},
+		{name: "over", costs: []*float64{cost(1), cost(3.01)}, maxCost: 4, wantErr: true},
+		{name: "unpriced", costs: []*float64{cost(1), nil}, maxCost: 4, wantErr: true},
+		{name: "unpriced allowed", costs: []*float64{cost(1), nil}, maxCost: 4, allowUnpriced: true},
+		{name: "unpriced allowed but over", costs: []*float64{cost(5), nil}, maxCost: 4, allowUnpriced: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var estimates []taskEstimate
+			for _, c := range tt.costs {
+				estimates = append(estimates, taskEstimate{Task: "task", Cost: c})
+			}
+			err := checkEstimatedCost(estimates, tt.maxCost, tt.allowUnpriced)
+			if tt.wantErr && err == nil {
+				t.Error("expected an error")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"io"
-	"os"
+	"fmt"
 
 	"github.com/beaker/client/api"
 	"github.com/spf13/cobra"
@@ -14,6 +13,7 @@ func newExecutionCommand() *cobra.Command {
 		Short: "Manage executions",
 	}
 	cmd.AddCommand(newExecutionGetCommand())
+	cmd.AddCommand(newExecutionListCommand())
 	cmd.AddCommand(newExecutionLogsCommand())
 	cmd.AddCommand(newExecutionResultsCommand())
 	cmd.AddCommand(newExecutionStopCommand())
@@ -40,15 +40,82 @@ func newExecutionGetCommand() *cobra.Command {
 	}
 }
 
+func newExecutionListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List executions on a cluster or node, or in an experiment",
+		Long: `List executions on a cluster or node, or in an experiment.
+
+Executions on a cluster or node are those which are pending or running.`,
+		Args: cobra.NoArgs,
+	}
+
+	var cluster string
+	var node string
+	var experiment string
+	cmd.Flags().StringVar(&cluster, "cluster", "", "List executions on a cluster")
+	cmd.Flags().StringVar(&node, "node", "", "List executions on a node")
+	cmd.Flags().StringVar(&experiment, "experiment", "", "List executions in an experiment")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var set int
+		for _, flag := range []string{cluster, node, experiment} {
+			if flag != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("exactly one of --cluster, --node, or --experiment must be set")
+		}
+
+		var executions []api.Execution
+		switch {
+		case cluster != "":
+			var err error
+			if executions, err = beaker.Cluster(cluster).ListExecutions(ctx, nil); err != nil {
+				return err
+			}
+		case node != "":
+			result, err := beaker.Node(node).ListExecutions(ctx)
+			if err != nil {
+				return err
+			}
+			executions = result.Data
+		default:
+			info, err := beaker.Experiment(experiment).Get(ctx)
+			if err != nil {
+				return err
+			}
+			for _, execution := range info.Executions {
+				executions = append(executions, *execution)
+			}
+		}
+		return printExecutions(executions)
+	}
+	return cmd
+}
+
 func newExecutionLogsCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "logs <execution>",
-		Short: "Fetch execution logs",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return printExecutionLogs(args[0])
-		},
+		Short: "Print an execution's logs",
+		Long: `Print an execution's logs.
+
+With --follow, new output is printed as it's written until the execution
+finishes. Unlike 'beaker logs <task>', logs aren't followed into later
+executions of the same task.`,
+		Args: cobra.ExactArgs(1),
 	}
+
+	flags := addLogFlags(cmd)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		execution, err := beaker.Execution(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		return runLogs([]namedLogSource{{source: &executionLogs{id: execution.ID}}}, flags.options())
+	}
+	return cmd
 }
 
 func newExecutionResultsCommand() *cobra.Command {
@@ -65,6 +132,8 @@ func newExecutionResultsCommand() *cobra.Command {
 			switch format {
 			case formatJSON:
 				return printJSON(results)
+			case formatYAML:
+				return printYAML(results)
 			default:
 				if err := printTableRow("METRIC", "VALUE"); err != nil {
 					return err
@@ -95,13 +164,3 @@ func newExecutionStopCommand() *cobra.Command {
 	}
 	return cmd
 }
-
-func printExecutionLogs(executionID string) error {
-	logs, err := beaker.Execution(executionID).GetLogs(ctx)
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(os.Stdout, logs)
-	return err
-}
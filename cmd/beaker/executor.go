@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -30,6 +32,125 @@ type executorConfig struct {
 	// to an NFS mount to enable roaming profiles. If unset, sessions mount the
 	// invoking user's home directory.
 	SessionHome string `yaml:"sessionHome"`
+
+	// (optional) Directory on node-local disk, such as an NVMe mount, in which
+	// scratch space is allocated. Must be writeable by every user who runs
+	// sessions. Defaults to a scratch directory in StoragePath.
+	ScratchPath string `yaml:"scratchPath,omitempty"`
+
+	// (optional) Hardening applied to task containers.
+	Sandbox *sandboxPolicy `yaml:"sandbox,omitempty"`
+
+	// (optional) Address, such as :9100, on which the executor serves
+	// Prometheus metrics at /metrics.
+	MetricsAddr string `yaml:"metricsAddr,omitempty"`
+
+	// (optional) NVIDIA GPUs the node advertises by type, detected when the
+	// executor is installed.
+	Accelerators []nodeAccelerator `yaml:"accelerators,omitempty"`
+
+	// (optional) Labels describing the node, such as gpu: a100, which
+	// sessions may require with --constraint.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// (optional) Container runtime, either "docker" or "podman", through
+	// which containers are created. Defaults to Docker.
+	Runtime string `yaml:"runtime,omitempty"`
+}
+
+// Label the Beaker runtime applies to every container it creates, including
+// those of executions and sessions.
+const managedContainerLabel = "beaker.org/managed"
+
+// executorImage is a Docker image used by containers of Beaker executions or
+// sessions.
+type executorImage struct {
+	ID       string    `json:"id"`
+	Tags     []string  `json:"tags,omitempty"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+	InUse    bool      `json:"inUse"`
+
+	// Stopped containers which must be removed along with the image.
+	containers []string
+}
+
+// executorCache summarizes the disk used by an executor's caches.
+type executorCache struct {
+	Entries []nodeCacheEntry `json:"entries"`
+	Images  []executorImage  `json:"images"`
+
+	// Bytes used by all entries and images, and bytes free in the storage
+	// directory's file system.
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+// Sandbox settings which tasks may be allowed to opt out of.
+const (
+	sandboxSeccomp        = "seccomp"
+	sandboxAppArmor       = "apparmor"
+	sandboxReadOnlyRootFS = "readOnlyRootFS"
+	sandboxCapabilities   = "capabilities"
+)
+
+// sandboxPolicy hardens the containers the executor runs for tasks, which
+// matters on nodes shared by many users. A task's spec may opt out of a
+// setting only if the setting is listed in AllowOptOut; otherwise the
+// executor rejects the task.
+type sandboxPolicy struct {
+	// (optional) Path to a seccomp profile in JSON, or "unconfined". Docker's
+	// default profile is used if unset.
+	SeccompProfile string `yaml:"seccompProfile,omitempty"`
+
+	// (optional) Name of a loaded AppArmor profile. Docker's default profile is
+	// used if unset.
+	AppArmorProfile string `yaml:"apparmorProfile,omitempty"`
+
+	// (optional) Mount the container's root filesystem read-only. Mounted
+	// datasets, results, and /tmp remain writeable.
+	ReadOnlyRootFS bool `yaml:"readOnlyRootFS,omitempty"`
+
+	// (optional) Linux capabilities to drop, such as NET_RAW, or ALL.
+	DropCapabilities []string `yaml:"dropCapabilities,omitempty"`
+
+	// (optional) Settings which tasks may opt out of.
+	AllowOptOut []string `yaml:"allowOptOut,omitempty"`
+}
+
+// validate checks that a policy is well formed and normalizes capability names.
+func (p *sandboxPolicy) validate() error {
+	if p.SeccompProfile != "" && p.SeccompProfile != "unconfined" {
+		if _, err := os.Stat(p.SeccompProfile); err != nil {
+			return fmt.Errorf("invalid seccomp profile: %w", err)
+		}
+	}
+
+	for i, capability := range p.DropCapabilities {
+		capability = strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		if capability == "" || strings.TrimFunc(capability, func(r rune) bool {
+			return (r >= 'A' && r <= 'Z') || r == '_'
+		}) != "" {
+			return fmt.Errorf("invalid capability %q", p.DropCapabilities[i])
+		}
+		p.DropCapabilities[i] = capability
+	}
+
+	for _, setting := range p.AllowOptOut {
+		switch setting {
+		case sandboxSeccomp, sandboxAppArmor, sandboxReadOnlyRootFS, sandboxCapabilities:
+		default:
+			return fmt.Errorf("invalid sandbox setting %q; must be one of %s, %s, %s, or %s",
+				setting, sandboxSeccomp, sandboxAppArmor, sandboxReadOnlyRootFS, sandboxCapabilities)
+		}
+	}
+	return nil
+}
+
+// isZero returns whether a policy changes nothing from Docker's defaults.
+func (p *sandboxPolicy) isZero() bool {
+	return p.SeccompProfile == "" && p.AppArmorProfile == "" && !p.ReadOnlyRootFS &&
+		len(p.DropCapabilities) == 0
 }
 
 // Get the config of the executor running on this machine.
@@ -44,6 +165,11 @@ func getExecutorConfig() (*executorConfig, error) {
 	if err := yaml.NewDecoder(expanded).Decode(&config); err != nil {
 		return nil, err
 	}
+	if config.Sandbox != nil {
+		if err := config.Sandbox.validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &config, nil
 }
@@ -61,3 +187,25 @@ func getCurrentNode() (string, error) {
 	}
 	return string(node), nil
 }
+
+// localNodeConfig returns the config of the executor on this machine, which
+// must run the given node, and the node's ID. Properties such as labels and
+// accelerators are only kept in the executor's config, so only this machine's
+// node can be checked.
+func localNodeConfig(node, property string) (string, *executorConfig, error) {
+	notLocal := fmt.Errorf("%s of node %s can only be checked on that node; run this command there", property, node)
+	config, err := getExecutorConfig()
+	if err != nil {
+		return "", nil, notLocal
+	}
+	local, err := getCurrentNode()
+	if err != nil {
+		return "", nil, notLocal
+	}
+	if node != local {
+		if info, err := beaker.Node(node).Get(ctx); err != nil || info.ID != local {
+			return "", nil, notLocal
+		}
+	}
+	return local, config, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/allenai/bytefmt"
+	"github.com/beaker/runtime"
+	docker "github.com/docker/docker/client"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func newExecutorCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache <command>",
+		Short: "Manage the executor's dataset and image caches",
+		Long: `Manage the executor's dataset and image caches.
+
+The executor caches the datasets used by executions in its storage directory,
+and the container runtime keeps the images they ran. Pull images before a large
+sweep so its executions don't all fetch them at once, and clear images which
+are no longer used. The executor manages its dataset cache itself, so these
+commands only read it.
+
+These commands must be run on the node, usually as root.`,
+	}
+	cmd.AddCommand(newExecutorCacheClearCommand())
+	cmd.AddCommand(newExecutorCacheListCommand())
+	cmd.AddCommand(newExecutorCacheWarmCommand())
+	return cmd
+}
+
+func newExecutorCacheClearCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Remove images which Beaker's containers no longer use",
+		Long: `Remove images which Beaker's containers no longer use.
+
+Removes every image used by Beaker which hasn't been used for --older-than, or
+every such image if it isn't set. An image is removed along with the stopped
+containers created from it. Images used by running containers are never
+removed.
+
+Unless --yes is set, what would be removed is listed and you're asked to
+confirm first; --yes is required when stdin isn't a terminal. Use --dry-run to
+only list it.`,
+		Args: cobra.NoArgs,
+	}
+
+	var olderThan time.Duration
+	var dryRun bool
+	var yes bool
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "Only remove images unused for this long")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be removed without removing it")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Don't ask for confirmation")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		storage, err := nodeStoragePath("")
+		if err != nil {
+			return err
+		}
+		client, err := newContainerClient()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		cache, err := readExecutorCache(client, storage)
+		if err != nil {
+			return err
+		}
+
+		clear := executorCache{Available: cache.Available}
+		for _, image := range cache.Images {
+			if !image.InUse && time.Since(image.LastUsed) >= olderThan {
+				clear.Images = append(clear.Images, image)
+				clear.Used += image.Size
+			}
+		}
+		if len(clear.Images) == 0 {
+			if !quiet {
+				fmt.Println("Nothing to remove.")
+			}
+			return nil
+		}
+
+		if dryRun || !yes {
+			if err := printExecutorCache(&clear); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return nil
+		}
+		if !yes {
+			fmt.Println()
+			ok, err := confirmOrFail(fmt.Sprintf("Remove %d images, freeing %s?",
+				len(clear.Images), bytefmt.New(clear.Used, bytefmt.Binary)), "--yes")
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+
+		var freed int64
+		for i := range clear.Images {
+			if err := removeExecutorImage(client, &clear.Images[i]); err != nil {
+				return err
+			}
+			freed += clear.Images[i].Size
+		}
+		if !quiet {
+			fmt.Printf("Freed %s\n", bytefmt.New(freed, bytefmt.Binary))
+		}
+		return nil
+	}
+	return cmd
+}
+
+func newExecutorCacheListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List cached datasets and images and the disk they use",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storage, err := nodeStoragePath("")
+			if err != nil {
+				return err
+			}
+			client, err := newContainerClient()
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			cache, err := readExecutorCache(client, storage)
+			if err != nil {
+				return err
+			}
+			return printExecutorCache(cache)
+		},
+	}
+}
+
+func newExecutorCacheWarmCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "warm",
+		Short: "Fetch datasets and images onto this node ahead of use",
+		Long: `Fetch datasets and images onto this node ahead of use.
+
+Images are pulled unless they're already present, so executions which use them
+start without pulling them again. Run this on each node of a cluster before a
+large sweep so every node doesn't pull the same images at once.
+
+Datasets are downloaded to a directory per dataset within --output, which must
+be outside the executor's storage directory; the executor fetches datasets for
+executions itself. Files already downloaded are skipped, so an interrupted
+download can be resumed.`,
+		Args: cobra.NoArgs,
+	}
+
+	var images []string
+	var datasets []string
+	var outputPath string
+	var concurrency int
+	cmd.Flags().StringArrayVar(&images, "image", nil, "Image to pull, such as beaker://user/image or docker://ubuntu (repeatable)")
+	cmd.Flags().StringArrayVar(&datasets, "dataset", nil, "Dataset to download (repeatable)")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Directory to download datasets to")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 8, "Number of files to download at once")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if len(images) == 0 && len(datasets) == 0 {
+			return fmt.Errorf("pass at least one --image or --dataset")
+		}
+		if len(datasets) != 0 {
+			if outputPath == "" {
+				return usageError{fmt.Errorf("--output is required to download datasets")}
+			}
+			if err := checkOutsideExecutorStorage(outputPath); err != nil {
+				return err
+			}
+		}
+
+		// Resolve everything first so a typo doesn't fail halfway through.
+		var rtImages []*runtime.DockerImage
+		for _, image := range images {
+			rtImage, err := resolveImage(beaker, image)
+			if err != nil {
+				return fmt.Errorf("%s: %w", image, err)
+			}
+			rtImages = append(rtImages, rtImage)
+		}
+		var datasetIDs []string
+		for _, ref := range datasets {
+			dataset, err := beaker.Dataset(ref).Get(ctx)
+			if err != nil {
+				return err
+			}
+			datasetIDs = append(datasetIDs, dataset.ID)
+		}
+
+		if len(rtImages) != 0 {
+			rt, err := newContainerRuntime()
+			if err != nil {
+				return err
+			}
+			for i, rtImage := range rtImages {
+				if !quiet {
+					fmt.Printf("Pulling %s\n", color.CyanString(images[i]))
+				}
+				if err := rt.PullImage(ctx, rtImage, runtime.PullIfMissing, quiet); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, id := range datasetIDs {
+			if err := warmDataset(id, filepath.Join(outputPath, id), concurrency); err != nil {
+				return fmt.Errorf("couldn't download %s: %w", datasets[i], err)
+			}
+		}
+		return nil
+	}
+	return cmd
+}
+
+// checkOutsideExecutorStorage fails if dir is within the executor's storage
+// directory, which belongs to the executor alone.
+func checkOutsideExecutorStorage(dir string) error {
+	config, err := getExecutorConfig()
+	if os.IsNotExist(err) {
+		// Without an executor there's nothing to protect.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read executor config: %w", err)
+	}
+	storage, err := filepath.Abs(config.StoragePath)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(storage, abs)
+	if err == nil && (rel == "." || !strings.HasPrefix(rel, "..")) {
+		return fmt.Errorf("%s is within the executor's storage directory %s; download datasets elsewhere", dir, storage)
+	}
+	return nil
+}
+
+// warmDataset downloads a dataset to target.
+func warmDataset(id, target string, concurrency int) error {
+	storage, _, err := beaker.Dataset(id).Storage(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := listFiles(storage, fileFilter{})
+	if err != nil {
+		return err
+	}
+	if !quiet {
+		fmt.Printf("Downloading %s to %s\n", color.CyanString(id), color.GreenString(target))
+	}
+	return downloadFiles(storage, files, target, concurrency)
+}
+
+// readExecutorCache lists an executor's cache entries and the Docker images
+// its containers used.
+func readExecutorCache(client *docker.Client, storage string) (*executorCache, error) {
+	entries, err := listNodeCache(storage)
+	if err != nil {
+		return nil, err
+	}
+	images, err := listExecutorImages(client)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &executorCache{Entries: entries, Images: images}
+	for _, entry := range entries {
+		cache.Used += entry.Size
+	}
+	for _, image := range images {
+		cache.Used += image.Size
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(storage, &stat); err != nil {
+		return nil, fmt.Errorf("couldn't check free space in %s: %w", storage, err)
+	}
+	cache.Available = int64(stat.Bavail) * int64(stat.Bsize)
+	return cache, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	docker "github.com/docker/docker/client"
+)
+
+// listExecutorImages lists images used by Beaker's containers, most recently
+// used first.
+func listExecutorImages(client *docker.Client) ([]executorImage, error) {
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedContainerLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*executorImage{}
+	for _, container := range containers {
+		image := byID[container.ImageID]
+		if image == nil {
+			image = &executorImage{ID: container.ImageID}
+			byID[container.ImageID] = image
+		}
+
+		if container.State == "running" {
+			image.InUse = true
+			image.LastUsed = time.Now()
+			continue
+		}
+		image.containers = append(image.containers, container.ID)
+
+		// A container was last used when it finished, or when it was
+		// created if it never ran.
+		lastUsed := time.Unix(container.Created, 0)
+		info, err := client.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			return nil, err
+		}
+		if finished, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt); err == nil && finished.After(lastUsed) {
+			lastUsed = finished
+		}
+		if lastUsed.After(image.LastUsed) {
+			image.LastUsed = lastUsed
+		}
+	}
+
+	summaries, err := client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var images []executorImage
+	for _, summary := range summaries {
+		if image, ok := byID[summary.ID]; ok {
+			image.Tags = summary.RepoTags
+			image.Size = summary.Size
+			images = append(images, *image)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].LastUsed.After(images[j].LastUsed)
+	})
+	return images, nil
+}
+
+// removeExecutorImage removes an image along with its stopped containers.
+// Removal isn't forced, so an image which a new container started using is
+// kept. Each tag is removed in turn since Docker won't remove an image with
+// several tags by ID.
+func removeExecutorImage(client *docker.Client, image *executorImage) error {
+	for _, container := range image.containers {
+		if err := client.ContainerRemove(ctx, container, types.ContainerRemoveOptions{}); err != nil && !docker.IsErrNotFound(err) {
+			return err
+		}
+	}
+
+	var refs []string
+	for _, tag := range image.Tags {
+		if tag != "<none>:<none>" {
+			refs = append(refs, tag)
+		}
+	}
+	if len(refs) == 0 {
+		refs = []string{image.ID}
+	}
+	for _, ref := range refs {
+		_, err := client.ImageRemove(ctx, ref, types.ImageRemoveOptions{PruneChildren: true})
+		if err != nil && !docker.IsErrNotFound(err) {
+			return fmt.Errorf("couldn't remove image %s: %w", image.ID, err)
+		}
+	}
+	return nil
+}
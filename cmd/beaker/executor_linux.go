@@ -1,17 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"text/template"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -31,24 +37,56 @@ const (
 	defaultStorageDir = "/var/beaker"
 )
 
+// Init systems which may manage the executor.
+const (
+	initNone        = "none"
+	initSystemd     = "systemd"
+	initSupervisord = "supervisord"
+)
+
 var (
 	// Path where the Beaker token used by the executor is stored.
 	executorTokenPath = path.Join(executorConfigDir, "executor-token")
 
 	// Path where the Systemd configuration file for the executor is stored.
 	executorSystemdPath = fmt.Sprintf("/etc/systemd/system/%s.service", executorService)
+
+	// Path where the Supervisor configuration file for the executor is stored.
+	executorSupervisordPath = fmt.Sprintf("/etc/supervisor/conf.d/%s.conf", executorService)
+
+	// Returned when managing an executor which isn't run by an init system.
+	errUnmanaged = errors.New(`the executor is not managed by an init system; use "executor run"`)
 )
 
 var configTemplate = template.Must(template.New("config").Parse(`
 storagePath: {{.StoragePath}}
 beaker:
   tokenPath: {{.TokenPath}}
-  cluster: {{.Cluster}}`))
+  cluster: {{.Cluster}}
+{{- with .ScratchPath}}
+scratchPath: {{.}}{{end}}
+{{- with .MetricsAddr}}
+metricsAddr: {{.}}{{end}}
+{{- with .Runtime}}
+runtime: {{.}}{{end}}
+{{- with .Sandbox}}
+{{.}}{{end}}
+{{- with .Accelerators}}
+{{.}}{{end}}`))
 
 type configOpts struct {
 	StoragePath string
 	TokenPath   string
 	Cluster     string
+	ScratchPath string
+	MetricsAddr string
+	Runtime     string
+
+	// Sandbox is the sandbox section of the config as YAML, if any.
+	Sandbox string
+
+	// Accelerators is the accelerators section of the config as YAML, if any.
+	Accelerators string
 }
 
 var systemdTemplate = template.Must(template.New("systemd").Parse(`
@@ -63,13 +101,29 @@ Restart=always
 RestartSec=1
 ExecStart={{.BinaryPath}}
 Environment=CONFIG_PATH={{.ConfigPath}}
+{{- with .DockerHost}}
+Environment=DOCKER_HOST={{.}}{{end}}
 
 [Install]
 WantedBy=multi-user.target`))
 
-type systemdOpts struct {
+var supervisordTemplate = template.Must(template.New("supervisord").Parse(`
+[program:{{.Name}}]
+command={{.BinaryPath}}
+environment=CONFIG_PATH="{{.ConfigPath}}"{{with .DockerHost}},DOCKER_HOST="{{.}}"{{end}}
+autostart=true
+autorestart=true
+startsecs=1
+stopsignal=TERM`))
+
+type serviceOpts struct {
+	Name       string
 	BinaryPath string
 	ConfigPath string
+
+	// DockerHost points the executor at its container runtime, if it isn't
+	// Docker's default.
+	DockerHost string
 }
 
 func newExecutorCommand() *cobra.Command {
@@ -77,9 +131,12 @@ func newExecutorCommand() *cobra.Command {
 		Use:   "executor <command>",
 		Short: "Manage the executor",
 	}
+	cmd.AddCommand(newExecutorCacheCommand())
 	cmd.AddCommand(newExecutorInstallCommand())
 	cmd.AddCommand(newExecutorRestartCommand())
+	cmd.AddCommand(newExecutorRunCommand())
 	cmd.AddCommand(newExecutorStartCommand())
+	cmd.AddCommand(newExecutorStatusCommand())
 	cmd.AddCommand(newExecutorStopCommand())
 	cmd.AddCommand(newExecutorUninstallCommand())
 	cmd.AddCommand(newExecutorUpgradeCommand())
@@ -91,23 +148,148 @@ func newExecutorInstallCommand() *cobra.Command {
 		Use:   "install <cluster>",
 		Short: "Install and start the Beaker executor",
 		Long: `Install the Beaker executor, start it, and configure it to run on boot.
-Requires access to /etc, /var, and /usr/bin. Also requires access to systemd.`,
+Requires access to /etc, /var, and /usr/bin. Also requires access to the init
+system, if any.
+
+With --init=none the executor is installed but not started. Run it in the
+foreground with "executor run", e.g. as the entrypoint of a container.
+
+With --runtime=podman the executor runs containers through the Docker-compatible
+API of root Podman at ` + podmanSocket + `; enable it with
+"systemctl enable --now podman.socket". Other beaker commands on the node use
+the same runtime.
+
+The sandbox flags harden task containers on nodes shared by many users. By
+default tasks can't opt out of any sandbox setting; list the settings they
+may opt out of with --allow-sandbox-opt-out.
+
+With --metrics-addr the executor serves Prometheus metrics, such as running
+executions, GPU allocation, dataset cache size, and pull durations, at /metrics
+on the given address. Summarize them with "executor status".
+
+NVIDIA GPUs are detected and advertised by type as the node's accelerators,
+which sessions may require with --accelerator. Declare them with
+--accelerators if detection misses any, e.g. --accelerators a100:8.`,
 		Args: cobra.ExactArgs(1),
 	}
 
 	var storageDir string
+	var initSystem string
+	var scratchDir string
+	var metricsAddr string
+	var runtimeName string
+	var acceleratorFlags []string
 	cmd.Flags().StringVar(
 		&storageDir,
 		"storage-dir",
 		defaultStorageDir,
 		"Writeable directory for storing Beaker datasets")
+	cmd.Flags().StringVar(&initSystem, "init", initSystemd, fmt.Sprintf(
+		"Init system which manages the executor (%s|%s|%s)", initNone, initSystemd, initSupervisord))
+	cmd.Flags().StringVar(&scratchDir, "scratch-dir", "",
+		"Directory on node-local disk for scratch space. Defaults to a directory in --storage-dir")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "",
+		"Address on which the executor serves Prometheus metrics, e.g. :9100")
+	cmd.Flags().StringVar(&runtimeName, "runtime", runtimeDocker, fmt.Sprintf(
+		"Container runtime which runs tasks and sessions (%s|%s)", runtimeDocker, runtimePodman))
+	cmd.Flags().StringSliceVar(&acceleratorFlags, "accelerators", nil,
+		"Accelerators to advertise as <type>:<count>, e.g. a100:8. Detected if unset")
+
+	var sandbox sandboxPolicy
+	cmd.Flags().StringVar(&sandbox.SeccompProfile, "seccomp-profile", "",
+		`Seccomp profile for task containers, as a path to a JSON profile or "unconfined"`)
+	cmd.Flags().StringVar(&sandbox.AppArmorProfile, "apparmor-profile", "",
+		"Name of a loaded AppArmor profile for task containers")
+	cmd.Flags().BoolVar(&sandbox.ReadOnlyRootFS, "read-only-rootfs", false,
+		"Mount the root filesystem of task containers read-only")
+	cmd.Flags().StringSliceVar(&sandbox.DropCapabilities, "cap-drop", nil,
+		"Linux capabilities to drop from task containers, such as NET_RAW, or ALL")
+	cmd.Flags().StringSliceVar(&sandbox.AllowOptOut, "allow-sandbox-opt-out", nil, fmt.Sprintf(
+		"Sandbox settings which tasks may opt out of (%s|%s|%s|%s)",
+		sandboxSeccomp, sandboxAppArmor, sandboxReadOnlyRootFS, sandboxCapabilities))
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		switch initSystem {
+		case initNone, initSystemd, initSupervisord:
+		default:
+			return fmt.Errorf("invalid init system %q; must be one of %q, %q, or %q",
+				initSystem, initNone, initSystemd, initSupervisord)
+		}
+		switch runtimeName {
+		case runtimeDocker, runtimePodman:
+		default:
+			return fmt.Errorf("invalid runtime %q; must be %q or %q", runtimeName, runtimeDocker, runtimePodman)
+		}
+
 		if _, err := os.Stat(executorPath); err == nil {
 			return fmt.Errorf(`executor is already installed.
 Run "upgrade" to install the latest version or run "uninstall" before installing.`)
 		}
 
+		if metricsAddr != "" {
+			if _, _, err := net.SplitHostPort(metricsAddr); err != nil {
+				return fmt.Errorf("invalid metrics address %q: %w", metricsAddr, err)
+			}
+		}
+
+		if sandbox.SeccompProfile != "" && sandbox.SeccompProfile != "unconfined" {
+			// The executor may run from a different working directory.
+			path, err := filepath.Abs(sandbox.SeccompProfile)
+			if err != nil {
+				return err
+			}
+			sandbox.SeccompProfile = path
+		}
+		if err := sandbox.validate(); err != nil {
+			return err
+		}
+		var sandboxConfig string
+		if !sandbox.isZero() {
+			var b strings.Builder
+			encoder := yaml.NewEncoder(&b)
+			encoder.SetIndent(2)
+			if err := encoder.Encode(map[string]*sandboxPolicy{"sandbox": &sandbox}); err != nil {
+				return err
+			}
+			sandboxConfig = strings.TrimSuffix(b.String(), "\n")
+		}
+
+		accelerators, err := parseNodeAccelerators(acceleratorFlags)
+		if err != nil {
+			return err
+		}
+		if len(acceleratorFlags) == 0 {
+			if accelerators, err = detectAccelerators(); err != nil {
+				return err
+			}
+		}
+		var acceleratorConfig string
+		if len(accelerators) != 0 {
+			var b strings.Builder
+			encoder := yaml.NewEncoder(&b)
+			encoder.SetIndent(2)
+			if err := encoder.Encode(map[string][]nodeAccelerator{"accelerators": accelerators}); err != nil {
+				return err
+			}
+			acceleratorConfig = strings.TrimSuffix(b.String(), "\n")
+		}
+
+		if scratchDir != "" {
+			// Sessions share the directory, so any user must be able to create
+			// their own space in it.
+			path, err := filepath.Abs(scratchDir)
+			if err != nil {
+				return err
+			}
+			scratchDir = path
+			if err := os.MkdirAll(scratchDir, 0755); err != nil {
+				return err
+			}
+			if err := os.Chmod(scratchDir, os.ModeSticky|0777); err != nil {
+				return err
+			}
+		}
+
 		cluster := args[0]
 		if _, err := beaker.Cluster(args[0]).Get(ctx); err != nil {
 			return err
@@ -131,22 +313,19 @@ Run "upgrade" to install the latest version or run "uninstall" before installing
 		}
 		defer configFile.Close()
 		if err := configTemplate.Execute(configFile, configOpts{
-			StoragePath: storageDir,
-			TokenPath:   executorTokenPath,
-			Cluster:     cluster,
+			StoragePath:  storageDir,
+			TokenPath:    executorTokenPath,
+			Cluster:      cluster,
+			ScratchPath:  scratchDir,
+			MetricsAddr:  metricsAddr,
+			Runtime:      runtimeName,
+			Sandbox:      sandboxConfig,
+			Accelerators: acceleratorConfig,
 		}); err != nil {
 			return err
 		}
 
-		systemdFile, err := os.Create(executorSystemdPath)
-		if err != nil {
-			return err
-		}
-		defer systemdFile.Close()
-		if err := systemdTemplate.Execute(systemdFile, systemdOpts{
-			BinaryPath: executorPath,
-			ConfigPath: executorConfigPath,
-		}); err != nil {
+		if err := writeServiceConfig(initSystem, runtimeName); err != nil {
 			return err
 		}
 
@@ -154,6 +333,13 @@ Run "upgrade" to install the latest version or run "uninstall" before installing
 			return err
 		}
 
+		if initSystem == initNone {
+			if !quiet {
+				fmt.Println(`Executor installed. Start it with "beaker executor run".`)
+			}
+			return nil
+		}
+
 		if err := startExecutor(); err != nil {
 			return err
 		}
@@ -188,6 +374,57 @@ func newExecutorRestartCommand() *cobra.Command {
 	}
 }
 
+func newExecutorRunCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "run",
+		Short: "Run the executor in the foreground",
+		Long: `Run the executor in the foreground until it exits.
+
+For hosts without an init system, such as containers in a Kubernetes DaemonSet.
+The executor must be installed with --init=none or have its configuration
+mounted at ` + executorConfigPath + `. Termination signals are forwarded to the
+executor so that it can shut down gracefully.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(executorConfigPath); err != nil {
+				return fmt.Errorf("executor is not configured; run \"install --init=none\" first: %w", err)
+			}
+
+			if _, err := os.Stat(executorPath); os.IsNotExist(err) {
+				if err := downloadExecutor(); err != nil {
+					return err
+				}
+			}
+
+			executor := exec.Command(executorPath)
+			executor.Env = append(os.Environ(), "CONFIG_PATH="+executorConfigPath)
+			if config, err := getExecutorConfig(); err == nil && os.Getenv("DOCKER_HOST") == "" {
+				if host := runtimeDockerHost(config.Runtime); host != "" {
+					executor.Env = append(executor.Env, "DOCKER_HOST="+host)
+				}
+			}
+			executor.Stdout = os.Stdout
+			executor.Stderr = os.Stderr
+
+			// Forward signals rather than killing the executor with the
+			// command's context so it can clean up running containers.
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+
+			if err := executor.Start(); err != nil {
+				return err
+			}
+			go func() {
+				for sig := range signals {
+					_ = executor.Process.Signal(sig)
+				}
+			}()
+			return executor.Wait()
+		},
+	}
+}
+
 func newExecutorStartCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "start",
@@ -206,6 +443,32 @@ func newExecutorStartCommand() *cobra.Command {
 	}
 }
 
+func newExecutorStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Summarize the executor's metrics",
+		Long: `Summarize the metrics of the executor running on this machine.
+The executor must be installed with --metrics-addr.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := getExecutorConfig()
+			if err != nil {
+				return err
+			}
+			if config.MetricsAddr == "" {
+				return errors.New(`the executor doesn't serve metrics; reinstall it with "install --metrics-addr"`)
+			}
+
+			status, err := scrapeExecutorStatus(config.MetricsAddr)
+			if err != nil {
+				return err
+			}
+			status.Accelerators = config.Accelerators
+			return printExecutorStatus(status)
+		},
+	}
+}
+
 func newExecutorStopCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "stop",
@@ -262,8 +525,8 @@ Are you sure you want to uninstall the executor?`
 				return nil
 			}
 
-			// This may fail if the systemd file has already been deleted.
-			if err := stopExecutor(); err != nil {
+			// This may fail if the service file has already been deleted.
+			if err := stopExecutor(); err != nil && err != errUnmanaged {
 				fmt.Fprintf(os.Stderr, "error stopping executor: %v\n", err)
 			}
 
@@ -280,10 +543,20 @@ Are you sure you want to uninstall the executor?`
 				return err
 			}
 
+			initSystem := installedInitSystem()
 			if err := os.Remove(executorSystemdPath); err != nil && !os.IsNotExist(err) {
 				return err
 			}
 
+			if err := os.Remove(executorSupervisordPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if initSystem == initSupervisord {
+				// Best-effort: Supervisor forgets the program once its config is gone.
+				_ = run("supervisorctl", "reread")
+				_ = run("supervisorctl", "update")
+			}
+
 			if err := os.Remove(executorConfigPath); err != nil && !os.IsNotExist(err) {
 				return err
 			}
@@ -308,6 +581,17 @@ func newExecutorUpgradeCommand() *cobra.Command {
 To update executor configuration, run uninstall and then install.`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if installedInitSystem() == initNone {
+				// The executor's owner is responsible for restarting it.
+				if err := downloadExecutor(); err != nil {
+					return err
+				}
+				if !quiet {
+					fmt.Println("Executor upgraded. Restart \"executor run\" to use the new version.")
+				}
+				return nil
+			}
+
 			if err := stopExecutor(); err != nil {
 				return err
 			}
@@ -368,24 +652,96 @@ func getLatestVersion() (string, error) {
 	return strings.TrimSpace(string(version)), nil
 }
 
-func startExecutor() error {
-	if err := run("systemctl", "daemon-reload"); err != nil {
-		return err
+// installedInitSystem detects which init system the executor was installed with.
+func installedInitSystem() string {
+	if _, err := os.Stat(executorSystemdPath); err == nil {
+		return initSystemd
 	}
+	if _, err := os.Stat(executorSupervisordPath); err == nil {
+		return initSupervisord
+	}
+	return initNone
+}
 
-	if err := run("systemctl", "enable", executorService); err != nil {
+// writeServiceConfig configures an init system to run the executor through a
+// container runtime.
+func writeServiceConfig(initSystem, runtimeName string) error {
+	var filePath string
+	var tmpl *template.Template
+	switch initSystem {
+	case initSystemd:
+		filePath, tmpl = executorSystemdPath, systemdTemplate
+	case initSupervisord:
+		filePath, tmpl = executorSupervisordPath, supervisordTemplate
+		if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
 		return err
 	}
+	defer file.Close()
+	return tmpl.Execute(file, serviceOpts{
+		Name:       executorService,
+		BinaryPath: executorPath,
+		ConfigPath: executorConfigPath,
+		DockerHost: runtimeDockerHost(runtimeName),
+	})
+}
+
+func startExecutor() error {
+	switch installedInitSystem() {
+	case initSystemd:
+		if err := run("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+
+		if err := run("systemctl", "enable", executorService); err != nil {
+			return err
+		}
+
+		return run("systemctl", "start", executorService)
 
-	return run("systemctl", "start", executorService)
+	case initSupervisord:
+		if err := run("supervisorctl", "reread"); err != nil {
+			return err
+		}
+
+		// Update starts newly added programs, so only start the executor if it
+		// was already known and stopped.
+		if err := run("supervisorctl", "update"); err != nil {
+			return err
+		}
+		status, _ := exec.CommandContext(ctx, "supervisorctl", "status", executorService).Output()
+		if strings.Contains(string(status), "RUNNING") {
+			return nil
+		}
+		return run("supervisorctl", "start", executorService)
+
+	default:
+		return errUnmanaged
+	}
 }
 
 func stopExecutor() error {
-	if err := run("systemctl", "disable", executorService); err != nil {
-		return err
-	}
+	switch installedInitSystem() {
+	case initSystemd:
+		if err := run("systemctl", "disable", executorService); err != nil {
+			return err
+		}
+
+		return run("systemctl", "stop", executorService)
 
-	return run("systemctl", "stop", executorService)
+	case initSupervisord:
+		return run("supervisorctl", "stop", executorService)
+
+	default:
+		return errUnmanaged
+	}
 }
 
 // The executor cleanup command removes running containers.
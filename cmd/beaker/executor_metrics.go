@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus metrics served by the executor.
+const (
+	metricRunningExecutions = "beaker_executor_running_executions"
+	metricGPUs              = "beaker_executor_gpus"
+	metricGPUsAllocated     = "beaker_executor_gpus_allocated"
+	metricDatasetCacheBytes = "beaker_executor_dataset_cache_bytes"
+
+	// A histogram labeled by what was pulled: "image" or "dataset".
+	metricPullDuration = "beaker_executor_pull_duration_seconds"
+)
+
+// executorStatus summarizes the metrics of an executor.
+type executorStatus struct {
+	RunningExecutions int          `json:"runningExecutions"`
+	GPUs              int          `json:"gpus"`
+	GPUsAllocated     int          `json:"gpusAllocated"`
+	DatasetCacheBytes int64        `json:"datasetCacheBytes"`
+	Pulls             []pullStatus `json:"pulls,omitempty"`
+
+	// Accelerators advertised in the executor's config.
+	Accelerators []nodeAccelerator `json:"accelerators,omitempty"`
+}
+
+// pullStatus summarizes the time spent pulling one kind of resource.
+type pullStatus struct {
+	Kind  string        `json:"kind"`
+	Count int           `json:"count"`
+	Total time.Duration `json:"total"`
+}
+
+// Mean returns the average duration of a pull.
+func (p pullStatus) Mean() time.Duration {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Total / time.Duration(p.Count)
+}
+
+// metricSample is one sample in the Prometheus text format.
+type metricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// scrapeExecutorStatus reads the metrics an executor serves on addr.
+func scrapeExecutorStatus(addr string) (*executorStatus, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	url := "http://" + net.JoinHostPort(host, port) + "/metrics"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reach the executor's metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+
+	samples, err := parseMetrics(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var status executorStatus
+	var kinds []string
+	pulls := map[string]*pullStatus{}
+	pull := func(kind string) *pullStatus {
+		if pulls[kind] == nil {
+			pulls[kind] = &pullStatus{Kind: kind}
+			kinds = append(kinds, kind)
+		}
+		return pulls[kind]
+	}
+	for _, sample := range samples {
+		switch sample.Name {
+		case metricRunningExecutions:
+			status.RunningExecutions = int(sample.Value)
+		case metricGPUs:
+			status.GPUs = int(sample.Value)
+		case metricGPUsAllocated:
+			status.GPUsAllocated = int(sample.Value)
+		case metricDatasetCacheBytes:
+			status.DatasetCacheBytes = int64(sample.Value)
+		case metricPullDuration + "_count":
+			pull(sample.Labels["kind"]).Count = int(sample.Value)
+		case metricPullDuration + "_sum":
+			pull(sample.Labels["kind"]).Total = time.Duration(sample.Value * float64(time.Second))
+		}
+	}
+	for _, kind := range kinds {
+		status.Pulls = append(status.Pulls, *pulls[kind])
+	}
+	return &status, nil
+}
+
+// parseMetrics parses metrics in the Prometheus text exposition format.
+// Comments, including type and help metadata, are skipped.
+func parseMetrics(r io.Reader) ([]metricSample, error) {
+	var samples []metricSample
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sample, err := parseMetricSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("metrics line %d: %w", lineNum, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// parseMetricSample parses a line such as `name{label="value"} 1.5 [timestamp]`.
+func parseMetricSample(line string) (metricSample, error) {
+	sample := metricSample{Labels: map[string]string{}}
+
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return sample, fmt.Errorf("invalid sample %q", line)
+	}
+	sample.Name, line = line[:end], line[end:]
+
+	if strings.HasPrefix(line, "{") {
+		line = line[1:]
+		for {
+			line = strings.TrimLeft(line, " ,")
+			if strings.HasPrefix(line, "}") {
+				line = line[1:]
+				break
+			}
+
+			eq := strings.Index(line, `="`)
+			if eq <= 0 {
+				return sample, fmt.Errorf("invalid labels in sample of %s", sample.Name)
+			}
+			key := strings.TrimSpace(line[:eq])
+			line = line[eq+2:]
+
+			var value strings.Builder
+			var closed bool
+			for i := 0; i < len(line); i++ {
+				c := line[i]
+				if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						value.WriteByte('\n')
+					default:
+						value.WriteByte(line[i])
+					}
+					continue
+				}
+				if c == '"' {
+					line, closed = line[i+1:], true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return sample, fmt.Errorf("unterminated label value in sample of %s", sample.Name)
+			}
+			sample.Labels[key] = value.String()
+		}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("invalid value in sample of %s", sample.Name)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value in sample of %s: %w", sample.Name, err)
+	}
+	sample.Value = value
+	return sample, nil
+}
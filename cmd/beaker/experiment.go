@@ -2,17 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/allenai/beaker/config"
+	"github.com/allenai/bytefmt"
 	"github.com/beaker/client/api"
 	"github.com/beaker/client/client"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 func newExperimentCommand() *cobra.Command {
@@ -20,68 +27,505 @@ func newExperimentCommand() *cobra.Command {
 		Use:   "experiment <command>",
 		Short: "Manage experiments",
 	}
+	cmd.AddCommand(newExperimentAwaitCommand())
+	cmd.AddCommand(newExperimentBoostCommand())
 	cmd.AddCommand(newExperimentCreateCommand())
 	cmd.AddCommand(newExperimentDeleteCommand())
+	cmd.AddCommand(newExperimentEstimateCommand())
 	cmd.AddCommand(newExperimentExecutionsCommand())
+	cmd.AddCommand(newExperimentFallbackCommand())
 	cmd.AddCommand(newExperimentGroupsCommand())
 	cmd.AddCommand(newExperimentGetCommand())
+	cmd.AddCommand(newExperimentInitCommand())
+	cmd.AddCommand(newExperimentLintCommand())
+	cmd.AddCommand(newExperimentLogSinksCommand())
+	cmd.AddCommand(newExperimentLogsCommand())
+	cmd.AddCommand(newExperimentPatchCommand())
 	cmd.AddCommand(newExperimentRenameCommand())
+	cmd.AddCommand(newExperimentResubmitCommand())
+	cmd.AddCommand(newExperimentResultsCommand())
 	cmd.AddCommand(newExperimentResumeCommand())
 	cmd.AddCommand(newExperimentSpecCommand())
 	cmd.AddCommand(newExperimentStopCommand())
 	cmd.AddCommand(newExperimentTasksCommand())
+	cmd.AddCommand(newExperimentValidateCommand())
+	return cmd
+}
+
+func newExperimentAwaitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "await <experiment...>",
+		Aliases: []string{"wait"},
+		Short:   "Wait for one or more experiments to finish",
+		Long: `Wait for one or more experiments to finish.
+
+Prints a summary of all executions once every experiment has finished. Exits
+with an error if any execution did not succeed or the timeout expired.`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	var timeout time.Duration
+	var interval time.Duration
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum time to wait, e.g. 2h. Waits indefinitely if unset")
+	cmd.Flags().DurationVar(&interval, "interval", 10*time.Second, "Time between status checks")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+
+		ctx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		if !quiet && !progressJSON {
+			fmt.Fprintf(os.Stderr, "Waiting for %d experiment(s) to finish...\n", len(args))
+		}
+
+		pending := append([]string{}, args...)
+		finished := make(map[string][]api.Execution, len(args))
+		emitProgress(progressEvent{Operation: "await", Event: progressStart, ExperimentsTotal: len(args)})
+		delay := time.NewTimer(0) // When to poll experiment status.
+		for len(pending) != 0 {
+			select {
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf("timed out after %v waiting for %s", timeout, strings.Join(pending, ", "))
+				}
+				return ctx.Err()
+
+			case <-delay.C:
+				var stillPending []string
+				for _, name := range pending {
+					experiment, err := beaker.Experiment(name).Get(ctx)
+					if err != nil {
+						return err
+					}
+
+					var executions []api.Execution
+					done := true
+					for _, execution := range experiment.Executions {
+						executions = append(executions, *execution)
+						if execution.State.Finalized == nil {
+							done = false
+						}
+					}
+
+					if !done {
+						stillPending = append(stillPending, name)
+						continue
+					}
+					finished[name] = executions
+					emitProgress(progressEvent{
+						Operation: "await",
+						Event:     progressFinished,
+						ID:        experiment.ID,
+						Status:    executionsStatus(executions),
+					})
+					if !quiet && !progressJSON {
+						fmt.Fprintf(os.Stderr, "Experiment %s finished: %s\n",
+							color.BlueString(experiment.ID), executionsStatus(executions))
+					}
+				}
+				pending = stillPending
+				emitProgress(progressEvent{
+					Operation:        "await",
+					Event:            progressUpdate,
+					Experiments:      len(args) - len(pending),
+					ExperimentsTotal: len(args),
+				})
+				delay.Reset(interval)
+			}
+		}
+
+		emitProgress(progressEvent{
+			Operation:        "await",
+			Event:            progressDone,
+			Experiments:      len(args),
+			ExperimentsTotal: len(args),
+		})
+
+		var executions []api.Execution
+		for _, name := range args {
+			executions = append(executions, finished[name]...)
+		}
+		if err := printExecutions(executions); err != nil {
+			return err
+		}
+
+		var failed int
+		for _, execution := range executions {
+			if executionStatus(execution.State) != "succeeded" {
+				failed++
+			}
+		}
+		if failed != 0 {
+			return fmt.Errorf("%d of %d task(s) did not succeed", failed, len(executions))
+		}
+		return nil
+	}
+	return cmd
+}
+
+func newExperimentBoostCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "boost <experiment>",
+		Short: "Move an experiment's queued tasks to the front of their clusters' queues",
+		Long: `Move an experiment's queued tasks to the front of their clusters' queues.
+
+Boosting raises the priority of each queued execution and requires permission
+to manage the cluster on which it runs. Changes are attributed to the caller
+by the service so that preferential scheduling remains auditable.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var priority string
+	cmd.Flags().StringVarP(&priority, "priority", "p", string(api.UrgentPriority), "Priority to assign to queued tasks")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		experiment, err := beaker.Experiment(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		var boosted int
+		for _, execution := range experiment.Executions {
+			if execution.State.Scheduled != nil || execution.State.Finalized != nil || execution.State.Canceled != nil {
+				continue
+			}
+
+			if err := beaker.Cluster(execution.Spec.Context.Cluster).PatchExecution(
+				ctx,
+				execution.ID,
+				api.ExecutionPatchSpec{Priority: api.Priority(priority)},
+			); err != nil {
+				return err
+			}
+			boosted++
+
+			if !quiet {
+				fmt.Printf("Boosted %s to %s priority\n", color.BlueString(execution.ID), priority)
+			}
+		}
+
+		if boosted == 0 && !quiet {
+			fmt.Println("No queued tasks to boost.")
+		}
+		return nil
+	}
 	return cmd
 }
 
 func newExperimentCreateCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "create <spec-file>",
+		Use:   "create [spec-file]",
 		Short: "Create a new experiment",
-		Args:  cobra.ExactArgs(1),
+		Long: `Create a new experiment
+
+Specs are Go templates. Values passed with --arg, such as --arg lr=0.1, are
+referenced as {{.Args.lr}}. A top-level "sweep" section maps parameter names
+to lists of values, and each combination creates a separate experiment with
+its parameters set as environment variables on every task:
+
+    sweep:
+      lr: [0.1, 0.01]
+      seed: [1, 2, 3]
+
+Before anything is created, the spec is checked as with 'beaker experiment
+validate'. Use --dry-run to only check the spec.
+
+The name may be a template using .Args, .Sweep, .Index, .Date, .Time, .File,
+and .Env, e.g. --name "sweep-{{.Date}}-lr{{.Args.lr}}".
+
+Specs may also include "logSinks" and "fallback" sections; see 'beaker
+experiment log-sinks' and 'beaker experiment fallback'.`,
+		Args: cobra.MaximumNArgs(1),
 	}
 
 	var name string
 	var workspace string
 	var priority string
+	var group string
+	var sweepTasks bool
+	var dryRun bool
+	var skipVerify bool
+	var spool bool
+	var notify []string
+	var watch bool
+	var clusters []string
+	var fallbackAfter time.Duration
+	var templatePath string
+	var templateArgs []string
+	var maxCost float64
+	var allowUnpriced bool
 	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the experiment")
 	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace where the experiment will be placed")
 	cmd.Flags().StringVarP(&priority, "priority", "p", "", "Assign an execution priority to the experiment")
+	cmd.Flags().StringVarP(&group, "group", "g", "", "Create a group with this name containing all created experiments")
+	cmd.Flags().BoolVar(&sweepTasks, "sweep-tasks", false, "Expand a sweep into tasks of a single experiment")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate the spec without creating an experiment")
+	cmd.Flags().BoolVar(&skipVerify, "skip-verify", false, "Don't check the spec's references before creating it")
+	cmd.Flags().BoolVar(&spool, "spool", false, "Save the submission to submit later if Beaker can't be reached")
+	cmd.Flags().StringArrayVar(&notify, "notify", nil,
+		"Notify when experiments finish: slack://<webhook>, a webhook URL, email:<address>, or desktop; may be repeated")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Wait for the created experiments, moving them along fallback chains and sending notifications")
+	cmd.Flags().StringSliceVar(&clusters, "clusters", nil, "Clusters to try in order, moving on when tasks stay queued")
+	cmd.Flags().DurationVar(&fallbackAfter, "fallback-after", 0,
+		fmt.Sprintf("Time to wait for tasks to be scheduled before falling back (default %s)", defaultFallbackAfter))
+	cmd.Flags().StringVar(&templatePath, "template", "", "Spec template to create experiments from, instead of a spec file argument")
+	cmd.Flags().StringArrayVar(&templateArgs, "arg", nil, "Template argument as name=value; may be repeated")
+	cmd.Flags().Float64Var(&maxCost, "max-cost", 0, "Don't create experiments estimated to cost more than this many USD")
+	cmd.Flags().BoolVar(&allowUnpriced, "allow-unpriced", false, "With --max-cost, leave tasks which can't be priced out of the estimate")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		specFile, err := openPath(args[0])
+		specPath := templatePath
+		switch {
+		case templatePath != "" && len(args) != 0:
+			return usageError{fmt.Errorf("pass either a spec file or --template, not both")}
+		case templatePath == "" && len(args) == 0:
+			return usageError{fmt.Errorf("pass a spec file or --template")}
+		case len(args) != 0:
+			specPath = args[0]
+		}
+		templateValues, err := parseTemplateArgs(templateArgs)
 		if err != nil {
+			return usageError{err}
+		}
+		if err := validateNotifyMethods(notify); err != nil {
 			return err
 		}
+		if fallbackAfter < 0 {
+			return fmt.Errorf("fallback-after must be positive")
+		}
+		if maxCost < 0 {
+			return usageError{fmt.Errorf("max-cost must be positive")}
+		}
+		var chain *fallbackChain
+		if len(clusters) != 0 {
+			chain = &fallbackChain{Clusters: clusters}
+			if err := chain.validate(); err != nil {
+				return err
+			}
+		}
 
-		if workspace, err = ensureWorkspace(workspace); err != nil {
+		specFile, err := openPath(specPath)
+		if err != nil {
 			return err
 		}
 
-		rawSpec, err := readSpec(specFile)
+		specTemplate, err := ioutil.ReadAll(specFile)
 		if err != nil {
 			return err
 		}
 
-		experiment, err := beaker.Workspace(workspace).CreateExperimentRaw(
-			ctx,
-			"application/x-yaml",
-			bytes.NewReader(rawSpec),
-			&client.ExperimentOpts{Name: name})
+		runs, err := expandSpec(string(specTemplate), templateValues, sweepTasks)
+		if err != nil {
+			return err
+		}
+		logSinks, err := extractRunLogSinks(runs)
 		if err != nil {
 			return err
 		}
+		fallbacks, err := extractRunFallbacks(runs, chain)
+		if err != nil {
+			return err
+		}
+		if fallbackAfter != 0 {
+			for _, runChain := range fallbacks {
+				if runChain != nil {
+					runChain.After = fallbackAfter.String()
+				}
+			}
+		}
+		if err := enforceImagePolicy(runs, fallbacks); err != nil {
+			return err
+		}
+		if watch && len(notify) == 0 && !hasFallbacks(fallbacks) {
+			return usageError{fmt.Errorf("--watch requires --notify or a fallback chain")}
+		}
 
-		if quiet {
-			fmt.Println(experiment.ID)
-		} else {
-			fmt.Printf("Experiment %s submitted. See progress at %s/ex/%s\n",
-				color.BlueString(experiment.ID), beaker.Address(), experiment.ID)
+		if name == "" {
+			name = beakerConfig.ExperimentName
+		}
+		var names []string
+		if strings.Contains(name, "{{") {
+			if names, err = renderExperimentNames(name, specPath, templateValues, runs); err != nil {
+				return err
+			}
+			name = ""
+		}
+
+		sub := newSubmission(specPath, workspace, name, group, runs, logSinks)
+		sub.Notify = notify
+		for i := range names {
+			sub.Runs[i].Name = names[i]
+		}
+		for i := range fallbacks {
+			sub.Runs[i].Fallback = fallbacks[i]
+		}
+
+		// spoolOrFail saves the submission to the spool if err means Beaker
+		// couldn't be reached and --spool is set.
+		spoolOrFail := func(err error) error {
+			if !spool || dryRun || !isUnreachable(err) {
+				return err
+			}
+			if spoolErr := writeSpool(sub); spoolErr != nil {
+				return fmt.Errorf("couldn't spool submission after error: %v: %w", err, spoolErr)
+			}
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Couldn't reach Beaker: %v\n", err)
+				fmt.Printf("Spooled %d experiment(s) as %s. Submit them later with 'beaker spool flush'\n",
+					len(sub.Runs), color.BlueString(sub.ID))
+			}
+			return nil
+		}
+
+		if sub.Workspace, err = resolveWorkspace(workspace, api.Write); err != nil {
+			if workspace == "" {
+				workspace = beakerConfig.DefaultWorkspace
+			}
+			sub.Workspace, sub.Verify = workspace, !skipVerify
+			return spoolOrFail(err)
+		}
+
+		if dryRun {
+			return reportValidation(runs, sub.Workspace)
+		}
+		if !skipVerify {
+			problems, err := validateRuns(runs, sub.Workspace)
+			if err != nil {
+				sub.Verify = true
+				return spoolOrFail(err)
+			}
+			if problems != 0 {
+				return fmt.Errorf("spec has %d problem(s); nothing was created", problems)
+			}
+			// Runs are validated against the first cluster of their chains.
+			for _, runChain := range fallbacks {
+				if runChain == nil {
+					continue
+				}
+				if err := runChain.verifyClusters(); err != nil {
+					return err
+				}
+				if chain != nil {
+					break // Every run shares the chain from --clusters.
+				}
+			}
+		}
+
+		if cmd.Flags().Changed("max-cost") {
+			if err := checkMaxCost(runs, maxCost, allowUnpriced); err != nil {
+				return err
+			}
+		}
+
+		if err := sub.submit(); err != nil {
+			return spoolOrFail(err)
+		}
+		if watch {
+			return watchExperiments(sub.Created, defaultWatchInterval, len(sub.Fallbacks) != 0, len(notify) != 0)
 		}
+		sub.printWatchHint()
 		return nil
 	}
 	return cmd
 }
 
+// checkMaxCost returns an error if the estimated total cost of runs is over
+// maxCost.
+func checkMaxCost(runs []sweepRun, maxCost float64, allowUnpriced bool) error {
+	estimates, err := estimateRuns(runs)
+	if err != nil {
+		return fmt.Errorf("couldn't estimate cost: %w", err)
+	}
+	return checkEstimatedCost(estimates, maxCost, allowUnpriced)
+}
+
+// checkEstimatedCost returns an error if the total of estimates is over
+// maxCost, or if any task couldn't be priced unless allowUnpriced is set.
+func checkEstimatedCost(estimates []taskEstimate, maxCost float64, allowUnpriced bool) error {
+	total, unknown := estimateTotal(estimates)
+	if unknown != 0 {
+		if !allowUnpriced {
+			return fmt.Errorf("%d task(s) couldn't be priced, so the cost can't be checked; "+
+				"pass --allow-unpriced to leave them out; nothing was created", unknown)
+		}
+		// Warn even with --quiet since the limit may be exceeded.
+		fmt.Fprintf(os.Stderr, "Warning: %d task(s) couldn't be priced and aren't included in the estimated cost\n", unknown)
+	}
+	if total > maxCost {
+		return fmt.Errorf("estimated cost $%.2f is over --max-cost $%.2f; nothing was created", total, maxCost)
+	}
+	return nil
+}
+
+// renderExperimentNames renders a name template for each experiment of a
+// submission. Names which aren't unique are suffixed with their index.
+func renderExperimentNames(text, source string, templateArgs map[string]string, runs []sweepRun) ([]string, error) {
+	nameTemplate, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+
+	envVars := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		envVars[parts[0]] = parts[1]
+	}
+	file := filepath.Base(source)
+	if source == "-" {
+		file = "stdin"
+	}
+	file = strings.TrimSuffix(file, filepath.Ext(file))
+
+	type nameParams struct {
+		Args  map[string]string
+		Sweep map[string]string
+		Index int
+		Date  string
+		Time  string
+		File  string
+		Env   map[string]string
+	}
+	now := time.Now()
+	names := make([]string, len(runs))
+	counts := map[string]int{}
+	for i, run := range runs {
+		sweep := run.Point.values
+		if sweep == nil {
+			sweep = map[string]string{}
+		}
+		var buf strings.Builder
+		if err := nameTemplate.Execute(&buf, nameParams{
+			Args:  mergeTemplateArgs(templateArgs, sweep),
+			Sweep: sweep,
+			Index: i,
+			Date:  now.Format("20060102"),
+			Time:  now.Format("150405"),
+			File:  file,
+			Env:   envVars,
+		}); err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+		names[i] = strings.TrimSpace(buf.String())
+		if names[i] == "" {
+			return nil, fmt.Errorf("name template %q renders an empty name", text)
+		}
+		counts[names[i]]++
+	}
+	for i := range names {
+		if counts[names[i]] > 1 {
+			names[i] = fmt.Sprintf("%s-%d", names[i], i)
+		}
+	}
+	return names, nil
+}
+
 func newExperimentDeleteCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "delete <experiment>",
@@ -98,6 +542,66 @@ func newExperimentDeleteCommand() *cobra.Command {
 	}
 }
 
+func newExperimentEstimateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "estimate <spec-file>",
+		Short: "Estimate the cost and queue wait of an experiment spec",
+		Long: `Estimate the cost and queue wait of an experiment spec
+
+Each task's runtime is estimated as the median runtime of similar tasks among
+your recent experiments: those which ran on the same cluster with the same task
+name or image. Its queue wait is the median time your recent tasks on the
+cluster waited to start. Cost is the runtime times the task's share of a node
+times the cluster's node cost, so it's only estimated for clusters with
+pricing, such as cloud clusters, and tasks with history.
+
+Estimates are a guide only; they don't account for preemption or retries.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var templateArgs []string
+	var sweepTasks bool
+	cmd.Flags().StringArrayVar(&templateArgs, "arg", nil, "Template argument as name=value; may be repeated")
+	cmd.Flags().BoolVar(&sweepTasks, "sweep-tasks", false, "Expand a sweep into tasks of a single experiment")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		templateValues, err := parseTemplateArgs(templateArgs)
+		if err != nil {
+			return usageError{err}
+		}
+
+		specFile, err := openPath(args[0])
+		if err != nil {
+			return err
+		}
+		specTemplate, err := ioutil.ReadAll(specFile)
+		if err != nil {
+			return err
+		}
+		runs, err := expandSpec(string(specTemplate), templateValues, sweepTasks)
+		if err != nil {
+			return err
+		}
+
+		estimates, err := estimateRuns(runs)
+		if err != nil {
+			return err
+		}
+		if err := printTaskEstimates(estimates); err != nil {
+			return err
+		}
+		if format != formatJSON && format != formatYAML && !quiet {
+			total, unknown := estimateTotal(estimates)
+			fmt.Printf("\nEstimated total cost: $%.2f\n", total)
+			if unknown != 0 {
+				fmt.Printf("%d task(s) couldn't be priced and aren't included.\n", unknown)
+			}
+		}
+		return nil
+	}
+	return cmd
+}
+
 func newExperimentExecutionsCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "executions <experiment>",
@@ -163,90 +667,1234 @@ func newExperimentGetCommand() *cobra.Command {
 	}
 }
 
-func newExperimentRenameCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "rename <experiment> <name>",
-		Short: "Rename an experiment",
-		Args:  cobra.ExactArgs(2),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			experiment := beaker.Experiment(args[0])
-			if err := experiment.SetName(ctx, args[1]); err != nil {
-				return err
-			}
+func newExperimentInitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Interactively write a new experiment spec",
+		Long: `Interactively write a new experiment spec.
 
-			exp, err := experiment.Get(ctx)
-			if err != nil {
+Images, datasets, and clusters are completed against the workspace: type a
+unique prefix to expand it, or end an answer with "?" to list matches.`,
+		Args: cobra.NoArgs,
+	}
+
+	var output string
+	var workspace string
+	cmd.Flags().StringVarP(&output, "output", "o", "spec.yaml", "File to write the spec to")
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace used to complete images and datasets")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(output); err == nil {
+			ok, err := confirm(fmt.Sprintf("%s already exists. Overwrite it?", output))
+			if err != nil || !ok {
 				return err
 			}
+		}
 
-			if quiet {
-				fmt.Println(exp.ID)
-			} else {
-				fmt.Printf("Renamed %s to %s\n", color.BlueString(exp.ID), exp.FullName)
+		if workspace == "" {
+			workspace = beakerConfig.DefaultWorkspace
+		}
+		var images, datasets, clusters []string
+		if workspace != "" {
+			var err error
+			if images, datasets, clusters, err = initCandidates(workspace); err != nil {
+				return err
 			}
-			return nil
-		},
-	}
-}
+		}
 
-func newExperimentResumeCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "resume <experiment>",
-		Short: "Resume a preempted experiment",
-		Args:  cobra.ExactArgs(1),
-	}
+		p := newPrompter()
+		task := api.TaskSpecV2{Name: "main"}
 
-	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		if err := beaker.Experiment(args[0]).Resume(ctx); err != nil {
+		image, err := p.askWithCompletion("Image (beaker://<image> or docker://<tag>)", "", images)
+		if err != nil {
 			return err
 		}
+		switch {
+		case strings.HasPrefix(image, "docker://"):
+			task.Image.Docker = strings.TrimPrefix(image, "docker://")
+		case strings.HasPrefix(image, "beaker://"):
+			task.Image.Beaker = strings.TrimPrefix(image, "beaker://")
+		case image == "":
+			return fmt.Errorf("an image is required")
+		default:
+			task.Image.Beaker = image
+		}
 
-		return nil
-	}
-	return cmd
-}
+		command, err := p.ask("Command (leave empty to use the image's default)", "")
+		if err != nil {
+			return err
+		}
+		task.Command = strings.Fields(command)
 
-func newExperimentSpecCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "spec <experiment>",
-		Short: "Get the spec of an experiment as YAML",
-		Args:  cobra.ExactArgs(1),
-	}
+		for {
+			dataset, err := p.askWithCompletion("Dataset to mount (leave empty when done)", "", datasets)
+			if err != nil {
+				return err
+			}
+			if dataset == "" {
+				break
+			}
 
-	var version string
-	cmd.Flags().StringVar(&version, "version", "v2-alpha", "Spec version: v1 or v2-alpha")
+			mountPath, err := p.ask("  Mount path", "/data")
+			if err != nil {
+				return err
+			}
+			task.Datasets = append(task.Datasets, api.DataMount{
+				MountPath: mountPath,
+				Source:    api.DataSource{Beaker: dataset},
+			})
+		}
 
-	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		spec, err := beaker.Experiment(args[0]).Spec(ctx, version, format == formatJSON)
-		if err != nil {
+		if task.Result.Path, err = p.ask("Result path", "/output"); err != nil {
 			return err
 		}
-		defer spec.Close()
 
-		_, err = io.Copy(os.Stdout, spec)
-		return err
+		resources := &api.ResourceRequest{}
+		for {
+			gpus, err := p.ask("GPUs", "0")
+			if err != nil {
+				return err
+			}
+			if resources.GPUCount, err = strconv.Atoi(gpus); err == nil && resources.GPUCount >= 0 {
+				break
+			}
+			fmt.Println("  Please enter a whole number.")
+		}
+		for {
+			cpus, err := p.ask("CPUs (leave empty for no minimum)", "")
+			if err != nil {
+				return err
+			}
+			if cpus == "" {
+				break
+			}
+			if resources.CPUCount, err = strconv.ParseFloat(cpus, 64); err == nil && resources.CPUCount >= 0 {
+				break
+			}
+			fmt.Println("  Please enter a number, e.g. 7.5")
+		}
+		for {
+			memory, err := p.ask("Memory (leave empty for no minimum)", "")
+			if err != nil {
+				return err
+			}
+			if memory == "" {
+				break
+			}
+			if resources.Memory, err = bytefmt.Parse(memory); err == nil {
+				break
+			}
+			fmt.Println("  Please enter a size, e.g. 6.5GiB")
+		}
+		if resources.GPUCount != 0 || resources.CPUCount != 0 || resources.Memory != nil {
+			task.Resources = resources
+		}
+
+		for task.Context.Cluster == "" {
+			if task.Context.Cluster, err = p.askWithCompletion("Cluster", "", clusters); err != nil {
+				return err
+			}
+		}
+
+		spec := api.ExperimentSpecV2{Version: "v2-alpha", Tasks: []api.TaskSpecV2{task}}
+		b, err := yaml.Marshal(spec)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(output, b, 0644); err != nil {
+			return err
+		}
+
+		if !quiet {
+			fmt.Printf("\nWrote %s. Submit it with: beaker experiment create %s\n", color.GreenString(output), output)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// initCandidates returns names used to complete answers to experiment init.
+// Only the first page of images and datasets is fetched to keep prompts fast.
+func initCandidates(workspace string) (images, datasets, clusters []string, err error) {
+	imagePage, _, err := beaker.Workspace(workspace).Images(ctx, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, image := range imagePage {
+		if image.FullName != "" {
+			images = append(images, "beaker://"+image.FullName)
+		}
+	}
+
+	committed := true
+	datasetPage, _, err := beaker.Workspace(workspace).Datasets(ctx, &client.ListDatasetOptions{
+		CommittedOnly: &committed,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, dataset := range datasetPage {
+		if dataset.FullName != "" {
+			datasets = append(datasets, dataset.FullName)
+		}
+	}
+
+	info, err := beaker.Workspace(workspace).Get(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var cursor string
+	for {
+		var page []api.Cluster
+		page, cursor, err = beaker.ListClusters(ctx, info.Owner.Name, &client.ListClusterOptions{Cursor: cursor})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, cluster := range page {
+			clusters = append(clusters, cluster.FullName)
+		}
+		if cursor == "" {
+			break
+		}
+	}
+	return images, datasets, clusters, nil
+}
+
+func newExperimentLintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check an experiment spec against a policy",
+		Long: `Check an experiment spec against a policy without creating it.
+
+A policy is a YAML file with any of the following rules:
+
+    requireTimeout: true
+    allowedImages: ["beaker://ai2/*", "docker://nvidia/cuda:*"]
+    resultPath: /output
+
+Exits with an error if any task violates the policy.`,
+		Args: cobra.NoArgs,
+	}
+
+	var specPath string
+	var policyPath string
+	var templateArgs []string
+	cmd.Flags().StringVarP(&specPath, "file", "f", "", "Experiment spec to check, or \"-\" for STDIN")
+	cmd.Flags().StringVar(&policyPath, "policy", "", "Policy file to enforce")
+	cmd.Flags().StringArrayVar(&templateArgs, "arg", nil, "Template argument as name=value; may be repeated")
+	_ = cmd.MarkFlagRequired("file")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		templateValues, err := parseTemplateArgs(templateArgs)
+		if err != nil {
+			return usageError{err}
+		}
+
+		policy := &specPolicy{}
+		if policyPath != "" {
+			if policy, err = readPolicy(policyPath); err != nil {
+				return err
+			}
+		}
+
+		specFile, err := openPath(specPath)
+		if err != nil {
+			return err
+		}
+
+		specTemplate, err := ioutil.ReadAll(specFile)
+		if err != nil {
+			return err
+		}
+
+		runs, err := expandSpec(string(specTemplate), templateValues, false)
+		if err != nil {
+			return err
+		}
+
+		var violations int
+		for _, run := range runs {
+			tasks, err := parseValidateTasks(run.Spec)
+			if err != nil {
+				return err
+			}
+
+			for _, task := range tasks {
+				name := task.Name
+				if name == "" {
+					name = task.Path
+				}
+				for _, problem := range policy.lint(task) {
+					violations++
+					if len(run.Point.names) != 0 {
+						fmt.Printf("%s (%s): %s\n", name, run.Point, problem)
+					} else {
+						fmt.Printf("%s: %s\n", name, problem)
+					}
+				}
+			}
+		}
+
+		if violations != 0 {
+			return fmt.Errorf("spec has %d policy violation(s)", violations)
+		}
+		if !quiet {
+			fmt.Println("Spec passed all checks.")
+		}
+		return nil
+	}
+	return cmd
+}
+
+func newExperimentLogSinksCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "log-sinks <experiment>",
+		Short: "List, configure, or forward to an experiment's log sinks",
+		Long: `List, configure, or forward to an experiment's log sinks
+
+Log sinks are set by the "logSinks" section of a spec when an experiment is
+created, or with --add and --remove. Sinks are written as <kind>=<value>:
+
+    file=<path>       Append logs to a local file
+    dataset=<name>    Create a dataset of logs in the experiment's workspace
+    gcs=gs://<path>   Upload logs to GCS with gsutil
+    url=<endpoint>    POST logs to an HTTP endpoint as they're written
+
+With --forward, the experiment's logs are followed until it finishes and each
+line is sent to every sink as newline-delimited JSON. Sinks are stored on this
+machine, so logs must be forwarded from where they were configured.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var add []string
+	var remove []string
+	var forward bool
+	var interval time.Duration
+	cmd.Flags().StringArrayVar(&add, "add", nil, "Add a sink, e.g. file=logs.ndjson")
+	cmd.Flags().StringArrayVar(&remove, "remove", nil, "Remove a sink")
+	cmd.Flags().BoolVar(&forward, "forward", false, "Forward logs to every sink until the experiment finishes")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "How often to check for new logs with --forward")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		experiment, err := beaker.Experiment(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		sinks, err := readLogSinks(experiment.ID)
+		if err != nil {
+			return err
+		}
+		if len(add) != 0 || len(remove) != 0 {
+			for _, s := range remove {
+				sink, err := parseLogSink(s)
+				if err != nil {
+					return err
+				}
+				var kept []logSink
+				for _, existing := range sinks {
+					if existing != sink {
+						kept = append(kept, existing)
+					}
+				}
+				if len(kept) == len(sinks) {
+					return fmt.Errorf("experiment %s has no log sink %s", experiment.ID, sink)
+				}
+				sinks = kept
+			}
+			for _, s := range add {
+				sink, err := parseLogSink(s)
+				if err != nil {
+					return err
+				}
+				sinks = append(sinks, sink)
+			}
+			if err := writeLogSinks(experiment.ID, sinks); err != nil {
+				return fmt.Errorf("couldn't save log sinks: %w", err)
+			}
+		}
+
+		if !forward {
+			return printLogSinks(sinks)
+		}
+		if len(sinks) == 0 {
+			return fmt.Errorf("experiment %s has no log sinks", experiment.ID)
+		}
+		return forwardLogs(experiment, sinks, interval)
+	}
+	return cmd
+}
+
+func newExperimentLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <experiment>",
+		Short: "Print the logs of an experiment's tasks",
+		Long: `Print the logs of an experiment's tasks.
+
+Each line is prefixed by its task's name in a color of its own. Use --task to
+print only some tasks, chosen by name or by index in the spec counting from 0.
+Lines of different tasks are merged in order of the time they were written.
+With --follow and --timestamps, lines are held for an --interval so they stay
+in order as they arrive.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := addLogFlags(cmd)
+	var tasks []string
+	cmd.Flags().StringSliceVar(&tasks, "task", nil, "Only print the logs of these tasks, by name or index")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		experiment, err := beaker.Experiment(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		sources, err := findExperimentLogSources(experiment.ID, tasks)
+		if err != nil {
+			return err
+		}
+		return runLogs(sources, flags.options())
+	}
+	return cmd
+}
+
+func newExperimentPatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "patch <experiment>",
+		Short: "Update an experiment in place from a file",
+		Long: `Update an experiment in place from a file
+
+The file is YAML with any of the following fields:
+
+    name: my-experiment
+    description: Baseline with a larger batch size
+    priority: high
+
+Priority applies to tasks which haven't been scheduled yet. Each change is
+printed as a diff; use --dry-run to see changes without applying them.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var patchPath string
+	var dryRun bool
+	cmd.Flags().StringVarP(&patchPath, "file", "f", "", "File describing changes, or \"-\" for STDIN")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print changes without applying them")
+	_ = cmd.MarkFlagRequired("file")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		patchFile, err := openPath(patchPath)
+		if err != nil {
+			return err
+		}
+
+		var patch experimentPatch
+		dec := yaml.NewDecoder(patchFile)
+		dec.KnownFields(true)
+		if err := dec.Decode(&patch); err != nil {
+			return fmt.Errorf("invalid patch %s: %w", patchPath, err)
+		}
+		if patch.Priority != nil {
+			switch api.Priority(*patch.Priority) {
+			case api.UrgentPriority, api.HighPriority, api.NormalPriority, api.LowPriority:
+			default:
+				return fmt.Errorf("invalid priority %q", *patch.Priority)
+			}
+		}
+
+		handle := beaker.Experiment(args[0])
+		experiment, err := handle.Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		var changes int
+		diff := func(field, before, after string) {
+			if before == after {
+				return
+			}
+			changes++
+			fmt.Println(color.RedString("- %s: %s", field, before))
+			fmt.Println(color.GreenString("+ %s: %s", field, after))
+		}
+
+		if patch.Name != nil {
+			diff("name", experiment.Name, *patch.Name)
+		}
+		if patch.Description != nil {
+			diff("description", experiment.Description, *patch.Description)
+		}
+
+		var queued []*api.Execution
+		if patch.Priority != nil {
+			for _, execution := range experiment.Executions {
+				if execution.State.Scheduled != nil || execution.State.Finalized != nil || execution.State.Canceled != nil {
+					continue
+				}
+				before := string(execution.Spec.Context.Priority)
+				if before != *patch.Priority {
+					diff(fmt.Sprintf("priority (%s)", execution.ID), before, *patch.Priority)
+					queued = append(queued, execution)
+				}
+			}
+		}
+
+		if changes == 0 {
+			if !quiet {
+				fmt.Println("No changes.")
+			}
+			return nil
+		}
+		if dryRun {
+			return nil
+		}
+
+		if patch.Name != nil && *patch.Name != experiment.Name {
+			if err := handle.SetName(ctx, *patch.Name); err != nil {
+				return err
+			}
+		}
+		if patch.Description != nil && *patch.Description != experiment.Description {
+			if err := handle.SetDescription(ctx, *patch.Description); err != nil {
+				return err
+			}
+		}
+		for _, execution := range queued {
+			if err := beaker.Cluster(execution.Spec.Context.Cluster).PatchExecution(
+				ctx,
+				execution.ID,
+				api.ExecutionPatchSpec{Priority: api.Priority(*patch.Priority)},
+			); err != nil {
+				return err
+			}
+		}
+
+		if !quiet {
+			fmt.Printf("Applied %d change(s) to %s\n", changes, color.BlueString(experiment.ID))
+		}
+		return nil
+	}
+	return cmd
+}
+
+// experimentPatch describes the fields of an experiment which may be updated
+// in place. Unset fields are left unchanged.
+type experimentPatch struct {
+	Name        *string `yaml:"name"`
+	Description *string `yaml:"description"`
+	Priority    *string `yaml:"priority"`
+}
+
+func newExperimentRenameCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rename <experiment> <name>",
+		Short: "Rename an experiment",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			experiment := beaker.Experiment(args[0])
+			if err := experiment.SetName(ctx, args[1]); err != nil {
+				return err
+			}
+
+			exp, err := experiment.Get(ctx)
+			if err != nil {
+				return err
+			}
+
+			if quiet {
+				fmt.Println(exp.ID)
+			} else {
+				fmt.Printf("Renamed %s to %s\n", color.BlueString(exp.ID), exp.FullName)
+			}
+			return nil
+		},
+	}
+}
+
+func newExperimentResubmitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resubmit <experiment>",
+		Short: "Create a new experiment from an existing experiment's spec",
+		Long: `Create a new experiment from an existing experiment's spec
+
+The original spec may be changed before submitting. --image and --env apply to
+every task. --set assigns a value at a path within the spec, for example:
+
+    --set tasks[0].context.cluster=ai2/my-cluster
+    --set tasks[1].resources.gpuCount=2
+
+Values are parsed as YAML. The new experiment's description notes which
+experiment it was resubmitted from. Use --dry-run to print the new spec without
+creating an experiment.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var name string
+	var workspace string
+	var image string
+	var env []string
+	var sets []string
+	var dryRun bool
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the new experiment")
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace for the new experiment. Defaults to the original's workspace")
+	cmd.Flags().StringVar(&image, "image", "", "Image for every task, may be a Beaker or Docker image")
+	cmd.Flags().StringArrayVar(&env, "env", nil, "Environment variable for every task as KEY=VALUE, may be repeated")
+	cmd.Flags().StringArrayVar(&sets, "set", nil, "Set a value in the spec as path=value, may be repeated")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the new spec without creating an experiment")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		original, err := beaker.Experiment(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		r, err := beaker.Experiment(original.ID).Spec(ctx, "v2-alpha", false)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		var doc yaml.Node
+		if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+			return fmt.Errorf("failed to parse spec: %w", err)
+		}
+		root := documentRoot(&doc)
+		tasks := config.MappingValue(root, "tasks")
+		if tasks == nil || tasks.Kind != yaml.SequenceNode {
+			return fmt.Errorf("spec must contain a list of tasks")
+		}
+
+		for _, task := range tasks.Content {
+			if image != "" {
+				setTaskImage(task, image)
+			}
+			for _, kv := range env {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) != 2 || parts[0] == "" {
+					return fmt.Errorf("invalid environment variable %q; must be KEY=VALUE", kv)
+				}
+				setTaskEnvV2(task, parts[0], parts[1])
+			}
+		}
+		for _, set := range sets {
+			parts := strings.SplitN(set, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid --set %q; must be path=value", set)
+			}
+			if err := setSpecPath(root, parts[0], parts[1]); err != nil {
+				return fmt.Errorf("invalid --set %q: %w", set, err)
+			}
+		}
+
+		description := "Resubmitted from " + original.ID
+		if prior := scalarValue(config.MappingValue(root, "description")); prior != "" {
+			description = fmt.Sprintf("%s (resubmitted from %s)", prior, original.ID)
+		}
+		setMappingValue(root, "description", stringNode(description))
+
+		spec, err := yaml.Marshal(root)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			_, err := os.Stdout.Write(spec)
+			return err
+		}
+
+		if workspace == "" {
+			workspace = original.Workspace.ID
+		}
+		if workspace, err = resolveWorkspace(workspace, api.Write); err != nil {
+			return err
+		}
+
+		experiment, err := beaker.Workspace(workspace).CreateExperimentRaw(
+			ctx,
+			"application/x-yaml",
+			bytes.NewReader(spec),
+			&client.ExperimentOpts{Name: name})
+		if err != nil {
+			return err
+		}
+		recordRecent(recentExperiment, experiment.ID)
+		if quiet {
+			fmt.Println(experiment.ID)
+		} else {
+			fmt.Printf("Experiment %s resubmitted from %s. See progress at %s/ex/%s\n",
+				color.BlueString(experiment.ID), original.ID, beaker.Address(), experiment.ID)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// setTaskImage replaces a v2 task's image. References prefixed with
+// "docker://" are Docker images; all others are Beaker images.
+func setTaskImage(task *yaml.Node, ref string) {
+	source := &yaml.Node{Kind: yaml.MappingNode}
+	if strings.HasPrefix(ref, "docker://") {
+		setMappingValue(source, "docker", stringNode(strings.TrimPrefix(ref, "docker://")))
+	} else {
+		setMappingValue(source, "beaker", stringNode(strings.TrimPrefix(ref, "beaker://")))
+	}
+	setMappingValue(task, "image", source)
+}
+
+// setSpecPath sets a value at a path such as "tasks[0].context.cluster",
+// creating intermediate objects as needed. The value is parsed as YAML.
+func setSpecPath(root *yaml.Node, path, value string) error {
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return err
+	}
+	newValue := documentRoot(&parsed)
+	if parsed.Kind == 0 {
+		// An empty value parses to nothing, so set an empty string instead.
+		newValue = stringNode("")
+	}
+
+	node := root
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		key := segment
+		var indexes []int
+		if open := strings.Index(segment, "["); open != -1 {
+			key = segment[:open]
+			for rest := segment[open:]; rest != ""; {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end == -1 {
+					return fmt.Errorf("malformed path segment %q", segment)
+				}
+				index, err := strconv.Atoi(rest[1:end])
+				if err != nil || index < 0 {
+					return fmt.Errorf("invalid index in %q", segment)
+				}
+				indexes = append(indexes, index)
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" {
+			return fmt.Errorf("empty key in path %q", path)
+		}
+
+		last := i == len(segments)-1
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not an object", strings.Join(segments[:i], "."))
+		}
+		if last && len(indexes) == 0 {
+			setMappingValue(node, key, newValue)
+			return nil
+		}
+
+		child := config.MappingValue(node, key)
+		if child == nil {
+			if len(indexes) != 0 {
+				return fmt.Errorf("%s doesn't exist", key)
+			}
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(node, key, child)
+		}
+
+		for j, index := range indexes {
+			if child.Kind != yaml.SequenceNode {
+				return fmt.Errorf("%s is not a list", key)
+			}
+			if index >= len(child.Content) {
+				return fmt.Errorf("index %d of %s is out of range", index, key)
+			}
+			if last && j == len(indexes)-1 {
+				child.Content[index] = newValue
+				return nil
+			}
+			child = child.Content[index]
+		}
+		node = child
+	}
+	return nil
+}
+
+func newExperimentResultsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "results <experiment>",
+		Short: "List or fetch the results of an experiment's tasks",
+		Long: `List or fetch the results of an experiment's tasks
+
+The result dataset of the latest execution of every task is listed with its
+size.
+
+With --output, the results are downloaded to a directory per task within the
+output directory, keeping their paths within the result dataset. For example,
+to download the results of two tasks:
+
+    beaker experiment results my-experiment --task train,eval -o out
+
+Files are downloaded in parallel as with 'beaker dataset fetch', and files
+already downloaded are skipped. Use --verify to re-check a previous download.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var tasks []string
+	var outputPath string
+	var concurrency int
+	var verify bool
+	cmd.Flags().StringSliceVar(&tasks, "task", nil, "Only include these tasks, by name or index")
+	cmd.Flags().StringVarP(&outputPath, "output", "o", "", "Download the results to this directory")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "Number of files to download at a time")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Verify downloaded results instead of downloading")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if verify && outputPath == "" {
+			return usageError{fmt.Errorf("--verify requires --output")}
+		}
+
+		results, err := findTaskResults(args[0], tasks)
+		if err != nil {
+			return err
+		}
+		if outputPath == "" {
+			return printTaskResults(results)
+		}
+
+		return fetchTaskResults(results, outputPath, concurrency, verify)
+	}
+	return cmd
+}
+
+// taskResult is the result dataset of a task's latest execution.
+type taskResult struct {
+	Task    string `json:"task"`
+	Dataset string `json:"dataset"`
+	Files   int64  `json:"files"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// findTaskResults lists the results of the latest execution of each selected
+// task, or every task if none are selected.
+func findTaskResults(experimentID string, selected []string) ([]taskResult, error) {
+	tasks, err := beaker.Experiment(experimentID).Tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tasks, err = selectExperimentTasks(experimentID, tasks, selected); err != nil {
+		return nil, err
+	}
+
+	var results []taskResult
+	for _, task := range tasks {
+		name := task.Name
+		if name == "" {
+			name = task.ID
+		}
+		var dataset string
+		if len(task.Executions) != 0 {
+			dataset = task.Executions[len(task.Executions)-1].Result.Beaker
+		}
+		if dataset == "" {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Warning: task %s has no results yet\n", name)
+			}
+			continue
+		}
+		storage, _, err := beaker.Dataset(dataset).Storage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := listFiles(storage, fileFilter{})
+		if err != nil {
+			return nil, err
+		}
+
+		result := taskResult{Task: name, Dataset: dataset}
+		for _, info := range manifest {
+			result.Files++
+			result.Bytes += info.Size
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// fetchTaskResults downloads results to a directory per task within
+// outputPath, or with verify, checks a previous download.
+func fetchTaskResults(results []taskResult, outputPath string, concurrency int, verify bool) error {
+	for _, result := range results {
+		if !validTaskDir(result.Task) {
+			return fmt.Errorf("task name %q can't be used as a directory name", result.Task)
+		}
+	}
+
+	var total, failed int
+	for _, result := range results {
+		storage, _, err := beaker.Dataset(result.Dataset).Storage(ctx)
+		if err != nil {
+			return err
+		}
+		files, err := listFiles(storage, fileFilter{})
+		if err != nil {
+			return err
+		}
+		total += len(files)
+
+		target := filepath.Join(outputPath, result.Task)
+		if verify {
+			missing, modified, err := verifyFiles(files, target)
+			if err != nil {
+				return err
+			}
+			for _, file := range missing {
+				fmt.Println(color.YellowString("missing: ") + filepath.Join(result.Task, file))
+			}
+			for _, file := range modified {
+				fmt.Println(color.RedString("corrupt: ") + filepath.Join(result.Task, file))
+			}
+			failed += len(missing) + len(modified)
+			continue
+		}
+
+		if !quiet {
+			fmt.Printf("Downloading results of %s to %s\n", color.CyanString(result.Task), color.GreenString(target))
+		}
+		if err := downloadFiles(storage, files, target, concurrency); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case failed != 0:
+		return fmt.Errorf("%d of %d files failed verification", failed, total)
+	case quiet:
+	case verify:
+		fmt.Printf("Verified %d files of %d task(s) in %s\n", total, len(results), color.GreenString(outputPath))
+	default:
+		fmt.Printf("Fetched %d files of %d task(s) to %s\n", total, len(results), color.GreenString(outputPath))
+	}
+	return nil
+}
+
+// validTaskDir reports whether a task name can name a directory within the
+// output directory without escaping it.
+func validTaskDir(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// selectExperimentTasks returns the tasks selected by name, ID, or index, in
+// the experiment's order, or every task if none are selected.
+func selectExperimentTasks(experimentID string, tasks []api.Task, selected []string) ([]api.Task, error) {
+	if len(selected) == 0 {
+		return tasks, nil
+	}
+
+	include := make([]bool, len(tasks))
+	for _, ref := range selected {
+		found := false
+		for i, task := range tasks {
+			if task.Name == ref || task.ID == ref {
+				include[i], found = true, true
+			}
+		}
+		if index, err := strconv.Atoi(ref); !found && err == nil && index >= 0 && index < len(tasks) {
+			include[index], found = true, true
+		}
+		if !found {
+			return nil, fmt.Errorf("experiment %s has no task %q", experimentID, ref)
+		}
+	}
+
+	var result []api.Task
+	for i, task := range tasks {
+		if include[i] {
+			result = append(result, task)
+		}
+	}
+	return result, nil
+}
+
+func newExperimentResumeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resume <experiment>",
+		Short: "Resume a preempted experiment",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if err := beaker.Experiment(args[0]).Resume(ctx); err != nil {
+			return err
+		}
+
+		return nil
+	}
+	return cmd
+}
+
+func newExperimentSpecCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spec <experiment>",
+		Short: "Get the spec of an experiment as YAML",
+		Long: `Get the spec of an experiment as YAML
+
+By default the spec is printed as it was submitted. With --resolved, each
+task's spec is printed as it was run, with images, datasets, and clusters
+referred to by ID rather than by name. References by name can point to
+something new if an image or dataset is renamed or replaced, so the resolved
+spec records exactly what ran. Either can be passed to "experiment create".`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var version string
+	var resolved bool
+	cmd.Flags().StringVar(&version, "version", "v2-alpha", "Spec version: v1 or v2-alpha")
+	cmd.Flags().BoolVar(&resolved, "resolved", false, "Print the spec as it was run, with references resolved to IDs")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if resolved {
+			if version != "v2-alpha" {
+				return fmt.Errorf("--resolved is only supported with --version v2-alpha")
+			}
+			spec, err := resolvedExperimentSpec(args[0])
+			if err != nil {
+				return err
+			}
+			if format == formatJSON {
+				return printJSON(spec)
+			}
+			encoder := yaml.NewEncoder(os.Stdout)
+			encoder.SetIndent(2)
+			if err := encoder.Encode(spec); err != nil {
+				return err
+			}
+			return encoder.Close()
+		}
+
+		spec, err := beaker.Experiment(args[0]).Spec(ctx, version, format == formatJSON)
+		if err != nil {
+			return err
+		}
+		defer spec.Close()
+
+		_, err = io.Copy(os.Stdout, spec)
+		return err
 	}
 	return cmd
 }
 
+// resolvedExperimentSpec rebuilds an experiment's spec from the specs of each
+// task's latest execution, in which all references are resolved to IDs.
+func resolvedExperimentSpec(experimentRef string) (*api.ExperimentSpecV2, error) {
+	experiment, err := beaker.Experiment(experimentRef).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := beaker.Experiment(experiment.ID).Tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &api.ExperimentSpecV2{
+		Version:     "v2-alpha",
+		Description: experiment.Description,
+	}
+	for _, task := range tasks {
+		if len(task.Executions) == 0 {
+			return nil, fmt.Errorf("task %s hasn't been resolved yet; try again once it's scheduled", task.ID)
+		}
+		taskSpec := task.Executions[len(task.Executions)-1].Spec
+		if taskSpec.Name == "" {
+			taskSpec.Name = task.Name
+		}
+		spec.Tasks = append(spec.Tasks, taskSpec)
+	}
+	return spec, nil
+}
+
 func newExperimentStopCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "stop <experiment...>",
-		Short: "Stop one or more running experiments",
-		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			for _, name := range args {
-				if err := beaker.Experiment(name).Stop(ctx); err != nil {
-					// We want to stop as many of the requested experiments as possible.
-					// Therefore we print to STDERR here instead of returning.
-					fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+	cmd := &cobra.Command{
+		Use:     "stop [experiment...]",
+		Aliases: []string{"cancel"},
+		Short:   "Stop one or more running experiments",
+		Long: `Stop one or more running experiments
+
+Experiments may be given by name or ID, selected from a group with --group, or
+found by searching with --author and --name-prefix. --author, --status, and
+--name-prefix also narrow experiments given by name or group. For example, to
+stop every running experiment of a sweep:
+
+    beaker experiment stop --author me --status running --name-prefix sweep-
+
+Only experiments with unfinished tasks are stopped. If more than one is
+selected with --group or a search, they're listed for confirmation first;
+pass --yes to skip it, as is required when stdin isn't a terminal.`,
+		Args: cobra.ArbitraryArgs,
+	}
+
+	var group string
+	var author string
+	var status string
+	var namePrefix string
+	var yes bool
+	cmd.Flags().StringVarP(&group, "group", "g", "", "Stop experiments in a group")
+	cmd.Flags().StringVar(&author, "author", "", "Only stop experiments by this author, or \"me\"")
+	cmd.Flags().StringVar(&status, "status", "", "Only stop experiments with a task in this status: pending, starting, running, or uploading")
+	cmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Only stop experiments whose names start with this prefix")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Stop experiments without asking for confirmation")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		switch status {
+		case "", "pending", "starting", "running", "uploading":
+		default:
+			return fmt.Errorf("invalid status %q; must be pending, starting, running, or uploading", status)
+		}
+		selecting := group != "" || author != "" || status != "" || namePrefix != ""
+		if len(args) == 0 && group == "" && author == "" && namePrefix == "" {
+			return fmt.Errorf("pass experiments to stop, --group, or a search with --author or --name-prefix")
+		}
+
+		// Stop experiments given only by name without looking them up, as
+		// they always have been.
+		if !selecting && len(args) == 1 {
+			return stopExperiments(args)
+		}
+
+		var authorID string
+		if author == "me" {
+			user, err := beaker.WhoAmI(ctx)
+			if err != nil {
+				return err
+			}
+			author, authorID = user.Name, user.ID
+		}
+
+		var experiments []api.Experiment
+		for _, name := range args {
+			experiment, err := beaker.Experiment(name).Get(ctx)
+			if err != nil {
+				return err
+			}
+			experiments = append(experiments, *experiment)
+		}
+		if group != "" {
+			ids, err := beaker.Group(group).Experiments(ctx)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				experiment, err := beaker.Experiment(id).Get(ctx)
+				if err != nil {
+					return err
 				}
+				experiments = append(experiments, *experiment)
+			}
+		}
+		if len(args) == 0 && group == "" {
+			var err error
+			if experiments, err = searchExperiments(author, namePrefix); err != nil {
+				return err
+			}
+		}
 
-				fmt.Println(name)
+		var ids []string
+		var selected []api.Experiment
+		seen := make(map[string]bool)
+		for _, experiment := range experiments {
+			switch {
+			case seen[experiment.ID]:
+			case authorID != "" && experiment.Author.ID != authorID:
+			case authorID == "" && author != "" && experiment.Author.Name != author:
+			case namePrefix != "" && !strings.HasPrefix(experiment.Name, namePrefix):
+			case !hasExecutionStatus(experiment.Executions, status):
+			default:
+				ids = append(ids, experiment.ID)
+				selected = append(selected, experiment)
+			}
+			seen[experiment.ID] = true
+		}
+		if len(ids) == 0 {
+			if !quiet {
+				fmt.Println("No experiments to stop.")
 			}
 			return nil
-		},
+		}
+
+		if selecting && len(ids) > 1 && !yes {
+			if err := printExperiments(selected); err != nil {
+				return err
+			}
+			if err := tableOut.Flush(); err != nil {
+				return err
+			}
+			confirmed, err := confirmOrFail(fmt.Sprintf("\nStop %d experiment(s)?", len(ids)), "--yes")
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				return nil
+			}
+		}
+		return stopExperiments(ids)
+	}
+	return cmd
+}
+
+// stopExperiments stops each experiment, printing its name once stopped.
+func stopExperiments(names []string) error {
+	var failed int
+	for _, name := range names {
+		if err := beaker.Experiment(name).Stop(ctx); err != nil {
+			// We want to stop as many of the requested experiments as possible.
+			// Therefore we print to STDERR here instead of returning.
+			fmt.Fprintln(os.Stderr, color.RedString("Error:"), err)
+			failed++
+			continue
+		}
+
+		fmt.Println(name)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d experiment(s) couldn't be stopped", failed, len(names))
+	}
+	return nil
+}
+
+// searchExperiments finds experiments in any workspace by author name and a
+// name prefix, either of which may be empty. Names are matched by substring,
+// so callers must check the prefix.
+func searchExperiments(author, namePrefix string) ([]api.Experiment, error) {
+	var opts api.ExperimentSearchOptions
+	if author != "" {
+		opts.FilterClauses = append(opts.FilterClauses, api.ExperimentFilterClause{
+			Field: api.ExperimentAuthor, Operator: api.OpEqual, Value: author,
+		})
+	}
+	if namePrefix != "" {
+		opts.FilterClauses = append(opts.FilterClauses, api.ExperimentFilterClause{
+			Field: api.ExperimentName, Operator: api.OpContains, Value: namePrefix,
+		})
+	}
+
+	var experiments []api.Experiment
+	for page := 0; ; page++ {
+		results, err := beaker.SearchExperiments(ctx, opts, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return experiments, nil
+		}
+		experiments = append(experiments, results...)
+	}
+}
+
+// hasExecutionStatus returns whether any execution is unfinished and, if
+// status is set, in that status.
+func hasExecutionStatus(executions []*api.Execution, status string) bool {
+	for _, execution := range executions {
+		s := executionStatus(execution.State)
+		if s == "succeeded" || s == "failed" || execution.State.Canceled != nil {
+			continue
+		}
+		if status == "" || s == status {
+			return true
+		}
 	}
+	return false
 }
 
 func newExperimentTasksCommand() *cobra.Command {
@@ -264,33 +1912,90 @@ func newExperimentTasksCommand() *cobra.Command {
 	}
 }
 
-// readSpec reads an experiment spec from YAML.
-func readSpec(r io.Reader) ([]byte, error) {
-	b, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
+func newExperimentValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate <spec-file>",
+		Short: "Check an experiment spec without creating it",
+		Long: `Check an experiment spec without creating it
 
-	specTemplate, err := template.New("spec").Parse(string(b))
-	if err != nil {
-		return nil, err
+Parses the spec, confirms that referenced images, datasets, and clusters exist
+and are accessible, that datasets are committed, and checks resource requests
+against each cluster's node shape. Secrets are resolved in the workspace given
+by --workspace or the default workspace, and aren't checked if neither is set.
+All problems are reported at once along with their paths in the spec.
+
+Administrators may restrict the images used on each cluster by installing a
+policy at ` + imagePolicyPath + `:
+
+    clusters:
+      ai2/on-prem-*:
+        allow: ["beaker://ai2/*", "docker://nvcr.io/nvidia/*"]
+        deny: ["beaker://ai2/scratch-*"]
+
+A trailing "*" matches any suffix. Tasks on a cluster whose rules list allowed
+images may only use those images, and denied images are never allowed. The
+policy is enforced by 'beaker experiment create' even with --skip-verify.`,
+		Args: cobra.ExactArgs(1),
 	}
 
-	envVars := map[string]string{}
-	for _, kv := range os.Environ() {
-		parts := strings.SplitN(kv, "=", 2)
-		envVars[parts[0]] = parts[1]
+	var workspace string
+	var templateArgs []string
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Workspace in which to resolve secrets")
+	cmd.Flags().StringArrayVar(&templateArgs, "arg", nil, "Template argument as name=value; may be repeated")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		templateValues, err := parseTemplateArgs(templateArgs)
+		if err != nil {
+			return usageError{err}
+		}
+
+		specFile, err := openPath(args[0])
+		if err != nil {
+			return err
+		}
+
+		if workspace == "" {
+			workspace = beakerConfig.DefaultWorkspace
+		}
+		if workspace != "" {
+			if workspace, err = resolveWorkspace(workspace, api.Read); err != nil {
+				return err
+			}
+		}
+
+		specTemplate, err := ioutil.ReadAll(specFile)
+		if err != nil {
+			return err
+		}
+
+		runs, err := expandSpec(string(specTemplate), templateValues, false)
+		if err != nil {
+			return err
+		}
+		if _, err := extractRunLogSinks(runs); err != nil {
+			return err
+		}
+		if _, err := extractRunFallbacks(runs, nil); err != nil {
+			return err
+		}
+		return reportValidation(runs, workspace)
 	}
+	return cmd
+}
 
-	type templateParams struct {
-		Env map[string]string
+// reportValidation validates runs, returning an error if there were problems.
+func reportValidation(runs []sweepRun, workspace string) error {
+	problems, err := validateRuns(runs, workspace)
+	if err != nil {
+		return err
 	}
-	buf := &bytes.Buffer{}
-	if err := specTemplate.Execute(buf, templateParams{Env: envVars}); err != nil {
-		return nil, err
+	if problems != 0 {
+		return fmt.Errorf("spec has %d problem(s)", problems)
 	}
-
-	return buf.Bytes(), nil
+	if !quiet {
+		fmt.Println("Spec is valid.")
+	}
+	return nil
 }
 
 func openPath(p string) (io.Reader, error) {
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/allenai/beaker/config"
+	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// How long an experiment waits in a cluster's queue before falling back to
+// the next cluster, unless a spec or --fallback-after says otherwise.
+const defaultFallbackAfter = 30 * time.Minute
+
+// fallbackChain is an ordered list of clusters to run an experiment on. The
+// experiment is submitted to the first cluster and moved to the next if none
+// of its tasks have been scheduled within After.
+type fallbackChain struct {
+	Clusters []string `yaml:"clusters" json:"clusters"`
+	After    string   `yaml:"after,omitempty" json:"after,omitempty"`
+}
+
+func (c *fallbackChain) validate() error {
+	if len(c.Clusters) < 2 {
+		return errors.New("a fallback chain must list at least two clusters")
+	}
+	for _, cluster := range c.Clusters {
+		if cluster == "" {
+			return errors.New("fallback clusters must not be empty")
+		}
+	}
+	if c.After != "" {
+		after, err := time.ParseDuration(c.After)
+		if err != nil || after <= 0 {
+			return fmt.Errorf("invalid fallback time %q; must be a positive duration such as 30m", c.After)
+		}
+	}
+	return nil
+}
+
+// verifyClusters checks that every cluster of a chain exists.
+func (c *fallbackChain) verifyClusters() error {
+	for _, cluster := range c.Clusters {
+		if _, err := beaker.Cluster(cluster).Get(ctx); err != nil {
+			return errors.WithMessagef(err, "fallback cluster %s", cluster)
+		}
+	}
+	return nil
+}
+
+func (c *fallbackChain) after() time.Duration {
+	if after, err := time.ParseDuration(c.After); err == nil {
+		return after
+	}
+	return defaultFallbackAfter
+}
+
+// extractFallback removes the top-level "fallback" section from a rendered
+// spec, which the Beaker service doesn't accept. If a chain is given or
+// found, every task is assigned its first cluster.
+func extractFallback(spec []byte, chain *fallbackChain) ([]byte, *fallbackChain, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse spec")
+	}
+	root := documentRoot(&doc)
+	section := config.MappingValue(root, "fallback")
+	if section == nil && chain == nil {
+		return spec, nil, nil
+	}
+
+	if chain == nil {
+		chain = &fallbackChain{}
+		if err := section.Decode(chain); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid fallback")
+		}
+		if err := chain.validate(); err != nil {
+			return nil, nil, errors.WithMessage(err, "fallback")
+		}
+	}
+	deleteMappingKey(root, "fallback")
+	if err := setSpecCluster(root, chain.Clusters[0]); err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return spec, chain, nil
+}
+
+// extractRunFallbacks removes fallback sections from each run's spec and
+// returns the chains in the order of the runs. A chain given by flags applies
+// to every run.
+func extractRunFallbacks(runs []sweepRun, chain *fallbackChain) ([]*fallbackChain, error) {
+	chains := make([]*fallbackChain, len(runs))
+	for i := range runs {
+		spec, runChain, err := extractFallback(runs[i].Spec, chain)
+		if err != nil {
+			return nil, err
+		}
+		runs[i].Spec, chains[i] = spec, runChain
+	}
+	return chains, nil
+}
+
+// hasFallbacks returns whether any run has a fallback chain.
+func hasFallbacks(chains []*fallbackChain) bool {
+	for _, chain := range chains {
+		if chain != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setSpecCluster assigns every task of a spec to a cluster.
+func setSpecCluster(root *yaml.Node, cluster string) error {
+	tasks := config.MappingValue(root, "tasks")
+	if tasks == nil || tasks.Kind != yaml.SequenceNode {
+		return errors.New("spec must contain a list of tasks")
+	}
+	v2 := strings.HasPrefix(scalarValue(config.MappingValue(root, "version")), "v2")
+	for _, task := range tasks.Content {
+		if task.Kind != yaml.MappingNode {
+			return errors.New("tasks must be objects")
+		}
+		if !v2 {
+			setMappingValue(task, "cluster", stringNode(cluster))
+			continue
+		}
+		context := config.MappingValue(task, "context")
+		if context == nil || context.Kind != yaml.MappingNode {
+			context = &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(task, "context", context)
+		}
+		setMappingValue(context, "cluster", stringNode(cluster))
+	}
+	return nil
+}
+
+// fallbacksStateKind is the state directory of experiments' fallback chains.
+const fallbacksStateKind = "fallbacks"
+
+// fallbackState is what's needed to move an experiment to its next cluster.
+type fallbackState struct {
+	Workspace string        `json:"workspace"`
+	Name      string        `json:"name,omitempty"`
+	Spec      string        `json:"spec"`
+	Chain     fallbackChain `json:"chain"`
+
+	// Index in the chain of the cluster the experiment was submitted to.
+	Cluster   int       `json:"cluster"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// readFallbackState returns an experiment's fallback state, or nil if it has
+// no fallback chain.
+func readFallbackState(experimentID string) (*fallbackState, error) {
+	var state fallbackState
+	found, err := readState(fallbacksStateKind, experimentID, &state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeFallbackState(experimentID string, state *fallbackState) error {
+	return writeState(fallbacksStateKind, experimentID, state)
+}
+
+// fallBackIfQueued moves an experiment to the next cluster of its fallback
+// chain if none of its tasks were scheduled in time, returning the ID of the
+// experiment which replaced it. It returns true while the experiment may still
+// fall back.
+func fallBackIfQueued(experiment *api.Experiment) (string, bool, error) {
+	state, err := readFallbackState(experiment.ID)
+	if err != nil || state == nil {
+		return "", false, err
+	}
+	if state.Cluster+1 >= len(state.Chain.Clusters) || !allQueued(experiment.Executions) {
+		return "", false, nil
+	}
+	if time.Since(state.Submitted) < state.Chain.after() {
+		return "", true, nil
+	}
+
+	moved, err := fallBack(experiment, state)
+	if err != nil {
+		return "", false, err
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Experiment %s was still queued on %s; moved to %s as %s\n",
+			experiment.ID,
+			state.Chain.Clusters[state.Cluster],
+			state.Chain.Clusters[state.Cluster+1],
+			color.BlueString(moved))
+	}
+	return moved, true, nil
+}
+
+func newExperimentFallbackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fallback <experiment...>",
+		Short: "Move queued experiments along their cluster fallback chains",
+		Long: `Move queued experiments along their cluster fallback chains.
+
+Experiments created with --clusters, or from a spec with a "fallback" section
+such as the following, start on the first cluster of their chain.
+
+    fallback:
+      clusters: [ai2/on-prem, ai2/cloud-preemptible]
+      after: 30m
+
+If none of an experiment's tasks have been scheduled when its fallback time
+passes, it's submitted again to the next cluster and then stopped. The stopped
+experiment is renamed, and the new one takes its name, groups, log sinks, and
+notification targets.
+
+Experiments are only moved while they're watched, by this command or by
+'beaker experiment create --watch'. It exits once every experiment has been
+scheduled, stopped, or reached the end of its chain.`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	var interval time.Duration
+	cmd.Flags().DurationVar(&interval, "interval", defaultWatchInterval, "Time between status checks")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		return watchExperiments(args, interval, true, false)
+	}
+	return cmd
+}
+
+// allQueued returns whether an experiment has tasks and none of them have
+// been scheduled or finished.
+func allQueued(executions []*api.Execution) bool {
+	if len(executions) == 0 {
+		return false
+	}
+	for _, execution := range executions {
+		state := execution.State
+		if state.Scheduled != nil || state.Finalized != nil || state.Canceled != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// fallBack submits an experiment's spec to the next cluster of its chain and
+// then stops it, returning the new experiment's ID. The new experiment joins
+// the old one's groups before the old one is stopped; if either step fails,
+// the new experiment is stopped and deleted, and the old one is left as it was.
+func fallBack(experiment *api.Experiment, state *fallbackState) (string, error) {
+	next := state.Cluster + 1
+	if next >= len(state.Chain.Clusters) {
+		return "", errors.New("no clusters are left in the chain")
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(state.Spec), &doc); err != nil {
+		return "", errors.Wrap(err, "failed to parse spec")
+	}
+	root := documentRoot(&doc)
+	if err := setSpecCluster(root, state.Chain.Clusters[next]); err != nil {
+		return "", err
+	}
+	spec, err := yaml.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+
+	groups, err := beaker.Experiment(experiment.ID).Groups(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The new experiment takes the old one's name once the old one is renamed.
+	created, err := beaker.Workspace(state.Workspace).CreateExperimentRaw(
+		ctx,
+		"application/x-yaml",
+		bytes.NewReader(spec),
+		&client.ExperimentOpts{})
+	if err != nil {
+		return "", err
+	}
+
+	var added []string
+	rollback := func(cause error) error {
+		for _, group := range added {
+			if err := beaker.Group(group).RemoveExperiments(ctx, []string{created.ID}); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: couldn't remove %s from group %s: %v\n", created.ID, group, err)
+			}
+		}
+		if err := beaker.Experiment(created.ID).Stop(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't stop %s: %v\n", created.ID, err)
+		} else if err := beaker.Experiment(created.ID).Delete(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't delete %s: %v\n", created.ID, err)
+		}
+		return cause
+	}
+	for _, group := range groups {
+		if err := beaker.Group(group).AddExperiments(ctx, []string{created.ID}); err != nil {
+			return "", rollback(errors.WithMessagef(err, "group %s", group))
+		}
+		added = append(added, group)
+	}
+	if err := beaker.Experiment(experiment.ID).Stop(ctx); err != nil {
+		return "", rollback(err)
+	}
+
+	// The new experiment is running from here on, so later failures are
+	// reported without undoing the move.
+	for _, group := range groups {
+		if err := beaker.Group(group).RemoveExperiments(ctx, []string{experiment.ID}); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't remove %s from group %s: %v\n", experiment.ID, group, err)
+		}
+	}
+	if state.Name != "" {
+		// Free the name for the new experiment.
+		cluster := state.Chain.Clusters[state.Cluster]
+		cluster = cluster[strings.LastIndex(cluster, "/")+1:]
+		err := beaker.Experiment(experiment.ID).SetName(ctx, state.Name+"-"+cluster)
+		if err == nil {
+			err = beaker.Experiment(created.ID).SetName(ctx, state.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't move the name %s to %s: %v\n", state.Name, created.ID, err)
+		}
+	}
+
+	sinks, err := readLogSinks(experiment.ID)
+	if err == nil && len(sinks) != 0 {
+		err = writeLogSinks(created.ID, sinks)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: couldn't copy log sinks to %s: %v\n", created.ID, err)
+	}
+	targets, err := readNotificationTargets(experiment.ID)
+	if err == nil && len(targets) != 0 {
+		err = writeNotificationTargets(created.ID, targets)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: couldn't move notifications to %s: %v\n", created.ID, err)
+	}
+
+	moved := *state
+	moved.Cluster = next
+	moved.Submitted = time.Now()
+	if err := writeFallbackState(created.ID, &moved); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: couldn't save the fallback chain of %s: %v\n", created.ID, err)
+	}
+	return created.ID, nil
+}
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"encoding/csv"
 	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/beaker/client/api"
 	"github.com/fatih/color"
@@ -15,13 +25,19 @@ func newGroupCommand() *cobra.Command {
 		Short: "Manage groups",
 	}
 	cmd.AddCommand(newGroupAddCommand())
+	cmd.AddCommand(newGroupCompareCommand())
 	cmd.AddCommand(newGroupCreateCommand())
 	cmd.AddCommand(newGroupDeleteCommand())
 	cmd.AddCommand(newGroupExecutionsCommand())
 	cmd.AddCommand(newGroupExperimentsCommand())
+	cmd.AddCommand(newGroupExportCommand())
 	cmd.AddCommand(newGroupGetCommand())
+	cmd.AddCommand(newGroupPruneCommand())
 	cmd.AddCommand(newGroupRemoveCommand())
 	cmd.AddCommand(newGroupRenameCommand())
+	cmd.AddCommand(newGroupReportCommand())
+	cmd.AddCommand(newGroupStatsCommand())
+	cmd.AddCommand(newGroupSyncCommand())
 	cmd.AddCommand(newGroupTasksCommand())
 	return cmd
 }
@@ -47,39 +63,123 @@ func newGroupAddCommand() *cobra.Command {
 	}
 }
 
+func newGroupCompareCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare <group>",
+		Short: "Compare parameters and metrics side by side across a group's tasks",
+		Long: `Compare parameters and metrics side by side across a group's tasks
+
+Prints one row per task with the selected parameters followed by the selected
+metrics. The best value of each metric is marked with an asterisk. Parameters
+are given as env:NAME for the environment variable NAME.
+
+For example, to rank a sweep by F1:
+
+    beaker group compare my-sweep --param env:LR --metric f1 --sort-by f1`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{delimitedFormats: ""},
+	}
+
+	var params []string
+	var metrics []string
+	var sortBy string
+	var minimize []string
+	cmd.Flags().StringSliceVar(&params, "param", nil, "Parameters to compare, as env:NAME. Defaults to all parameters")
+	cmd.Flags().StringSliceVar(&metrics, "metric", nil, "Metrics to compare. Defaults to all metrics")
+	cmd.Flags().StringVar(&sortBy, "sort-by", "", "Metric to rank tasks by, best first")
+	cmd.Flags().StringSliceVar(&minimize, "minimize", nil, "Metrics for which lower values are better")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var env []string
+		for _, param := range params {
+			name := strings.TrimPrefix(param, "env:")
+			if name == param || name == "" {
+				return fmt.Errorf("invalid --param %q; must be env:NAME", param)
+			}
+			env = append(env, name)
+		}
+
+		tasks, err := listGroupTasks(args[0])
+		if err != nil {
+			return err
+		}
+		allEnv, allMetrics := groupParameters(tasks)
+		if len(env) == 0 {
+			env = allEnv
+		}
+		if len(metrics) == 0 {
+			metrics = allMetrics
+		}
+		return printGroupComparison(env, metrics, compareGroupTasks(tasks, env, metrics, sortBy, minimize))
+	}
+	return cmd
+}
+
 func newGroupCreateCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "create <name> <experiment...>",
 		Short: "Create a new experiment group",
-		Args:  cobra.MinimumNArgs(1),
+		Long: `Create a new experiment group
+
+Experiments may be given by name or ID, found by a search of the group's
+workspace with --author and --name-prefix, or both. A search is saved in the
+group's description so that 'beaker group sync' can add experiments which
+match it later. For example:
+
+    beaker group create sweep7 --author me --name-prefix sweep7-`,
+		Args: cobra.MinimumNArgs(1),
 	}
 
 	var description string
 	var workspace string
+	var query groupQuery
 	cmd.Flags().StringVar(&description, "desc", "", "Group description")
 	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Group workspace")
+	cmd.Flags().StringVar(&query.Author, "author", "", `Add experiments by this author, or "me"`)
+	cmd.Flags().StringVar(&query.NamePrefix, "name-prefix", "", "Add experiments whose names start with this prefix")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		var err error
-		if workspace, err = ensureWorkspace(workspace); err != nil {
+		if workspace, err = resolveWorkspace(workspace, api.Write); err != nil {
 			return err
 		}
 
+		experiments := args[1:]
+		if !query.empty() {
+			if err := query.resolve(); err != nil {
+				return err
+			}
+			info, err := beaker.Workspace(workspace).Get(ctx)
+			if err != nil {
+				return err
+			}
+			found, err := query.run(info.ID)
+			if err != nil {
+				return err
+			}
+			experiments = append(experiments, found...)
+			if description, err = withGroupQuery(description, query); err != nil {
+				return err
+			}
+		}
+
 		spec := api.GroupSpec{
 			Name:        args[0],
 			Description: description,
 			Workspace:   workspace,
-			Experiments: trimAndUnique(args[1:]),
+			Experiments: trimAndUnique(experiments),
 		}
 		group, err := beaker.CreateGroup(ctx, spec)
 		if err != nil {
 			return err
 		}
-
 		if quiet {
 			fmt.Println(group.Ref())
 		} else {
 			fmt.Println("Created group " + color.BlueString(group.Ref()))
+			if !query.empty() {
+				fmt.Printf("Added %d experiment(s)\n", len(spec.Experiments))
+			}
 		}
 		return nil
 	}
@@ -158,6 +258,66 @@ func newGroupExperimentsCommand() *cobra.Command {
 	}
 }
 
+func newGroupExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <group>",
+		Short: "Export a group's parameters and metrics as a table",
+		Long: `Export a group's parameters and metrics as a table
+
+Writes one row per task with the task's experiment, status, parameters, and
+metrics, ready to load into a spreadsheet or data frame. Rows are written as
+CSV by default, or with --format tsv as tab-separated values.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{delimitedFormats: ""},
+	}
+
+	var output string
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write to. Defaults to stdout")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		comma := ','
+		switch format {
+		case "", formatCSV:
+		case formatTSV:
+			comma = '\t'
+		default:
+			return fmt.Errorf("invalid format %q; must be %q or %q", format, formatCSV, formatTSV)
+		}
+
+		group, err := beaker.Group(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		tasks, err := listGroupTasks(group.ID)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+
+		out := csv.NewWriter(w)
+		out.Comma = comma
+		if err := writeGroupExport(out, tasks); err != nil {
+			return err
+		}
+
+		if output != "" && !quiet {
+			fmt.Printf("Exported %d tasks from %s to %s\n", len(tasks), color.BlueString(group.FullName), output)
+		}
+		return nil
+	}
+	return cmd
+}
+
 func newGroupGetCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:     "get <group...>",
@@ -178,6 +338,118 @@ func newGroupGetCommand() *cobra.Command {
 	}
 }
 
+func newGroupPruneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune <group>",
+		Short: "Remove failed or superseded experiments from a group",
+		Long: `Remove failed or superseded experiments from a group
+
+With --failed, experiments with a failed task are removed. With
+--duplicate-params=keep-best, experiments whose tasks share the same
+parameters are compared by --metric and all but the best are removed.
+Parameters are the environment variables of each task.
+
+For example, to clean up a sweep which was partly run twice:
+
+    beaker group prune my-sweep --failed --duplicate-params keep-best --metric f1
+
+Higher values of --metric are better unless it's listed in --minimize, as in
+'group compare' and 'group report'.
+
+The experiments to remove are listed for confirmation first. Experiments are
+only removed from the group; they aren't deleted.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var failed bool
+	var duplicates string
+	var metric string
+	var minimize []string
+	var yes bool
+	cmd.Flags().BoolVar(&failed, "failed", false, "Remove experiments with a failed task")
+	cmd.Flags().StringVar(&duplicates, "duplicate-params", "", fmt.Sprintf(
+		"Remove experiments with duplicate parameters (%s)", pruneKeepBest))
+	cmd.Flags().StringVar(&metric, "metric", "", "Metric which ranks duplicate experiments")
+	cmd.Flags().StringSliceVar(&minimize, "minimize", nil, "Metrics for which lower values are better")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Remove experiments without asking for confirmation")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		switch duplicates {
+		case "":
+		case pruneKeepBest:
+			if metric == "" {
+				return fmt.Errorf("--duplicate-params=%s requires --metric", pruneKeepBest)
+			}
+		default:
+			return fmt.Errorf("invalid --duplicate-params %q; must be %q", duplicates, pruneKeepBest)
+		}
+		if !failed && duplicates == "" {
+			return fmt.Errorf("pass --failed, --duplicate-params, or both")
+		}
+
+		group, err := beaker.Group(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		tasks, err := listGroupTasks(group.ID)
+		if err != nil {
+			return err
+		}
+
+		var prune []groupPruneCandidate
+		if failed {
+			prune = failedGroupExperiments(tasks)
+		}
+		if duplicates != "" {
+			lowerIsBetter := false
+			for _, name := range minimize {
+				lowerIsBetter = lowerIsBetter || name == metric
+			}
+			prune = append(prune, supersededGroupExperiments(tasks, prune, metric, lowerIsBetter)...)
+		}
+		if len(prune) == 0 {
+			if !quiet {
+				fmt.Println("Nothing to prune.")
+			}
+			return nil
+		}
+
+		if !yes {
+			if err := printTableRow("EXPERIMENT", "NAME", "REASON"); err != nil {
+				return err
+			}
+			for _, candidate := range prune {
+				if err := printTableRow(candidate.Experiment.ID, candidate.Experiment.Name, candidate.Reason); err != nil {
+					return err
+				}
+			}
+			confirmed, err := confirm(fmt.Sprintf("\nRemove %d experiment(s) from %s?", len(prune), group.FullName))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				return nil
+			}
+		}
+
+		ids := make([]string, len(prune))
+		for i, candidate := range prune {
+			ids[i] = candidate.Experiment.ID
+		}
+		if err := beaker.Group(group.ID).RemoveExperiments(ctx, ids); err != nil {
+			return err
+		}
+
+		if quiet {
+			fmt.Println(group.ID)
+		} else {
+			fmt.Printf("Removed %d experiment(s) from %s\n", len(ids), color.BlueString(group.FullName))
+		}
+		return nil
+	}
+	return cmd
+}
+
 func newGroupRemoveCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "remove <group> <experiment...>",
@@ -225,6 +497,439 @@ func newGroupRenameCommand() *cobra.Command {
 	}
 }
 
+func newGroupReportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report <group>",
+		Short: "Render a shareable report of a group's parameters and metrics",
+		Long: `Render a shareable report of a group's parameters and metrics.
+
+The report includes a table of each task's parameters and metrics, summary
+statistics for each metric, and the best task for each metric. The report is
+written as Markdown unless the output file ends in .html.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var output string
+	var minimize []string
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write the report to. Defaults to stdout")
+	cmd.Flags().StringSliceVar(&minimize, "minimize", nil, "Metrics for which lower values are better")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		group, err := beaker.Group(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		tasks, err := listGroupTasks(group.ID)
+		if err != nil {
+			return err
+		}
+		report := newGroupReport(group, tasks, minimize)
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+
+		switch strings.ToLower(filepath.Ext(output)) {
+		case ".html", ".htm":
+			err = groupReportHTML.Execute(w, report)
+		default:
+			err = groupReportMarkdown.Execute(w, report)
+		}
+		if err != nil {
+			return err
+		}
+
+		if output != "" && !quiet {
+			fmt.Printf("Wrote report for %s to %s\n", color.BlueString(group.FullName), output)
+		}
+		return nil
+	}
+	return cmd
+}
+
+func newGroupStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats <group>",
+		Short: "Summarize a group's metrics across tasks",
+		Long: `Summarize a group's metrics across tasks
+
+Computes the count, mean, standard deviation, minimum, and maximum of each
+metric across the group's tasks. With --group-by, tasks are bucketed by the
+values of the given parameters and each bucket is summarized separately.
+Parameters are given as env:NAME for the environment variable NAME.
+
+For example, to see how accuracy varies across seeds for each learning rate:
+
+    beaker group stats my-sweep --metric accuracy --group-by env:LR`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{delimitedFormats: ""},
+	}
+
+	var metrics []string
+	var groupBy []string
+	cmd.Flags().StringSliceVar(&metrics, "metric", nil, "Metrics to summarize. Defaults to all metrics")
+	cmd.Flags().StringSliceVar(&groupBy, "group-by", nil, "Parameters to bucket tasks by, as env:NAME")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var params []string
+		for _, param := range groupBy {
+			name := strings.TrimPrefix(param, "env:")
+			if name == param || name == "" {
+				return fmt.Errorf("invalid --group-by %q; must be env:NAME", param)
+			}
+			params = append(params, name)
+		}
+
+		tasks, err := listGroupTasks(args[0])
+		if err != nil {
+			return err
+		}
+		if len(metrics) == 0 {
+			_, metrics = groupParameters(tasks)
+		}
+		return printGroupStats(groupBy, groupStats(tasks, params, metrics))
+	}
+	return cmd
+}
+
+// groupStatsRow summarizes one metric over the tasks in a bucket.
+type groupStatsRow struct {
+	// Params are the values of each --group-by parameter shared by the bucket.
+	Params []string `json:"params,omitempty"`
+	Metric string   `json:"metric"`
+	metricStats
+}
+
+// groupStats buckets tasks by the values of the given environment variables and
+// summarizes each metric within each bucket. Tasks without a numeric value for a
+// metric are left out of its statistics. Buckets are sorted by parameter value.
+func groupStats(tasks []api.GroupExperimentTask, params []string, metrics []string) []groupStatsRow {
+	type bucket struct {
+		params []string
+		values map[string][]float64
+	}
+	buckets := make(map[string]*bucket)
+	var keys []string
+	for _, task := range tasks {
+		values := make([]string, len(params))
+		for i, name := range params {
+			values[i] = task.Task.Env[name]
+		}
+		key := strings.Join(values, "\x00")
+
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{params: values, values: make(map[string][]float64)}
+			buckets[key] = b
+			keys = append(keys, key)
+		}
+		for _, name := range metrics {
+			if v, ok := metricValue(task.Task.Metrics[name]); ok {
+				b.values[name] = append(b.values[name], v)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := buckets[keys[i]].params, buckets[keys[j]].params
+		for k := range a {
+			if a[k] != b[k] {
+				return lessParam(a[k], b[k])
+			}
+		}
+		return false
+	})
+
+	var rows []groupStatsRow
+	for _, key := range keys {
+		b := buckets[key]
+		for _, name := range metrics {
+			if len(b.values[name]) == 0 {
+				continue
+			}
+			row := groupStatsRow{Metric: name, metricStats: summarize(b.values[name])}
+			if len(params) != 0 {
+				row.Params = b.params
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// groupComparisonRow holds the parameters and metrics of one task.
+type groupComparisonRow struct {
+	ExperimentID string   `json:"experimentId"`
+	Experiment   string   `json:"experiment,omitempty"`
+	TaskID       string   `json:"taskId"`
+	Task         string   `json:"task,omitempty"`
+	Params       []string `json:"params"`
+
+	// Metrics are in the order compared. Missing metrics are nil.
+	Metrics []interface{} `json:"metrics"`
+
+	// Best lists the metrics for which this task has the best value.
+	Best []string `json:"best,omitempty"`
+}
+
+// compareGroupTasks builds a row for each task with the given parameters and
+// metrics, marking the best value of each metric. If sortBy is set, rows are
+// ordered by that metric, best first, with tasks lacking it last.
+func compareGroupTasks(
+	tasks []api.GroupExperimentTask,
+	params []string,
+	metrics []string,
+	sortBy string,
+	minimize []string,
+) []groupComparisonRow {
+	lowerIsBetter := make(map[string]bool)
+	for _, name := range minimize {
+		lowerIsBetter[name] = true
+	}
+	better := func(metric string, a, b float64) bool {
+		if lowerIsBetter[metric] {
+			return a < b
+		}
+		return a > b
+	}
+
+	if sortBy != "" {
+		tasks = append([]api.GroupExperimentTask(nil), tasks...)
+		sort.SliceStable(tasks, func(i, j int) bool {
+			a, okA := metricValue(tasks[i].Task.Metrics[sortBy])
+			b, okB := metricValue(tasks[j].Task.Metrics[sortBy])
+			if okA != okB {
+				return okA
+			}
+			return okA && better(sortBy, a, b)
+		})
+	}
+
+	rows := make([]groupComparisonRow, len(tasks))
+	for i, task := range tasks {
+		row := groupComparisonRow{
+			ExperimentID: task.Experiment.ID,
+			Experiment:   task.Experiment.Name,
+			TaskID:       task.Task.ID,
+			Task:         task.Task.Name,
+		}
+		for _, name := range params {
+			row.Params = append(row.Params, task.Task.Env[name])
+		}
+		for _, name := range metrics {
+			row.Metrics = append(row.Metrics, task.Task.Metrics[name])
+		}
+		rows[i] = row
+	}
+
+	for i, name := range metrics {
+		best := -1
+		var bestValue float64
+		for j, row := range rows {
+			if v, ok := metricValue(row.Metrics[i]); ok && (best < 0 || better(name, v, bestValue)) {
+				best, bestValue = j, v
+			}
+		}
+		// Every task with the best value is marked so ties are visible.
+		for j, row := range rows {
+			if v, ok := metricValue(row.Metrics[i]); best >= 0 && ok && v == bestValue {
+				rows[j].Best = append(rows[j].Best, name)
+			}
+		}
+	}
+
+	return rows
+}
+
+// lessParam orders parameter values numerically if both are numbers, so seeds
+// and learning rates sort naturally.
+func lessParam(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// Strategies for pruning experiments with duplicate parameters.
+const pruneKeepBest = "keep-best"
+
+// groupPruneCandidate is an experiment to remove from a group.
+type groupPruneCandidate struct {
+	Experiment api.GroupExperiment
+	Reason     string
+}
+
+// failedGroupExperiments finds experiments with at least one failed task.
+func failedGroupExperiments(tasks []api.GroupExperimentTask) []groupPruneCandidate {
+	var failed []groupPruneCandidate
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		if seen[task.Experiment.ID] || task.Task.LastState == nil ||
+			executionStatus(*task.Task.LastState) != "failed" {
+			continue
+		}
+		seen[task.Experiment.ID] = true
+
+		name := task.Task.Name
+		if name == "" {
+			name = task.Task.ID
+		}
+		failed = append(failed, groupPruneCandidate{
+			Experiment: task.Experiment,
+			Reason:     fmt.Sprintf("task %s failed", name),
+		})
+	}
+	return failed
+}
+
+// supersededGroupExperiments finds experiments whose tasks have the same
+// parameters as another experiment with a better value of a metric. An
+// experiment's value is the best value among its tasks. Experiments without
+// the metric are superseded by any experiment with it. Experiments in exclude
+// are ignored.
+func supersededGroupExperiments(
+	tasks []api.GroupExperimentTask,
+	exclude []groupPruneCandidate,
+	metric string,
+	minimize bool,
+) []groupPruneCandidate {
+	excluded := make(map[string]bool)
+	for _, candidate := range exclude {
+		excluded[candidate.Experiment.ID] = true
+	}
+	better := func(a, b float64) bool {
+		if minimize {
+			return a < b
+		}
+		return a > b
+	}
+
+	type experiment struct {
+		info     api.GroupExperiment
+		params   []string
+		value    float64
+		hasValue bool
+	}
+	var experiments []*experiment
+	byID := make(map[string]*experiment)
+	for _, task := range tasks {
+		if excluded[task.Experiment.ID] {
+			continue
+		}
+		e, ok := byID[task.Experiment.ID]
+		if !ok {
+			e = &experiment{info: task.Experiment}
+			byID[task.Experiment.ID] = e
+			experiments = append(experiments, e)
+		}
+
+		var env []string
+		for name, value := range task.Task.Env {
+			env = append(env, name+"="+value)
+		}
+		sort.Strings(env)
+		e.params = append(e.params, task.Task.Name+"\x00"+strings.Join(env, "\x00"))
+
+		if v, ok := metricValue(task.Task.Metrics[metric]); ok && (!e.hasValue || better(v, e.value)) {
+			e.value, e.hasValue = v, true
+		}
+	}
+
+	// Find the best experiment for each set of parameters, preferring the
+	// earliest in the group when tied.
+	best := make(map[string]*experiment)
+	for _, e := range experiments {
+		sort.Strings(e.params)
+		key := strings.Join(e.params, "\x01")
+		if b, ok := best[key]; !ok || (e.hasValue && (!b.hasValue || better(e.value, b.value))) {
+			best[key] = e
+		}
+	}
+
+	var superseded []groupPruneCandidate
+	for _, e := range experiments {
+		key := strings.Join(e.params, "\x01")
+		b := best[key]
+		if b == e || !b.hasValue {
+			continue
+		}
+		reason := fmt.Sprintf("superseded by %s (%s=%v)", b.info.ID, metric, b.value)
+		if e.hasValue {
+			reason = fmt.Sprintf("superseded by %s (%s=%v, not %v)", b.info.ID, metric, b.value, e.value)
+		}
+		superseded = append(superseded, groupPruneCandidate{Experiment: e.info, Reason: reason})
+	}
+	return superseded
+}
+
+func newGroupSyncCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync <group>",
+		Short: "Add experiments matching a group's saved search",
+		Long: `Add experiments matching a group's saved search
+
+Runs the search given when the group was created with --author or --name-prefix
+again, and adds experiments in the group's workspace which match it but aren't
+yet in the group. Experiments are never removed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			group, err := beaker.Group(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+			query, err := readGroupQuery(group)
+			if err != nil {
+				return err
+			}
+			found, err := query.run(group.Workspace.ID)
+			if err != nil {
+				return err
+			}
+
+			existing, err := beaker.Group(group.ID).Experiments(ctx)
+			if err != nil {
+				return err
+			}
+			inGroup := make(map[string]bool, len(existing))
+			for _, id := range existing {
+				inGroup[id] = true
+			}
+			var added []string
+			for _, id := range found {
+				if !inGroup[id] {
+					added = append(added, id)
+				}
+			}
+
+			if len(added) != 0 {
+				if err := beaker.Group(group.ID).AddExperiments(ctx, added); err != nil {
+					return err
+				}
+			}
+
+			if quiet {
+				for _, id := range added {
+					fmt.Println(id)
+				}
+			} else if len(added) == 0 {
+				fmt.Printf("Group %s is up to date\n", color.BlueString(args[0]))
+			} else {
+				fmt.Printf("Added %d experiment(s) to %s: %s\n", len(added), color.BlueString(args[0]), added)
+			}
+			return nil
+		},
+	}
+}
+
 func newGroupTasksCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "tasks <group>",
@@ -267,3 +972,322 @@ func trimAndUnique(ids []string) []string {
 
 	return unique
 }
+
+// listGroupTasks collects every task in a group along with its parameters and
+// metrics. Parameters are the literal environment variables in each task's
+// spec, and metrics are the results of the task's most recent execution.
+func listGroupTasks(group string) ([]api.GroupExperimentTask, error) {
+	experimentIDs, err := beaker.Group(group).Experiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var groupTasks []api.GroupExperimentTask
+	for _, experimentID := range experimentIDs {
+		experiment, err := beaker.Experiment(experimentID).Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks, err := beaker.Experiment(experimentID).Tasks(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			groupTask := api.GroupTask{ID: task.ID, Name: task.Name}
+			if len(task.Executions) != 0 {
+				execution := task.Executions[len(task.Executions)-1]
+				groupTask.LastState = &execution.State
+				groupTask.Canceled = execution.State.Canceled
+
+				groupTask.Env = make(map[string]string)
+				for _, env := range execution.Spec.EnvVars {
+					if env.Value != nil {
+						groupTask.Env[env.Name] = *env.Value
+					}
+				}
+
+				if execution.State.Finalized != nil {
+					results, err := beaker.Execution(execution.ID).GetResults(ctx)
+					if err != nil {
+						// Tasks which didn't write metrics have no results.
+						if apiErr, ok := err.(api.Error); !ok || apiErr.Code != http.StatusNotFound {
+							return nil, err
+						}
+					} else {
+						groupTask.Metrics = results.Metrics
+					}
+				}
+			}
+
+			groupTasks = append(groupTasks, api.GroupExperimentTask{
+				Experiment: api.GroupExperiment{ID: experiment.ID, Name: experiment.Name},
+				Task:       groupTask,
+			})
+		}
+	}
+	return groupTasks, nil
+}
+
+// writeGroupExport writes a header followed by one row per task. Metrics
+// which are missing for a task are left empty.
+func writeGroupExport(out *csv.Writer, tasks []api.GroupExperimentTask) error {
+	env, metrics := groupParameters(tasks)
+
+	header := []string{"experiment_id", "experiment", "task_id", "task", "status"}
+	header = append(header, env...)
+	header = append(header, metrics...)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		status := "pending"
+		if task.Task.LastState != nil {
+			status = executionStatus(*task.Task.LastState)
+		}
+
+		row := []string{task.Experiment.ID, task.Experiment.Name, task.Task.ID, task.Task.Name, status}
+		for _, name := range env {
+			row = append(row, task.Task.Env[name])
+		}
+		for _, name := range metrics {
+			var value string
+			if v, ok := task.Task.Metrics[name]; ok {
+				value = fmt.Sprint(v)
+			}
+			row = append(row, value)
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// groupParameters returns the sorted names of all environment variables and metrics in a set of tasks.
+func groupParameters(tasks []api.GroupExperimentTask) (env []string, metrics []string) {
+	envSeen := make(map[string]bool)
+	metricSeen := make(map[string]bool)
+	for _, task := range tasks {
+		for name := range task.Task.Env {
+			if !envSeen[name] {
+				envSeen[name] = true
+				env = append(env, name)
+			}
+		}
+		for name := range task.Task.Metrics {
+			if !metricSeen[name] {
+				metricSeen[name] = true
+				metrics = append(metrics, name)
+			}
+		}
+	}
+	sort.Strings(env)
+	sort.Strings(metrics)
+	return env, metrics
+}
+
+// metricValue converts a metric to a number, if possible.
+func metricValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// metricStats summarizes the values of a single metric.
+type metricStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	Std   float64 `json:"std"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// summarize computes statistics over a non-empty list of values.
+func summarize(values []float64) metricStats {
+	stats := metricStats{
+		Count: len(values),
+		Min:   math.Inf(1),
+		Max:   math.Inf(-1),
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+	}
+	stats.Mean = sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.Std = math.Sqrt(variance / float64(len(values)))
+	return stats
+}
+
+// groupReport is the data rendered by the group report templates.
+type groupReport struct {
+	Group   *api.Group
+	URL     string
+	Env     []string
+	Metrics []string
+	Rows    []groupReportRow
+	Summary []groupReportMetric
+}
+
+type groupReportRow struct {
+	Experiment string
+	URL        string
+	Task       string
+	Status     string
+	Env        []string
+	Metrics    []string
+}
+
+type groupReportMetric struct {
+	Name     string
+	Stats    metricStats
+	Best     string
+	BestTask string
+	BestURL  string
+}
+
+func newGroupReport(group *api.Group, tasks []api.GroupExperimentTask, minimize []string) *groupReport {
+	env, metrics := groupParameters(tasks)
+	// The saved query isn't meant for readers of the report.
+	described := *group
+	described.Description = groupDescription(group)
+	report := &groupReport{
+		Group:   &described,
+		URL:     fmt.Sprintf("%s/gr/%s", beaker.Address(), group.ID),
+		Env:     env,
+		Metrics: metrics,
+	}
+
+	for _, task := range tasks {
+		experiment := task.Experiment.ID
+		if task.Experiment.Name != "" {
+			experiment = task.Experiment.Name
+		}
+		status := "pending"
+		if task.Task.LastState != nil {
+			status = executionStatus(*task.Task.LastState)
+		}
+
+		row := groupReportRow{
+			Experiment: experiment,
+			URL:        fmt.Sprintf("%s/ex/%s", beaker.Address(), task.Experiment.ID),
+			Task:       task.Task.Name,
+			Status:     status,
+		}
+		for _, name := range env {
+			row.Env = append(row.Env, task.Task.Env[name])
+		}
+		for _, name := range metrics {
+			var value string
+			if v, ok := task.Task.Metrics[name]; ok {
+				value = fmt.Sprint(v)
+			}
+			row.Metrics = append(row.Metrics, value)
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	lowerIsBetter := make(map[string]bool)
+	for _, name := range minimize {
+		lowerIsBetter[name] = true
+	}
+	for i, name := range metrics {
+		var values []float64
+		best := -1
+		var bestValue float64
+		for j, task := range tasks {
+			v, ok := metricValue(task.Task.Metrics[name])
+			if !ok {
+				continue
+			}
+			values = append(values, v)
+			if best < 0 || (lowerIsBetter[name] && v < bestValue) || (!lowerIsBetter[name] && v > bestValue) {
+				best, bestValue = j, v
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		row := report.Rows[best]
+		report.Summary = append(report.Summary, groupReportMetric{
+			Name:     name,
+			Stats:    summarize(values),
+			Best:     row.Metrics[i],
+			BestTask: row.Experiment + " " + row.Task,
+			BestURL:  row.URL,
+		})
+	}
+	return report
+}
+
+var groupReportMarkdown = template.Must(template.New("report").Parse(
+	`# [{{.Group.FullName}}]({{.URL}})
+{{if .Group.Description}}
+{{.Group.Description}}
+{{end}}
+{{- if .Summary}}
+## Summary
+
+| Metric | Count | Mean | Std | Min | Max | Best | Best run |
+|---|---|---|---|---|---|---|---|
+{{range .Summary}}| {{.Name}} | {{.Stats.Count}} | {{printf "%.4g" .Stats.Mean}} | {{printf "%.4g" .Stats.Std}} | {{printf "%.4g" .Stats.Min}} | {{printf "%.4g" .Stats.Max}} | {{.Best}} | [{{.BestTask}}]({{.BestURL}}) |
+{{end}}{{end}}
+## Runs
+
+| Experiment | Task | Status |{{range .Env}} {{.}} |{{end}}{{range .Metrics}} {{.}} |{{end}}
+|---|---|---|{{range .Env}}---|{{end}}{{range .Metrics}}---|{{end}}
+{{range .Rows}}| [{{.Experiment}}]({{.URL}}) | {{.Task}} | {{.Status}} |{{range .Env}} {{.}} |{{end}}{{range .Metrics}} {{.}} |{{end}}
+{{end}}`))
+
+var groupReportHTML = htmlTemplate.Must(htmlTemplate.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Group.FullName}}</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1><a href="{{.URL}}">{{.Group.FullName}}</a></h1>
+{{if .Group.Description}}<p>{{.Group.Description}}</p>{{end}}
+{{if .Summary}}<h2>Summary</h2>
+<table>
+<tr><th>Metric</th><th>Count</th><th>Mean</th><th>Std</th><th>Min</th><th>Max</th><th>Best</th><th>Best run</th></tr>
+{{range .Summary}}<tr><td>{{.Name}}</td><td>{{.Stats.Count}}</td><td>{{printf "%.4g" .Stats.Mean}}</td><td>{{printf "%.4g" .Stats.Std}}</td><td>{{printf "%.4g" .Stats.Min}}</td><td>{{printf "%.4g" .Stats.Max}}</td><td>{{.Best}}</td><td><a href="{{.BestURL}}">{{.BestTask}}</a></td></tr>
+{{end}}</table>{{end}}
+<h2>Runs</h2>
+<table>
+<tr><th>Experiment</th><th>Task</th><th>Status</th>{{range .Env}}<th>{{.}}</th>{{end}}{{range .Metrics}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td><a href="{{.URL}}">{{.Experiment}}</a></td><td>{{.Task}}</td><td>{{.Status}}</td>{{range .Env}}<td>{{.}}</td>{{end}}{{range .Metrics}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/beaker/client/api"
+)
+
+// Marks the line of a group's description which holds its saved query.
+const groupQueryMarker = "beaker-query: "
+
+// groupQuery is a search for experiments in a group's workspace which
+// populates the group. It's saved in the group's description when the group is
+// created, so anyone with access to the group can 'beaker group sync' it.
+type groupQuery struct {
+	Author     string `json:"author,omitempty"`
+	NamePrefix string `json:"namePrefix,omitempty"`
+}
+
+func (q groupQuery) empty() bool {
+	return q.Author == "" && q.NamePrefix == ""
+}
+
+func (q groupQuery) match(experiment api.Experiment) bool {
+	switch {
+	case q.Author != "" && experiment.Author.Name != q.Author:
+		return false
+	case q.NamePrefix != "" && !strings.HasPrefix(experiment.Name, q.NamePrefix):
+		return false
+	}
+	return true
+}
+
+// resolve replaces an author of "me" with the current user's name, so the
+// saved query means the same thing whoever syncs the group.
+func (q *groupQuery) resolve() error {
+	if q.Author != "me" {
+		return nil
+	}
+	user, err := beaker.WhoAmI(ctx)
+	if err != nil {
+		return err
+	}
+	q.Author = user.Name
+	return nil
+}
+
+// run returns the IDs of experiments in a workspace, by ID, matching the query.
+func (q groupQuery) run(workspace string) ([]string, error) {
+	experiments, err := searchExperiments(q.Author, q.NamePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, experiment := range experiments {
+		if experiment.Workspace.ID == workspace && q.match(experiment) {
+			ids = append(ids, experiment.ID)
+		}
+	}
+	return trimAndUnique(ids), nil
+}
+
+// withGroupQuery returns a group description with a query saved in it.
+func withGroupQuery(description string, q groupQuery) (string, error) {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", err
+	}
+	if description != "" {
+		description += "\n\n"
+	}
+	return description + groupQueryMarker + string(b), nil
+}
+
+// readGroupQuery returns the query saved in a group's description.
+func readGroupQuery(group *api.Group) (*groupQuery, error) {
+	lines := strings.Split(group.Description, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(lines[i], groupQueryMarker) {
+			continue
+		}
+		var q groupQuery
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[i], groupQueryMarker)), &q); err != nil {
+			return nil, fmt.Errorf("group %s has an invalid saved query: %w", group.ID, err)
+		}
+		return &q, nil
+	}
+	return nil, fmt.Errorf("group %s has no saved query; create it with --author or --name-prefix", group.ID)
+}
+
+// groupDescription returns a group's description without its saved query.
+func groupDescription(group *api.Group) string {
+	lines := strings.Split(group.Description, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], groupQueryMarker) {
+			lines = append(lines[:i], lines[i+1:]...)
+			break
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beaker/runtime"
+)
+
+// Kinds of cloud identity, named by their prefix in --identity.
+const (
+	identityGCP = "gcp"
+	identityAWS = "aws"
+)
+
+// Where a session's cloud credentials are mounted.
+const identityMountPath = "/var/run/beaker/identity"
+
+// Kind of local state under which sessions' credentials are kept.
+const identityStateKind = "identity"
+
+// Name of a GCP session's access token within its credentials directory.
+const gcpTokenFile = "gcp-access-token"
+
+// How often a session's credentials are renewed. Credentials last an hour.
+const identityRefreshInterval = 45 * time.Minute
+
+var awsRoleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+
+// identitySpec attaches a cloud identity to a task or session so it can access
+// cloud resources without the user's own credentials. Exactly one field is set.
+type identitySpec struct {
+	// Email of a GCP service account to impersonate.
+	GCP string `yaml:"gcp,omitempty" json:"gcp,omitempty"`
+
+	// ARN of an AWS IAM role to assume.
+	AWS string `yaml:"aws,omitempty" json:"aws,omitempty"`
+}
+
+// parseIdentityFlag parses an identity written as gcp:<service-account> or
+// aws:<role-arn>.
+func parseIdentityFlag(s string) (*identitySpec, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid identity %q; must be %s:<service-account> or %s:<role-arn>",
+			s, identityGCP, identityAWS)
+	}
+
+	var identity identitySpec
+	switch parts[0] {
+	case identityGCP:
+		identity.GCP = parts[1]
+	case identityAWS:
+		identity.AWS = parts[1]
+	default:
+		return nil, fmt.Errorf("invalid identity %q; must be %s:<service-account> or %s:<role-arn>",
+			s, identityGCP, identityAWS)
+	}
+	if err := identity.validate(); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// validate checks that exactly one identity is set and is well formed.
+func (s *identitySpec) validate() error {
+	switch {
+	case s.GCP != "" && s.AWS != "":
+		return fmt.Errorf("only one of %s or %s may be set", identityGCP, identityAWS)
+	case s.GCP != "":
+		addr, err := mail.ParseAddress(s.GCP)
+		if err != nil || addr.Address != s.GCP || !strings.HasSuffix(s.GCP, ".gserviceaccount.com") {
+			return fmt.Errorf("invalid GCP service account %q", s.GCP)
+		}
+	case s.AWS != "":
+		if !awsRoleARN.MatchString(s.AWS) {
+			return fmt.Errorf("invalid AWS role ARN %q", s.AWS)
+		}
+	default:
+		return fmt.Errorf("one of %s or %s must be set", identityGCP, identityAWS)
+	}
+	return nil
+}
+
+func (s *identitySpec) String() string {
+	if s.GCP != "" {
+		return identityGCP + ":" + s.GCP
+	}
+	return identityAWS + ":" + s.AWS
+}
+
+// sessionIdentity holds a session's short-lived credentials in a directory
+// which is mounted into its container.
+type sessionIdentity struct {
+	spec      *identitySpec
+	sessionID string
+	dir       string
+}
+
+// newSessionIdentity obtains credentials for a session with the cloud CLI on
+// this node. The CLIs use the credentials of the user creating the session,
+// or the node's own from its metadata server if the user has none. For GCP,
+// that identity must be allowed to impersonate the service account; for AWS,
+// it must be allowed to assume the role. Either way the credentials are
+// short-lived and renewed while the session is attached.
+//
+// Credentials are kept in ~/.beaker rather than the cache so clearing the
+// cache can't pull them out from under a running session.
+func newSessionIdentity(spec *identitySpec, sessionID string) (*sessionIdentity, error) {
+	root, err := statePath(identityStateKind, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.TrimSuffix(root, filepath.Ext(root))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("couldn't create credentials directory: %w", err)
+	}
+
+	identity := &sessionIdentity{spec: spec, sessionID: sessionID, dir: dir}
+	if err := identity.refresh(); err != nil {
+		_ = identity.Close()
+		return nil, err
+	}
+	return identity, nil
+}
+
+// mount returns the mount of the credentials directory.
+func (i *sessionIdentity) mount() runtime.Mount {
+	return runtime.Mount{HostPath: i.dir, ContainerPath: identityMountPath, ReadOnly: true}
+}
+
+// env returns the environment which points cloud SDKs at the credentials.
+func (i *sessionIdentity) env() map[string]string {
+	if i.spec.GCP != "" {
+		// gcloud, gsutil, and bq read the token from this file. Client
+		// libraries can pass its contents as an access token.
+		return map[string]string{
+			"CLOUDSDK_AUTH_ACCESS_TOKEN_FILE": path.Join(identityMountPath, gcpTokenFile),
+		}
+	}
+	return map[string]string{
+		"AWS_CONFIG_FILE":     path.Join(identityMountPath, "aws-config"),
+		"AWS_SDK_LOAD_CONFIG": "1",
+		"AWS_ROLE_ARN":        i.spec.AWS,
+	}
+}
+
+// refresh replaces the session's credentials with new ones.
+func (i *sessionIdentity) refresh() error {
+	if i.spec.GCP != "" {
+		out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token",
+			"--impersonate-service-account="+i.spec.GCP, "--quiet").Output()
+		if err != nil {
+			return fmt.Errorf("couldn't impersonate %s with gcloud: %w", i.spec.GCP, commandError(err))
+		}
+		return writeFileAtomic(filepath.Join(i.dir, gcpTokenFile), bytes.TrimSpace(out))
+	}
+
+	out, err := exec.CommandContext(ctx, "aws", "sts", "assume-role",
+		"--role-arn", i.spec.AWS,
+		"--role-session-name", "beaker-"+i.sessionID,
+		"--output", "json").Output()
+	if err != nil {
+		return fmt.Errorf("couldn't assume %s with the AWS CLI: %w", i.spec.AWS, commandError(err))
+	}
+	var assumed struct {
+		Credentials struct {
+			AccessKeyID     string `json:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      string
+		}
+	}
+	if err := json.Unmarshal(out, &assumed); err != nil {
+		return fmt.Errorf("couldn't parse AWS credentials: %w", err)
+	}
+
+	// SDKs run credential_process again once the credentials expire, so they
+	// pick up each refresh without restarting.
+	process, err := json.Marshal(map[string]interface{}{
+		"Version":         1,
+		"AccessKeyId":     assumed.Credentials.AccessKeyID,
+		"SecretAccessKey": assumed.Credentials.SecretAccessKey,
+		"SessionToken":    assumed.Credentials.SessionToken,
+		"Expiration":      assumed.Credentials.Expiration,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(i.dir, "aws-credentials.json"), process); err != nil {
+		return err
+	}
+	config := fmt.Sprintf("[default]\ncredential_process = cat %s\n", path.Join(identityMountPath, "aws-credentials.json"))
+	return writeFileAtomic(filepath.Join(i.dir, "aws-config"), []byte(config))
+}
+
+// keepFresh refreshes the credentials until ctx is done. Failures are
+// reported but don't end the session.
+func (i *sessionIdentity) keepFresh(ctx context.Context) {
+	ticker := time.NewTicker(identityRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := i.refresh(); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning: couldn't refresh cloud credentials:", err)
+			}
+		}
+	}
+}
+
+// Close deletes the credentials.
+func (i *sessionIdentity) Close() error {
+	return os.RemoveAll(i.dir)
+}
+
+// writeFileAtomic replaces a file so readers never see a partial write.
+func writeFileAtomic(name string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// commandError adds a failed command's output to its error.
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
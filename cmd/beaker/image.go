@@ -7,6 +7,10 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/beaker/client/api"
 	"github.com/docker/distribution/reference"
@@ -23,15 +27,134 @@ func newImageCommand() *cobra.Command {
 		Use:   "image <command>",
 		Short: "Manage images",
 	}
+	cmd.AddCommand(newImageBuildCommand())
 	cmd.AddCommand(newImageCommitCommand())
 	cmd.AddCommand(newImageCreateCommand())
 	cmd.AddCommand(newImageDeleteCommand())
 	cmd.AddCommand(newImageGetCommand())
+	cmd.AddCommand(newImageHistoryCommand())
 	cmd.AddCommand(newImagePullCommand())
 	cmd.AddCommand(newImageRenameCommand())
 	return cmd
 }
 
+func newImageBuildCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "build [context]",
+		Short: "Build an image with Docker and create it in Beaker",
+		Long: `Build an image with the local Docker daemon and create it in Beaker.
+
+The build context defaults to the current directory and respects .dockerignore.`,
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	var dockerfile string
+	var buildArgs []string
+	var target string
+	var tag string
+	var pull bool
+	var noCache bool
+	cmd.Flags().StringVarP(&dockerfile, "file", "f", "Dockerfile", "Path to the Dockerfile, relative to the context")
+	cmd.Flags().StringArrayVar(&buildArgs, "build-arg", nil, "Set a build-time variable as KEY=VALUE; may be repeated")
+	cmd.Flags().StringVar(&target, "target", "", "Build stage to target")
+	cmd.Flags().StringVarP(&tag, "tag", "t", "", "Also tag the built image locally")
+	cmd.Flags().BoolVar(&pull, "pull", false, "Always pull newer versions of base images")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Don't use cached layers")
+
+	var description string
+	var name string
+	var workspace string
+	cmd.Flags().StringVar(&description, "description", "", "Image description")
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Image name")
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", "", "Image workspace")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		contextDir := "."
+		if len(args) != 0 {
+			contextDir = args[0]
+		}
+
+		opts := types.ImageBuildOptions{
+			Dockerfile: filepath.ToSlash(filepath.Clean(dockerfile)),
+			BuildArgs:  make(map[string]*string, len(buildArgs)),
+			Target:     target,
+			PullParent: pull,
+			NoCache:    noCache,
+			Remove:     true,
+		}
+		if strings.HasPrefix(opts.Dockerfile, "../") {
+			return fmt.Errorf("the Dockerfile must be within the build context")
+		}
+		if tag != "" {
+			opts.Tags = []string{tag}
+		}
+		for _, arg := range buildArgs {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) == 1 {
+				// As with Docker, a bare key takes its value from the environment.
+				if value, ok := os.LookupEnv(parts[0]); ok {
+					opts.BuildArgs[parts[0]] = &value
+				}
+				continue
+			}
+			opts.BuildArgs[parts[0]] = &parts[1]
+		}
+
+		var err error
+		if workspace, err = resolveWorkspace(workspace, api.Write); err != nil {
+			return err
+		}
+
+		docker, err := newContainerClient()
+		if err != nil {
+			return fmt.Errorf("failed to create Docker client: %w", err)
+		}
+
+		buildContext, err := tarBuildContext(contextDir, filepath.FromSlash(opts.Dockerfile))
+		if err != nil {
+			return err
+		}
+		defer buildContext.Close()
+
+		if !quiet {
+			fmt.Printf("Building %s...\n", contextDir)
+		}
+		resp, err := docker.ImageBuild(ctx, buildContext, opts)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// Stream build output as the Docker CLI would, capturing the image ID.
+		var imageID string
+		var stream io.Writer = os.Stdout
+		if quiet {
+			stream = ioutil.Discard
+		}
+		if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, stream, 0, false, func(msg jsonmessage.JSONMessage) {
+			var result types.BuildResult
+			if msg.Aux != nil && json.Unmarshal(*msg.Aux, &result) == nil && result.ID != "" {
+				imageID = result.ID
+			}
+		}); err != nil {
+			return err
+		}
+		if imageID == "" {
+			return fmt.Errorf("build did not produce an image")
+		}
+
+		imageTag := imageID
+		if tag != "" {
+			imageTag = tag
+		}
+		return pushImage(docker, imageTag, api.ImageSpec{
+			Description: description,
+			Workspace:   workspace,
+		}, name)
+	}
+	return cmd
+}
+
 func newImageCommitCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "commit <image>",
@@ -54,7 +177,16 @@ func newImageCreateCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "create <docker image ID>",
 		Short: "Create a new image",
-		Args:  cobra.ExactArgs(1),
+		Long: `Create a new image
+
+Pushes a local Docker image to Beaker. On a terminal, each layer's progress is
+shown along with total throughput and the time remaining. Layers the registry
+already has are skipped and reported as already present.
+
+Layers are pushed concurrently by the Docker daemon, up to its
+max-concurrent-uploads setting (5 by default), which may be raised in the
+daemon's daemon.json.`,
+		Args: cobra.ExactArgs(1),
 	}
 
 	var description string
@@ -66,95 +198,94 @@ func newImageCreateCommand() *cobra.Command {
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		var err error
-		if workspace, err = ensureWorkspace(workspace); err != nil {
+		if workspace, err = resolveWorkspace(workspace, api.Write); err != nil {
 			return err
 		}
 
-		docker, err := docker.NewClientWithOpts(docker.FromEnv)
+		docker, err := newContainerClient()
 		if err != nil {
 			return fmt.Errorf("failed to create Docker client: %w", err)
 		}
-
-		imageTag := args[0]
-		dockerImage, _, err := docker.ImageInspectWithRaw(ctx, imageTag)
-		if err != nil {
-			return err
-		}
-
-		spec := api.ImageSpec{
+		return pushImage(docker, args[0], api.ImageSpec{
 			Description: description,
-			ImageID:     dockerImage.ID,
-			ImageTag:    imageTag,
 			Workspace:   workspace,
-		}
-		image, err := beaker.CreateImage(ctx, spec, name)
-		if err != nil {
-			return err
-		}
+		}, name)
+	}
+	return cmd
+}
 
-		if !quiet {
-			if name == "" {
-				fmt.Printf("Pushing %s as %s ...\n", imageTag, color.BlueString(image.Ref()))
-			} else {
-				fmt.Printf("Pushing %s as %s (%s)...\n", imageTag, color.BlueString(name), image.Ref())
-			}
-		}
+// pushImage registers a local Docker image with Beaker and pushes it.
+func pushImage(docker *docker.Client, imageTag string, spec api.ImageSpec, name string) error {
+	dockerImage, _, err := docker.ImageInspectWithRaw(ctx, imageTag)
+	if err != nil {
+		return err
+	}
 
-		repo, err := image.Repository(ctx, true)
-		if err != nil {
-			return fmt.Errorf("failed to retrieve credentials for remote repository: %w", err)
-		}
+	spec.ImageID = dockerImage.ID
+	spec.ImageTag = imageTag
+	image, err := beaker.CreateImage(ctx, spec, name)
+	if err != nil {
+		return err
+	}
 
-		// Tag the image to the remote repository.
-		if err := docker.ImageTag(ctx, imageTag, repo.ImageTag); err != nil {
-			return fmt.Errorf("failed to set remote image tag: %w", err)
-		}
-		defer func() {
-			// We ignore the error here intentionally. Cleaning up is best-effort
-			// and we can't do anything to recover if this fails.
-			_, _ = docker.ImageRemove(ctx, repo.ImageTag, types.ImageRemoveOptions{})
-		}()
-
-		authConfig := types.AuthConfig{
-			ServerAddress: repo.Auth.ServerAddress,
-			Username:      repo.Auth.User,
-			Password:      repo.Auth.Password,
-		}
-		authJSON, err := json.Marshal(authConfig)
-		if err != nil {
-			return fmt.Errorf("failed to encode remote repository auth: %w", err)
+	if !quiet {
+		if name == "" {
+			fmt.Printf("Pushing %s as %s ...\n", imageTag, color.BlueString(image.Ref()))
+		} else {
+			fmt.Printf("Pushing %s as %s (%s)...\n", imageTag, color.BlueString(name), image.Ref())
 		}
-		authStr := base64.URLEncoding.EncodeToString(authJSON)
+	}
 
-		r, err := docker.ImagePush(ctx, repo.ImageTag, types.ImagePushOptions{RegistryAuth: authStr})
-		if err != nil {
-			return err
-		}
-		// Display push responses as the Docker CLI would. This also translates remote errors.
-		var stream io.Writer = os.Stdout
-		if quiet {
-			stream = ioutil.Discard
-		}
-		if err := jsonmessage.DisplayJSONMessagesStream(r, stream, 0, false, nil); err != nil {
-			_ = r.Close()
-			return err
-		}
-		if err := r.Close(); err != nil {
-			return err
-		}
+	repo, err := image.Repository(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve credentials for remote repository: %w", err)
+	}
 
-		if err := image.Commit(ctx); err != nil {
-			return fmt.Errorf("failed to commit image: %w", err)
-		}
+	// Tag the image to the remote repository.
+	if err := docker.ImageTag(ctx, imageTag, repo.ImageTag); err != nil {
+		return fmt.Errorf("failed to set remote image tag: %w", err)
+	}
+	defer func() {
+		// We ignore the error here intentionally. Cleaning up is best-effort
+		// and we can't do anything to recover if this fails.
+		_, _ = docker.ImageRemove(ctx, repo.ImageTag, types.ImageRemoveOptions{})
+	}()
+
+	authConfig := types.AuthConfig{
+		ServerAddress: repo.Auth.ServerAddress,
+		Username:      repo.Auth.User,
+		Password:      repo.Auth.Password,
+	}
+	authJSON, err := json.Marshal(authConfig)
+	if err != nil {
+		return fmt.Errorf("failed to encode remote repository auth: %w", err)
+	}
+	authStr := base64.URLEncoding.EncodeToString(authJSON)
 
-		if quiet {
-			fmt.Println(image.Ref())
-		} else {
-			fmt.Println("Done.")
-		}
-		return nil
+	r, err := docker.ImagePush(ctx, repo.ImageTag, types.ImagePushOptions{RegistryAuth: authStr})
+	if err != nil {
+		return err
 	}
-	return cmd
+	// The daemon uploads layers concurrently; show each layer's progress.
+	// This also translates remote errors.
+	if err := displayImageProgress(r, "push"); err != nil {
+		_ = r.Close()
+		return err
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	if err := image.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit image: %w", err)
+	}
+
+	if quiet {
+		fmt.Println(image.Ref())
+	} else {
+		fmt.Println("Done.")
+	}
+	return nil
 }
 
 func newImageDeleteCommand() *cobra.Command {
@@ -184,7 +315,7 @@ func newImageGetCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var images []api.Image
 			for _, name := range args {
-				image, err := beaker.Image(name).Get(ctx)
+				image, err := getImage(name)
 				if err != nil {
 					return err
 				}
@@ -195,11 +326,143 @@ func newImageGetCommand() *cobra.Command {
 	}
 }
 
+// imageUse records an experiment which ran an image.
+type imageUse struct {
+	Experiment string    `json:"experiment"`
+	Name       string    `json:"name,omitempty"`
+	Workspace  string    `json:"workspace"`
+	Author     string    `json:"author"`
+	Tasks      []string  `json:"tasks"`
+	FirstUsed  time.Time `json:"firstUsed"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+func newImageHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history <image>",
+		Short: "List experiments which used an image",
+		Long: `List experiments which used an image, most recently used first.
+
+Use this to see who depends on an image before deleting or replacing it. Each
+experiment's executions record the image they ran, so experiments in every
+workspace you can see are searched. Pass --since to search faster, or
+--workspace to only show use in some workspaces.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var workspaces []string
+	var since string
+	cmd.Flags().StringArrayVarP(&workspaces, "workspace", "w", nil, "Only show use in a workspace; may be repeated")
+	cmd.Flags().StringVar(&since, "since", "", "Only show use since a date, time, or duration ago, e.g. 30d")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var cutoff time.Time
+		if since != "" {
+			var err error
+			if cutoff, err = parseTimeFlag(since, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		image, err := getImage(args[0])
+		if err != nil {
+			return err
+		}
+
+		uses, err := findImageUses(image, workspaces, cutoff)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(uses, func(i, j int) bool {
+			return uses[i].LastUsed.After(uses[j].LastUsed)
+		})
+		return printImageUses(uses)
+	}
+	return cmd
+}
+
+// findImageUses searches experiments in every workspace for executions of an
+// image since a cutoff. If workspaces are given, only experiments in them are
+// returned.
+func findImageUses(image *api.Image, workspaces []string, cutoff time.Time) ([]imageUse, error) {
+	inWorkspace := make(map[string]bool, len(workspaces))
+	for _, workspace := range workspaces {
+		inWorkspace[workspace] = true
+	}
+
+	opts := api.ExperimentSearchOptions{
+		SortClauses: []api.ExperimentSortClause{{
+			Field: api.ExperimentCreated, Order: api.SortDescending,
+		}},
+	}
+	if !cutoff.IsZero() {
+		// Executions are created with their experiment, so older experiments
+		// can't have used the image since the cutoff.
+		opts.FilterClauses = []api.ExperimentFilterClause{{
+			Field: api.ExperimentCreated, Operator: api.OpGreaterThanEqual, Value: cutoff,
+		}}
+	}
+
+	var uses []imageUse
+	for page := 0; ; page++ {
+		experiments, err := beaker.SearchExperiments(ctx, opts, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(experiments) == 0 {
+			return uses, nil
+		}
+		for _, experiment := range experiments {
+			if len(inWorkspace) != 0 && !inWorkspace[experiment.Workspace.FullName] &&
+				!inWorkspace[experiment.Workspace.ID] {
+				continue
+			}
+
+			use := imageUse{
+				Experiment: experiment.ID,
+				Name:       experiment.Name,
+				Workspace:  experiment.Workspace.FullName,
+				Author:     experiment.Author.Name,
+			}
+			tasks := make(map[string]bool)
+			for _, execution := range experiment.Executions {
+				ref := execution.Spec.Image.Beaker
+				if ref == "" || (ref != image.ID && ref != image.FullName) {
+					continue
+				}
+				created := execution.State.Created
+				if created.Before(cutoff) {
+					continue
+				}
+				if use.FirstUsed.IsZero() || created.Before(use.FirstUsed) {
+					use.FirstUsed = created
+				}
+				if created.After(use.LastUsed) {
+					use.LastUsed = created
+				}
+				if !tasks[execution.Spec.Name] {
+					tasks[execution.Spec.Name] = true
+					use.Tasks = append(use.Tasks, execution.Spec.Name)
+				}
+			}
+			if len(tasks) != 0 {
+				uses = append(uses, use)
+			}
+		}
+	}
+}
+
 func newImagePullCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "pull <image> [tag]",
 		Short: "Pull an image",
-		Args:  cobra.RangeArgs(1, 2),
+		Long: `Pull an image into the local Docker daemon
+
+The image is pulled from Beaker's registry using your Beaker token, so no
+separate registry login is needed. If a tag is given, the image is tagged with
+it instead of its registry location, e.g. "beaker image pull my-image
+my-image:latest".`,
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			imageRef := args[0]
 			var tag string
@@ -207,7 +470,7 @@ func newImagePullCommand() *cobra.Command {
 				tag = args[1]
 			}
 
-			docker, err := docker.NewClientWithOpts(docker.FromEnv)
+			docker, err := newContainerClient()
 			if err != nil {
 				return errors.Wrap(err, "failed to create Docker client")
 			}
@@ -238,13 +501,10 @@ func newImagePullCommand() *cobra.Command {
 			}
 			defer r.Close()
 
-			// Display push responses as the Docker CLI would. This also translates remote errors.
-			var stream io.Writer = os.Stdout
-			if quiet {
-				stream = ioutil.Discard
-			}
-			if err := jsonmessage.DisplayJSONMessagesStream(r, stream, 0, false, nil); err != nil {
-				return errors.WithStack(err)
+			// The daemon downloads layers concurrently; show each layer's
+			// progress. This also translates remote errors.
+			if err := displayImageProgress(r, "pull"); err != nil {
+				return err
 			}
 
 			if tag != "" {
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// imagePolicyPath is where administrators install the image policy. It's
+// read by every user's client, so it's kept beside the system-wide config.
+const imagePolicyPath = "/etc/beaker/image-policy.yml"
+
+// imagePolicy restricts the images tasks may use on each cluster, e.g.
+//
+//	clusters:
+//	  ai2/on-prem-*:
+//	    allow: ["beaker://ai2/*", "docker://nvcr.io/nvidia/*"]
+//	    deny: ["beaker://ai2/scratch-*"]
+//
+// Clusters and images are matched as in 'beaker experiment lint': a trailing
+// "*" matches any suffix, and images are written as "beaker://<image>" or
+// "docker://<tag>". Names are matched in canonical form so that a task can't
+// slip past a rule by naming its image or cluster differently: clusters and
+// Beaker images by full name, even if given by ID, and Docker images with
+// their registry, as in "docker://docker.io/library/ubuntu:latest". Docker
+// patterns are written either way.
+type imagePolicy struct {
+	Clusters map[string]imageRules `yaml:"clusters"`
+}
+
+// imageRules lists the images allowed and denied on matching clusters. Every
+// image is allowed if Allow is empty. Deny takes precedence over Allow.
+type imageRules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// readImagePolicy reads the installed image policy, or returns nil if there
+// isn't one.
+func readImagePolicy() (*imagePolicy, error) {
+	file, err := os.Open(imagePolicyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	var policy imagePolicy
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil {
+		return nil, errors.Wrapf(err, "invalid image policy %s", imagePolicyPath)
+	}
+	return &policy, nil
+}
+
+// check returns an error if an image may not be used on a cluster. Both must
+// be canonical; see policyNames. Every rule whose cluster pattern matches
+// applies.
+func (p *imagePolicy) check(cluster, image string) error {
+	patterns := make([]string, 0, len(p.Clusters))
+	for pattern := range p.Clusters {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if !matchImage([]string{pattern}, cluster) {
+			continue
+		}
+		rules := p.Clusters[pattern]
+		if matchImage(canonicalImagePatterns(rules.Deny), image) {
+			return fmt.Errorf("image %s is denied on cluster %s by the image policy", image, cluster)
+		}
+		if len(rules.Allow) != 0 && !matchImage(canonicalImagePatterns(rules.Allow), image) {
+			return fmt.Errorf("image %s is not allowed on cluster %s by the image policy", image, cluster)
+		}
+	}
+	return nil
+}
+
+// canonicalImagePatterns returns image patterns with Docker names in
+// canonical form.
+func canonicalImagePatterns(patterns []string) []string {
+	canonical := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "docker://") {
+			canonical[i] = pattern
+			continue
+		}
+		name := strings.TrimPrefix(pattern, "docker://")
+		if prefix := strings.TrimSuffix(name, "*"); prefix != name {
+			// A prefix has no tag to fill in, and an empty one matches anything.
+			if prefix != "" {
+				prefix = canonicalDockerName(prefix)
+			}
+			canonical[i] = "docker://" + prefix + "*"
+		} else {
+			canonical[i] = "docker://" + canonicalDockerImage(name)
+		}
+	}
+	return canonical
+}
+
+// canonicalDockerImage returns a Docker image reference with its registry,
+// namespace, and tag filled in as Docker does, so "ubuntu" becomes
+// "docker.io/library/ubuntu:latest". Image IDs are returned as they are.
+func canonicalDockerImage(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref
+	}
+	name := canonicalDockerName(ref)
+	if !strings.Contains(name, "@") && !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		name += ":latest"
+	}
+	return name
+}
+
+// canonicalDockerName fills in the registry and namespace of a Docker image
+// name. The first part of a name is its registry only if it looks like a host.
+func canonicalDockerName(name string) string {
+	registry, rest := "docker.io", name
+	if i := strings.Index(name, "/"); i != -1 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry, rest = first, name[i+1:]
+		}
+	}
+	if registry == "index.docker.io" {
+		registry = "docker.io"
+	}
+	if registry == "docker.io" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	return registry + "/" + rest
+}
+
+// policyNames resolves the clusters and images of tasks to the canonical
+// names the image policy matches, remembering each.
+type policyNames struct {
+	clusters map[string]string
+	images   map[string]string
+}
+
+func newPolicyNames() *policyNames {
+	return &policyNames{clusters: map[string]string{}, images: map[string]string{}}
+}
+
+// cluster returns the full name of a cluster given by name or ID.
+func (n *policyNames) cluster(ref string) (string, error) {
+	if name, ok := n.clusters[ref]; ok {
+		return name, nil
+	}
+	cluster, err := beaker.Cluster(ref).Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	n.clusters[ref] = cluster.FullName
+	return cluster.FullName, nil
+}
+
+// image returns the canonical form of an image written as "beaker://<image>"
+// or "docker://<tag>". Beaker images are named by full name, or by ID if they
+// have no name.
+func (n *policyNames) image(ref string) (string, error) {
+	if name, ok := n.images[ref]; ok {
+		return name, nil
+	}
+	var name string
+	if strings.HasPrefix(ref, "beaker://") {
+		image, err := getImage(strings.TrimPrefix(ref, "beaker://"))
+		if err != nil {
+			return "", err
+		}
+		name = image.FullName
+		if name == "" {
+			name = image.ID
+		}
+		name = "beaker://" + name
+	} else {
+		name = "docker://" + canonicalDockerImage(strings.TrimPrefix(ref, "docker://"))
+	}
+	n.images[ref] = name
+	return name, nil
+}
+
+// checkImagePolicy returns a description of each task which uses an image the
+// policy doesn't allow on its cluster, or on any later cluster of its chain.
+// Tasks whose image or cluster can't be resolved are reported too, since the
+// policy can't be checked.
+func checkImagePolicy(policy *imagePolicy, names *policyNames, tasks []validateTask, chain *fallbackChain) []string {
+	if policy == nil {
+		return nil
+	}
+	var problems []string
+	for _, task := range tasks {
+		if task.ImageURL.Ref == "" {
+			continue
+		}
+		image, err := names.image(task.ImageURL.Ref)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s: couldn't resolve image for the image policy: %v",
+				task.Name, task.ImageURL.Path, err))
+			continue
+		}
+		clusters := []string{task.Cluster.Ref}
+		if chain != nil {
+			clusters = chain.Clusters
+		}
+		for _, ref := range clusters {
+			if ref == "" {
+				continue
+			}
+			cluster, err := names.cluster(ref)
+			if err == nil {
+				err = policy.check(cluster, image)
+			}
+			if err != nil {
+				problems = append(problems, task.Name+": "+task.ImageURL.Path+": "+err.Error())
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// enforceImagePolicy checks runs against the installed image policy, printing
+// each violation. Unlike other validation it isn't skipped with --skip-verify.
+func enforceImagePolicy(runs []sweepRun, chains []*fallbackChain) error {
+	policy, err := readImagePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	names := newPolicyNames()
+	var count int
+	for i, run := range runs {
+		tasks, err := parseValidateTasks(run.Spec)
+		if err != nil {
+			return err
+		}
+		for _, problem := range checkImagePolicy(policy, names, tasks, chains[i]) {
+			count++
+			if len(run.Point.names) != 0 {
+				fmt.Printf("(%s) %s\n", run.Point, problem)
+			} else {
+				fmt.Println(problem)
+			}
+		}
+	}
+	if count != 0 {
+		return fmt.Errorf("spec has %d image policy violation(s); nothing was created", count)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalDockerImage(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu":                         "docker.io/library/ubuntu:latest",
+		"ubuntu:20.04":                   "docker.io/library/ubuntu:20.04",
+		"allenai/base":                   "docker.io/allenai/base:latest",
+		"docker.io/ubuntu":               "docker.io/library/ubuntu:latest",
+		"index.docker.io/library/ubuntu": "docker.io/library/ubuntu:latest",
+		"nvcr.io/nvidia/pytorch:21.03":   "nvcr.io/nvidia/pytorch:21.03",
+		"localhost/image":                "localhost/image:latest",
+		"localhost:5000/image":           "localhost:5000/image:latest",
+		"ubuntu@sha256:abc":              "docker.io/library/ubuntu@sha256:abc",
+		"sha256:abc":                     "sha256:abc",
+	}
+	for ref, want := range tests {
+		if got := canonicalDockerImage(ref); got != want {
+			t.Errorf("canonicalDockerImage(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestCanonicalImagePatterns(t *testing.T) {
+	got := canonicalImagePatterns([]string{
+		"beaker://ai2/*",
+		"docker://ubuntu",
+		"docker://nvcr.io/nvidia/*",
+		"docker://allenai/*",
+		"docker://*",
+	})
+	want := []string{
+		"beaker://ai2/*",
+		"docker://docker.io/library/ubuntu:latest",
+		"docker://nvcr.io/nvidia/*",
+		"docker://docker.io/allenai/*",
+		"docker://*",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("canonicalImagePatterns = %q, want %q", got, want)
+	}
+}
+
+func TestImagePolicyCheck(t *testing.T) {
+	policy := &imagePolicy{Clusters: map[string]imageRules{
+		"ai2/on-prem-*": {
+			Allow: []string{"beaker://ai2/*", "docker://nvcr.io/nvidia/*", "docker://ubuntu"},
+			Deny:  []string{"beaker://ai2/scratch-*"},
+		},
+		"ai2/on-prem-a100": {
+			Deny: []string{"docker://ubuntu"},
+		},
+		"ai2/cloud": {
+			Deny: []string{"docker://*"},
+		},
+	}}
+
+	tests := []struct {
+		cluster string
+		image   string
+		allowed bool
+	}{
+		{"ai2/on-prem-v100", "beaker://ai2/base", true},
+		{"ai2/on-prem-v100", "beaker://ai2/scratch-image", false},
+		{"ai2/on-prem-v100", "beaker://other/base", false},
+		{"ai2/on-prem-v100", "docker://nvcr.io/nvidia/pytorch:21.03", true},
+		{"ai2/on-prem-v100", "docker://docker.io/library/ubuntu:latest", true},
+		{"ai2/on-prem-v100", "docker://docker.io/library/ubuntu:20.04", false},
+		{"ai2/on-prem-a100", "docker://docker.io/library/ubuntu:latest", false},
+		{"ai2/on-prem-a100", "beaker://ai2/base", true},
+		{"ai2/cloud", "docker://docker.io/library/ubuntu:latest", false},
+		{"ai2/cloud", "beaker://other/base", true},
+		{"ai2/unlisted", "docker://docker.io/library/ubuntu:latest", true},
+	}
+	for _, tt := range tests {
+		err := policy.check(tt.cluster, tt.image)
+		if tt.allowed && err != nil {
+			t.Errorf("check(%q, %q): unexpected error: %v", tt.cluster, tt.image, err)
+		} else if !tt.allowed && err == nil {
+			t.Errorf("check(%q, %q): expected an error", tt.cluster, tt.image)
+		}
+	}
+}
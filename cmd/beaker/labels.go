@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/allenai/beaker/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Label keys are words separated by dots, dashes, underscores, or slashes,
+// such as "gpu", "scratch", or "ai2.org/fabric".
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// parseLabels parses node labels or constraints written as key=value.
+func parseLabels(values []string) (map[string]string, error) {
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !labelKey.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid label %q; must be key=value", value)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// formatLabels writes labels as key=value, sorted by key.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// checkConstraints returns an error unless a node has every label required
+// by the constraints.
+func checkConstraints(node string, labels, constraints map[string]string) error {
+	var unmet []string
+	for key, value := range constraints {
+		if labels[key] != value {
+			unmet = append(unmet, key+"="+value)
+		}
+	}
+	if len(unmet) == 0 {
+		return nil
+	}
+	sort.Strings(unmet)
+	if len(labels) == 0 {
+		return fmt.Errorf("node %s has no labels; it doesn't satisfy %s", node, strings.Join(unmet, ", "))
+	}
+	return fmt.Errorf("node %s doesn't satisfy %s; its labels are %s",
+		node, strings.Join(unmet, ", "), formatLabels(labels))
+}
+
+// localNodeLabels returns the ID and labels of the node whose executor runs on
+// this machine, or an empty ID if there's none. Labels are only kept in the
+// executor's config, so other nodes' labels can't be read.
+func localNodeLabels() (string, map[string]string, error) {
+	config, err := getExecutorConfig()
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read executor config: %w", err)
+	}
+	node, err := getCurrentNode()
+	if os.IsNotExist(err) {
+		// The executor hasn't registered its node yet.
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return node, config.Labels, nil
+}
+
+// updateExecutorLabels sets and removes labels in the executor's config file,
+// leaving the rest of the file as written, and returns the resulting labels.
+func updateExecutorLabels(set map[string]string, remove []string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(executorConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, errors.Wrapf(err, "invalid executor config %s", executorConfigPath)
+	}
+	root := documentRoot(&doc)
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid executor config %s", executorConfigPath)
+	}
+
+	labels := make(map[string]string)
+	if section := config.MappingValue(root, "labels"); section != nil {
+		if err := section.Decode(&labels); err != nil {
+			return nil, errors.Wrapf(err, "invalid labels in %s", executorConfigPath)
+		}
+	}
+	for key, value := range set {
+		labels[key] = value
+	}
+	for _, key := range remove {
+		delete(labels, key)
+	}
+
+	if len(labels) == 0 {
+		deleteMappingKey(root, "labels")
+	} else {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		section := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			setMappingValue(section, key, stringNode(labels[key]))
+		}
+		setMappingValue(root, "labels", section)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(executorConfigPath, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
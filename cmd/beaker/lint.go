@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// specPolicy describes rules which experiment specs must follow.
+type specPolicy struct {
+	// Require every task to set a timeout.
+	RequireTimeout bool `yaml:"requireTimeout"`
+
+	// Images which tasks may use, written as "beaker://<image>" or
+	// "docker://<tag>". A trailing "*" matches any suffix.
+	AllowedImages []string `yaml:"allowedImages"`
+
+	// Path where every task must write its results.
+	ResultPath string `yaml:"resultPath"`
+}
+
+// readPolicy reads a spec policy from a YAML file.
+func readPolicy(filename string) (*specPolicy, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	var policy specPolicy
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil {
+		return nil, errors.Wrapf(err, "invalid policy %s", filename)
+	}
+	return &policy, nil
+}
+
+// lint returns a description of each way a task violates the policy.
+func (p *specPolicy) lint(task validateTask) []string {
+	var problems []string
+	if image := task.ImageURL.Ref; image == "" {
+		problems = append(problems, "no image is set")
+	} else if len(p.AllowedImages) != 0 && !matchImage(p.AllowedImages, image) {
+		problems = append(problems, fmt.Sprintf("image %s is not approved", image))
+	}
+	if task.ResultPath == "" {
+		problems = append(problems, "no result path is set")
+	} else if p.ResultPath != "" && task.ResultPath != p.ResultPath {
+		problems = append(problems, fmt.Sprintf("result path must be %s, got %s", p.ResultPath, task.ResultPath))
+	}
+	if p.RequireTimeout && !task.HasTimeout {
+		problems = append(problems, "no timeout is set")
+	}
+	return problems
+}
+
+func matchImage(patterns []string, image string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(image, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if image == pattern {
+			return true
+		}
+	}
+	return false
+}
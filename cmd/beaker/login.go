@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/allenai/beaker/config"
+	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
+	"github.com/fatih/color"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+// Path of the web page which issues tokens to the CLI. It redirects to the
+// given redirect_uri with the token and state once the user has logged in.
+const loginPath = "/cli/login"
+
+// Path of the web page which displays a user's token, for pasting.
+const tokenPagePath = "/user"
+
+func newLoginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in to Beaker and save your token",
+		Long: `Log in to Beaker and save your token
+
+Opens a browser to log in to Beaker, which sends your token back to this
+command. If a browser can't be opened, such as over SSH, copy your token from
+the page printed instead and paste it when prompted. The token is checked
+against Beaker before it's saved to your config file, or to the OS keychain if
+the credential_store setting is "keychain".`,
+		Args: cobra.NoArgs,
+	}
+
+	var noBrowser bool
+	cmd.Flags().BoolVar(&noBrowser, "no-browser", false, "Don't open a browser; paste a token instead")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		user, err := logIn(noBrowser)
+		if err != nil {
+			return err
+		}
+		if !quiet {
+			fmt.Printf("Logged in as %s\n", color.BlueString(user.Name))
+		}
+		if _, ok := os.LookupEnv("BEAKER_TOKEN"); ok {
+			fmt.Fprintln(os.Stderr, color.YellowString("Warning:"),
+				"BEAKER_TOKEN is set and overrides the saved token")
+		}
+		return nil
+	}
+	return cmd
+}
+
+// logIn requests a token from the user, validates it, and saves it to the
+// config file. The global client is replaced with one using the new token.
+func logIn(noBrowser bool) (*api.UserDetail, error) {
+	var verified *client.Client
+	var user *api.UserDetail
+	token, err := requestToken(beakerConfig.BeakerAddress, noBrowser, func(token string) error {
+		var err error
+		if verified, err = client.NewClient(beakerConfig.BeakerAddress, token); err != nil {
+			return err
+		}
+		user, err = verified.WhoAmI(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.SaveUserToken(config.GetFilePath(), beakerConfig.Context(), beakerConfig.BeakerAddress, token); err != nil {
+		return nil, err
+	}
+
+	beakerConfig.UserToken = token
+	beaker = verified
+	return user, nil
+}
+
+func newLogoutCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Remove your saved token",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deleted, err := config.DeleteUserToken(config.GetFilePath(), beakerConfig.Context(), beakerConfig.BeakerAddress)
+			if err != nil {
+				return err
+			}
+			if !quiet {
+				if deleted {
+					fmt.Println("Logged out")
+				} else {
+					fmt.Println("Not logged in")
+				}
+			}
+
+			if _, ok := os.LookupEnv("BEAKER_TOKEN"); ok {
+				fmt.Fprintln(os.Stderr, color.YellowString("Warning:"),
+					"BEAKER_TOKEN is still set; unset it to log out completely")
+			}
+			return nil
+		},
+	}
+}
+
+// requestToken gets a token from the user and checks it with verify. Unless
+// noBrowser is set, a browser is opened to log in, which delivers the token
+// to a local callback server. The user may paste a token into the terminal at
+// any time instead, and is asked again if it's invalid.
+func requestToken(address string, noBrowser bool, verify func(token string) error) (string, error) {
+	tokens := make(chan string, 1)
+
+	opened := false
+	if !noBrowser {
+		loginURL, shutdown, err := serveLoginCallback(address, tokens)
+		if err != nil {
+			return "", err
+		}
+		defer shutdown()
+
+		if err := openBrowser(loginURL); err == nil {
+			opened = true
+			fmt.Printf("Opened %s in your browser to log in.\n", loginURL)
+		}
+	}
+	if !opened {
+		fmt.Printf("Copy your token from %s%s\n", strings.TrimSuffix(address, "/"), tokenPagePath)
+	}
+
+	prompt := "Paste your token: "
+	if opened {
+		prompt = "Waiting for the browser... or paste your token: "
+	}
+	fmt.Print(prompt)
+
+	// Tokens are secret, so don't echo them.
+	if fd, isTerminal := term.GetFdInfo(os.Stdin); isTerminal {
+		state, err := term.SaveState(fd)
+		if err != nil {
+			return "", err
+		}
+		if err := term.DisableEcho(fd, state); err != nil {
+			return "", err
+		}
+		defer func() { _ = term.RestoreTerminal(fd, state) }()
+	}
+
+	for {
+		var token string
+		pasted := false
+		select {
+		case token = <-tokens:
+		case line := <-stdinLines():
+			if line.err != nil && line.text == "" {
+				fmt.Println()
+				return "", line.err
+			}
+			token, pasted = strings.TrimSpace(line.text), true
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		fmt.Println()
+
+		err := errors.New("no token given")
+		if token != "" {
+			err = verify(token)
+		}
+		if err == nil {
+			return token, nil
+		}
+		if !pasted {
+			return "", fmt.Errorf("couldn't log in with the token: %w", err)
+		}
+		fmt.Print("Invalid token, please try again: ")
+	}
+}
+
+// serveLoginCallback starts a server on the loopback interface which receives
+// a token from the login page. It returns the URL of the login page.
+func serveLoginCallback(address string, tokens chan<- string) (string, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		listener.Close()
+		return "", nil, err
+	}
+	state := hex.EncodeToString(b)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The state ensures that only the login page we opened can log us in.
+		query := r.URL.Query()
+		if r.URL.Path != "/callback" || query.Get("state") != state || query.Get("token") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "Logged in to Beaker. You can close this window and return to your terminal.")
+
+		// Only the first token is used; the page may be reloaded.
+		select {
+		case tokens <- query.Get("token"):
+		default:
+		}
+	})}
+	go func() { _ = server.Serve(listener) }()
+
+	redirect := fmt.Sprintf("http://%s/callback", listener.Addr())
+	loginURL := strings.TrimSuffix(address, "/") + loginPath + "?" + url.Values{
+		"redirect_uri": {redirect},
+		"state":        {state},
+	}.Encode()
+	return loginURL, func() { _ = server.Shutdown(context.Background()) }, nil
+}
+
+// openBrowser opens a URL in the user's default browser.
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return fmt.Errorf("no display")
+		}
+		cmd = exec.Command("xdg-open", u)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/beaker/runtime"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// logLine is a line of output from a task or session.
+type logLine struct {
+	Time time.Time
+	Text string
+}
+
+// logSource produces the logs of a task or session.
+type logSource interface {
+	// next returns lines written since it was last called and whether the
+	// source will write any more.
+	next() ([]logLine, bool, error)
+}
+
+// namedLogSource is a log source along with the prefix of its lines.
+type namedLogSource struct {
+	name   string
+	source logSource
+}
+
+// logOptions selects which lines of a log are printed.
+type logOptions struct {
+	follow     bool
+	tail       int
+	since      time.Time
+	timestamps bool
+	interval   time.Duration
+}
+
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <experiment|task|execution|session>",
+		Short: "Print the logs of an experiment, task, execution, or session",
+		Long: `Print the logs of an experiment, task, execution, or session.
+
+The logs of an experiment include every task, with each line prefixed by its
+task's name. Logs of a task come from its latest execution. Session logs are
+read from the session's container, so they're only available on the session's
+node while it runs.
+
+With --follow, new output is printed as it's written until every task or
+session has finished.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	flags := addLogFlags(cmd)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		sources, err := findLogSources(args[0])
+		if err != nil {
+			return err
+		}
+		return runLogs(sources, flags.options())
+	}
+	return cmd
+}
+
+// logFlags are the flags shared by commands which print logs.
+type logFlags struct {
+	logOptions
+	since time.Duration
+}
+
+func addLogFlags(cmd *cobra.Command) *logFlags {
+	var flags logFlags
+	cmd.Flags().BoolVarP(&flags.follow, "follow", "f", false, "Print new output as it's written")
+	cmd.Flags().IntVar(&flags.tail, "tail", -1, "Number of lines to print from the end of each log; -1 prints all")
+	cmd.Flags().DurationVar(&flags.since, "since", 0, "Only print output written within this duration, e.g. 1h")
+	cmd.Flags().BoolVarP(&flags.timestamps, "timestamps", "t", false, "Print the time each line was written")
+	cmd.Flags().DurationVar(&flags.interval, "interval", 5*time.Second, "How often to check for new output with --follow")
+	return &flags
+}
+
+func (f *logFlags) options() logOptions {
+	opts := f.logOptions
+	if f.since != 0 {
+		opts.since = time.Now().Add(-f.since)
+	}
+	return opts
+}
+
+// runLogs prints the logs of sources, following them if requested.
+func runLogs(sources []namedLogSource, opts logOptions) error {
+	if !opts.follow {
+		return printLogs(sources, opts)
+	}
+
+	prefixes := logPrefixes(sources)
+	if !opts.timestamps || len(sources) < 2 {
+		return followLogs(sources, opts, func(source int, lines []logLine) error {
+			for _, line := range lines {
+				printLogLine(prefixes[source], line, opts)
+			}
+			return nil
+		})
+	}
+
+	// Sources are polled separately, so each line is held for an interval to
+	// print it in order of time with lines of other sources written meanwhile.
+	var mu sync.Mutex
+	var pending []logEntry
+	flush := func(before time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.SliceStable(pending, func(i, j int) bool {
+			return pending[i].line.Time.Before(pending[j].line.Time)
+		})
+		n := 0
+		for n < len(pending) && (before.IsZero() || pending[n].line.Time.Before(before)) {
+			printLogLine(prefixes[pending[n].source], pending[n].line, opts)
+			n++
+		}
+		pending = pending[n:]
+	}
+
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		ticker := time.NewTicker(opts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				flush(time.Now().Add(-2 * opts.interval))
+			}
+		}
+	}()
+
+	err := followLogs(sources, opts, func(source int, lines []logLine) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, line := range lines {
+			pending = append(pending, logEntry{source, line})
+		}
+		return nil
+	})
+	close(done)
+	<-flushed
+	flush(time.Time{})
+	return err
+}
+
+// logEntry is a line of the source with the given index.
+type logEntry struct {
+	source int
+	line   logLine
+}
+
+// findLogSources finds the logs of an experiment's tasks, a task, an execution,
+// or a session.
+func findLogSources(ref string) ([]namedLogSource, error) {
+	experiment, err := beaker.Experiment(ref).Get(ctx)
+	if err == nil {
+		return findExperimentLogSources(experiment.ID, nil)
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	task, err := beaker.Task(ref).Get(ctx)
+	if err == nil {
+		return []namedLogSource{{source: &taskLogs{id: task.ID}}}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	execution, err := beaker.Execution(ref).Get(ctx)
+	if err == nil {
+		return []namedLogSource{{source: &executionLogs{id: execution.ID}}}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	session, err := beaker.Session(ref).Get(ctx)
+	if err == nil {
+		container, err := findRunningContainer(session.ID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read logs of session %s: %w", session.ID, err)
+		}
+		return []namedLogSource{{source: &sessionLogs{container: container}}}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s is not an experiment, task, execution, or session", ref)
+}
+
+// findExperimentLogSources finds the logs of an experiment's tasks. If tasks
+// are selected by name or by index from 0, only their logs are found.
+func findExperimentLogSources(experimentID string, selected []string) ([]namedLogSource, error) {
+	tasks, err := beaker.Experiment(experimentID).Tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tasks, err = selectExperimentTasks(experimentID, tasks, selected); err != nil {
+		return nil, err
+	}
+
+	var sources []namedLogSource
+	for _, task := range tasks {
+		name := task.Name
+		if name == "" {
+			name = task.ID
+		}
+		sources = append(sources, namedLogSource{name: name, source: &taskLogs{id: task.ID}})
+	}
+	return sources, nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr api.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// printLogs prints each source's logs so far, merged in order of time.
+func printLogs(sources []namedLogSource, opts logOptions) error {
+	var entries []logEntry
+	for i, source := range sources {
+		lines, _, err := source.source.next()
+		if err != nil {
+			return err
+		}
+		for _, line := range selectLines(lines, opts) {
+			entries = append(entries, logEntry{i, line})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].line.Time.Before(entries[j].line.Time)
+	})
+
+	prefixes := logPrefixes(sources)
+	for _, entry := range entries {
+		printLogLine(prefixes[entry.source], entry.line, opts)
+	}
+	return nil
+}
+
+// followLogs passes the lines of each source to handle as they're written until
+// every source is done. Sources are identified by their indexes.
+func followLogs(sources []namedLogSource, opts logOptions, handle func(source int, lines []logLine) error) error {
+	type batch struct {
+		source int
+		lines  []logLine
+	}
+
+	batches := make(chan batch)
+	errs := make(chan error, len(sources))
+	for i, source := range sources {
+		go func(i int, source logSource) {
+			first := true
+			for {
+				lines, done, err := source.next()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if first {
+					lines = selectLines(lines, opts)
+					first = false
+				} else {
+					lines = selectLines(lines, logOptions{tail: -1, since: opts.since})
+				}
+
+				if len(lines) != 0 {
+					select {
+					case batches <- batch{i, lines}:
+					case <-ctx.Done():
+						errs <- ctx.Err()
+						return
+					}
+				}
+				if done {
+					errs <- nil
+					return
+				}
+
+				select {
+				case <-time.After(opts.interval):
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+		}(i, source.source)
+	}
+
+	for remaining := len(sources); remaining > 0; {
+		select {
+		case b := <-batches:
+			if err := handle(b.source, b.lines); err != nil {
+				return err
+			}
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+			remaining--
+		}
+	}
+	return nil
+}
+
+// selectLines applies the --since and --tail options to a log.
+func selectLines(lines []logLine, opts logOptions) []logLine {
+	if !opts.since.IsZero() {
+		start := 0
+		for start < len(lines) && !lines[start].Time.IsZero() && lines[start].Time.Before(opts.since) {
+			start++
+		}
+		lines = lines[start:]
+	}
+	if opts.tail >= 0 && len(lines) > opts.tail {
+		lines = lines[len(lines)-opts.tail:]
+	}
+	return lines
+}
+
+// Colors of the prefixes which distinguish sources, in order of use.
+var logColors = []color.Attribute{
+	color.FgCyan, color.FgYellow, color.FgGreen, color.FgMagenta, color.FgBlue, color.FgRed,
+}
+
+// logPrefixes returns the prefix of each source's lines, padded to line up.
+// Lines aren't prefixed if there's only one source.
+func logPrefixes(sources []namedLogSource) []string {
+	prefixes := make([]string, len(sources))
+	if len(sources) < 2 {
+		return prefixes
+	}
+
+	var width int
+	for _, source := range sources {
+		if len(source.name) > width {
+			width = len(source.name)
+		}
+	}
+	for i, source := range sources {
+		c := color.New(logColors[i%len(logColors)])
+		prefixes[i] = c.Sprintf("%-*s |", width, source.name) + " "
+	}
+	return prefixes
+}
+
+func printLogLine(prefix string, line logLine, opts logOptions) {
+	if opts.timestamps && !line.Time.IsZero() {
+		prefix += line.Time.Format(time.RFC3339Nano) + " "
+	}
+	fmt.Println(prefix + line.Text)
+}
+
+// taskLogs reads the logs of a task's latest execution. If the task is run
+// again, the logs of the new execution follow.
+type taskLogs struct {
+	id        string
+	execution string
+	cursor    logCursor
+}
+
+func (t *taskLogs) next() ([]logLine, bool, error) {
+	task, err := beaker.Task(t.id).Get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(task.Executions) == 0 {
+		return nil, false, nil
+	}
+	execution := task.Executions[len(task.Executions)-1]
+	if execution.ID != t.execution {
+		t.execution, t.cursor = execution.ID, logCursor{}
+	}
+
+	// Check whether the execution finished before reading so that output
+	// written just before it finished is still printed.
+	done := execution.State.Finalized != nil
+	lines, err := readExecutionLogs(execution.ID, &t.cursor, done)
+	return lines, done, err
+}
+
+// executionLogs reads the logs of a single execution.
+type executionLogs struct {
+	id     string
+	cursor logCursor
+}
+
+func (e *executionLogs) next() ([]logLine, bool, error) {
+	execution, err := beaker.Execution(e.id).Get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	done := execution.State.Finalized != nil
+	lines, err := readExecutionLogs(e.id, &e.cursor, done)
+	return lines, done, err
+}
+
+// logCursor is how far an execution's logs have been read, in bytes.
+type logCursor struct {
+	offset int64
+}
+
+// readExecutionLogs returns the lines of an execution's logs after the cursor,
+// and advances the cursor past them. Beaker always returns an execution's
+// whole log, so what was already read is skipped. A partial last line is left
+// for the next read unless the execution is done.
+func readExecutionLogs(id string, cursor *logCursor, done bool) ([]logLine, error) {
+	logs, err := beaker.Execution(id).GetLogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, logs, cursor.offset); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(logs)
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		b = b[:bytes.LastIndexByte(b, '\n')+1]
+	}
+	cursor.offset += int64(len(b))
+	return parseExecutionLogs(bytes.NewReader(b))
+}
+
+// parseExecutionLogs parses logs with a timestamp at the start of each line.
+func parseExecutionLogs(r io.Reader) ([]logLine, error) {
+	var lines []logLine
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		text := scanner.Text()
+		line := logLine{Text: text}
+		if i := strings.IndexByte(text, ' '); i != -1 {
+			if t, err := time.Parse(time.RFC3339Nano, text[:i]); err == nil {
+				line = logLine{Time: t, Text: text[i+1:]}
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// sessionLogs reads the output of a session's container.
+type sessionLogs struct {
+	container runtime.Container
+	last      time.Time
+}
+
+func (s *sessionLogs) next() ([]logLine, bool, error) {
+	info, err := s.container.Info(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	done := info.Status != runtime.StatusRunning
+
+	var lines []logLine
+	err = readSessionLogs(s.container, s.last, func(t time.Time, line string) bool {
+		// Logs are read from the time of the last line, which was already printed.
+		if !t.After(s.last) {
+			return true
+		}
+		s.last = t
+		lines = append(lines, logLine{Time: t, Text: strings.TrimRight(line, "\r\n")})
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return lines, done, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/allenai/beaker/config"
+	"github.com/beaker/client/api"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// logSink is a destination to which an experiment's logs are forwarded.
+// Exactly one field is set.
+type logSink struct {
+	// Path of a local file to which logs are appended.
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+
+	// Name of a dataset created in the experiment's workspace once the
+	// experiment finishes.
+	Dataset string `json:"dataset,omitempty" yaml:"dataset,omitempty"`
+
+	// URL of a GCS location, e.g. gs://bucket/prefix, under which logs are
+	// uploaded once the experiment finishes. Requires gsutil.
+	GCS string `json:"gcs,omitempty" yaml:"gcs,omitempty"`
+
+	// HTTP endpoint to which batches of logs are posted as they're written.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+// Kinds of log sinks, named by their fields.
+const (
+	logSinkFile    = "file"
+	logSinkDataset = "dataset"
+	logSinkGCS     = "gcs"
+	logSinkURL     = "url"
+)
+
+// parseLogSink parses a sink written as "<kind>=<value>", e.g. "file=out.log".
+func parseLogSink(s string) (logSink, error) {
+	var sink logSink
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return sink, fmt.Errorf("invalid log sink %q; must be <kind>=<value>", s)
+	}
+	switch parts[0] {
+	case logSinkFile:
+		sink.File = parts[1]
+	case logSinkDataset:
+		sink.Dataset = parts[1]
+	case logSinkGCS:
+		sink.GCS = parts[1]
+	case logSinkURL:
+		sink.URL = parts[1]
+	default:
+		return sink, fmt.Errorf("invalid log sink kind %q; must be %s, %s, %s, or %s",
+			parts[0], logSinkFile, logSinkDataset, logSinkGCS, logSinkURL)
+	}
+	return sink, sink.validate()
+}
+
+// kind returns the kind and value of a sink.
+func (s logSink) kind() (string, string) {
+	switch {
+	case s.File != "":
+		return logSinkFile, s.File
+	case s.Dataset != "":
+		return logSinkDataset, s.Dataset
+	case s.GCS != "":
+		return logSinkGCS, s.GCS
+	default:
+		return logSinkURL, s.URL
+	}
+}
+
+func (s logSink) String() string {
+	kind, value := s.kind()
+	return kind + "=" + value
+}
+
+func (s logSink) validate() error {
+	var set int
+	for _, value := range []string{s.File, s.Dataset, s.GCS, s.URL} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("each log sink must set exactly one of %s, %s, %s, or %s",
+			logSinkFile, logSinkDataset, logSinkGCS, logSinkURL)
+	}
+
+	if s.GCS != "" && !strings.HasPrefix(s.GCS, "gs://") {
+		return fmt.Errorf("invalid GCS location %q; must start with gs://", s.GCS)
+	}
+	if s.URL != "" {
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid log sink URL %q", s.URL)
+		}
+	}
+	return nil
+}
+
+// extractLogSinks removes the top-level "logSinks" section from a rendered
+// spec, which the Beaker service doesn't accept, and returns the sinks.
+func extractLogSinks(spec []byte) ([]byte, []logSink, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse spec")
+	}
+	root := documentRoot(&doc)
+	section := config.MappingValue(root, "logSinks")
+	if section == nil {
+		return spec, nil, nil
+	}
+
+	var sinks []logSink
+	if err := section.Decode(&sinks); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid logSinks")
+	}
+	for i, sink := range sinks {
+		if err := sink.validate(); err != nil {
+			return nil, nil, errors.WithMessagef(err, "logSinks[%d]", i)
+		}
+	}
+
+	deleteMappingKey(root, "logSinks")
+	spec, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return spec, sinks, nil
+}
+
+// extractRunLogSinks removes the log sinks from each run's spec and returns
+// them in the order of the runs.
+func extractRunLogSinks(runs []sweepRun) ([][]logSink, error) {
+	sinks := make([][]logSink, len(runs))
+	for i := range runs {
+		spec, runSinks, err := extractLogSinks(runs[i].Spec)
+		if err != nil {
+			return nil, err
+		}
+		runs[i].Spec, sinks[i] = spec, runSinks
+	}
+	return sinks, nil
+}
+
+// logSinksStateKind is the state directory of experiments' log sinks.
+const logSinksStateKind = "logsinks"
+
+// readLogSinks returns the log sinks configured for an experiment.
+func readLogSinks(experimentID string) ([]logSink, error) {
+	var sinks []logSink
+	if _, err := readState(logSinksStateKind, experimentID, &sinks); err != nil {
+		return nil, err
+	}
+	return sinks, nil
+}
+
+// writeLogSinks configures the log sinks of an experiment.
+func writeLogSinks(experimentID string, sinks []logSink) error {
+	return writeState(logSinksStateKind, experimentID, sinks)
+}
+
+// logRecord is a line of an experiment's logs as sent to sinks.
+type logRecord struct {
+	Experiment string    `json:"experiment"`
+	Task       string    `json:"task"`
+	Time       time.Time `json:"time"`
+	Text       string    `json:"text"`
+}
+
+// forwardLogs follows an experiment's logs until it finishes, sending each
+// line to every sink as newline-delimited JSON. Dataset and GCS sinks receive
+// the whole log once the experiment has finished.
+func forwardLogs(experiment *api.Experiment, sinks []logSink, interval time.Duration) error {
+	sources, err := findLogSources(experiment.ID)
+	if err != nil {
+		return err
+	}
+
+	// Logs are collected in a file for the sinks which are written at the end.
+	collected, err := ioutil.TempFile("", "beaker-logs-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(collected.Name())
+	defer collected.Close()
+
+	var files []*os.File
+	for _, sink := range sinks {
+		if sink.File == "" {
+			continue
+		}
+		f, err := os.OpenFile(sink.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	var lines int
+	err = followLogs(sources, logOptions{tail: -1, interval: interval}, func(source int, batch []logLine) error {
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		for _, line := range batch {
+			if err := encoder.Encode(logRecord{
+				Experiment: experiment.ID,
+				Task:       sources[source].name,
+				Time:       line.Time,
+				Text:       line.Text,
+			}); err != nil {
+				return err
+			}
+		}
+		lines += len(batch)
+
+		if _, err := collected.Write(b.Bytes()); err != nil {
+			return errors.WithStack(err)
+		}
+		for _, f := range files {
+			if _, err := f.Write(b.Bytes()); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		for _, sink := range sinks {
+			if sink.URL != "" {
+				if err := postLogs(sink.URL, b.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sink := range sinks {
+		switch {
+		case sink.Dataset != "":
+			err = uploadLogDataset(experiment, sink.Dataset, collected.Name())
+		case sink.GCS != "":
+			err = uploadLogGCS(experiment, sink.GCS, collected.Name())
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "log sink %s", sink)
+		}
+	}
+
+	if !quiet {
+		fmt.Printf("Forwarded %d lines to %d sink(s)\n", lines, len(sinks))
+	}
+	return nil
+}
+
+// postLogs posts a batch of newline-delimited JSON records.
+func postLogs(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log sink %s responded with %s", url, resp.Status)
+	}
+	return nil
+}
+
+// uploadLogDataset creates a dataset in the experiment's workspace holding its logs.
+func uploadLogDataset(experiment *api.Experiment, name, logPath string) error {
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	dataset, err := beaker.CreateDataset(ctx, api.DatasetSpec{
+		Description: fmt.Sprintf("Logs of experiment %s", experiment.ID),
+		Workspace:   experiment.Workspace.FullName,
+		FileHeap:    true,
+	}, name)
+	if err != nil {
+		return err
+	}
+	storage, _, err := dataset.Storage(ctx)
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFile(ctx, "logs.ndjson", f, info.Size()); err != nil {
+		return err
+	}
+	return dataset.Commit(ctx)
+}
+
+// uploadLogGCS copies an experiment's logs to GCS with gsutil.
+func uploadLogGCS(experiment *api.Experiment, location, logPath string) error {
+	path, err := exec.LookPath("gsutil")
+	if err != nil {
+		return errors.New("GCS log sinks require gsutil")
+	}
+	dest := strings.TrimSuffix(location, "/") + "/" + experiment.ID + ".ndjson"
+	out, err := exec.CommandContext(ctx, path, "-q", "cp", logPath, dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gsutil: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -1,23 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"strings"
-	"text/tabwriter"
+	"sync"
 
 	"github.com/allenai/beaker/config"
 	"github.com/beaker/client/api"
 	"github.com/beaker/client/client"
+	"github.com/beaker/fileheap/async"
 	"github.com/fatih/color"
+	"github.com/moby/term"
 	"github.com/spf13/cobra"
 )
 
@@ -32,19 +32,47 @@ var beakerConfig *config.Config
 var ctx context.Context
 var quiet bool
 var format string
+var retries int
+var retryUnsafe bool
+var maxRPS float64
+var contextName string
+var noTrunc bool
+var columns string
+var noHeader bool
+var progressJSON bool
+var errorFormat string
+var absoluteTimes bool
+var exactBytes bool
 
 const (
-	formatJSON = "json"
+	formatJSON  = "json"
+	formatTable = "table"
+	formatYAML  = "yaml"
+
+	// Delimited formats are only supported by commands which set the
+	// delimitedFormats annotation.
+	formatCSV = "csv"
+	formatTSV = "tsv"
+
+	delimitedFormats = "delimitedFormats"
+
+	// Newline-delimited JSON is only supported by list commands which set the
+	// streamedFormats annotation. They print each page as soon as it arrives.
+	formatNDJSON = "ndjson"
+
+	streamedFormats = "streamedFormats"
 )
 
 var jsonOut *json.Encoder
-var tableOut *tabwriter.Writer
+var ndjsonOut *json.Encoder
+var tableOut *tableWriter
 
 func main() {
 	jsonOut = json.NewEncoder(os.Stdout)
 	jsonOut.SetIndent("", "    ")
+	ndjsonOut = json.NewEncoder(os.Stdout)
 
-	tableOut = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	tableOut = newTableWriter(os.Stdout)
 	defer tableOut.Flush()
 
 	var cancel context.CancelFunc
@@ -52,29 +80,118 @@ func main() {
 	defer cancel()
 
 	root := &cobra.Command{
-		Use:           "beaker <command>",
-		Short:         "Beaker is a tool for running machine learning experiments.",
+		Use:   "beaker <command>",
+		Short: "Beaker is a tool for running machine learning experiments.",
+		Long: `Beaker is a tool for running machine learning experiments.
+
+Commands exit with a code describing why they failed:
+
+    1    Any other error
+    2    Invalid arguments or flags
+    3    Not found
+    4    Permission denied
+    5    Conflict, such as a name which is already taken
+    6    Invalid request
+    7    Beaker couldn't be reached or is overloaded
+    8    Timed out
+    130  Interrupted
+
+With --error-format json, the error is written to STDERR as a JSON object with
+its "code" (such as "not_found"), "exitCode", "message", and for errors from
+Beaker, the HTTP "status" and "requestId".`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Version:       fmt.Sprintf("Beaker %s (%q)", version, commit),
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := expandRecentArgs(cmd, args); err != nil {
+				return err
+			}
+
 			var err error
-			if beakerConfig, err = config.New(); err != nil {
+			if beakerConfig, err = config.NewContext(contextName); err != nil {
+				return err
+			}
+			if err := applyFlagDefaults(cmd, beakerConfig.Defaults); err != nil {
 				return err
 			}
 
+			if errorFormat != errorFormatText && errorFormat != errorFormatJSON {
+				return usageError{fmt.Errorf("invalid error format %q; must be %q or %q",
+					errorFormat, errorFormatText, errorFormatJSON)}
+			}
+
+			switch format {
+			case "", formatJSON, formatTable, formatYAML:
+			case formatCSV, formatTSV:
+				if _, ok := cmd.Annotations[delimitedFormats]; !ok {
+					return fmt.Errorf("format %q is not supported by this command", format)
+				}
+			case formatNDJSON:
+				if _, ok := cmd.Annotations[streamedFormats]; !ok {
+					return fmt.Errorf("format %q is not supported by this command", format)
+				}
+			default:
+				return fmt.Errorf("invalid format %q; must be one of %q, %q, %q, %q, %q, or %q",
+					format, formatJSON, formatYAML, formatTable, formatCSV, formatTSV, formatNDJSON)
+			}
+			if !noTrunc {
+				tableOut.maxWidth = terminalWidth()
+			}
+			tableOut.columns = columns
+			tableOut.noHeader = noHeader
+			tableOut.idsOnly = quiet
+			tableOut.columnDefaults = beakerConfig.Columns
+			// The migrate command reports deprecations itself.
+			deprecations := beakerConfig.Deprecations()
+			if len(deprecations) != 0 && !quiet && cmd.CommandPath() != "beaker config migrate" {
+				fmt.Fprintln(os.Stderr, color.YellowString("Warning:"),
+					"your config file has deprecated settings; update it with 'beaker config migrate'")
+				for _, deprecation := range deprecations {
+					fmt.Fprintln(os.Stderr, "    "+deprecation)
+				}
+			}
+
 			beaker, err = client.NewClient(
 				beakerConfig.BeakerAddress,
 				beakerConfig.UserToken,
 			)
-			return err
+			if err != nil {
+				return err
+			}
+			return installTransport(cmd)
 		},
+		PersistentPostRun: recordRecentArgs,
 	}
 
-	root.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Quiet mode")
-	root.PersistentFlags().StringVar(&format, "format", "", "Output format")
+	root.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Quiet mode; tables show only IDs")
+	root.PersistentFlags().StringVar(&format, "format", "", "Output format: json, yaml, or table")
+	root.PersistentFlags().IntVar(&retries, "retries", defaultRetries,
+		"Times to retry requests which fail with transient errors; overrides the retries config setting")
+	root.PersistentFlags().Float64Var(&maxRPS, "max-rps", 0,
+		"Most requests per second to send to Beaker, or 0 for no limit; overrides the max_rps config setting")
+	root.PersistentFlags().BoolVar(&noCache, "no-cache", false,
+		"Don't reuse cached responses from Beaker; see 'beaker cache --help'")
+	root.PersistentFlags().StringVar(&contextName, "context", "",
+		"Profile of the Beaker deployment to use; overrides the current_context config setting")
+	root.PersistentFlags().BoolVar(&noTrunc, "no-trunc", false,
+		"Don't truncate table cells to fit the terminal")
+	root.PersistentFlags().StringVar(&columns, "columns", "",
+		"Comma-separated table columns to show, e.g. id,name,status")
+	root.PersistentFlags().BoolVar(&noHeader, "no-header", false, "Don't print table headers")
+	root.PersistentFlags().BoolVar(&absoluteTimes, "absolute", false,
+		"Show times in tables as RFC 3339 timestamps instead of relative to now, e.g. 3h ago")
+	root.PersistentFlags().BoolVar(&exactBytes, "bytes", false,
+		"Show sizes in tables as exact byte counts instead of in units, e.g. 1.5GiB")
+	root.PersistentFlags().BoolVar(&retryUnsafe, "retry-unsafe", false,
+		"Also retry requests which aren't idempotent, such as creating objects")
+	root.PersistentFlags().StringVar(&errorFormat, "error-format", errorFormatText,
+		"Format of errors printed to STDERR: text or json")
+	root.PersistentFlags().BoolVar(&progressJSON, "progress-json", false,
+		"Write progress of transfers, image pushes and pulls, and waits to STDERR as newline-delimited JSON")
 
 	root.AddCommand(newAccountCommand())
+	root.AddCommand(newCacheCommand())
+	root.AddCommand(newCleanupCommand())
 	root.AddCommand(newClusterCommand())
 	root.AddCommand(newConfigCommand())
 	root.AddCommand(newDatasetCommand())
@@ -83,16 +200,26 @@ func main() {
 	root.AddCommand(newExperimentCommand())
 	root.AddCommand(newGroupCommand())
 	root.AddCommand(newImageCommand())
+	root.AddCommand(newLoginCommand())
+	root.AddCommand(newLogoutCommand())
+	root.AddCommand(newLogsCommand())
 	root.AddCommand(newNodeCommand())
+	root.AddCommand(newNotificationCommand())
 	root.AddCommand(newOrganizationCommand())
 	root.AddCommand(newSecretCommand())
 	root.AddCommand(newSessionCommand())
+	root.AddCommand(newSpoolCommand())
+	root.AddCommand(newTaskCommand())
+	root.AddCommand(newUsageCommand())
+	root.AddCommand(newWhoAmICommand())
 	root.AddCommand(newWorkspaceCommand())
+	addRecentCompletions(root)
+	markUsageErrors(root)
 
 	err := root.Execute()
 	if err != nil {
 		var apiErr api.Error
-		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized && errorFormat != errorFormatJSON {
 			err = login()
 			if err == nil {
 				err = root.Execute()
@@ -100,18 +227,45 @@ func main() {
 		}
 	}
 	if err != nil {
-		// Don't print "context canceled" error on Ctrl-C.
-		if !errors.Is(err, context.Canceled) {
-			fmt.Fprintf(os.Stderr, "%s %+v\n", color.RedString("Error:"), err)
-		}
-		os.Exit(1)
+		// Deferred calls don't run after os.Exit, so print partial output first.
+		tableOut.Flush()
+		os.Exit(reportError(err, errorFormat))
 	}
 }
 
-// ensureWorkspace ensures that workspaceRef exists or that the default workspace
-// exists if workspaceRef is empty.
-// Returns an error if workspaceRef and the default workspace are empty.
-func ensureWorkspace(workspaceRef string) (string, error) {
+// transportOnce guards wrapping the default transport. The root command runs
+// again after login, and wrapping it again would apply each layer twice.
+var transportOnce sync.Once
+
+// installTransport wraps the default transport, through which the Beaker
+// client sends every request, with the response cache, retry policy, and rate
+// limit. Cached responses skip the other layers, and retries wrap the rate
+// limit so that each attempt is limited.
+func installTransport(cmd *cobra.Command) error {
+	var err error
+	transportOnce.Do(func() {
+		var transport http.RoundTripper
+		transport, err = rateLimit(http.DefaultTransport, cmd.Flags().Changed("max-rps"))
+		if err != nil {
+			return
+		}
+		transport, err = retryRequests(transport, cmd.Flags().Changed("retries"))
+		if err != nil {
+			return
+		}
+		transport, err = responseCache(transport)
+		if err != nil {
+			return
+		}
+		http.DefaultTransport = transport
+	})
+	return err
+}
+
+// resolveWorkspace returns workspaceRef, or the default workspace if it's
+// empty, after checking that the workspace exists and that the caller has at
+// least the given permission on it.
+func resolveWorkspace(workspaceRef string, permission api.Permission) (string, error) {
 	if workspaceRef == "" {
 		if beakerConfig.DefaultWorkspace == "" {
 			return "", errors.New(`workspace not provided, either:
@@ -119,30 +273,47 @@ func ensureWorkspace(workspaceRef string) (string, error) {
 2. Configure a default workspace with 'beaker config set default_workspace <workspace>'`)
 		}
 		workspaceRef = beakerConfig.DefaultWorkspace
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Using default workspace %s\n", color.BlueString(workspaceRef))
+		}
 	}
 
-	// Create the workspace if it doesn't exist.
-	if _, err := beaker.Workspace(workspaceRef).Get(ctx); err != nil {
+	workspace := beaker.Workspace(workspaceRef)
+	if _, err := workspace.Get(ctx); err != nil {
 		if apiErr, ok := err.(api.Error); ok && apiErr.Code == http.StatusNotFound {
-			parts := strings.Split(workspaceRef, "/")
-			if len(parts) != 2 {
-				return "", errors.New("workspace must be formatted like '<account>/<name>'")
-			}
-
-			if _, err = beaker.CreateWorkspace(ctx, api.WorkspaceSpec{
-				Organization: parts[0],
-				Name:         parts[1],
-			}); err != nil {
-				return "", err
-			}
-		} else {
-			return "", err
+			// Keep the API error so the error is still classified as not found.
+			apiErr.Message = fmt.Sprintf("workspace %q does not exist; create it with 'beaker workspace create'", workspaceRef)
+			return "", apiErr
 		}
+		return "", err
+	}
+
+	if permission == api.Read {
+		// Any workspace we can get is readable.
+		return workspaceRef, nil
 	}
 
+	permissions, err := workspace.Permissions(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !hasPermission(permissions.RequesterAuth, permission) {
+		return "", fmt.Errorf("you don't have %s access to workspace %q", permission, workspaceRef)
+	}
 	return workspaceRef, nil
 }
 
+// hasPermission returns whether a granted permission includes a required one.
+func hasPermission(granted, required api.Permission) bool {
+	rank := map[api.Permission]int{
+		api.NoPermission: 0,
+		api.Read:         1,
+		api.Write:        2,
+		api.FullControl:  3,
+	}
+	return rank[granted] >= rank[required]
+}
+
 // Return a cancelable context which ends on signal interrupt.
 //
 // The first interrupt cancels the context, allowing callers to terminate
@@ -173,55 +344,36 @@ func withSignal(parent context.Context) (context.Context, context.CancelFunc) {
 	}
 }
 
-// login prompts the user for an authentication token, validates it,
-// and writes it to the configuration file.
+// login logs in after a request fails because the user isn't logged in.
 func login() error {
-	loginURL, err := url.Parse(beakerConfig.BeakerAddress)
+	fmt.Println("You are not logged in.")
+	user, err := logIn(false)
 	if err != nil {
 		return err
 	}
-	loginURL.Path = path.Join(loginURL.Path, "user")
-
-	fmt.Println(
-		"You are not logged in. To log in, find your user token here:",
-		color.BlueString(loginURL.String()),
-	)
-	fmt.Print("Enter your user token: ")
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			return err
-		}
-		beakerConfig.UserToken = strings.TrimSpace(input)
-
-		beaker, err = client.NewClient(
-			beakerConfig.BeakerAddress,
-			beakerConfig.UserToken,
-		)
-		if err != nil {
-			return err
-		}
-		user, err := beaker.WhoAmI(ctx)
-		if err != nil {
-			fmt.Print("Invalid user token, please try again: ")
-			continue
-		}
-
-		fmt.Printf("Successfully logged in as %q\n\n", user.Name)
-		break
-	}
-	return config.WriteConfig(beakerConfig, config.GetFilePath())
+	fmt.Printf("Logged in as %s\n\n", color.BlueString(user.Name))
+	return nil
 }
 
 // confirm prompts the user for a yes/no answer and defaults to no.
 // Returns true, nil if the user enters yes.
 func confirm(prompt string) (bool, error) {
 	fmt.Print(prompt, " [y/N]: ")
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		input := scanner.Text()
-		input = strings.TrimSpace(input)
+	for {
+		var line stdinLine
+		select {
+		case line = <-stdinLines():
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		if line.err != nil && line.text == "" {
+			if line.err == io.EOF {
+				return false, nil
+			}
+			return false, line.err
+		}
+
+		input := strings.TrimSpace(line.text)
 		input = strings.ToLower(input)
 		switch input {
 		case "y", "yes":
@@ -232,5 +384,36 @@ func confirm(prompt string) (bool, error) {
 			fmt.Print("Please type 'yes' or 'no': ")
 		}
 	}
-	return false, scanner.Err()
+}
+
+// confirmOrFail asks for confirmation like confirm, but fails instead of
+// asking if stdin isn't a terminal, so scripts don't silently do nothing. The
+// error names the flag which skips confirmation.
+func confirmOrFail(prompt, skipFlag string) (bool, error) {
+	if _, isTerminal := term.GetFdInfo(os.Stdin); !isTerminal {
+		return false, fmt.Errorf("confirmation is required but stdin isn't a terminal; pass %s to proceed", skipFlag)
+	}
+	return confirm(prompt)
+}
+
+// Number of resources fetched at once by commands which get several.
+const getConcurrency = 8
+
+// forEachConcurrently calls get for each index in [0, n) with bounded
+// parallelism. Callers store results by index to preserve their order. If
+// calls fail, the error of the lowest index is returned.
+func forEachConcurrently(n int, get func(i int) error) error {
+	errs := make([]error, n)
+	limiter := async.NewLimiter(getConcurrency)
+	for i := 0; i < n; i++ {
+		i := i
+		limiter.Go(func() { errs[i] = get(i) })
+	}
+	limiter.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
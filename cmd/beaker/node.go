@@ -1,23 +1,353 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/allenai/bytefmt"
 	"github.com/beaker/client/api"
+	"github.com/docker/docker/api/types"
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
+// nodeAlert describes a problem with a node which is likely to affect jobs.
+type nodeAlert struct {
+	Node     string `json:"node"`
+	Hostname string `json:"hostname"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+}
+
 func newNodeCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "node <command>",
 		Short: "Manage nodes",
 	}
+	cmd.AddCommand(newNodeAlertsCommand())
 	cmd.AddCommand(newNodeCordonCommand())
 	cmd.AddCommand(newNodeDeleteCommand())
+	cmd.AddCommand(newNodeDrainCommand())
 	cmd.AddCommand(newNodeExecutionsCommand())
 	cmd.AddCommand(newNodeGetCommand())
+	cmd.AddCommand(newNodeLabelCommand())
 	cmd.AddCommand(newNodeUncordonCommand())
+	cmd.AddCommand(newNodeUtilizationCommand())
+	return cmd
+}
+
+func newNodeAlertsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alerts [node...]",
+		Short: "Report nodes which are likely to fail jobs",
+		Long: `Report nodes which are likely to fail jobs.
+
+Checks the given nodes, every node in a cluster, or the current node. A node
+is reported if it's cordoned, about to expire, or has repeatedly failed to
+start executions. Alerts can be posted as JSON to a webhook, e.g. from a cron
+job, so that cluster owners hear about unhealthy nodes early.
+
+The current node is also reported if its executor's storage disk is fuller
+than --max-disk-usage or a GPU has at least --ecc-errors uncorrected memory
+errors. These are measured on the node itself, so run the command on each
+node to check them.`,
+		Args: cobra.ArbitraryArgs,
+	}
+
+	var cluster string
+	var webhook string
+	var since time.Duration
+	var startFailures int
+	var maxDiskUsage float64
+	var eccErrors int
+	cmd.Flags().StringVar(&cluster, "cluster", "", "Check every node in a cluster")
+	cmd.Flags().StringVar(&webhook, "webhook", "", "URL to which alerts are posted as JSON")
+	cmd.Flags().DurationVar(&since, "since", 24*time.Hour, "How far back to look for failed executions")
+	cmd.Flags().IntVar(&startFailures, "start-failures", 3, "Number of executions failing to start which trigger an alert")
+	cmd.Flags().Float64Var(&maxDiskUsage, "max-disk-usage", 90, "Percent of the current node's storage disk in use which triggers an alert")
+	cmd.Flags().IntVar(&eccErrors, "ecc-errors", 1, "Number of uncorrected ECC errors on a GPU of the current node which trigger an alert")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var nodes []api.Node
+		switch {
+		case cluster != "" && len(args) != 0:
+			return fmt.Errorf("nodes and --cluster are mutually exclusive")
+
+		case cluster != "":
+			var err error
+			if nodes, err = beaker.Cluster(cluster).ListClusterNodes(ctx); err != nil {
+				return err
+			}
+
+		default:
+			if len(args) == 0 {
+				node, err := getCurrentNode()
+				if err != nil {
+					return fmt.Errorf("failed to detect node; pass a node or use --cluster flag: %w", err)
+				}
+				args = []string{node}
+			}
+			for _, id := range args {
+				node, err := beaker.Node(id).Get(ctx)
+				if err != nil {
+					return err
+				}
+				nodes = append(nodes, *node)
+			}
+		}
+
+		current, _ := getCurrentNode()
+		var alerts []nodeAlert
+		for _, node := range nodes {
+			executions, err := beaker.Node(node.ID).ListExecutions(ctx)
+			if err != nil {
+				return err
+			}
+			alerts = append(alerts, checkNode(node, executions.Data, time.Now().Add(-since), startFailures)...)
+
+			if node.ID == current {
+				local, err := checkLocalNode(node, maxDiskUsage, eccErrors)
+				if err != nil {
+					return err
+				}
+				alerts = append(alerts, local...)
+			}
+		}
+
+		if webhook != "" && len(alerts) != 0 {
+			if err := postAlerts(webhook, alerts); err != nil {
+				return err
+			}
+		}
+		return printNodeAlerts(alerts)
+	}
 	return cmd
 }
 
+// checkNode returns alerts for a node given its recent executions.
+func checkNode(node api.Node, executions []api.Execution, since time.Time, startFailures int) []nodeAlert {
+	alert := func(kind, message string) nodeAlert {
+		return nodeAlert{Node: node.ID, Hostname: node.Hostname, Kind: kind, Message: message}
+	}
+
+	var alerts []nodeAlert
+	if node.Cordoned != nil {
+		alerts = append(alerts, alert("cordoned", "cordoned "+formatTime(*node.Cordoned)))
+	}
+	if node.Expiry != nil && time.Until(*node.Expiry) < 24*time.Hour {
+		alerts = append(alerts, alert("expiring", "expires "+formatTime(*node.Expiry)))
+	}
+
+	// Executions which fail before starting usually indicate a problem with
+	// the node, such as a broken GPU driver or a full disk.
+	var failed int
+	for _, execution := range executions {
+		state := execution.State
+		if state.Created.Before(since) || state.Started != nil {
+			continue
+		}
+		if state.Failed != nil || (state.Finalized != nil && state.Canceled == nil) {
+			failed++
+		}
+	}
+	if startFailures > 0 && failed >= startFailures {
+		alerts = append(alerts, alert("start-failures",
+			fmt.Sprintf("%d executions failed to start", failed)))
+	}
+	return alerts
+}
+
+// checkLocalNode returns alerts for this machine's node from measurements the
+// API doesn't have: usage of the executor's storage disk and uncorrected GPU
+// memory errors. A threshold of zero disables its check.
+func checkLocalNode(node api.Node, maxDiskUsage float64, eccErrors int) ([]nodeAlert, error) {
+	alert := func(kind, message string) nodeAlert {
+		return nodeAlert{Node: node.ID, Hostname: node.Hostname, Kind: kind, Message: message}
+	}
+
+	var alerts []nodeAlert
+	if maxDiskUsage > 0 {
+		config, err := getExecutorConfig()
+		if err != nil {
+			return nil, err
+		}
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(config.StoragePath, &stat); err != nil {
+			return nil, fmt.Errorf("couldn't check disk usage of %s: %w", config.StoragePath, err)
+		}
+		total := int64(stat.Blocks) * int64(stat.Bsize)
+		free := int64(stat.Bavail) * int64(stat.Bsize)
+		if total > 0 {
+			if used := 100 * float64(total-free) / float64(total); used >= maxDiskUsage {
+				alerts = append(alerts, alert("disk", fmt.Sprintf("storage disk is %.0f%% full with %s free",
+					used, formatSize(bytefmt.New(free, bytefmt.Binary)))))
+			}
+		}
+	}
+
+	// Nodes without NVIDIA GPUs have nothing to check.
+	if _, err := exec.LookPath("nvidia-smi"); eccErrors > 0 && err == nil {
+		out, err := exec.CommandContext(ctx, "nvidia-smi",
+			"--query-gpu=index,ecc.errors.uncorrected.volatile.total",
+			"--format=csv,noheader,nounits").Output()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't query GPUs: %w", commandError(err))
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ",")
+			if len(fields) != 2 {
+				continue
+			}
+			// GPUs without ECC report "[N/A]".
+			count, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+			if err != nil || count < eccErrors {
+				continue
+			}
+			alerts = append(alerts, alert("ecc-errors",
+				fmt.Sprintf("GPU %s has %d uncorrected ECC errors", strings.TrimSpace(fields[0]), count)))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return alerts, nil
+}
+
+// postAlerts sends alerts to a webhook as a JSON object.
+func postAlerts(url string, alerts []nodeAlert) error {
+	if err := postJSON(url, struct {
+		Alerts []nodeAlert `json:"alerts"`
+	}{alerts}); err != nil {
+		return fmt.Errorf("couldn't post alerts: %w", err)
+	}
+	return nil
+}
+
+// Layout of the executor's storage directory. Fetched datasets are kept in
+// directories named by their IDs; images are kept by the container runtime.
+const executorDatasetsPath = "datasets"
+
+// nodeCacheEntry describes a dataset cached by the executor.
+type nodeCacheEntry struct {
+	Kind     string    `json:"kind"`
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	// Whether a running container mounts the entry.
+	InUse bool `json:"inUse"`
+
+	path string
+}
+
+// nodeStoragePath returns the storage directory of the executor on this node.
+// If node is set, it must be this node.
+func nodeStoragePath(node string) (string, error) {
+	config, err := getExecutorConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to read executor config; node caches can only be managed on the node: %w", err)
+	}
+	if node != "" {
+		current, err := getCurrentNode()
+		if err != nil {
+			return "", err
+		}
+		if node != current {
+			return "", fmt.Errorf("%s is not this node (%s); node caches can only be managed on the node", node, current)
+		}
+	}
+	return config.StoragePath, nil
+}
+
+// listNodeCache lists the entries in an executor's cache.
+func listNodeCache(storage string) ([]nodeCacheEntry, error) {
+	dir := path.Join(storage, executorDatasetsPath)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := nodeCacheInUse(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []nodeCacheEntry
+	for _, info := range infos {
+		// Hidden directories are partial downloads and evictions.
+		if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		entry := nodeCacheEntry{
+			Kind:  "dataset",
+			ID:    info.Name(),
+			InUse: inUse[info.Name()],
+			path:  path.Join(dir, info.Name()),
+		}
+
+		// The entry's modification time is the closest record of its last use.
+		entry.LastUsed = info.ModTime()
+		if err := filepath.Walk(entry.path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				entry.Size += info.Size()
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	// Most recently used first.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// nodeCacheInUse returns the IDs of cached datasets which running containers
+// mount from the cache directory dir.
+func nodeCacheInUse(dir string) (map[string]bool, error) {
+	client, err := newContainerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check which datasets are in use: %w", err)
+	}
+
+	inUse := make(map[string]bool)
+	for _, container := range containers {
+		for _, mount := range container.Mounts {
+			rel, err := filepath.Rel(dir, mount.Source)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			inUse[strings.SplitN(rel, string(filepath.Separator), 2)[0]] = true
+		}
+	}
+	return inUse, nil
+}
+
 func newNodeCordonCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "cordon <node>",
@@ -43,6 +373,146 @@ func newNodeDeleteCommand() *cobra.Command {
 	}
 }
 
+func newNodeDrainCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drain <node>",
+		Short: "Cordon a node and wait for its work to finish",
+		Long: `Cordon a node and wait for its work to finish.
+
+The node is cordoned so it's assigned no new work, then this command waits for
+its running executions and sessions to finish. With --grace-period, whatever is
+still running when the period ends is preempted: executions are stopped and
+queued to run again elsewhere, and sessions are canceled. Once nothing is left,
+the node is safe to reboot. Uncordon it afterward with 'beaker node uncordon'.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var gracePeriod time.Duration
+	var interval time.Duration
+	cmd.Flags().DurationVar(&gracePeriod, "grace-period", 0,
+		"Time to wait before preempting remaining work. Waits indefinitely if unset")
+	cmd.Flags().DurationVar(&interval, "interval", 10*time.Second, "Time between status checks")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		if gracePeriod < 0 {
+			return fmt.Errorf("grace period must be positive")
+		}
+
+		node, err := beaker.Node(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		if node.Cordoned == nil {
+			cordoned := true
+			if err := beaker.Node(node.ID).Patch(ctx, &api.NodePatchSpec{Cordoned: &cordoned}); err != nil {
+				return err
+			}
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Cordoned node %s\n", color.BlueString(node.ID))
+			}
+		}
+
+		var deadline <-chan time.Time
+		if gracePeriod > 0 {
+			timer := time.NewTimer(gracePeriod)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		running := map[string]string{} // Kind of each running execution or session, by ID.
+		var preempted bool
+		stopped := map[string]bool{}
+		preempt := func() error {
+			for id, kind := range running {
+				if stopped[id] {
+					continue
+				}
+				if err := preemptNodeWork(kind, id); err != nil {
+					return err
+				}
+				stopped[id] = true
+				if !quiet && !progressJSON {
+					fmt.Fprintf(os.Stderr, "Preempted %s %s\n", kind, color.BlueString(id))
+				}
+			}
+			return nil
+		}
+		emitProgress(progressEvent{Operation: "drain", Event: progressStart, ID: node.ID})
+		delay := time.NewTimer(0) // When to poll the node's work.
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case <-deadline:
+				deadline = nil
+				preempted = true
+				if err := preempt(); err != nil {
+					return err
+				}
+
+			case <-delay.C:
+				utilization, err := getNodeUtilization(node.ID)
+				if err != nil {
+					return err
+				}
+				current := make(map[string]string, len(utilization.Executions)+len(utilization.Sessions))
+				for _, execution := range utilization.Executions {
+					current[execution.ID] = "execution"
+				}
+				for _, session := range utilization.Sessions {
+					current[session.ID] = "session"
+				}
+
+				for id, kind := range running {
+					if _, ok := current[id]; ok {
+						continue
+					}
+					emitProgress(progressEvent{Operation: "drain", Event: progressFinished, ID: id})
+					if !quiet && !progressJSON {
+						fmt.Fprintf(os.Stderr, "The %s %s finished\n", kind, color.BlueString(id))
+					}
+				}
+				if len(running) == 0 && len(current) != 0 && !quiet && !progressJSON {
+					fmt.Fprintf(os.Stderr, "Waiting for %d execution(s) and %d session(s) to finish...\n",
+						len(utilization.Executions), len(utilization.Sessions))
+				}
+				running = current
+				if len(running) == 0 {
+					emitProgress(progressEvent{Operation: "drain", Event: progressDone, ID: node.ID})
+					if !quiet {
+						fmt.Printf("Node %s is drained and safe to reboot\n", color.BlueString(node.ID))
+					}
+					return nil
+				}
+				if preempted {
+					// Preempt anything which started since the grace period ended.
+					if err := preempt(); err != nil {
+						return err
+					}
+				}
+				delay.Reset(interval)
+			}
+		}
+	}
+	return cmd
+}
+
+// preemptNodeWork stops a running execution, queuing it to run again, or
+// cancels a running session.
+func preemptNodeWork(kind, id string) error {
+	if kind == "session" {
+		_, err := beaker.Session(id).Patch(ctx, api.SessionPatch{
+			State: &api.ExecStatusUpdate{Canceled: true},
+		})
+		return err
+	}
+	return beaker.Execution(id).Stop(ctx, true)
+}
+
 func newNodeExecutionsCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "executions <node>",
@@ -65,19 +535,79 @@ func newNodeGetCommand() *cobra.Command {
 		Short:   "Display detailed information about one or more nodes",
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var nodes []api.Node
-			for _, id := range args {
-				node, err := beaker.Node(id).Get(ctx)
+			nodes := make([]api.Node, len(args))
+			if err := forEachConcurrently(len(args), func(i int) error {
+				node, err := beaker.Node(args[i]).Get(ctx)
 				if err != nil {
 					return err
 				}
-				nodes = append(nodes, *node)
+				nodes[i] = *node
+				return nil
+			}); err != nil {
+				return err
 			}
 			return printNodes(nodes)
 		},
 	}
 }
 
+func newNodeLabelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "label <node> [key=value...]",
+		Short: "Set or remove a node's labels",
+		Long: `Set or remove a node's labels
+
+Labels describe a node's hardware, such as gpu=a100, scratch=nvme, or
+fabric=infiniband, so that sessions can require them with --constraint. Labels
+are kept in the config of the node's executor, so they must be set on the node
+itself. Without labels to set or remove, the node's labels are printed.`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	var remove []string
+	cmd.Flags().StringSliceVar(&remove, "remove", nil, "Labels to remove, by key")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		set, err := parseLabels(args[1:])
+		if err != nil {
+			return usageError{err}
+		}
+
+		current, labels, err := localNodeLabels()
+		if err != nil {
+			return err
+		}
+		if current == "" {
+			return fmt.Errorf("no executor runs on this machine; labels of node %s must be set on that node", args[0])
+		}
+		if current != args[0] {
+			return fmt.Errorf("labels of node %s must be set on that node; this is node %s", args[0], current)
+		}
+		if len(set) != 0 || len(remove) != 0 {
+			if labels, err = updateExecutorLabels(set, remove); err != nil {
+				return err
+			}
+		}
+
+		switch format {
+		case formatJSON:
+			return printJSON(labels)
+		case formatYAML:
+			return printYAML(labels)
+		}
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s=%s\n", key, labels[key])
+		}
+		return nil
+	}
+	return cmd
+}
+
 func newNodeUncordonCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "uncordon <node>",
@@ -91,3 +621,52 @@ func newNodeUncordonCommand() *cobra.Command {
 		},
 	}
 }
+
+func newNodeUtilizationCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "utilization [node...]",
+		Short: "Show how each node's resources are allocated",
+		Long: `Show how each node's resources are allocated.
+
+For the given nodes, every node in a cluster, or the current node, shows total
+and allocated GPUs, CPUs, and memory along with the executions and sessions
+occupying them and how long each has been running.`,
+		Args: cobra.ArbitraryArgs,
+	}
+
+	var cluster string
+	cmd.Flags().StringVar(&cluster, "cluster", "", "Show every node in a cluster")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var nodes []nodeUtilization
+		switch {
+		case cluster != "" && len(args) != 0:
+			return fmt.Errorf("nodes and --cluster are mutually exclusive")
+
+		case cluster != "":
+			utilization, err := getClusterUtilization(cluster, "")
+			if err != nil {
+				return err
+			}
+			nodes = utilization.Nodes
+
+		default:
+			if len(args) == 0 {
+				node, err := getCurrentNode()
+				if err != nil {
+					return fmt.Errorf("failed to detect node; pass a node or use --cluster flag: %w", err)
+				}
+				args = []string{node}
+			}
+			for _, id := range args {
+				utilization, err := getNodeUtilization(id)
+				if err != nil {
+					return err
+				}
+				nodes = append(nodes, *utilization)
+			}
+		}
+		return printNodeUtilization(nodes)
+	}
+	return cmd
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Events on which an experiment's notification targets are notified.
+const (
+	eventCompleted = "completed"
+	eventFailed    = "failed"
+	eventPreempted = "preempted"
+
+	// Sent by 'beaker notification test' to check that targets work.
+	eventTest = "test"
+)
+
+// notificationEvent is sent to targets when an experiment finishes or is
+// preempted. Webhooks receive it as JSON.
+type notificationEvent struct {
+	Event      string    `json:"event"`
+	Experiment string    `json:"experiment"`
+	Name       string    `json:"name,omitempty"`
+	URL        string    `json:"url"`
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+}
+
+func newNotificationEvent(experiment *api.Experiment, event string) notificationEvent {
+	name := experiment.ID
+	if experiment.Name != "" {
+		name = experiment.Name
+	}
+	var message string
+	switch event {
+	case eventCompleted:
+		message = fmt.Sprintf("Experiment %s completed successfully", name)
+	case eventFailed:
+		message = fmt.Sprintf("Experiment %s failed", name)
+	case eventPreempted:
+		message = fmt.Sprintf("Experiment %s was preempted; resume it with 'beaker experiment resume %s'",
+			name, experiment.ID)
+	case eventTest:
+		message = "Test notification from Beaker"
+	}
+	return notificationEvent{
+		Event:      event,
+		Experiment: experiment.ID,
+		Name:       experiment.Name,
+		URL:        fmt.Sprintf("%s/ex/%s", beaker.Address(), experiment.ID),
+		Time:       time.Now(),
+		Message:    message,
+	}
+}
+
+// sendNotificationEvent sends an event to each of an experiment's targets.
+// All targets are attempted even if some fail.
+func sendNotificationEvent(targets []string, event notificationEvent) error {
+	return sendNotification(targets, event.Message+"\n"+event.URL, event)
+}
+
+// notificationsStateKind is the state directory of experiments' notification
+// targets.
+const notificationsStateKind = "notifications"
+
+// readNotificationTargets returns the notification targets of an experiment.
+func readNotificationTargets(experimentID string) ([]string, error) {
+	var targets []string
+	if _, err := readState(notificationsStateKind, experimentID, &targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// writeNotificationTargets configures the notification targets of an experiment.
+func writeNotificationTargets(experimentID string, targets []string) error {
+	return writeState(notificationsStateKind, experimentID, targets)
+}
+
+// experimentOutcome returns the event to notify once every task's latest
+// execution has finished, or false if some are still running. Executions
+// canceled by the user end watching without an event.
+func experimentOutcome(executions []*api.Execution) (string, bool) {
+	latest := make(map[string]*api.Execution)
+	for _, execution := range executions {
+		if prev, ok := latest[execution.Task]; !ok || execution.State.Created.After(prev.State.Created) {
+			latest[execution.Task] = execution
+		}
+	}
+	if len(latest) == 0 {
+		return "", false
+	}
+
+	var failed, preempted, canceled bool
+	for _, execution := range latest {
+		state := execution.State
+		switch {
+		case state.Finalized == nil && state.Failed == nil:
+			return "", false
+		case state.Canceled != nil && strings.Contains(strings.ToLower(state.Message), "preempt"):
+			preempted = true
+		case state.Canceled != nil:
+			canceled = true
+		case executionStatus(state) == "failed":
+			failed = true
+		}
+	}
+	switch {
+	case failed:
+		return eventFailed, true
+	case preempted:
+		return eventPreempted, true
+	case canceled:
+		return "", true
+	default:
+		return eventCompleted, true
+	}
+}
+
+// notifyIfFinished sends an experiment's notifications once it has finished.
+// It returns false while the experiment is still running.
+func notifyIfFinished(experiment *api.Experiment) (bool, error) {
+	event, done := experimentOutcome(experiment.Executions)
+	if !done {
+		return false, nil
+	}
+	if event == "" {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Experiment %s was stopped\n", color.BlueString(experiment.ID))
+		}
+		return true, nil
+	}
+
+	targets, err := readNotificationTargets(experiment.ID)
+	if err != nil {
+		return true, err
+	}
+	if len(targets) == 0 {
+		fmt.Fprintf(os.Stderr, "Experiment %s %s but has no notification targets\n", experiment.ID, event)
+		return true, nil
+	}
+	if err := sendNotificationEvent(targets, newNotificationEvent(experiment, event)); err != nil {
+		return true, err
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Experiment %s %s; notified %d target(s)\n",
+			color.BlueString(experiment.ID), event, len(targets))
+	}
+	return true, nil
+}
+
+func newNotificationCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notification <command>",
+		Short: "Manage notifications sent when experiments finish",
+		Long: `Manage notifications sent when experiments finish
+
+Notifications are sent when an experiment completes, fails, or is preempted.
+Targets are written as:
+
+    slack://<webhook>      Post a message to a Slack incoming webhook
+    https://<endpoint>     POST the event as JSON to a webhook
+    email:<address>        Send an email with the local sendmail
+    desktop                Show a desktop notification on this machine
+
+Targets are set with 'beaker experiment create --notify' or with 'beaker
+notification add'. Targets are stored on this machine, and notifications are
+only sent while experiments are watched in the foreground, either with 'beaker
+experiment create --watch' or with 'beaker notification watch'.`,
+	}
+	cmd.AddCommand(newNotificationAddCommand())
+	cmd.AddCommand(newNotificationListCommand())
+	cmd.AddCommand(newNotificationRemoveCommand())
+	cmd.AddCommand(newNotificationTestCommand())
+	cmd.AddCommand(newNotificationWatchCommand())
+	return cmd
+}
+
+func newNotificationAddCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <experiment> <target...>",
+		Short: "Add notification targets to an experiment",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateNotifyMethods(args[1:]); err != nil {
+				return err
+			}
+			experiment, err := beaker.Experiment(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+
+			targets, err := readNotificationTargets(experiment.ID)
+			if err != nil {
+				return err
+			}
+			targets = trimAndUnique(append(targets, args[1:]...))
+			if err := writeNotificationTargets(experiment.ID, targets); err != nil {
+				return err
+			}
+			if !quiet {
+				fmt.Printf("Experiment %s has %d notification target(s). Start sending with 'beaker notification watch %s'\n",
+					color.BlueString(experiment.ID), len(targets), experiment.ID)
+			}
+			return nil
+		},
+	}
+}
+
+func newNotificationListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list <experiment>",
+		Short: "List an experiment's notification targets",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			experiment, err := beaker.Experiment(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+			targets, err := readNotificationTargets(experiment.ID)
+			if err != nil {
+				return err
+			}
+			return printNotificationTargets(targets)
+		},
+	}
+}
+
+func newNotificationRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <experiment> <target...>",
+		Short: "Remove notification targets from an experiment",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			experiment, err := beaker.Experiment(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+
+			targets, err := readNotificationTargets(experiment.ID)
+			if err != nil {
+				return err
+			}
+			remove := make(map[string]bool)
+			for _, target := range args[1:] {
+				remove[target] = true
+			}
+			var kept []string
+			for _, target := range targets {
+				if remove[target] {
+					delete(remove, target)
+				} else {
+					kept = append(kept, target)
+				}
+			}
+			for target := range remove {
+				return fmt.Errorf("experiment %s has no notification target %s", experiment.ID, target)
+			}
+			if err := writeNotificationTargets(experiment.ID, kept); err != nil {
+				return err
+			}
+			if !quiet {
+				fmt.Printf("Removed %d notification target(s) from %s\n", len(targets)-len(kept), color.BlueString(experiment.ID))
+			}
+			return nil
+		},
+	}
+}
+
+func newNotificationTestCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "test <target...>",
+		Short: "Send a test notification",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateNotifyMethods(args); err != nil {
+				return err
+			}
+			event := newNotificationEvent(&api.Experiment{ID: "test"}, eventTest)
+			if err := sendNotificationEvent(args, event); err != nil {
+				return err
+			}
+			if !quiet {
+				fmt.Printf("Sent a test notification to %d target(s)\n", len(args))
+			}
+			return nil
+		},
+	}
+}
+
+func newNotificationWatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch <experiment...>",
+		Short: "Send notifications when experiments finish",
+		Long: `Send notifications when experiments finish.
+
+Each experiment is checked until its tasks have all finished, then a
+notification is sent to its targets if it completed, failed, or was
+preempted. Experiments which were stopped don't send a notification. A
+preempted experiment must be watched again after it's resumed.
+
+Experiments with cluster fallback chains are also moved along their chains,
+as with 'beaker experiment fallback', and the experiments which replace them
+are watched instead.`,
+		Args: cobra.MinimumNArgs(1),
+	}
+
+	var interval time.Duration
+	cmd.Flags().DurationVar(&interval, "interval", defaultWatchInterval, "Time between status checks")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		return watchExperiments(args, interval, true, true)
+	}
+	return cmd
+}
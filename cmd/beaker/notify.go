@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Notification methods other than webhooks, which are given as URLs.
+const (
+	notifyBell    = "bell"
+	notifyDesktop = "desktop"
+
+	// Prefixes of Slack incoming webhooks, written slack://<webhook>, and of
+	// email recipients, written email:<address>.
+	notifySlack = "slack://"
+	notifyEmail = "email:"
+)
+
+// notifyKind returns the kind of a notification method: "bell", "desktop",
+// "slack", "email", or "webhook" for an HTTP(S) URL.
+func notifyKind(method string) (string, error) {
+	switch {
+	case method == notifyBell || method == notifyDesktop:
+		return method, nil
+	case strings.HasPrefix(method, notifySlack):
+		if u, err := url.Parse("https://" + strings.TrimPrefix(method, notifySlack)); err == nil && u.Host != "" {
+			return "slack", nil
+		}
+	case strings.HasPrefix(method, notifyEmail):
+		if _, err := mail.ParseAddress(strings.TrimPrefix(method, notifyEmail)); err == nil {
+			return "email", nil
+		}
+	default:
+		if u, err := url.Parse(method); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return "webhook", nil
+		}
+	}
+	return "", fmt.Errorf("invalid notification %q; must be %q, %q, a webhook URL, slack://<webhook>, or email:<address>",
+		method, notifyBell, notifyDesktop)
+}
+
+// validateNotifyMethods checks that each method is "bell", "desktop", an
+// HTTP(S) URL, a Slack webhook, or an email address.
+func validateNotifyMethods(methods []string) error {
+	for _, method := range methods {
+		if _, err := notifyKind(method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendNotification notifies the user by each method. Webhooks receive payload
+// as JSON; Slack and email receive the message. All methods are attempted even
+// if some fail.
+func sendNotification(methods []string, message string, payload interface{}) error {
+	var failures []string
+	for _, method := range methods {
+		var err error
+		switch {
+		case method == notifyBell:
+			_, err = fmt.Fprint(os.Stderr, "\a")
+		case method == notifyDesktop:
+			err = desktopNotification(message)
+		case strings.HasPrefix(method, notifySlack):
+			err = postJSON("https://"+strings.TrimPrefix(method, notifySlack), map[string]string{"text": message})
+		case strings.HasPrefix(method, notifyEmail):
+			err = sendEmail(strings.TrimPrefix(method, notifyEmail), message)
+		default:
+			err = postJSON(method, payload)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", method, err))
+		}
+	}
+	if len(failures) != 0 {
+		return fmt.Errorf("couldn't send notification: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// desktopNotification shows a message with notify-send on Linux or osascript
+// on Mac, whichever is available.
+func desktopNotification(message string) error {
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		return exec.Command(path, "Beaker", message).Run()
+	}
+	if path, err := exec.LookPath("osascript"); err == nil {
+		script := fmt.Sprintf("display notification %q with title %q", message, "Beaker")
+		return exec.Command(path, "-e", script).Run()
+	}
+	return fmt.Errorf("desktop notifications require notify-send or osascript")
+}
+
+// sendEmail sends a message by email with the local sendmail. The first line
+// of the message is its subject.
+func sendEmail(to, message string) error {
+	path, err := exec.LookPath("sendmail")
+	if err != nil {
+		return fmt.Errorf("email notifications require sendmail")
+	}
+
+	subject := strings.SplitN(message, "\n", 2)[0]
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: [Beaker] %s\r\n", subject)
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(message, "\n", "\r\n"))
+
+	cmd := exec.CommandContext(ctx, path, "-t")
+	cmd.Stdin = &msg
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sendmail: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// postJSON posts a value to a webhook as JSON.
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -74,26 +74,25 @@ func newOrganizationGetCommand() *cobra.Command {
 
 func newOrganizationListCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:    "list",
-		Short:  "List all organizations",
-		Args:   cobra.NoArgs,
-		Hidden: true,
+		Use:         "list",
+		Short:       "List all organizations",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{streamedFormats: ""},
+		Hidden:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var orgs []api.Organization
 			var cursor string
-			for {
+			return printPages(&orgs, func() (interface{}, bool, error) {
 				var page []api.Organization
 				var err error
 				page, cursor, err = beaker.ListOrganizations(ctx, cursor)
 				if err != nil {
-					return err
+					return nil, false, err
 				}
-				orgs = append(orgs, page...)
-				if cursor == "" {
-					break
-				}
-			}
-			return printOrganizations(orgs)
+				return page, cursor != "", nil
+			}, func() error {
+				return printOrganizations(orgs)
+			})
 		},
 	}
 }
@@ -148,25 +147,24 @@ func newOrganizationMemberGetCommand() *cobra.Command {
 
 func newOrganizationMemberListCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:   "list <organization>",
-		Short: "List members of an organization",
-		Args:  cobra.ExactArgs(1),
+		Use:         "list <organization>",
+		Short:       "List members of an organization",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var users []api.UserDetail
 			var cursor string
-			for {
+			return printPages(&users, func() (interface{}, bool, error) {
 				var page []api.UserDetail
 				var err error
 				page, cursor, err = beaker.Organization(args[0]).ListMembers(ctx, cursor)
 				if err != nil {
-					return err
+					return nil, false, err
 				}
-				users = append(users, page...)
-				if cursor == "" {
-					break
-				}
-			}
-			return printUsers(users)
+				return page, cursor != "", nil
+			}, func() error {
+				return printUsers(users)
+			})
 		},
 	}
 }
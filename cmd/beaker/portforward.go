@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+// portMapping forwards a local port to a container port.
+type portMapping struct {
+	Host      int
+	Container int
+}
+
+// parsePortMapping parses a port in the form "host:container". A single port
+// number is forwarded from the same local port.
+func parsePortMapping(s string) (portMapping, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+
+	var ports [2]int
+	for i, part := range parts {
+		port, err := strconv.Atoi(part)
+		if err != nil || port < 1 || port > 65535 {
+			return portMapping{}, errors.Errorf("invalid port mapping %q; expected host:container", s)
+		}
+		ports[i] = port
+	}
+	return portMapping{Host: ports[0], Container: ports[1]}, nil
+}
+
+func parsePortMappings(values []string) ([]portMapping, error) {
+	var ports []portMapping
+	for _, value := range values {
+		port, err := parsePortMapping(value)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func (p portMapping) String() string {
+	return fmt.Sprintf("localhost:%d -> %d", p.Host, p.Container)
+}
+
+// portForwarder proxies connections from local ports into a container.
+type portForwarder struct {
+	ports     []portMapping
+	listeners []net.Listener
+}
+
+// listenPorts binds all local ports up front so conflicts are reported before
+// a session starts.
+func listenPorts(ports []portMapping) (*portForwarder, error) {
+	f := &portForwarder{ports: ports}
+	for _, port := range ports {
+		l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port.Host))
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "couldn't publish port %d", port.Host)
+		}
+		f.listeners = append(f.listeners, l)
+	}
+	return f, nil
+}
+
+// Serve forwards connections to a container's address until the context is
+// canceled. Connection errors are reported without interrupting the session.
+func (f *portForwarder) Serve(ctx context.Context, address string) {
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for i, l := range f.listeners {
+		target := net.JoinHostPort(address, strconv.Itoa(f.ports[i].Container))
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return // The listener was closed.
+				}
+				go proxyConn(conn, target)
+			}
+		}(l)
+	}
+	wg.Wait()
+}
+
+// Close stops listening on all ports.
+func (f *portForwarder) Close() {
+	for _, l := range f.listeners {
+		_ = l.Close()
+	}
+}
+
+func proxyConn(conn net.Conn, target string) {
+	defer conn.Close()
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error:"), "port forwarding:", err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// containerAddress returns a Docker container's IP address.
+func containerAddress(containerID string) (string, error) {
+	client, err := newContainerClient()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	info, err := client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.NetworkSettings == nil {
+		return "", errors.New("container has no network")
+	}
+	if info.NetworkSettings.IPAddress != "" {
+		return info.NetworkSettings.IPAddress, nil
+	}
+	for _, network := range info.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress, nil
+		}
+	}
+	return "", errors.New("container has no IP address")
+}
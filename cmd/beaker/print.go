@@ -1,26 +1,126 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/allenai/bytefmt"
 	"github.com/beaker/client/api"
+	"gopkg.in/yaml.v3"
 )
 
 func printJSON(v interface{}) error {
 	return jsonOut.Encode(v)
 }
 
+// printNDJSON prints each element of a slice as a line of JSON. List commands
+// print each page this way as it arrives rather than collecting every page.
+func printNDJSON(items interface{}) error {
+	v := reflect.ValueOf(items)
+	for i := 0; i < v.Len(); i++ {
+		if err := ndjsonOut.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printPages fetches and prints a list page by page. Each call to fetch returns
+// a page, which must be a slice, and whether more pages follow. With --format
+// ndjson, each page is printed as soon as it's fetched. Otherwise pages are
+// appended to the slice all points to, which is printed by print once every
+// page is fetched.
+func printPages(all interface{}, fetch func() (interface{}, bool, error), print func() error) error {
+	for {
+		page, more, err := fetch()
+		if err != nil {
+			return err
+		}
+		if format == formatNDJSON {
+			if err := printNDJSON(page); err != nil {
+				return err
+			}
+		} else {
+			v := reflect.ValueOf(all).Elem()
+			v.Set(reflect.AppendSlice(v, reflect.ValueOf(page)))
+		}
+		if !more {
+			break
+		}
+	}
+	if format == formatNDJSON {
+		return nil
+	}
+	return print()
+}
+
+// printYAML prints a value as YAML using the same field names as JSON output.
+func printYAML(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// YAML is a superset of JSON, so decoding into a node preserves field order.
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return err
+	}
+	clearStyle(&node)
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// clearStyle resets a YAML tree decoded from JSON to block style.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// defaultColumns lists the columns shown for a resource when neither
+// --columns nor the columns config selects them. Resources not listed show
+// every column. The ID column shows names where objects have them, so the
+// separate NAME column is only shown on request.
+var defaultColumns = map[string]string{
+	"datasets":    "id,workspace,author,committed,source-execution",
+	"experiments": "id,workspace,author,created,status",
+	"groups":      "id,workspace,author,created",
+	"images":      "id,workspace,author,created",
+}
+
+// printTableHeader starts a table of a resource, whose columns may be chosen
+// with --columns or the columns config.
+func printTableHeader(resource string, cells ...string) error {
+	return tableOut.Header(resource, cells...)
+}
+
 func printTableRow(cells ...interface{}) error {
 	var cellStrings []string
 	for _, cell := range cells {
 		var formatted string
 		if t, ok := cell.(time.Time); ok {
-			if !t.IsZero() {
-				formatted = t.Format(time.Stamp)
+			formatted = formatTime(t)
+		} else if t, ok := cell.(*time.Time); ok {
+			if t != nil {
+				formatted = formatTime(*t)
 			}
+		} else if size, ok := cell.(*bytefmt.Size); ok {
+			formatted = formatSize(size)
+		} else if size, ok := cell.(bytefmt.Size); ok {
+			formatted = formatSize(&size)
 		} else if d, ok := cell.(time.Duration); ok {
 			// Format duration as HH:MM:SS.
 			second := d % time.Minute
@@ -39,16 +139,64 @@ func printTableRow(cells ...interface{}) error {
 		}
 		cellStrings = append(cellStrings, formatted)
 	}
-	_, err := fmt.Fprintln(tableOut, strings.Join(cellStrings, "\t"))
-	return err
+	tableOut.Row(cellStrings...)
+	return nil
+}
+
+// formatTime formats a time for tables: relative to now, such as "3h ago", or
+// as RFC 3339 with --absolute. Zero times are blank.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if absoluteTimes {
+		return t.Local().Format(time.RFC3339)
+	}
+
+	d := time.Since(t)
+	suffix := " ago"
+	if d < 0 {
+		d, suffix = -d, ""
+	}
+	var formatted string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		formatted = fmt.Sprintf("%dm", d/time.Minute)
+	case d < 48*time.Hour:
+		formatted = fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		formatted = fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	if suffix == "" {
+		return "in " + formatted
+	}
+	return formatted + suffix
+}
+
+// formatSize formats a size for tables: in binary units, such as "1.5GiB", or
+// as a count of bytes with --bytes. Nil sizes are blank.
+func formatSize(size *bytefmt.Size) string {
+	switch {
+	case size == nil:
+		return ""
+	case exactBytes:
+		return strconv.FormatInt(size.Int64(), 10)
+	default:
+		return size.String()
+	}
 }
 
 func printClusters(clusters []api.Cluster) error {
 	switch format {
 	case formatJSON:
 		return printJSON(clusters)
+	case formatYAML:
+		return printYAML(clusters)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"clusters",
 			"NAME",
 			"GPU TYPE",
 			"GPU COUNT",
@@ -70,7 +218,7 @@ func printClusters(clusters []api.Cluster) error {
 				gpuCount = cluster.NodeShape.GPUCount
 				cpuCount = cluster.NodeShape.CPUCount
 				if cluster.NodeShape.Memory != nil {
-					memory = cluster.NodeShape.Memory.String()
+					memory = formatSize(cluster.NodeShape.Memory)
 				}
 			}
 			if err := printTableRow(
@@ -88,17 +236,142 @@ func printClusters(clusters []api.Cluster) error {
 	}
 }
 
+func printClusterDetails(clusters []api.Cluster, utilization []*clusterUtilization) error {
+	switch format {
+	case formatJSON, formatYAML:
+		type clusterDetail struct {
+			api.Cluster
+			Utilization *clusterUtilization `json:"utilization,omitempty"`
+		}
+		var details []clusterDetail
+		for i, cluster := range clusters {
+			details = append(details, clusterDetail{Cluster: cluster, Utilization: utilization[i]})
+		}
+		if format == formatYAML {
+			return printYAML(details)
+		}
+		return printJSON(details)
+	default:
+		if err := printTableRow(
+			"NAME",
+			"GPU TYPE",
+			"NODES",
+			"FREE GPUS",
+			"FREE CPUS",
+			"FREE MEMORY",
+			"AUTOSCALE",
+		); err != nil {
+			return err
+		}
+		for i, cluster := range clusters {
+			var gpuType string
+			if cluster.NodeShape != nil {
+				gpuType = cluster.NodeShape.GPUType
+			}
+			util := utilization[i]
+			if err := printTableRow(
+				cluster.Name,
+				gpuType,
+				len(util.Nodes),
+				fmt.Sprintf("%d/%d", util.Free.GPUCount, util.Total.GPUCount),
+				fmt.Sprintf("%v/%v", util.Free.CPUCount, util.Total.CPUCount),
+				formatSize(util.Free.Memory)+"/"+formatSize(util.Total.Memory),
+				cluster.Autoscale,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printClusterUtilization(utilization *clusterUtilization) error {
+	switch format {
+	case formatJSON:
+		return printJSON(utilization)
+	case formatYAML:
+		return printYAML(utilization)
+	default:
+		if err := printTableRow(
+			"NODE",
+			"STATUS",
+			"FREE GPUS",
+			"FREE CPUS",
+			"FREE MEMORY",
+			"EXECUTIONS",
+		); err != nil {
+			return err
+		}
+		for _, node := range utilization.Nodes {
+			status := "ok"
+			if node.Node.Cordoned != nil {
+				status = "cordoned"
+			}
+
+			var gpus, cpus, memory string
+			if limits := node.Node.Limits; limits != nil {
+				gpus = fmt.Sprintf("%d/%d", node.Free.GPUCount, limits.GPUCount)
+				cpus = fmt.Sprintf("%v/%v", node.Free.CPUCount, limits.CPUCount)
+				if limits.Memory != nil {
+					memory = formatSize(node.Free.Memory) + "/" + formatSize(limits.Memory)
+				}
+			}
+			if err := printTableRow(
+				node.Node.Hostname,
+				status,
+				gpus,
+				cpus,
+				memory,
+				len(node.Executions)+len(node.Sessions),
+			); err != nil {
+				return err
+			}
+		}
+		return printTableRow(
+			"TOTAL",
+			"",
+			fmt.Sprintf("%d/%d", utilization.Free.GPUCount, utilization.Total.GPUCount),
+			fmt.Sprintf("%v/%v", utilization.Free.CPUCount, utilization.Total.CPUCount),
+			formatSize(utilization.Free.Memory)+"/"+formatSize(utilization.Total.Memory),
+			"",
+		)
+	}
+}
+
+func printContexts(contexts []contextInfo) error {
+	switch format {
+	case formatJSON:
+		return printJSON(contexts)
+	case formatYAML:
+		return printYAML(contexts)
+	default:
+		if err := printTableHeader("contexts", "NAME", "ADDRESS", "DEFAULT WORKSPACE", "CURRENT"); err != nil {
+			return err
+		}
+		for _, context := range contexts {
+			if err := printTableRow(context.Name, context.Address, context.DefaultWorkspace, context.Current); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func printDatasets(datasets []api.Dataset) error {
 	switch format {
 	case formatJSON:
 		return printJSON(datasets)
+	case formatYAML:
+		return printYAML(datasets)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"datasets",
 			"ID",
 			"WORKSPACE",
 			"AUTHOR",
 			"COMMITTED",
 			"SOURCE EXECUTION",
+			"NAME",
 		); err != nil {
 			return err
 		}
@@ -113,6 +386,7 @@ func printDatasets(datasets []api.Dataset) error {
 				dataset.Author.Name,
 				dataset.Committed,
 				dataset.SourceExecution,
+				dataset.Name,
 			); err != nil {
 				return err
 			}
@@ -121,12 +395,91 @@ func printDatasets(datasets []api.Dataset) error {
 	}
 }
 
+// printContainerStats prints a sample of a container's usage, with a table
+// header if header is set.
+func printContainerStats(stats containerStats, header bool) error {
+	switch format {
+	case formatJSON:
+		return printJSON(stats)
+	case formatYAML:
+		return printYAML(stats)
+	default:
+		if header {
+			if err := printTableRow("TIME", "CPU %", "RSS", "MEMORY LIMIT", "GPU %", "GPU MEMORY"); err != nil {
+				return err
+			}
+		}
+
+		var gpuPercent, gpuMemory string
+		if len(stats.GPUs) != 0 {
+			var utilization float64
+			var used, total int64
+			for _, gpu := range stats.GPUs {
+				utilization += gpu.Utilization
+				used += gpu.MemoryUsed
+				total += gpu.MemoryTotal
+			}
+			gpuPercent = fmt.Sprintf("%.0f%%", utilization/float64(len(stats.GPUs)))
+			gpuMemory = formatSize(bytefmt.New(used, bytefmt.Binary)) + " / " + formatSize(bytefmt.New(total, bytefmt.Binary))
+		}
+		var limit string
+		if stats.MemoryLimit != 0 {
+			limit = formatSize(bytefmt.New(stats.MemoryLimit, bytefmt.Binary))
+		}
+		return printTableRow(
+			stats.Time.Local().Format("15:04:05"),
+			fmt.Sprintf("%.1f%%", stats.CPUPercent),
+			bytefmt.New(stats.RSS, bytefmt.Binary),
+			limit,
+			gpuPercent,
+			gpuMemory,
+		)
+	}
+}
+
+func printDatasetTree(root *datasetTree) error {
+	switch format {
+	case formatJSON:
+		return printJSON(root)
+	case formatYAML:
+		return printYAML(root)
+	default:
+		if err := printTableRow("PATH", "SIZE", "FILES"); err != nil {
+			return err
+		}
+		var printNode func(node *datasetTree, prefix, indent string) error
+		printNode = func(node *datasetTree, prefix, indent string) error {
+			if err := printTableRow(
+				prefix+node.Name,
+				bytefmt.New(node.Size, bytefmt.Binary),
+				node.Files,
+			); err != nil {
+				return err
+			}
+			for i, child := range node.Children {
+				branch, next := "├── ", "│   "
+				if i == len(node.Children)-1 {
+					branch, next = "└── ", "    "
+				}
+				if err := printNode(child, indent+branch, indent+next); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return printNode(root, "", "")
+	}
+}
+
 func printExecutions(executions []api.Execution) error {
 	switch format {
 	case formatJSON:
 		return printJSON(executions)
+	case formatYAML:
+		return printYAML(executions)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"executions",
 			"ID",
 			"NAME",
 			"AUTHOR",
@@ -153,11 +506,17 @@ func printExecutions(executions []api.Execution) error {
 				scheduled = *execution.State.Scheduled
 			}
 
+			status := executionStatus(execution.State)
+			if status == "running" && execution.State.Message != "" {
+				// Running tasks may annotate their progress.
+				status += " (" + execution.State.Message + ")"
+			}
+
 			if err := printTableRow(
 				execution.ID,
 				execution.Spec.Name,
 				execution.Author.Name,
-				executionStatus(execution.State),
+				status,
 				scheduled,
 				duration,
 				len(execution.Limits.GPUs),
@@ -174,13 +533,18 @@ func printExperiments(experiments []api.Experiment) error {
 	switch format {
 	case formatJSON:
 		return printJSON(experiments)
+	case formatYAML:
+		return printYAML(experiments)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"experiments",
 			"ID",
 			"WORKSPACE",
 			"AUTHOR",
 			"CREATED",
 			"STATUS",
+			"NAME",
+			"GPUS",
 		); err != nil {
 			return err
 		}
@@ -190,8 +554,12 @@ func printExperiments(experiments []api.Experiment) error {
 				name = experiment.Name
 			}
 			var executions []api.Execution
+			var gpus int
 			for _, execution := range experiment.Executions {
 				executions = append(executions, *execution)
+				if execution.State.Finalized == nil {
+					gpus += len(execution.Limits.GPUs)
+				}
 			}
 			if err := printTableRow(
 				name,
@@ -199,6 +567,8 @@ func printExperiments(experiments []api.Experiment) error {
 				experiment.Author.Name,
 				experiment.Created,
 				executionsStatus(executions),
+				experiment.Name,
+				gpus,
 			); err != nil {
 				return err
 			}
@@ -207,16 +577,116 @@ func printExperiments(experiments []api.Experiment) error {
 	}
 }
 
+func printExecutorCache(cache *executorCache) error {
+	switch format {
+	case formatJSON:
+		return printJSON(cache)
+	case formatYAML:
+		return printYAML(cache)
+	default:
+		if len(cache.Entries) == 0 && len(cache.Images) == 0 {
+			if !quiet {
+				fmt.Println("Nothing is cached.")
+			}
+			return nil
+		}
+		if err := printTableRow("KIND", "ID", "SIZE", "LAST USED"); err != nil {
+			return err
+		}
+		for _, entry := range cache.Entries {
+			lastUsed := interface{}(entry.LastUsed)
+			if entry.InUse {
+				lastUsed = "in use"
+			}
+			if err := printTableRow(
+				entry.Kind,
+				entry.ID,
+				bytefmt.New(entry.Size, bytefmt.Binary),
+				lastUsed,
+			); err != nil {
+				return err
+			}
+		}
+		for _, image := range cache.Images {
+			id := strings.TrimPrefix(image.ID, "sha256:")[:12]
+			if len(image.Tags) != 0 {
+				id = image.Tags[0]
+			}
+			lastUsed := interface{}(image.LastUsed)
+			if image.InUse {
+				lastUsed = "in use"
+			}
+			if err := printTableRow(
+				"docker image",
+				id,
+				bytefmt.New(image.Size, bytefmt.Binary),
+				lastUsed,
+			); err != nil {
+				return err
+			}
+		}
+		if err := tableOut.Flush(); err != nil {
+			return err
+		}
+		if !quiet {
+			fmt.Printf("\nCaches use %s; %s is available.\n",
+				formatSize(bytefmt.New(cache.Used, bytefmt.Binary)), formatSize(bytefmt.New(cache.Available, bytefmt.Binary)))
+		}
+		return nil
+	}
+}
+
+func printExecutorStatus(status *executorStatus) error {
+	switch format {
+	case formatJSON:
+		return printJSON(status)
+	case formatYAML:
+		return printYAML(status)
+	default:
+		if err := printTableRow("METRIC", "VALUE"); err != nil {
+			return err
+		}
+		rows := [][]interface{}{
+			{"Running executions", status.RunningExecutions},
+			{"GPUs allocated", fmt.Sprintf("%d of %d", status.GPUsAllocated, status.GPUs)},
+			{"Dataset cache", bytefmt.New(status.DatasetCacheBytes, bytefmt.Binary)},
+		}
+		if len(status.Accelerators) != 0 {
+			var accelerators []string
+			for _, a := range status.Accelerators {
+				accelerators = append(accelerators, a.String())
+			}
+			rows = append(rows, []interface{}{"Accelerators", strings.Join(accelerators, ", ")})
+		}
+		for _, pull := range status.Pulls {
+			rows = append(rows, []interface{}{
+				strings.Title(pull.Kind) + " pulls",
+				fmt.Sprintf("%d, %v on average", pull.Count, pull.Mean().Round(time.Millisecond)),
+			})
+		}
+		for _, row := range rows {
+			if err := printTableRow(row...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func printGroups(groups []api.Group) error {
 	switch format {
 	case formatJSON:
 		return printJSON(groups)
+	case formatYAML:
+		return printYAML(groups)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"groups",
 			"ID",
 			"WORKSPACE",
 			"AUTHOR",
 			"CREATED",
+			"NAME",
 		); err != nil {
 			return err
 		}
@@ -230,6 +700,7 @@ func printGroups(groups []api.Group) error {
 				group.Workspace.Name,
 				group.Author.Name,
 				group.Created,
+				group.Name,
 			); err != nil {
 				return err
 			}
@@ -242,12 +713,16 @@ func printImages(images []api.Image) error {
 	switch format {
 	case formatJSON:
 		return printJSON(images)
+	case formatYAML:
+		return printYAML(images)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"images",
 			"ID",
 			"WORKSPACE",
 			"AUTHOR",
 			"CREATED",
+			"NAME",
 		); err != nil {
 			return err
 		}
@@ -261,6 +736,43 @@ func printImages(images []api.Image) error {
 				image.Workspace.Name,
 				image.Author.Name,
 				image.Created,
+				image.Name,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printImageUses(uses []imageUse) error {
+	switch format {
+	case formatJSON:
+		return printJSON(uses)
+	case formatYAML:
+		return printYAML(uses)
+	default:
+		if err := printTableHeader(
+			"image-history",
+			"EXPERIMENT",
+			"NAME",
+			"WORKSPACE",
+			"AUTHOR",
+			"TASKS",
+			"FIRST USED",
+			"LAST USED",
+		); err != nil {
+			return err
+		}
+		for _, use := range uses {
+			if err := printTableRow(
+				use.Experiment,
+				use.Name,
+				use.Workspace,
+				use.Author,
+				strings.Join(use.Tasks, ", "),
+				use.FirstUsed,
+				use.LastUsed,
 			); err != nil {
 				return err
 			}
@@ -269,12 +781,35 @@ func printImages(images []api.Image) error {
 	}
 }
 
+func printLogSinks(sinks []logSink) error {
+	switch format {
+	case formatJSON:
+		return printJSON(sinks)
+	case formatYAML:
+		return printYAML(sinks)
+	default:
+		if err := printTableRow("KIND", "DESTINATION"); err != nil {
+			return err
+		}
+		for _, sink := range sinks {
+			kind, value := sink.kind()
+			if err := printTableRow(kind, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func printMembers(members []api.OrgMembership) error {
 	switch format {
 	case formatJSON:
 		return printJSON(members)
+	case formatYAML:
+		return printYAML(members)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"members",
 			"ID",
 			"NAME",
 			"DISPLAY NAME",
@@ -296,12 +831,152 @@ func printMembers(members []api.OrgMembership) error {
 	}
 }
 
+func printTaskResults(results []taskResult) error {
+	switch format {
+	case formatJSON:
+		return printJSON(results)
+	case formatYAML:
+		return printYAML(results)
+	default:
+		if err := printTableHeader(
+			"results",
+			"TASK",
+			"DATASET",
+			"FILES",
+			"SIZE",
+		); err != nil {
+			return err
+		}
+		for _, result := range results {
+			if err := printTableRow(
+				result.Task,
+				result.Dataset,
+				result.Files,
+				bytefmt.New(result.Bytes, bytefmt.Binary),
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printNodeAlerts(alerts []nodeAlert) error {
+	switch format {
+	case formatJSON:
+		return printJSON(alerts)
+	case formatYAML:
+		return printYAML(alerts)
+	default:
+		if len(alerts) == 0 {
+			if !quiet {
+				fmt.Println("No alerts.")
+			}
+			return nil
+		}
+		if err := printTableRow("NODE", "HOSTNAME", "ALERT", "MESSAGE"); err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			if err := printTableRow(alert.Node, alert.Hostname, alert.Kind, alert.Message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printNodeUtilization(nodes []nodeUtilization) error {
+	switch format {
+	case formatJSON:
+		return printJSON(nodes)
+	case formatYAML:
+		return printYAML(nodes)
+	default:
+		for i, node := range nodes {
+			if i != 0 {
+				fmt.Println()
+			}
+
+			status := "ok"
+			if node.Node.Cordoned != nil {
+				status = "cordoned"
+			}
+			if err := printTableRow("Node:", fmt.Sprintf("%s (%s)", node.Node.Hostname, node.Node.ID)); err != nil {
+				return err
+			}
+			if err := printTableRow("Status:", status); err != nil {
+				return err
+			}
+			if limits := node.Node.Limits; limits != nil {
+				gpus := fmt.Sprintf("%d of %d allocated", limits.GPUCount-node.Free.GPUCount, limits.GPUCount)
+				if err := printTableRow("GPUs:", gpus); err != nil {
+					return err
+				}
+				cpus := fmt.Sprintf("%v of %v allocated", limits.CPUCount-node.Free.CPUCount, limits.CPUCount)
+				if err := printTableRow("CPUs:", cpus); err != nil {
+					return err
+				}
+				if limits.Memory != nil && node.Free.Memory != nil {
+					allocated := *limits.Memory
+					allocated.Sub(*node.Free.Memory)
+					memory := fmt.Sprintf("%s of %s allocated", formatSize(&allocated), formatSize(limits.Memory))
+					if err := printTableRow("Memory:", memory); err != nil {
+						return err
+					}
+				}
+			}
+			if err := tableOut.Flush(); err != nil {
+				return err
+			}
+
+			if len(node.Executions)+len(node.Sessions) == 0 {
+				fmt.Println("No running workloads.")
+				continue
+			}
+			fmt.Println()
+			if err := printTableRow("KIND", "ID", "AUTHOR", "GPUS", "CPUS", "MEMORY", "RUNNING"); err != nil {
+				return err
+			}
+			for _, exec := range node.Executions {
+				if err := printWorkloadRow("execution", exec.ID, exec.Author.Name, &exec.Limits, exec.State); err != nil {
+					return err
+				}
+			}
+			for _, session := range node.Sessions {
+				if err := printWorkloadRow("session", session.ID, session.Author.Name, session.Limits, session.State); err != nil {
+					return err
+				}
+			}
+			if err := tableOut.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printWorkloadRow(kind, id, author string, limits *api.ResourceLimits, state api.ExecutionState) error {
+	var running time.Duration
+	if state.Started != nil {
+		running = time.Since(*state.Started).Truncate(time.Second)
+	}
+	var memory interface{} = ""
+	if limits.Memory != nil {
+		memory = limits.Memory
+	}
+	return printTableRow(kind, id, author, len(limits.GPUs), limits.CPUCount, memory, running)
+}
+
 func printNodes(nodes []api.Node) error {
 	switch format {
 	case formatJSON:
 		return printJSON(nodes)
+	case formatYAML:
+		return printYAML(nodes)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"nodes",
 			"ID",
 			"HOSTNAME",
 			"CPU COUNT",
@@ -309,10 +984,20 @@ func printNodes(nodes []api.Node) error {
 			"GPU TYPE",
 			"MEMORY",
 			"STATUS",
+			"LABELS",
 		); err != nil {
 			return err
 		}
+		// Only the labels of this machine's node are known.
+		current, labels, err := localNodeLabels()
+		if err != nil {
+			return err
+		}
 		for _, node := range nodes {
+			var nodeLabels string
+			if node.ID == current {
+				nodeLabels = formatLabels(labels)
+			}
 			status := "ok"
 			if node.Cordoned != nil {
 				status = "cordoned"
@@ -325,6 +1010,7 @@ func printNodes(nodes []api.Node) error {
 				node.Limits.GPUType,
 				node.Limits.Memory,
 				status,
+				nodeLabels,
 			); err != nil {
 				return err
 			}
@@ -333,12 +1019,38 @@ func printNodes(nodes []api.Node) error {
 	}
 }
 
+func printNotificationTargets(targets []string) error {
+	switch format {
+	case formatJSON:
+		return printJSON(targets)
+	case formatYAML:
+		return printYAML(targets)
+	default:
+		if err := printTableRow("KIND", "TARGET"); err != nil {
+			return err
+		}
+		for _, s := range targets {
+			kind, err := notifyKind(s)
+			if err != nil {
+				kind = "invalid"
+			}
+			if err := printTableRow(kind, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func printOrganizations(orgs []api.Organization) error {
 	switch format {
 	case formatJSON:
 		return printJSON(orgs)
+	case formatYAML:
+		return printYAML(orgs)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"organizations",
 			"ID",
 			"NAME",
 			"DISPLAY NAME",
@@ -362,8 +1074,10 @@ func printSecrets(secrets []api.Secret) error {
 	switch format {
 	case formatJSON:
 		return printJSON(secrets)
+	case formatYAML:
+		return printYAML(secrets)
 	default:
-		if err := printTableRow("NAME", "CREATED", "UPDATED"); err != nil {
+		if err := printTableHeader("secrets", "NAME", "CREATED", "UPDATED"); err != nil {
 			return err
 		}
 		for _, secret := range secrets {
@@ -383,8 +1097,11 @@ func printSessions(sessions []api.Session) error {
 	switch format {
 	case formatJSON:
 		return printJSON(sessions)
+	case formatYAML:
+		return printYAML(sessions)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"sessions",
 			"ID",
 			"NAME",
 			"AUTHOR",
@@ -433,12 +1150,41 @@ func printSessions(sessions []api.Session) error {
 	}
 }
 
+func printSubmissions(submissions []*submission) error {
+	switch format {
+	case formatJSON:
+		return printJSON(submissions)
+	case formatYAML:
+		return printYAML(submissions)
+	default:
+		if err := printTableHeader("submissions", "ID", "SPOOLED", "SOURCE", "WORKSPACE", "REMAINING", "GROUP"); err != nil {
+			return err
+		}
+		for _, s := range submissions {
+			if err := printTableRow(
+				s.ID,
+				s.Spooled,
+				s.Source,
+				s.Workspace,
+				fmt.Sprintf("%d of %d", len(s.Runs), s.Total),
+				s.Group,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func printTasks(tasks []api.Task) error {
 	switch format {
 	case formatJSON:
 		return printJSON(tasks)
+	case formatYAML:
+		return printYAML(tasks)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"tasks",
 			"ID",
 			"EXPERIMENT",
 			"NAME",
@@ -462,12 +1208,85 @@ func printTasks(tasks []api.Task) error {
 	}
 }
 
+func printTaskEnvironment(env taskEnvironment) error {
+	switch format {
+	case formatJSON:
+		return printJSON(env)
+	case formatYAML:
+		return printYAML(env)
+	default:
+		rows := []struct{ label, value string }{
+			{"Execution", env.Execution},
+			{"Node", env.Node},
+			{"Hostname", env.Hostname},
+			{"Driver Version", env.DriverVersion},
+			{"CUDA Version", env.CUDAVersion},
+			{"GPU Models", strings.Join(env.GPUModels, ", ")},
+			{"Image", env.Image},
+		}
+		for _, row := range rows {
+			if err := printTableRow(row.label+":", row.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printUploadEstimate(estimate *uploadEstimate) error {
+	switch format {
+	case formatJSON:
+		return printJSON(estimate)
+	case formatYAML:
+		return printYAML(estimate)
+	default:
+		rate := bytefmt.New(int64(estimate.BytesPerSecond), bytefmt.Binary)
+		if err := printTableRow("Files:", estimate.Files); err != nil {
+			return err
+		}
+		if err := printTableRow("Size:", bytefmt.New(estimate.Bytes, bytefmt.Binary)); err != nil {
+			return err
+		}
+		if err := printTableRow("Bandwidth:", formatSize(rate)+"/s"); err != nil {
+			return err
+		}
+		if err := printTableRow("Estimated Time:", estimate.Duration); err != nil {
+			return err
+		}
+
+		if len(estimate.Largest) != 0 {
+			if err := printTableRow("Largest Files:"); err != nil {
+				return err
+			}
+			for _, file := range estimate.Largest {
+				if err := printTableRow("  "+file.Path, bytefmt.New(file.Size, bytefmt.Binary)); err != nil {
+					return err
+				}
+			}
+		}
+		if len(estimate.Ignored) != 0 {
+			if err := printTableRow("Ignored (not regular files):"); err != nil {
+				return err
+			}
+			for _, path := range estimate.Ignored {
+				if err := printTableRow("  " + path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
 func printUsers(users []api.UserDetail) error {
 	switch format {
 	case formatJSON:
 		return printJSON(users)
+	case formatYAML:
+		return printYAML(users)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"users",
 			"ID",
 			"NAME",
 			"DISPLAY NAME",
@@ -493,8 +1312,11 @@ func printWorkspaces(workspaces []api.Workspace) error {
 	switch format {
 	case formatJSON:
 		return printJSON(workspaces)
+	case formatYAML:
+		return printYAML(workspaces)
 	default:
-		if err := printTableRow(
+		if err := printTableHeader(
+			"workspaces",
 			"NAME",
 			"AUTHOR",
 			"DATASETS",
@@ -524,6 +1346,8 @@ func printWorkspacePermissions(permissions *api.WorkspacePermissionSummary) erro
 	switch format {
 	case formatJSON:
 		return printJSON(permissions)
+	case formatYAML:
+		return printYAML(permissions)
 	default:
 		visibility := "private"
 		if permissions.Public {
@@ -589,3 +1413,284 @@ func executionsStatus(executions []api.Execution) string {
 	}
 	return strings.Join(parts, ", ")
 }
+
+// newDelimitedWriter returns a writer of stdout for --format csv or tsv.
+func newDelimitedWriter() *csv.Writer {
+	out := csv.NewWriter(os.Stdout)
+	if format == formatTSV {
+		out.Comma = '\t'
+	}
+	return out
+}
+
+// printMetricSeries prints metric points in any output format, including CSV
+// and TSV.
+func printMetricSeries(points []metricPoint) error {
+	switch format {
+	case formatJSON:
+		return printJSON(points)
+	case formatYAML:
+		return printYAML(points)
+	case formatCSV, formatTSV:
+		out := newDelimitedWriter()
+		if err := out.Write([]string{"metric", "step", "time", "value"}); err != nil {
+			return err
+		}
+		for _, point := range points {
+			var step string
+			if point.Step != nil {
+				step = strconv.FormatInt(*point.Step, 10)
+			}
+			if err := out.Write([]string{
+				point.Name,
+				step,
+				point.Time.Format(time.RFC3339Nano),
+				strconv.FormatFloat(point.Value, 'g', -1, 64),
+			}); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		if err := printTableRow("METRIC", "STEP", "TIME", "VALUE"); err != nil {
+			return err
+		}
+		for _, point := range points {
+			var step string
+			if point.Step != nil {
+				step = strconv.FormatInt(*point.Step, 10)
+			}
+			if err := printTableRow(point.Name, step, point.Time, point.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printUsage(noun string, rows []usageRow) error {
+	switch format {
+	case formatJSON:
+		return printJSON(rows)
+	case formatYAML:
+		return printYAML(rows)
+	case formatCSV, formatTSV:
+		out := newDelimitedWriter()
+		if err := out.Write([]string{noun, "window", "gpu_hours", "node_hours", "cost"}); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var window, cost string
+			if row.Window != nil {
+				window = row.Window.Format("2006-01-02")
+			}
+			if row.Cost != nil {
+				cost = strconv.FormatFloat(*row.Cost, 'f', 2, 64)
+			}
+			if err := out.Write([]string{
+				row.Key,
+				window,
+				strconv.FormatFloat(row.GPUHours, 'f', 2, 64),
+				strconv.FormatFloat(row.NodeHours, 'f', 2, 64),
+				cost,
+			}); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		if err := printTableRow(strings.ToUpper(noun), "WINDOW", "GPU HOURS", "NODE HOURS", "COST"); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var window, cost string
+			if row.Window != nil {
+				window = row.Window.Format("2006-01-02")
+			}
+			if row.Cost != nil {
+				cost = fmt.Sprintf("$%.2f", *row.Cost)
+			}
+			if err := printTableRow(
+				row.Key,
+				window,
+				fmt.Sprintf("%.2f", row.GPUHours),
+				fmt.Sprintf("%.2f", row.NodeHours),
+				cost,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printTaskEstimates(estimates []taskEstimate) error {
+	switch format {
+	case formatJSON:
+		return printJSON(estimates)
+	case formatYAML:
+		return printYAML(estimates)
+	default:
+		hours := func(h *float64) string {
+			if h == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.2f", *h)
+		}
+		if err := printTableRow("RUN", "TASK", "CLUSTER", "NODE SHARE", "RUNTIME HOURS", "QUEUE HOURS", "SAMPLES", "COST"); err != nil {
+			return err
+		}
+		for _, estimate := range estimates {
+			var cost string
+			if estimate.Cost != nil {
+				cost = fmt.Sprintf("$%.2f", *estimate.Cost)
+			}
+			if err := printTableRow(
+				estimate.Run,
+				estimate.Task,
+				estimate.Cluster,
+				fmt.Sprintf("%.2f", estimate.NodeShare),
+				hours(estimate.RuntimeHours),
+				hours(estimate.QueueHours),
+				estimate.Samples,
+				cost,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printGroupComparison(params []string, metrics []string, rows []groupComparisonRow) error {
+	switch format {
+	case formatJSON:
+		return printJSON(rows)
+	case formatYAML:
+		return printYAML(rows)
+	case formatCSV, formatTSV:
+		out := newDelimitedWriter()
+		header := []string{"experiment_id", "experiment", "task_id", "task"}
+		header = append(header, params...)
+		header = append(header, metrics...)
+		if err := out.Write(header); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := []string{row.ExperimentID, row.Experiment, row.TaskID, row.Task}
+			record = append(record, row.Params...)
+			for _, value := range row.Metrics {
+				var formatted string
+				if value != nil {
+					formatted = fmt.Sprint(value)
+				}
+				record = append(record, formatted)
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		header := []interface{}{"EXPERIMENT", "TASK"}
+		for _, param := range params {
+			header = append(header, strings.ToUpper(param))
+		}
+		for _, metric := range metrics {
+			header = append(header, strings.ToUpper(metric))
+		}
+		if err := printTableRow(header...); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			experiment := row.Experiment
+			if experiment == "" {
+				experiment = row.ExperimentID
+			}
+			cells := []interface{}{experiment, row.Task}
+			for _, value := range row.Params {
+				cells = append(cells, value)
+			}
+			best := make(map[string]bool)
+			for _, metric := range row.Best {
+				best[metric] = true
+			}
+			for i, value := range row.Metrics {
+				var formatted string
+				if v, ok := metricValue(value); ok {
+					formatted = fmt.Sprintf("%.4g", v)
+				} else if value != nil {
+					formatted = fmt.Sprint(value)
+				}
+				if best[metrics[i]] {
+					formatted += "*"
+				}
+				cells = append(cells, formatted)
+			}
+			if err := printTableRow(cells...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printGroupStats(params []string, rows []groupStatsRow) error {
+	switch format {
+	case formatJSON:
+		return printJSON(rows)
+	case formatYAML:
+		return printYAML(rows)
+	case formatCSV, formatTSV:
+		out := newDelimitedWriter()
+		header := append(append([]string{}, params...), "metric", "count", "mean", "std", "min", "max")
+		if err := out.Write(header); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := append(append([]string{}, row.Params...),
+				row.Metric,
+				strconv.Itoa(row.Count),
+				strconv.FormatFloat(row.Mean, 'g', -1, 64),
+				strconv.FormatFloat(row.Std, 'g', -1, 64),
+				strconv.FormatFloat(row.Min, 'g', -1, 64),
+				strconv.FormatFloat(row.Max, 'g', -1, 64),
+			)
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		var header []interface{}
+		for _, param := range params {
+			header = append(header, strings.ToUpper(param))
+		}
+		header = append(header, "METRIC", "COUNT", "MEAN", "STD", "MIN", "MAX")
+		if err := printTableRow(header...); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var cells []interface{}
+			for _, value := range row.Params {
+				cells = append(cells, value)
+			}
+			cells = append(cells,
+				row.Metric,
+				row.Count,
+				fmt.Sprintf("%.4g", row.Mean),
+				fmt.Sprintf("%.4g", row.Std),
+				fmt.Sprintf("%.4g", row.Min),
+				fmt.Sprintf("%.4g", row.Max),
+			)
+			if err := printTableRow(cells...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allenai/bytefmt"
+	"github.com/beaker/fileheap/cli"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	"github.com/pkg/errors"
+)
+
+const (
+	// How often progress is redrawn on a terminal.
+	terminalRefresh = 200 * time.Millisecond
+
+	// How often progress is printed as text when output isn't a terminal.
+	textRefresh = 10 * time.Second
+
+	// Width of a layer's progress bar, in characters.
+	progressBarWidth = 30
+
+	// How often progress events are written with --progress-json.
+	eventRefresh = time.Second
+)
+
+// Kinds of progress events.
+const (
+	progressStart    = "start"
+	progressUpdate   = "progress"
+	progressFinished = "finished" // One item, such as an experiment, is done.
+	progressDone     = "done"
+)
+
+// progressEvent is a line of the --progress-json stream. Each operation
+// writes a start event, progress events as it proceeds, and a done event once
+// it succeeds. Counts which are zero or don't apply to an operation are
+// omitted.
+type progressEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"` // e.g. "upload", "download", "push", "await"
+	Event     string    `json:"event"`
+
+	Files           int64 `json:"files,omitempty"`
+	FilesTotal      int64 `json:"filesTotal,omitempty"`
+	FilesInProgress int64 `json:"filesInProgress,omitempty"`
+	Bytes           int64 `json:"bytes,omitempty"`
+	BytesTotal      int64 `json:"bytesTotal,omitempty"`
+
+	Layers        int `json:"layers,omitempty"`
+	LayersTotal   int `json:"layersTotal,omitempty"`
+	LayersSkipped int `json:"layersSkipped,omitempty"`
+
+	Experiments      int `json:"experiments,omitempty"`
+	ExperimentsTotal int `json:"experimentsTotal,omitempty"`
+
+	// Object the event is about, such as a finished experiment, and its status.
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+var progressEventsMu sync.Mutex
+
+// emitProgress writes a progress event to STDERR if --progress-json is set.
+func emitProgress(event progressEvent) {
+	if !progressJSON {
+		return
+	}
+	event.Time = time.Now()
+	progressEventsMu.Lock()
+	defer progressEventsMu.Unlock()
+	_ = json.NewEncoder(os.Stderr).Encode(&event)
+}
+
+// layerProgress tracks a single layer of an image push or pull.
+type layerProgress struct {
+	ID      string
+	Status  string
+	Current int64
+	Total   int64
+}
+
+// Statuses the Docker daemon reports for layers which are done.
+var layerDoneStatuses = map[string]bool{
+	"Pushed":               true,
+	"Layer already exists": true,
+	"Pull complete":        true,
+	"Already exists":       true,
+}
+
+// Statuses the Docker daemon reports for layers which are skipped because the
+// registry or local store already has them.
+var layerSkippedStatuses = map[string]bool{
+	"Layer already exists": true,
+	"Already exists":       true,
+}
+
+// imageProgress aggregates per-layer progress from a Docker push or pull
+// stream.
+type imageProgress struct {
+	start  time.Time
+	layers []*layerProgress
+	byID   map[string]*layerProgress
+}
+
+// event describes progress so far as a progress event.
+func (p *imageProgress) event(operation, kind string) progressEvent {
+	e := progressEvent{Operation: operation, Event: kind, LayersTotal: len(p.layers)}
+	for _, layer := range p.layers {
+		e.Bytes += layer.Current
+		e.BytesTotal += layer.Total
+		if layerDoneStatuses[layer.Status] {
+			e.Layers++
+		}
+		if layerSkippedStatuses[layer.Status] {
+			e.LayersSkipped++
+		}
+	}
+	return e
+}
+
+func newImageProgress() *imageProgress {
+	return &imageProgress{start: time.Now(), byID: map[string]*layerProgress{}}
+}
+
+// update applies a message from the Docker daemon.
+func (p *imageProgress) update(msg *jsonmessage.JSONMessage) {
+	if msg.ID == "" {
+		return
+	}
+
+	layer, ok := p.byID[msg.ID]
+	if !ok {
+		layer = &layerProgress{ID: msg.ID}
+		p.byID[msg.ID] = layer
+		p.layers = append(p.layers, layer)
+	}
+	layer.Status = msg.Status
+	if msg.Progress != nil && msg.Progress.Total > 0 {
+		layer.Current, layer.Total = msg.Progress.Current, msg.Progress.Total
+	}
+	if layerDoneStatuses[msg.Status] {
+		layer.Current = layer.Total
+	}
+}
+
+// summary describes overall progress with throughput and an estimate of the
+// time remaining. Layers which haven't started uploading don't have a known
+// size yet, so the estimate improves as the push proceeds. Skipped layers
+// aren't transferred, so they don't count toward throughput.
+func (p *imageProgress) summary() string {
+	var current, total int64
+	var done, skipped int
+	for _, layer := range p.layers {
+		current += layer.Current
+		total += layer.Total
+		if layerDoneStatuses[layer.Status] {
+			done++
+		}
+		if layerSkippedStatuses[layer.Status] {
+			skipped++
+		}
+	}
+
+	s := fmt.Sprintf("%d/%d layers", done, len(p.layers))
+	if skipped != 0 {
+		s += fmt.Sprintf(" (%d already present)", skipped)
+	}
+	if total == 0 {
+		return s
+	}
+	s += fmt.Sprintf(", %v of %v (%d%%)",
+		bytefmt.New(current, bytefmt.Binary),
+		bytefmt.New(total, bytefmt.Binary),
+		current*100/total)
+
+	elapsed := time.Since(p.start)
+	if current == 0 || elapsed <= time.Second {
+		return s
+	}
+	rate := float64(current) / elapsed.Seconds()
+	s += fmt.Sprintf(", %v/s", bytefmt.New(int64(rate), bytefmt.Binary))
+	if current < total {
+		remaining := time.Duration(float64(total-current)/rate) * time.Second
+		s += fmt.Sprintf(", about %v remaining", remaining.Round(time.Second))
+	}
+	return s
+}
+
+// lines renders a progress bar for each layer followed by the summary.
+func (p *imageProgress) lines() []string {
+	var lines []string
+	for _, layer := range p.layers {
+		line := fmt.Sprintf("%s: %-20s", layer.ID, layer.Status)
+		if layer.Total > 0 && layer.Current < layer.Total {
+			filled := int(int64(progressBarWidth) * layer.Current / layer.Total)
+			line += fmt.Sprintf(" [%s>%s] %v/%v",
+				strings.Repeat("=", filled),
+				strings.Repeat(" ", progressBarWidth-filled),
+				bytefmt.New(layer.Current, bytefmt.Binary),
+				bytefmt.New(layer.Total, bytefmt.Binary))
+		}
+		lines = append(lines, line)
+	}
+	return append(lines, "Total: "+p.summary())
+}
+
+// displayImageProgress shows the progress of an image push or pull until the
+// stream ends. On a terminal each layer gets a progress bar; otherwise, or in
+// quiet mode, a one-line summary is printed periodically so long transfers
+// don't appear hung. With --progress-json, events are written instead.
+func displayImageProgress(r io.Reader, operation string) error {
+	_, isTerminal := term.GetFdInfo(os.Stdout)
+	interactive := isTerminal && !quiet && !progressJSON
+	verb := strings.Title(operation) + "ing"
+	pastVerb := strings.Title(operation) + "ed"
+
+	// Quiet mode reserves STDOUT for the image reference and prints no
+	// progress at all, though progress events are still emitted.
+	out := os.Stdout
+
+	type result struct {
+		msg *jsonmessage.JSONMessage
+		err error
+	}
+	messages := make(chan result)
+	go func() {
+		defer close(messages)
+		decoder := json.NewDecoder(r)
+		for {
+			var msg jsonmessage.JSONMessage
+			if err := decoder.Decode(&msg); err != nil {
+				if err != io.EOF {
+					messages <- result{err: errors.WithStack(err)}
+				}
+				return
+			}
+			messages <- result{msg: &msg}
+		}
+	}()
+
+	refresh := textRefresh
+	if progressJSON {
+		refresh = eventRefresh
+	} else if interactive {
+		refresh = terminalRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	progress := newImageProgress()
+	emitProgress(progress.event(operation, progressStart))
+	var drawn int // Lines drawn on the terminal so far.
+	draw := func() {
+		if progressJSON {
+			emitProgress(progress.event(operation, progressUpdate))
+			return
+		}
+		if quiet {
+			return
+		}
+		if !interactive {
+			fmt.Fprintf(out, "%s: %s\n", verb, progress.summary())
+			return
+		}
+		if drawn > 0 {
+			fmt.Fprintf(out, "\033[%dA", drawn)
+		}
+		lines := progress.lines()
+		for _, line := range lines {
+			fmt.Fprintf(out, "\r\033[2K%s\n", line)
+		}
+		drawn = len(lines)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			draw()
+
+		case res, ok := <-messages:
+			if !ok {
+				if progressJSON {
+					emitProgress(progress.event(operation, progressDone))
+				} else if interactive {
+					draw()
+				} else if !quiet {
+					fmt.Fprintln(out, pastVerb, progress.summary())
+				}
+				return nil
+			}
+			if res.err != nil {
+				return res.err
+			}
+
+			msg := res.msg
+			if msg.Error != nil {
+				return msg.Error
+			}
+			if msg.ErrorMessage != "" {
+				return errors.New(msg.ErrorMessage)
+			}
+			if msg.ID == "" && msg.Status != "" && !interactive && !quiet && !progressJSON {
+				fmt.Fprintln(out, msg.Status)
+			}
+			progress.update(msg)
+		}
+	}
+}
+
+// transferProgress shows the combined progress of a multi-file transfer on a
+// single line, with aggregate throughput and an estimate of the time
+// remaining. It implements cli.ProgressTracker and may be updated
+// concurrently. Bytes may be reported as they're written and taken back if a
+// file has to be retried.
+type transferProgress struct {
+	operation  string // Named in progress events, such as "download"
+	verb       string // Past tense, such as "Downloaded"
+	totalFiles int64
+	totalBytes int64
+	start      time.Time
+
+	mu sync.Mutex
+	p  cli.ProgressUpdate
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newTransferProgress starts displaying progress until Close is called.
+// Nothing is displayed in quiet mode, though progress events are still written
+// with --progress-json.
+func newTransferProgress(operation, verb string, totalFiles, totalBytes int64) *transferProgress {
+	t := &transferProgress{
+		operation:  operation,
+		verb:       verb,
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		start:      time.Now(),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go t.display()
+	return t
+}
+
+func (t *transferProgress) Update(u *cli.ProgressUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.FilesWritten += u.FilesWritten
+	t.p.FilesPending += u.FilesPending
+	t.p.BytesWritten += u.BytesWritten
+	t.p.BytesPending += u.BytesPending
+}
+
+// Close stops the display and prints a summary of the transfer.
+func (t *transferProgress) Close() error {
+	close(t.done)
+	<-t.stopped
+	emitProgress(t.event(progressDone))
+	if quiet {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elapsed := time.Since(t.start)
+	fmt.Printf("%s %d files (%v) in %v",
+		t.verb, t.p.FilesWritten, bytefmt.New(t.p.BytesWritten, bytefmt.Binary), elapsed.Round(time.Millisecond))
+	if elapsed > 0 && t.p.BytesWritten > 0 {
+		fmt.Printf(", %v/s", bytefmt.New(int64(float64(t.p.BytesWritten)/elapsed.Seconds()), bytefmt.Binary))
+	}
+	fmt.Println()
+	return nil
+}
+
+// event describes the transfer so far as a progress event.
+func (t *transferProgress) event(kind string) progressEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return progressEvent{
+		Operation:       t.operation,
+		Event:           kind,
+		Files:           t.p.FilesWritten,
+		FilesTotal:      t.totalFiles,
+		FilesInProgress: t.p.FilesPending,
+		Bytes:           t.p.BytesWritten,
+		BytesTotal:      t.totalBytes,
+	}
+}
+
+func (t *transferProgress) display() {
+	defer close(t.stopped)
+	if progressJSON {
+		t.emit()
+		return
+	}
+	if quiet {
+		<-t.done
+		return
+	}
+
+	_, interactive := term.GetFdInfo(os.Stdout)
+	refresh := textRefresh
+	if interactive {
+		refresh = terminalRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			if interactive {
+				fmt.Print("\r\033[2K")
+			}
+			return
+		case <-ticker.C:
+			if interactive {
+				fmt.Printf("\r\033[2K%s", t.summary())
+			} else {
+				fmt.Println(t.summary())
+			}
+		}
+	}
+}
+
+// emit writes progress events until the transfer is closed.
+func (t *transferProgress) emit() {
+	emitProgress(t.event(progressStart))
+	ticker := time.NewTicker(eventRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			emitProgress(t.event(progressUpdate))
+		}
+	}
+}
+
+// newBoundedTracker returns a tracker for a transfer of known size. It writes
+// progress events with --progress-json, shows fileheap's progress display
+// otherwise, and does nothing in quiet mode.
+func newBoundedTracker(operation, verb string, totalFiles, totalBytes int64) cli.ProgressTracker {
+	switch {
+	case progressJSON:
+		return newTransferProgress(operation, verb, totalFiles, totalBytes)
+	case quiet:
+		return cli.NoTracker
+	default:
+		return cli.BoundedTracker(ctx, totalFiles, totalBytes)
+	}
+}
+
+// summary describes the transfer so far.
+func (t *transferProgress) summary() string {
+	t.mu.Lock()
+	p := t.p
+	t.mu.Unlock()
+
+	s := fmt.Sprintf("Files: %d/%d", p.FilesWritten, t.totalFiles)
+	if p.FilesPending > 0 {
+		s += fmt.Sprintf(" (%d in progress)", p.FilesPending)
+	}
+	s += fmt.Sprintf(", %v of %v", bytefmt.New(p.BytesWritten, bytefmt.Binary), bytefmt.New(t.totalBytes, bytefmt.Binary))
+	if t.totalBytes > 0 {
+		s += fmt.Sprintf(" (%d%%)", p.BytesWritten*100/t.totalBytes)
+	}
+
+	elapsed := time.Since(t.start)
+	if p.BytesWritten > 0 && elapsed > time.Second {
+		rate := float64(p.BytesWritten) / elapsed.Seconds()
+		s += fmt.Sprintf(", %v/s", bytefmt.New(int64(rate), bytefmt.Binary))
+		if p.BytesWritten < t.totalBytes {
+			remaining := time.Duration(float64(t.totalBytes-p.BytesWritten)/rate) * time.Second
+			s += fmt.Sprintf(", about %v remaining", remaining.Round(time.Second))
+		}
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Maximum number of completion candidates to show at once.
+const maxCandidates = 10
+
+// prompter reads answers to interactive questions from STDIN.
+type prompter struct {
+	reader *bufio.Reader
+}
+
+func newPrompter() *prompter {
+	return &prompter{reader: bufio.NewReader(os.Stdin)}
+}
+
+// ask prompts for a line of input, returning def if the answer is empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+
+	input, err := p.reader.ReadString('\n')
+	if err == io.EOF && input != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if input = strings.TrimSpace(input); input == "" {
+		return def, nil
+	}
+	return input, nil
+}
+
+// askWithCompletion prompts for a value which may be completed from a list of
+// known candidates. An answer which is a prefix of exactly one candidate is
+// expanded to that candidate, and "?" lists candidates. Answers which don't
+// match any candidate are accepted as-is since the list may be incomplete.
+func (p *prompter) askWithCompletion(question, def string, candidates []string) (string, error) {
+	sort.Strings(candidates)
+	for {
+		answer, err := p.ask(question, def)
+		if err != nil || answer == "" {
+			return answer, err
+		}
+
+		prefix := strings.TrimSuffix(answer, "?")
+		var matches []string
+		for _, candidate := range candidates {
+			if candidate == prefix && !strings.HasSuffix(answer, "?") {
+				return candidate, nil
+			}
+			if strings.HasPrefix(candidate, prefix) {
+				matches = append(matches, candidate)
+			}
+		}
+
+		switch {
+		case len(matches) == 0 && !strings.HasSuffix(answer, "?"):
+			return answer, nil
+		case len(matches) == 1 && !strings.HasSuffix(answer, "?"):
+			fmt.Println("  =>", matches[0])
+			return matches[0], nil
+		case len(matches) == 0:
+			fmt.Println("  No matches.")
+		default:
+			for i, match := range matches {
+				if i == maxCandidates {
+					fmt.Printf("  ... and %d more\n", len(matches)-maxCandidates)
+					break
+				}
+				fmt.Println("  " + match)
+			}
+		}
+	}
+}
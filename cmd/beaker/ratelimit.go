@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Slowest rate to which the limiter backs off, in requests per second.
+const minRequestRate = 0.1
+
+// rateLimit limits the rate of requests to the Beaker service made through
+// base, or returns base if there's no limit. The --max-rps flag takes
+// precedence over the max_rps config setting.
+func rateLimit(base http.RoundTripper, flagSet bool) (http.RoundTripper, error) {
+	if !flagSet && beakerConfig.MaxRPS != "" {
+		rps, err := strconv.ParseFloat(beakerConfig.MaxRPS, 64)
+		if err != nil || rps < 0 {
+			return nil, fmt.Errorf("invalid max_rps setting %q; must be a non-negative number", beakerConfig.MaxRPS)
+		}
+		maxRPS = rps
+	}
+	if maxRPS < 0 {
+		return nil, fmt.Errorf("invalid max-rps %v; must be non-negative", maxRPS)
+	}
+	if maxRPS == 0 {
+		return base, nil
+	}
+
+	address, err := url.Parse(beaker.Address())
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitTransport{
+		base: base,
+		host: address.Host,
+		max:  maxRPS,
+		rate: maxRPS,
+	}, nil
+}
+
+// rateLimitTransport spaces out requests to the Beaker service so that there
+// are at most rate per second. When the service responds with 429 Too Many
+// Requests, the rate is halved; it recovers gradually toward max as requests
+// succeed.
+type rateLimitTransport struct {
+	base http.RoundTripper
+	host string
+	max  float64
+
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	if wait := t.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.adapt(resp.StatusCode == http.StatusTooManyRequests)
+	}
+	return resp, err
+}
+
+// reserve claims the next slot for a request and returns how long to wait for it.
+func (t *rateLimitTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(time.Second) / t.rate))
+	return wait
+}
+
+// adapt slows down after the service throttles a request and speeds back up
+// by a twentieth of the maximum rate after each other response.
+func (t *rateLimitTransport) adapt(throttled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !throttled {
+		if t.rate += t.max / 20; t.rate > t.max {
+			t.rate = t.max
+		}
+		return
+	}
+
+	previous := t.rate
+	if t.rate /= 2; t.rate < minRequestRate {
+		t.rate = minRequestRate
+	}
+	if !quiet && t.rate < previous {
+		fmt.Fprintf(os.Stderr, "Throttled by Beaker; slowing to %.2g requests per second\n", t.rate)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allenai/beaker/config"
+	"github.com/beaker/client/client"
+	"github.com/spf13/cobra"
+)
+
+func TestRateLimitSettings(t *testing.T) {
+	defer func(c *config.Config, r float64, b *client.Client) {
+		beakerConfig, maxRPS, beaker = c, r, b
+	}(beakerConfig, maxRPS, beaker)
+
+	var err error
+	if beaker, err = client.NewClient("https://beaker.org", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setting string
+		flag    float64
+		flagSet bool
+		want    float64 // 0 if requests aren't limited.
+		wantErr bool
+	}{
+		{name: "unlimited"},
+		{name: "setting", setting: "2.5", want: 2.5},
+		{name: "zero setting", setting: "0"},
+		{name: "flag over setting", setting: "2.5", flag: 10, flagSet: true, want: 10},
+		{name: "invalid setting", setting: "fast", wantErr: true},
+		{name: "negative setting", setting: "-1", wantErr: true},
+		{name: "negative flag", flag: -1, flagSet: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beakerConfig = &config.Config{MaxRPS: tt.setting}
+			maxRPS = tt.flag
+
+			base := roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, nil })
+			transport, err := rateLimit(base, tt.flagSet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			limiter, ok := transport.(*rateLimitTransport)
+			switch {
+			case tt.want == 0 && ok:
+				t.Error("requests are limited, want unlimited")
+			case tt.want != 0 && !ok:
+				t.Error("requests are unlimited")
+			case ok && (limiter.max != tt.want || limiter.host != "beaker.org"):
+				t.Errorf("limit is %v on %s, want %v on beaker.org", limiter.max, limiter.host, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitReserve(t *testing.T) {
+	limiter := &rateLimitTransport{max: 10, rate: 10}
+	if wait := limiter.reserve(); wait != 0 {
+		t.Errorf("first request waits %s, want 0", wait)
+	}
+	for i := 1; i <= 3; i++ {
+		want := time.Duration(i) * 100 * time.Millisecond
+		if wait := limiter.reserve(); wait < want-10*time.Millisecond || wait > want {
+			t.Errorf("request %d waits %s, want about %s", i+1, wait, want)
+		}
+	}
+}
+
+func TestRateLimitAdapt(t *testing.T) {
+	defer func(q bool) { quiet = q }(quiet)
+	quiet = true
+
+	limiter := &rateLimitTransport{max: 1, rate: 1}
+	limiter.adapt(true)
+	if limiter.rate != 0.5 {
+		t.Errorf("rate after throttling is %v, want 0.5", limiter.rate)
+	}
+	for i := 0; i < 20; i++ {
+		limiter.adapt(true)
+	}
+	if limiter.rate != minRequestRate {
+		t.Errorf("rate after repeated throttling is %v, want %v", limiter.rate, minRequestRate)
+	}
+
+	limiter.adapt(false)
+	if want := minRequestRate + 0.05; math.Abs(limiter.rate-want) > 1e-9 {
+		t.Errorf("rate after a success is %v, want %v", limiter.rate, want)
+	}
+	for i := 0; i < 40; i++ {
+		limiter.adapt(false)
+	}
+	if limiter.rate != limiter.max {
+		t.Errorf("rate after recovering is %v, want %v", limiter.rate, limiter.max)
+	}
+}
+
+func TestRateLimitOtherHosts(t *testing.T) {
+	sent := 0
+	limiter := &rateLimitTransport{
+		base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			sent++
+			return &http.Response{StatusCode: http.StatusTooManyRequests}, nil
+		}),
+		host: "beaker.org",
+		max:  1,
+		rate: 1,
+	}
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/bucket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := limiter.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent != 3 || limiter.rate != 1 {
+		t.Errorf("sent %d requests at rate %v, want 3 at rate 1", sent, limiter.rate)
+	}
+}
+
+func TestInstallTransportOnce(t *testing.T) {
+	defer func(c *config.Config, r float64, b *client.Client, d http.RoundTripper) {
+		beakerConfig, maxRPS, beaker, http.DefaultTransport = c, r, b, d
+		transportOnce = sync.Once{}
+	}(beakerConfig, maxRPS, beaker, http.DefaultTransport)
+
+	var err error
+	if beaker, err = client.NewClient("https://beaker.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	beakerConfig = &config.Config{}
+	maxRPS = 5
+	transportOnce = sync.Once{}
+	base := http.DefaultTransport
+
+	// The root command runs again after login.
+	cmd := &cobra.Command{}
+	for i := 0; i < 2; i++ {
+		if err := installTransport(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retry, ok := http.DefaultTransport.(*retryTransport)
+	if !ok {
+		t.Fatalf("default transport is %T, want *retryTransport", http.DefaultTransport)
+	}
+	limiter, ok := retry.base.(*rateLimitTransport)
+	if !ok {
+		t.Fatalf("retries wrap %T, want *rateLimitTransport", retry.base)
+	}
+	if limiter.base != base {
+		t.Errorf("rate limit wraps %T, want the original default transport", limiter.base)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Kinds of objects remembered in the recent history. Each kind matches the
+// argument placeholder used by commands which accept it, such as
+// "<experiment>" in "experiment get <experiment...>".
+const (
+	recentExperiment = "experiment"
+	recentSession    = "session"
+	recentDataset    = "dataset"
+)
+
+// Number of objects of each kind to remember.
+const recentLimit = 20
+
+// recentKinds are the placeholders whose arguments may use recent shorthands.
+var recentKinds = map[string]bool{
+	recentExperiment: true,
+	recentSession:    true,
+	recentDataset:    true,
+}
+
+// recentCacheKind is the cache directory of the recent history. The history
+// is only a convenience, so it's kept in the cache and 'beaker cache clear'
+// forgets it.
+const recentCacheKind = "recent"
+
+// readRecent returns references to recently used objects of a kind, most
+// recent first.
+func readRecent(kind string) []string {
+	var refs []string
+	readCache(recentCacheKind, kind, 0, &refs)
+	return refs
+}
+
+// recordRecent remembers that an object was used. Like the rest of the cache,
+// the history is best-effort.
+func recordRecent(kind, ref string) {
+	if ref == "" || strings.HasPrefix(ref, "@") {
+		return
+	}
+
+	refs := []string{ref}
+	for _, existing := range readRecent(kind) {
+		if existing != ref && len(refs) < recentLimit {
+			refs = append(refs, existing)
+		}
+	}
+	writeCache(recentCacheKind, kind, refs)
+}
+
+// resolveRecent expands "@last", or "@-N" for the Nth most recent object, to a
+// reference from the recent history. Other references are returned as-is.
+func resolveRecent(kind, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "@") {
+		return ref, nil
+	}
+
+	index := 1
+	if ref != "@last" {
+		n, err := strconv.Atoi(strings.TrimPrefix(ref, "@-"))
+		if err != nil || !strings.HasPrefix(ref, "@-") || n < 1 {
+			return "", fmt.Errorf("invalid %s %q; use @last or @-N for the Nth most recent", kind, ref)
+		}
+		index = n
+	}
+
+	refs := readRecent(kind)
+	if index > len(refs) {
+		if len(refs) == 0 {
+			return "", fmt.Errorf("%s: no recent %ss", ref, kind)
+		}
+		return "", fmt.Errorf("%s: only %d recent %ss are known", ref, len(refs), kind)
+	}
+	return refs[index-1], nil
+}
+
+// argumentKinds returns the recent kind of each positional argument of a
+// command, based on the placeholders in its usage. Arguments which don't refer
+// to a remembered kind are empty. A trailing variadic placeholder such as
+// "<experiment...>" applies to all remaining arguments.
+func argumentKinds(cmd *cobra.Command, n int) []string {
+	placeholders := strings.Fields(cmd.Use)
+	if len(placeholders) != 0 {
+		placeholders = placeholders[1:]
+	}
+
+	kinds := make([]string, n)
+	for i := range kinds {
+		var placeholder string
+		switch {
+		case i < len(placeholders):
+			placeholder = placeholders[i]
+		case len(placeholders) != 0 && strings.HasSuffix(strings.Trim(placeholders[len(placeholders)-1], "<>[]"), "..."):
+			placeholder = placeholders[len(placeholders)-1]
+		default:
+			continue
+		}
+
+		name := strings.Trim(placeholder, "<>[]")
+		name = strings.TrimSuffix(name, "...")
+		if recentKinds[name] {
+			kinds[i] = name
+		}
+	}
+	return kinds
+}
+
+// expandRecentArgs replaces recent shorthands in a command's arguments in place.
+func expandRecentArgs(cmd *cobra.Command, args []string) error {
+	for i, kind := range argumentKinds(cmd, len(args)) {
+		if kind == "" {
+			continue
+		}
+		ref, err := resolveRecent(kind, args[i])
+		if err != nil {
+			return err
+		}
+		args[i] = ref
+	}
+	return nil
+}
+
+// recordRecentArgs remembers every object a successful command referred to.
+func recordRecentArgs(cmd *cobra.Command, args []string) {
+	for i, kind := range argumentKinds(cmd, len(args)) {
+		if kind != "" {
+			recordRecent(kind, args[i])
+		}
+	}
+}
+
+// addRecentCompletions completes arguments from the recent history for every
+// command in a tree which doesn't already complete its arguments.
+func addRecentCompletions(cmd *cobra.Command) {
+	for _, child := range cmd.Commands() {
+		addRecentCompletions(child)
+	}
+	if cmd.ValidArgsFunction != nil || cmd.ValidArgs != nil {
+		return
+	}
+
+	cmd.ValidArgsFunction = func(
+		cmd *cobra.Command,
+		args []string,
+		toComplete string,
+	) ([]string, cobra.ShellCompDirective) {
+		kinds := argumentKinds(cmd, len(args)+1)
+		kind := kinds[len(args)]
+		if kind == "" {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		refs := readRecent(kind)
+		candidates := make([]string, 0, len(refs)+1)
+		if len(refs) != 0 && strings.HasPrefix("@last", toComplete) {
+			candidates = append(candidates, "@last\tMost recent "+kind)
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, toComplete) {
+				candidates = append(candidates, ref)
+			}
+		}
+		return candidates, cobra.ShellCompDirectiveNoFileComp
+	}
+}
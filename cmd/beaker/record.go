@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/term"
+)
+
+// castRecorder records terminal output in asciicast v2 format, which can be
+// replayed with 'asciinema play' or embedded with asciinema-player.
+//
+// Recording never interrupts a session: write errors are kept and reported
+// when the recorder is closed.
+type castRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	err   error
+
+	// Bytes of a character split across writes, held until it's complete.
+	pending []byte
+}
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// newCastRecorder creates a recording at path, replacing any existing file.
+func newCastRecorder(path, title string) (*castRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := castHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: time.Now().Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}
+	if fd, isTerminal := term.GetFdInfo(os.Stdout); isTerminal {
+		if size, err := term.GetWinsize(fd); err == nil && size.Width != 0 {
+			header.Width, header.Height = int(size.Width), int(size.Height)
+		}
+	}
+	if err := json.NewEncoder(file).Encode(&header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &castRecorder{file: file, start: time.Now()}, nil
+}
+
+// Write records p as output at the current time. It always succeeds.
+func (r *castRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut != 0 {
+		r.record(string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+func (r *castRecorder) record(text string) {
+	if r.err != nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	r.err = json.NewEncoder(r.file).Encode([]interface{}{elapsed, "o", text})
+}
+
+// Close flushes any incomplete character and closes the recording.
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) != 0 {
+		r.record(string(r.pending))
+		r.pending = nil
+	}
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// closeRecorder closes a recording, reporting where it was saved.
+func closeRecorder(recorder *castRecorder, path string) {
+	if err := recorder.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: recording %s may be incomplete: %v\n", path, err)
+	} else if !quiet {
+		fmt.Fprintf(os.Stderr, "Recorded session to %s\n", path)
+	}
+}
+
+// recordOutput tees a session's output to a recorder, if there is one.
+func recordOutput(resp types.HijackedResponse, recorder *castRecorder) types.HijackedResponse {
+	if recorder != nil {
+		resp.Reader = bufio.NewReader(io.TeeReader(resp.Reader, recorder))
+	}
+	return resp
+}
+
+// sessionOutput returns where a session's output is written outside of its
+// stream, such as when replaying logs.
+func sessionOutput(recorder *castRecorder) io.Writer {
+	if recorder == nil {
+		return os.Stdout
+	}
+	return io.MultiWriter(os.Stdout, recorder)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Largest response body which is cached.
+const maxCachedResponse = 1 << 20
+
+// noCache is set by --no-cache to bypass the response cache.
+var noCache bool
+
+// responseCache caches responses from the Beaker service to requests made
+// through base, or returns base if the cache_ttl setting isn't set.
+func responseCache(base http.RoundTripper) (http.RoundTripper, error) {
+	if beakerConfig.CacheTTL == "" {
+		return base, nil
+	}
+	ttl, err := time.ParseDuration(beakerConfig.CacheTTL)
+	if err != nil || ttl < 0 {
+		return nil, fmt.Errorf("invalid cache_ttl setting %q; must be a non-negative duration such as 30s", beakerConfig.CacheTTL)
+	}
+
+	address, err := url.Parse(beaker.Address())
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTransport{
+		base:   base,
+		host:   address.Host,
+		ttl:    ttl,
+		bypass: noCache,
+	}, nil
+}
+
+// cacheTransport caches JSON responses to GET requests to the Beaker service
+// on disk. A response is reused as-is for ttl, after which it's revalidated
+// with a conditional request if it had an ETag or Last-Modified header.
+//
+// Any other successful request may change what Beaker returns, so it clears
+// the cache. With bypass, the cache is cleared by changes but never read.
+type cacheTransport struct {
+	base   http.RoundTripper
+	host   string
+	ttl    time.Duration
+	bypass bool
+}
+
+// cachedResponse is a response stored in the cache.
+type cachedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Stored     time.Time   `json:"stored"`
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	if req.Method != http.MethodGet {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			clearResponseCache()
+		}
+		return resp, err
+	}
+	if t.bypass {
+		return t.base.RoundTrip(req)
+	}
+
+	key := responseCacheKey(req)
+	var cached cachedResponse
+	hit := readCache(cacheResponses, key, 0, &cached)
+	if hit && time.Since(cached.Stored) < t.ttl {
+		return cached.response(req), nil
+	}
+
+	if hit {
+		etag, modified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				req.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if hit && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cached.Stored = time.Now()
+		writeCache(cacheResponses, key, &cached)
+		return cached.response(req), nil
+	}
+	if !cacheable(resp) {
+		return resp, nil
+	}
+
+	// Read up to the size limit, passing larger bodies through uncached.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCachedResponse+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCachedResponse {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	writeCache(cacheResponses, key, &cachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Stored:     time.Now(),
+	})
+	return resp, nil
+}
+
+// response rebuilds an HTTP response to req from the cache.
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// cacheable returns whether a response may be cached. Only successful JSON
+// responses are, so streams such as logs are never held back.
+func cacheable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	if strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// responseCacheKey identifies a request by its URL and credentials, so users
+// sharing a machine never see each other's responses.
+func responseCacheKey(req *http.Request) string {
+	hash := sha256.New()
+	_, _ = io.WriteString(hash, req.Header.Get("Authorization")+"\n"+req.URL.String())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// clearResponseCache removes every cached response. It's best-effort, like
+// the rest of the cache.
+func clearResponseCache() {
+	filePath, err := cachePath(cacheResponses, "")
+	if err != nil {
+		return
+	}
+	if err := os.RemoveAll(filepath.Dir(filePath)); err != nil && !quiet {
+		fmt.Fprintln(os.Stderr, "Warning: couldn't clear cached responses:", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allenai/beaker/config"
+	"github.com/beaker/client/client"
+)
+
+// useTempCache points the cache at a temporary directory until the returned
+// function is called.
+func useTempCache(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "beaker-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdg, home := os.Getenv("XDG_CACHE_HOME"), os.Getenv("HOME")
+	os.Setenv("XDG_CACHE_HOME", dir)
+	os.Setenv("HOME", dir)
+	return func() {
+		os.Setenv("XDG_CACHE_HOME", xdg)
+		os.Setenv("HOME", home)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestResponseCacheSettings(t *testing.T) {
+	defer func(c *config.Config, b *client.Client) { beakerConfig, beaker = c, b }(beakerConfig, beaker)
+
+	var err error
+	if beaker, err = client.NewClient("https://beaker.org", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting string
+		want    time.Duration // 0 if responses aren't cached.
+		wantErr bool
+	}{
+		{setting: ""},
+		{setting: "30s", want: 30 * time.Second},
+		{setting: "soon", wantErr: true},
+		{setting: "-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		beakerConfig = &config.Config{CacheTTL: tt.setting}
+		base := roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, nil })
+		transport, err := responseCache(base)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("cache_ttl %q: expected an error", tt.setting)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("cache_ttl %q: %v", tt.setting, err)
+			continue
+		}
+		cache, ok := transport.(*cacheTransport)
+		switch {
+		case tt.want == 0 && ok:
+			t.Errorf("cache_ttl %q: responses are cached", tt.setting)
+		case tt.want != 0 && !ok:
+			t.Errorf("cache_ttl %q: responses aren't cached", tt.setting)
+		case ok && (cache.ttl != tt.want || cache.host != "beaker.org"):
+			t.Errorf("cache_ttl %q: cached for %s from %s", tt.setting, cache.ttl, cache.host)
+		}
+	}
+}
+
+// fakeService answers requests with a JSON body naming how many requests it
+// has seen, and records the conditional headers of each.
+type fakeService struct {
+	requests    int
+	contentType string
+	etag        string
+	conditional []string
+}
+
+func (s *fakeService) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.requests++
+	s.conditional = append(s.conditional, req.Header.Get("If-None-Match"))
+	if s.etag != "" && req.Header.Get("If-None-Match") == s.etag {
+		return &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+
+	contentType := s.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	header := http.Header{"Content-Type": {contentType}}
+	if s.etag != "" {
+		header.Set("ETag", s.etag)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"request":` + strconv.Itoa(s.requests) + `}`)),
+	}, nil
+}
+
+func fetch(t *testing.T, transport http.RoundTripper, method, rawURL string) string {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCacheTransport(t *testing.T) {
+	defer useTempCache(t)()
+	const dataset = "https://beaker.org/api/v3/datasets/ds1"
+
+	t.Run("fresh", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{}
+		cache := &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour}
+		first := fetch(t, cache, http.MethodGet, dataset)
+		second := fetch(t, cache, http.MethodGet, dataset)
+		if first != `{"request":1}` || second != first || service.requests != 1 {
+			t.Errorf("got %s then %s after %d requests, want the first response twice after 1",
+				first, second, service.requests)
+		}
+	})
+
+	t.Run("revalidated", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{etag: `"v1"`}
+		cache := &cacheTransport{base: service, host: "beaker.org"}
+		first := fetch(t, cache, http.MethodGet, dataset)
+		second := fetch(t, cache, http.MethodGet, dataset)
+		if second != first || service.requests != 2 || service.conditional[1] != `"v1"` {
+			t.Errorf("got %s then %s with conditions %q, want the first response twice, revalidated",
+				first, second, service.conditional)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{}
+		cache := &cacheTransport{base: service, host: "beaker.org"}
+		fetch(t, cache, http.MethodGet, dataset)
+		if second := fetch(t, cache, http.MethodGet, dataset); second != `{"request":2}` {
+			t.Errorf("got %s, want a new response", second)
+		}
+	})
+
+	t.Run("cleared by changes", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{}
+		cache := &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour}
+		fetch(t, cache, http.MethodGet, dataset)
+		fetch(t, cache, http.MethodPatch, dataset)
+		if third := fetch(t, cache, http.MethodGet, dataset); third != `{"request":3}` {
+			t.Errorf("got %s, want a new response", third)
+		}
+	})
+
+	t.Run("bypassed", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{}
+		fetch(t, &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour}, http.MethodGet, dataset)
+		bypass := &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour, bypass: true}
+		if second := fetch(t, bypass, http.MethodGet, dataset); second != `{"request":2}` {
+			t.Errorf("got %s, want a new response", second)
+		}
+	})
+
+	t.Run("not JSON", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{contentType: "text/plain"}
+		cache := &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour}
+		fetch(t, cache, http.MethodGet, dataset)
+		fetch(t, cache, http.MethodGet, dataset)
+		if service.requests != 2 {
+			t.Errorf("made %d requests, want 2", service.requests)
+		}
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		clearResponseCache()
+		service := &fakeService{}
+		cache := &cacheTransport{base: service, host: "beaker.org", ttl: time.Hour}
+		fetch(t, cache, http.MethodGet, "https://storage.googleapis.com/bucket/object")
+		fetch(t, cache, http.MethodGet, "https://storage.googleapis.com/bucket/object")
+		if service.requests != 2 {
+			t.Errorf("made %d requests, want 2", service.requests)
+		}
+	})
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	request := func(rawURL, token string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	key := responseCacheKey(request("https://beaker.org/api/v3/datasets/ds1", "alice"))
+	if other := responseCacheKey(request("https://beaker.org/api/v3/datasets/ds1", "alice")); other != key {
+		t.Error("the same request has different keys")
+	}
+	if other := responseCacheKey(request("https://beaker.org/api/v3/datasets/ds1", "bob")); other == key {
+		t.Error("requests with different credentials share a key")
+	}
+	if other := responseCacheKey(request("https://beaker.org/api/v3/datasets/ds2", "alice")); other == key {
+		t.Error("requests for different URLs share a key")
+	}
+}
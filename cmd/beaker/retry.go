@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Number of times to retry a request when neither the --retries flag nor
+	// the retries config setting is given.
+	defaultRetries = 3
+
+	// Number of times a file which fails to transfer is retried on its own
+	// before a dataset transfer is abandoned.
+	transferRetries = 3
+
+	retryWaitMin = 500 * time.Millisecond
+	retryWaitMax = 30 * time.Second
+)
+
+// retryPolicy returns how many times to retry requests to the Beaker service.
+// The --retries flag takes precedence over the retries config setting.
+func retryPolicy(flagSet bool) (int, error) {
+	if !flagSet && beakerConfig.Retries != "" {
+		n, err := strconv.Atoi(beakerConfig.Retries)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retries setting %q; must be a non-negative integer", beakerConfig.Retries)
+		}
+		retries = n
+	}
+	if retries < 0 {
+		return 0, fmt.Errorf("invalid retries %d; must be non-negative", retries)
+	}
+	return retries, nil
+}
+
+// retryRequests retries requests to the Beaker service made through base.
+func retryRequests(base http.RoundTripper, flagSet bool) (http.RoundTripper, error) {
+	n, err := retryPolicy(flagSet)
+	if err != nil {
+		return nil, err
+	}
+	address, err := url.Parse(beaker.Address())
+	if err != nil {
+		return nil, err
+	}
+	return &retryTransport{
+		base:    base,
+		host:    address.Host,
+		retries: n,
+		unsafe:  retryUnsafe,
+	}, nil
+}
+
+// retryTransport retries requests to the Beaker service which fail with
+// transient errors such as 502s and 503s, with exponential backoff and full
+// jitter, honoring Retry-After. By default only idempotent requests are
+// retried, since others may have taken effect despite failing.
+//
+// The client library has its own fixed retries of server errors, which sit
+// above this transport and can't be configured.
+type retryTransport struct {
+	base    http.RoundTripper
+	host    string
+	retries int
+	unsafe  bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || !(t.unsafe || isIdempotent(req.Method)) {
+		return t.base.RoundTrip(req)
+	}
+
+	// Requests with bodies can only be retried if the body can be rewound.
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && req.GetBody == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && hasBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if attempt == t.retries || req.Context().Err() != nil || !isTransient(resp, err) {
+			return resp, err
+		}
+
+		wait := retryDelay(attempt)
+		reason := "network error"
+		if resp != nil {
+			reason = resp.Status
+			if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = after
+				if wait > retryWaitMax {
+					wait = retryWaitMax
+				}
+			}
+			// Drain the body so the connection can be reused.
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Retrying %s %s in %v after %s\n",
+				req.Method, req.URL.Path, wait.Round(time.Millisecond), reason)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isIdempotent returns whether repeating a request with the given method has
+// the same effect as making it once.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTransient returns whether a failed request is likely to succeed later.
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns how long to wait before retrying a request or file
+// transfer. The delay grows exponentially with full jitter.
+func retryDelay(attempt int) time.Duration {
+	ceiling := retryWaitMax
+	if attempt < 16 {
+		if d := retryWaitMin << uint(attempt); d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
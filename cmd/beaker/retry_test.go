@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allenai/beaker/config"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	defer func(c *config.Config, r int) { beakerConfig, retries = c, r }(beakerConfig, retries)
+
+	tests := []struct {
+		name    string
+		setting string
+		flag    int
+		flagSet bool
+		want    int
+		wantErr bool
+	}{
+		{name: "default", flag: defaultRetries, want: defaultRetries},
+		{name: "setting", setting: "5", flag: defaultRetries, want: 5},
+		{name: "flag over setting", setting: "5", flag: 1, flagSet: true, want: 1},
+		{name: "zero setting", setting: "0", flag: defaultRetries, want: 0},
+		{name: "invalid setting", setting: "many", flag: defaultRetries, wantErr: true},
+		{name: "negative setting", setting: "-1", flag: defaultRetries, wantErr: true},
+		{name: "invalid setting ignored with flag", setting: "many", flag: 2, flagSet: true, want: 2},
+		{name: "negative flag", flag: -1, flagSet: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beakerConfig = &config.Config{Retries: tt.setting}
+			retries = tt.flag
+
+			got, err := retryPolicy(tt.flagSet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("retryPolicy = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 70; attempt++ {
+		ceiling := retryWaitMax
+		if attempt < 6 {
+			ceiling = retryWaitMin << uint(attempt)
+		}
+		for i := 0; i < 100; i++ {
+			if d := retryDelay(attempt); d < 0 || d >= ceiling {
+				t.Fatalf("retryDelay(%d) = %s, want in [0, %s)", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"7", 7 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// roundTripFunc adapts a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransport(t *testing.T) {
+	defer func(q bool) { quiet = q }(quiet)
+	quiet = true
+
+	tests := []struct {
+		name     string
+		method   string
+		host     string
+		unsafe   bool
+		statuses []int // Status of each attempt; 0 is a network error.
+		want     int   // Attempts made.
+	}{
+		{name: "success", method: http.MethodGet, statuses: []int{200}, want: 1},
+		{name: "transient", method: http.MethodGet, statuses: []int{503, 502, 200}, want: 3},
+		{name: "network error", method: http.MethodGet, statuses: []int{0, 200}, want: 2},
+		{name: "exhausted", method: http.MethodGet, statuses: []int{503, 503, 503, 503, 503}, want: 3},
+		{name: "not transient", method: http.MethodGet, statuses: []int{500, 200}, want: 1},
+		{name: "not found", method: http.MethodGet, statuses: []int{404, 200}, want: 1},
+		{name: "unsafe", method: http.MethodPost, statuses: []int{503, 200}, want: 1},
+		{name: "unsafe allowed", method: http.MethodPost, unsafe: true, statuses: []int{503, 200}, want: 2},
+		{name: "other host", method: http.MethodGet, host: "example.com", statuses: []int{503, 200}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			transport := &retryTransport{
+				base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					status := tt.statuses[attempts]
+					attempts++
+					if status == 0 {
+						return nil, errors.New("connection reset")
+					}
+					return &http.Response{
+						StatusCode: status,
+						Status:     http.StatusText(status),
+						// Retry at once rather than backing off.
+						Header: http.Header{"Retry-After": {"0"}},
+						Body:   ioutil.NopCloser(strings.NewReader("")),
+					}, nil
+				}),
+				host:    "beaker.org",
+				retries: 2,
+				unsafe:  tt.unsafe,
+			}
+
+			host := tt.host
+			if host == "" {
+				host = "beaker.org"
+			}
+			req, err := http.NewRequest(tt.method, "https://"+host+"/api/v3/datasets", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if attempts != tt.want {
+				t.Errorf("made %d attempts, want %d", attempts, tt.want)
+			}
+		})
+	}
+}
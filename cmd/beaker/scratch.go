@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/allenai/bytefmt"
+)
+
+// Where scratch space is mounted if no path is given.
+const defaultScratchPath = "/scratch"
+
+// scratchSpec requests an empty directory on node-local disk which is mounted
+// into a session and deleted once it finishes.
+type scratchSpec struct {
+	Size string `yaml:"size" json:"size"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+}
+
+// parseScratchFlag parses scratch space written as SIZE[:PATH], e.g. 200GiB:/scratch.
+func parseScratchFlag(s string) (*scratchSpec, error) {
+	parts := strings.SplitN(s, ":", 2)
+	scratch := &scratchSpec{Size: parts[0]}
+	if len(parts) == 2 {
+		scratch.Path = parts[1]
+	}
+	if _, err := scratch.validate(); err != nil {
+		return nil, err
+	}
+	return scratch, nil
+}
+
+// validate checks a scratch request and returns its size in bytes.
+func (s *scratchSpec) validate() (int64, error) {
+	size, err := bytefmt.Parse(s.Size)
+	if err != nil || size.Int64() <= 0 {
+		return 0, fmt.Errorf("invalid scratch size %q", s.Size)
+	}
+	if s.Path != "" && (!path.IsAbs(s.Path) || path.Clean(s.Path) == "/") {
+		return 0, fmt.Errorf("invalid scratch path %q; must be an absolute path other than /", s.Path)
+	}
+	return size.Int64(), nil
+}
+
+// mountPath returns where scratch space is mounted in the container.
+func (s *scratchSpec) mountPath() string {
+	if s.Path == "" {
+		return defaultScratchPath
+	}
+	return path.Clean(s.Path)
+}
+
+// scratchRoot returns the directory in which this node allocates scratch space.
+func scratchRoot() (string, error) {
+	config, err := getExecutorConfig()
+	if err != nil {
+		return "", fmt.Errorf("scratch space requires an executor on this node: %w", err)
+	}
+	if config.ScratchPath != "" {
+		return config.ScratchPath, nil
+	}
+	return filepath.Join(config.StoragePath, "scratch"), nil
+}
+
+// allocateScratch reserves scratch space for a session and returns its
+// directory. Space is reserved against the free space of the node's scratch
+// disk, less the space reserved for other sessions. Space left behind by
+// sessions which have ended is released first.
+func allocateScratch(sessionID string, size int64) (string, error) {
+	root, err := scratchRoot()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("couldn't create scratch directory: %w", err)
+	}
+
+	// Hold the node's lock from checking free space until the reservation is
+	// written so that concurrent sessions can't both claim the same space.
+	unlock, err := lockScratch(root)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	reserved, err := releaseStaleScratch(root)
+	if err != nil {
+		return "", err
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(root, &stat); err != nil {
+		return "", fmt.Errorf("couldn't check free space in %s: %w", root, err)
+	}
+	available := int64(stat.Bavail)*int64(stat.Bsize) - reserved
+	if size > available {
+		if available < 0 {
+			available = 0
+		}
+		return "", fmt.Errorf("requested %s of scratch space but this node has %s available",
+			bytefmt.New(size, bytefmt.Binary), bytefmt.New(available, bytefmt.Binary))
+	}
+
+	dir := filepath.Join(root, sessionID)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return "", fmt.Errorf("couldn't create scratch directory: %w", err)
+	}
+	if err := ioutil.WriteFile(dir+".size", []byte(strconv.FormatInt(size, 10)), 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// lockScratch takes an exclusive lock on a node's scratch space, waiting for
+// other allocations to finish, and returns a function which releases it.
+func lockScratch(root string) (func(), error) {
+	// The lock may be created by another user, so it's only opened for
+	// reading, which is enough to lock it.
+	f, err := os.OpenFile(filepath.Join(root, ".lock"), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open scratch lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't lock scratch space: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// releaseScratch deletes a session's scratch space.
+func releaseScratch(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Remove(dir + ".size"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// releaseStaleScratch deletes the scratch space of sessions which have ended
+// and returns the total size reserved by the rest.
+func releaseStaleScratch(root string) (int64, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+
+	var reserved int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".size") {
+			continue
+		}
+		sessionID := strings.TrimSuffix(entry.Name(), ".size")
+		dir := filepath.Join(root, sessionID)
+
+		session, err := beaker.Session(sessionID).Get(ctx)
+		if err != nil && !isNotFound(err) {
+			return 0, err
+		}
+		if err != nil || session.State.Finalized != nil {
+			// Space owned by another user may not be removable; it stays
+			// reserved until that user's next session releases it.
+			if err := releaseScratch(dir); err == nil {
+				continue
+			}
+		}
+
+		size, err := ioutil.ReadFile(dir + ".size")
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(size)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid scratch reservation %s: %w", dir+".size", err)
+		}
+		reserved += n
+	}
+	return reserved, nil
+}
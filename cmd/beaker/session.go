@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
@@ -16,6 +19,7 @@ import (
 	"github.com/beaker/client/client"
 	"github.com/beaker/runtime"
 	"github.com/beaker/runtime/docker"
+	"github.com/docker/docker/api/types"
 	"github.com/spf13/cobra"
 )
 
@@ -32,35 +36,72 @@ func newSessionCommand() *cobra.Command {
 		Use:   "session <command>",
 		Short: "Manage sessions",
 	}
+	cmd.PersistentFlags().StringVar(&containerRuntime, "runtime", "", fmt.Sprintf(
+		"Container runtime of sessions (%s|%s). Defaults to the executor's runtime", runtimeDocker, runtimePodman))
 	cmd.AddCommand(newSessionAttachCommand())
 	cmd.AddCommand(newSessionCreateCommand())
+	cmd.AddCommand(newSessionDoctorCommand())
 	cmd.AddCommand(newSessionExecCommand())
 	cmd.AddCommand(newSessionGetCommand())
 	cmd.AddCommand(newSessionListCommand())
+	cmd.AddCommand(newSessionLogsCommand())
+	cmd.AddCommand(newSessionPortForwardCommand())
+	cmd.AddCommand(newSessionSSHCommand())
+	cmd.AddCommand(newSessionStatsCommand())
 	cmd.AddCommand(newSessionStopCommand())
 	return cmd
 }
 
 func newSessionAttachCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "attach <session>",
 		Short: "Attach to a running session",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			container, err := findRunningContainer(args[0])
-			if err != nil {
-				return err
+		Long: `Attach to a running session
+
+If the connection to the session's container drops while it's still running,
+attach reconnects automatically and replays output written while disconnected.
+Use --replay to also show recent output from before attaching, such as after
+an SSH connection is lost.
+
+With --record, the session's output is also written to a file in asciicast v2
+format, which 'asciinema play' replays. Input is not recorded, though a shell
+echoes what's typed into it.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var replay time.Duration
+	var record string
+	cmd.Flags().DurationVar(&replay, "replay", 0, "Replay output written within this duration before attaching")
+	cmd.Flags().StringVar(&record, "record", "", "Record the session's output to an asciicast file, e.g. session.cast")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		container, err := findRunningContainer(args[0])
+		if err != nil {
+			return err
+		}
+
+		var recorder *castRecorder
+		if record != "" {
+			if recorder, err = newCastRecorder(record, "Beaker session "+args[0]); err != nil {
+				return fmt.Errorf("couldn't start recording: %w", err)
 			}
+			defer closeRecorder(recorder, record)
+		}
 
-			resp, err := container.(*docker.Container).Attach(ctx)
-			if err != nil {
+		resp, err := container.(*docker.Container).Attach(ctx)
+		if err != nil {
+			return err
+		}
+		if replay > 0 {
+			now := time.Now()
+			if err := replayLogs(container, now.Add(-replay), now, sessionOutput(recorder)); err != nil {
+				resp.Close()
 				return err
 			}
-			defer resp.Close()
-
-			return handleAttachErr(container.(*docker.Container).Stream(ctx, resp))
-		},
+		}
+		return streamSession(container.(*docker.Container), resp, recorder)
 	}
+	return cmd
 }
 
 func newSessionCreateCommand() *cobra.Command {
@@ -70,35 +111,97 @@ func newSessionCreateCommand() *cobra.Command {
 		Long: `Create a new interactive session backed by a Docker container.
 
 Arguments are passed to the Docker container as a command.
-To pass flags, use "--" e.g. "create -- ls -l"`,
+To pass flags, use "--" e.g. "create -- ls -l"
+
+With --port, connections to a port on localhost of the session's node are
+forwarded to a port of the container until this command exits. Ports aren't
+published by Docker, so they're no longer forwarded once the session is
+detached; use 'beaker session port-forward' to forward them again. To reach
+them from another machine, tunnel the host port over SSH, e.g.
+"ssh -L 8888:localhost:8888 <node>".
+
+With --queue, a session which can't start immediately waits for resources
+without prompting, and a notification is sent once it's scheduled. Use
+--notify to choose how: "bell" rings the terminal bell, "desktop" shows a
+desktop notification, and a URL receives the session as JSON via POST.
+
+With --ssh, an SSH server is started in the session so that SSH clients and
+remote IDEs can connect directly. Your authorized keys and default public keys
+on this node are accepted unless --ssh-key is given.
+
+With --scratch, an empty directory of the given size is allocated on the
+node's local disk and mounted at /scratch, or at the given path, e.g.
+--scratch 200GiB:/data. The session fails to start if the disk lacks the
+space. Scratch space is deleted once the session ends.
+
+With --identity, the session can access cloud resources as a GCP service
+account (gcp:<email>) or AWS IAM role (aws:<role-arn>). Short-lived
+credentials are obtained by impersonating the account with gcloud or assuming
+the role with the AWS CLI, using your credentials on this node or, if you have
+none, the node's own. They're refreshed while the session is attached, mounted
+at /var/run/beaker/identity, and found by cloud tools through the environment.
+
+With --detach, the session's container is started without attaching to it and
+the session's ID is printed. Pass a command to warm the session up, such as
+installing dependencies, then use 'beaker session attach' or 'beaker session
+exec' to connect and 'beaker session logs' to see its output.
+
+With --record, the session's output is also written to a file in asciicast v2
+format, which 'asciinema play' replays. See 'beaker session attach'.`,
 		Args: cobra.ArbitraryArgs,
 	}
 
+	var detach bool
+	var record string
 	var localHome bool
 	var image string
 	var name string
 	var node string
 	var pull string
+	var portFlags []string
+	var queue bool
+	var notify []string
+	var ssh bool
+	var sshKey string
+	var scratchFlag string
+	var identityFlag string
 	cmd.Flags().StringVar(
 		&image,
 		"image",
 		"beaker://ai2/cuda11.2-ubuntu20.04",
 		"Base image to run, may be a Beaker or Docker image")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Start the session without attaching to it and print its ID")
 	cmd.Flags().BoolVar(&localHome, "local-home", false, "Mount the invoking user's home directory, ignoring Beaker configuration")
 	cmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the session")
 	cmd.Flags().StringVar(&node, "node", "", "Node that the session will run on. Defaults to current node.")
+	cmd.Flags().StringVar(&record, "record", "", "Record the session's output to an asciicast file, e.g. session.cast")
 	cmd.Flags().StringVar(&pull, "pull", string(runtime.PullIfMissing), fmt.Sprintf(
 		"Pull image before running (%s|%s|%s)", runtime.PullAlways, runtime.PullIfMissing, runtime.PullNever))
+	cmd.Flags().StringArrayVar(&portFlags, "port", nil, "Forward a local port to the container as host:container, may be repeated")
+	cmd.Flags().BoolVar(&queue, "queue", false, "Wait for resources and send a notification once the session is scheduled")
+	cmd.Flags().StringArrayVar(&notify, "notify", []string{notifyBell},
+		"How to notify when a queued session is scheduled: bell, desktop, or a webhook URL; may be repeated")
+	cmd.Flags().BoolVar(&ssh, "ssh", false, "Start an SSH server in the session")
+	cmd.Flags().StringVar(&sshKey, "ssh-key", "", "Public key file to accept with --ssh")
+	cmd.Flags().StringVar(&scratchFlag, "scratch", "", "Node-local scratch space as SIZE[:PATH], e.g. 200GiB:/scratch")
+	cmd.Flags().StringVar(&identityFlag, "identity", "", "Cloud identity as gcp:<service-account> or aws:<role-arn>")
 
 	var cpus float64
 	var gpus int
+	var acceleratorFlag string
 	var memory string
 	cmd.Flags().Float64Var(&cpus, "cpus", 0, "Minimum CPU cores to reserve, e.g. 7.5")
 	cmd.Flags().IntVar(&gpus, "gpus", 0, "Minimum number of GPUs to reserve")
+	cmd.Flags().StringVar(&acceleratorFlag, "accelerator", "",
+		"NVIDIA GPUs to reserve as <type>[:<count>], e.g. a100:2; the node must have that type")
 	cmd.Flags().StringVar(&memory, "memory", "", "Minimum memory to reserve, e.g. 6.5GiB")
 
+	var constraintFlags []string
+	cmd.Flags().StringArrayVar(&constraintFlags, "constraint", nil,
+		"Label the node must have as key=value, e.g. gpu=a100; may be repeated")
+
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		rt, err := docker.NewRuntime()
+		rt, err := newContainerRuntime()
 		if err != nil {
 			return fmt.Errorf("couldn't initialize container runtime: %w", err)
 		}
@@ -109,6 +212,78 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 			}
 		}
 
+		ports, err := parsePortMappings(portFlags)
+		if err != nil {
+			return err
+		}
+		if detach && len(ports) != 0 {
+			return errors.New("ports can't be forwarded from a detached session; use 'beaker session port-forward'")
+		}
+		if detach && record != "" {
+			return errors.New("a detached session can't be recorded; use --record with 'beaker session attach'")
+		}
+		if detach && identityFlag != "" {
+			return errors.New("--identity can't be used with --detach because credentials are only refreshed while attached")
+		}
+		if err := validateNotifyMethods(notify); err != nil {
+			return err
+		}
+
+		var sshKeys string
+		if ssh {
+			if sshKeys, err = readSSHKeys(sshKey); err != nil {
+				return err
+			}
+		}
+
+		var scratch *scratchSpec
+		var scratchSize int64
+		if scratchFlag != "" {
+			if scratch, err = parseScratchFlag(scratchFlag); err != nil {
+				return err
+			}
+			scratchSize, _ = scratch.validate()
+		}
+
+		var cloudIdentity *identitySpec
+		if identityFlag != "" {
+			if cloudIdentity, err = parseIdentityFlag(identityFlag); err != nil {
+				return err
+			}
+		}
+
+		if acceleratorFlag != "" {
+			accelerator, err := parseAcceleratorFlag(acceleratorFlag)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("gpus") {
+				return errors.New("only one of --accelerator or --gpus may be set")
+			}
+			var config *executorConfig
+			if node, config, err = localNodeConfig(node, "accelerators"); err != nil {
+				return err
+			}
+			if err := checkAccelerators(node, config.Accelerators, accelerator); err != nil {
+				return err
+			}
+			gpus = accelerator.Count
+		}
+
+		if len(constraintFlags) != 0 {
+			constraints, err := parseLabels(constraintFlags)
+			if err != nil {
+				return usageError{err}
+			}
+			var config *executorConfig
+			if node, config, err = localNodeConfig(node, "labels"); err != nil {
+				return err
+			}
+			if err := checkConstraints(node, config.Labels, constraints); err != nil {
+				return err
+			}
+		}
+
 		var memSize *bytefmt.Size
 		if memory != "" {
 			if memSize, err = bytefmt.Parse(memory); err != nil {
@@ -139,6 +314,13 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 			return err
 		}
 
+		// Claim ports before creating the session so conflicts fail fast.
+		forwarder, err := listenPorts(ports)
+		if err != nil {
+			return err
+		}
+		defer forwarder.Close()
+
 		session, err := beaker.CreateSession(ctx, api.SessionSpec{
 			Name: name,
 			Node: node,
@@ -151,6 +333,7 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 		if err != nil {
 			return err
 		}
+		recordRecent(recentSession, session.ID)
 
 		shouldCancel, sessionID := true, session.ID
 		defer func() {
@@ -187,9 +370,15 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 			fmt.Println()
 		}
 
-		if session, err = awaitSessionSchedule(*session); err != nil {
+		if session, err = awaitSessionSchedule(*session, queue); err != nil {
 			return err
 		}
+		if queue {
+			message := fmt.Sprintf("Session %s has been scheduled", session.ID)
+			if err := sendNotification(notify, message, session); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			}
+		}
 
 		if lim := resourceLimitString(session.Limits); !quiet && lim != "" {
 			fmt.Println("Reserved", lim)
@@ -220,7 +409,55 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 			})
 		}
 
-		container, err := rt.CreateContainer(ctx, &runtime.ContainerOpts{
+		var container runtime.Container
+		if scratch != nil {
+			dir, err := allocateScratch(session.ID, scratchSize)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				// A session which is still running, e.g. after Ctrl+C, keeps its
+				// space until a later session on this node finds it has ended.
+				if container != nil {
+					info, err := container.Info(context.Background())
+					if err == nil && info.Status == runtime.StatusRunning {
+						return
+					}
+				}
+				if err := releaseScratch(dir); err != nil {
+					fmt.Fprintln(os.Stderr, "Couldn't delete scratch space:", err)
+				}
+			}()
+			mounts = append(mounts, runtime.Mount{HostPath: dir, ContainerPath: scratch.mountPath()})
+		}
+
+		if cloudIdentity != nil {
+			identity, err := newSessionIdentity(cloudIdentity, session.ID)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				// Credentials of a session which is still running are kept
+				// so it doesn't lose access.
+				if container != nil {
+					info, err := container.Info(context.Background())
+					if err == nil && info.Status == runtime.StatusRunning {
+						fmt.Fprintln(os.Stderr, "Session is still running; its cloud credentials are kept in", identity.dir)
+						return
+					}
+				}
+				if err := identity.Close(); err != nil {
+					fmt.Fprintln(os.Stderr, "Couldn't delete cloud credentials:", err)
+				}
+			}()
+			go identity.keepFresh(ctx)
+			for k, v := range identity.env() {
+				env[k] = v
+			}
+			mounts = append(mounts, identity.mount())
+		}
+
+		container, err = rt.CreateContainer(ctx, &runtime.ContainerOpts{
 			Name: strings.ToLower("session-" + session.ID),
 			Image: &runtime.DockerImage{
 				Tag: rtImage.Tag,
@@ -240,17 +477,64 @@ To pass flags, use "--" e.g. "create -- ls -l"`,
 			return err
 		}
 
+		if detach {
+			if err := container.Start(ctx); err != nil {
+				return err
+			}
+			shouldCancel = false
+			if ssh {
+				if err := connectSessionSSH(session, container.Name(), sshKeys); err != nil {
+					return err
+				}
+			}
+			if quiet {
+				fmt.Println(session.ID)
+			} else {
+				fmt.Printf("Session %s started. Attach with 'beaker session attach %s'\n", session.ID, session.ID)
+			}
+			return nil
+		}
+
+		var recorder *castRecorder
+		if record != "" {
+			if recorder, err = newCastRecorder(record, "Beaker session "+session.ID); err != nil {
+				return fmt.Errorf("couldn't start recording: %w", err)
+			}
+			defer closeRecorder(recorder, record)
+		}
+
 		resp, err := container.(*docker.Container).Attach(ctx)
 		if err != nil {
 			return err
 		}
-		defer resp.Close()
 
 		if err := container.Start(ctx); err != nil {
+			resp.Close()
 			return err
 		}
 
-		return handleAttachErr(container.(*docker.Container).Stream(ctx, resp))
+		if len(ports) != 0 {
+			address, err := containerAddress(container.Name())
+			if err != nil {
+				return err
+			}
+			go forwarder.Serve(ctx, address)
+
+			if !quiet {
+				for _, port := range ports {
+					fmt.Println("Forwarding", port)
+				}
+			}
+		}
+
+		if ssh {
+			if err := connectSessionSSH(session, container.Name(), sshKeys); err != nil {
+				resp.Close()
+				return err
+			}
+		}
+
+		return streamSession(container.(*docker.Container), resp, recorder)
 	}
 	return cmd
 }
@@ -350,6 +634,88 @@ If no command is provided, exec will run 'bash -l'`,
 	}
 }
 
+func newSessionSSHCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ssh <session>",
+		Short: "Connect to a running session with SSH",
+		Long: `Connect to a running session with SSH
+
+Starts an SSH server in the session if one isn't already running, then
+connects to it. Your authorized keys and default public keys on this node are
+accepted unless --ssh-key is given. Use --print-config to print an SSH config
+entry for connecting from another machine, e.g. with a remote IDE.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var sshKey string
+	var printConfig bool
+	cmd.Flags().StringVar(&sshKey, "ssh-key", "", "Public key file to accept")
+	cmd.Flags().BoolVar(&printConfig, "print-config", false, "Print an SSH config entry instead of connecting")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		keys, err := readSSHKeys(sshKey)
+		if err != nil {
+			return err
+		}
+
+		container, err := findRunningContainer(args[0])
+		if err != nil {
+			return err
+		}
+		if err := startSessionSSH(container.Name(), keys); err != nil {
+			return err
+		}
+		address, err := containerAddress(container.Name())
+		if err != nil {
+			return err
+		}
+
+		if printConfig {
+			session, err := beaker.Session(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+			node, err := beaker.Node(session.Node).Get(ctx)
+			if err != nil {
+				return err
+			}
+			return printSSHInstructions(session.ID, node.Hostname, address)
+		}
+
+		ssh := exec.CommandContext(ctx, "ssh", "-p", strconv.Itoa(sessionSSHPort), address)
+		ssh.Stdin, ssh.Stdout, ssh.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return ssh.Run()
+	}
+	return cmd
+}
+
+// connectSessionSSH starts an SSH server in a new session and prints how to
+// connect to it.
+func connectSessionSSH(session *api.Session, containerName, keys string) error {
+	if !quiet {
+		fmt.Println("Starting SSH server...")
+	}
+	if err := startSessionSSH(containerName, keys); err != nil {
+		return err
+	}
+	address, err := containerAddress(containerName)
+	if err != nil {
+		return err
+	}
+	node, err := beaker.Node(session.Node).Get(ctx)
+	if err != nil {
+		return err
+	}
+	if quiet {
+		return nil
+	}
+	if err := printSSHInstructions(session.ID, node.Hostname, address); err != nil {
+		return err
+	}
+	fmt.Println()
+	return nil
+}
+
 func newSessionGetCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:     "get <session...>",
@@ -357,13 +723,16 @@ func newSessionGetCommand() *cobra.Command {
 		Short:   "Display detailed information about one or more sessions",
 		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var sessions []api.Session
-			for _, id := range args {
-				info, err := beaker.Session(id).Get(ctx)
+			sessions := make([]api.Session, len(args))
+			if err := forEachConcurrently(len(args), func(i int) error {
+				info, err := beaker.Session(args[i]).Get(ctx)
 				if err != nil {
 					return err
 				}
-				sessions = append(sessions, *info)
+				sessions[i] = *info
+				return nil
+			}); err != nil {
+				return err
 			}
 			return printSessions(sessions)
 		},
@@ -415,89 +784,266 @@ func newSessionListCommand() *cobra.Command {
 	return cmd
 }
 
-func newSessionStopCommand() *cobra.Command {
+func newSessionLogsCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stop",
-		Short: "Stop a pending or running session",
-		Args:  cobra.ExactArgs(1),
+		Use:   "logs <session>",
+		Short: "Print a session's output",
+		Long: `Print a session's output
+
+Output is read from the session's container on this node, including after the
+session has exited. Use --follow to keep printing output until the session
+ends.`,
+		Args: cobra.ExactArgs(1),
 	}
 
+	var follow bool
+	var since time.Duration
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing output until the session ends")
+	cmd.Flags().DurationVar(&since, "since", 0, "Only print output written within this duration")
+
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		patch := api.SessionPatch{
-			State: &api.ExecStatusUpdate{Canceled: true},
+		info, err := beaker.Session(args[0]).Get(ctx)
+		if err != nil {
+			return err
 		}
-
-		session, err := beaker.Session(args[0]).Patch(ctx, patch)
+		container, err := findSessionContainer(info.ID)
 		if err != nil {
 			return err
 		}
-		return printSessions([]api.Session{*session})
+
+		var start time.Time
+		if since > 0 {
+			start = time.Now().Add(-since)
+		}
+		for {
+			last, err := printSessionLogs(container, start)
+			if err != nil {
+				return err
+			}
+			if !last.IsZero() {
+				// Log times are inclusive, so skip past the last message.
+				start = last.Add(time.Nanosecond)
+			}
+			if !follow {
+				return nil
+			}
+
+			status, err := container.Info(ctx)
+			if err != nil {
+				return err
+			}
+			if status.Status != runtime.StatusRunning {
+				// Print anything written between the last read and exiting.
+				_, err := printSessionLogs(container, start)
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+		}
 	}
 	return cmd
 }
 
-func awaitSessionSchedule(session api.Session) (*api.Session, error) {
-	s := beaker.Session(session.ID)
-	cl := beaker.Cluster(session.Cluster)
+// printSessionLogs writes a container's output since the given time to STDOUT. It
+// returns the time of the last line written, if any.
+func printSessionLogs(container runtime.Container, since time.Time) (time.Time, error) {
+	var last time.Time
+	err := readSessionLogs(container, since, func(t time.Time, line string) bool {
+		fmt.Fprint(os.Stdout, line)
+		last = t
+		return true
+	})
+	return last, err
+}
 
-	nodes, err := cl.ListClusterNodes(ctx)
+// readSessionLogs calls fn with each line of a session container's output
+// written since the given time, until fn returns false. Session containers
+// have TTYs, so Docker returns their logs as a raw stream rather than the
+// multiplexed stream the runtime's log reader expects. Each line is prefixed
+// by its timestamp.
+func readSessionLogs(container runtime.Container, since time.Time, fn func(t time.Time, line string) bool) error {
+	client, err := newContainerClient()
 	if err != nil {
-		return nil, fmt.Errorf("couldn't list cluster nodes: %w", err)
+		return err
 	}
+	defer client.Close()
 
-	nodesByID := make(map[string]*api.Node, len(nodes))
-	for _, node := range nodes {
-		node := node
-		nodesByID[node.ID] = &node
+	var sinceStr string
+	if !since.IsZero() {
+		sinceStr = since.Format(time.RFC3339Nano)
 	}
-
-	execs, err := cl.ListExecutions(ctx, &client.ExecutionFilters{
-		Scheduled: api.BoolPtr(true),
+	r, err := client.ContainerLogs(ctx, container.Name(), types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Since:      sinceStr,
+		Timestamps: true,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't list cluster workloads: %w", err)
+		return err
 	}
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			parts := strings.SplitN(line, " ", 2)
+			t, parseErr := time.Parse(time.RFC3339Nano, parts[0])
+			if parseErr != nil || len(parts) != 2 {
+				return fmt.Errorf("invalid log line %q", line)
+			}
+			if !fn(t, parts[1]) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	// Subtract each running execution from its node's capacity.
-	for _, exec := range execs {
-		node, ok := nodesByID[exec.Node]
-		if !ok || node.Limits == nil {
-			continue
+func newSessionPortForwardCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "port-forward <session> <host:container...>",
+		Short: "Forward local ports to a running session",
+		Long: `Forward local ports to a running session until interrupted.
+
+Connections to each host port on localhost are forwarded to the container
+port. The session's container is reached directly, so this must run on the
+session's node. To reach the ports from another machine, tunnel them over
+SSH, e.g. "ssh -L 8888:localhost:8888 <node>".`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ports, err := parsePortMappings(args[1:])
+			if err != nil {
+				return err
+			}
+
+			session, err := beaker.Session(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+			if node, err := getCurrentNode(); err == nil && session.Node != node {
+				return fmt.Errorf("session %s is running on node %s; run this command there", session.ID, session.Node)
+			}
+
+			container, err := findRunningContainer(args[0])
+			if err != nil {
+				return err
+			}
+
+			address, err := containerAddress(container.Name())
+			if err != nil {
+				return err
+			}
+
+			forwarder, err := listenPorts(ports)
+			if err != nil {
+				return err
+			}
+
+			if !quiet {
+				for _, port := range ports {
+					fmt.Println("Forwarding", port)
+				}
+				fmt.Println("Press Ctrl+C to stop.")
+			}
+			forwarder.Serve(ctx, address)
+			return nil
+		},
+	}
+}
+
+func newSessionStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats <session>",
+		Short: "Stream resource usage of a running session",
+		Long: `Stream CPU, memory, and GPU usage of a session's container, about once a
+second, until it stops.
+
+Usage is read from Docker and nvidia-smi, so this must run on the session's
+node. CPU usage is as in 'docker stats', where 100% is one CPU fully used.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
+	}
+
+	var noStream bool
+	cmd.Flags().BoolVar(&noStream, "no-stream", false, "Print one sample and exit")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if !noStream && (format == formatJSON || format == formatYAML) {
+			return fmt.Errorf("format %q requires --no-stream; use %q to stream", format, formatNDJSON)
 		}
 
-		node.Limits.CPUCount -= exec.Limits.CPUCount
-		node.Limits.GPUCount -= len(exec.Limits.GPUs)
-		if node.Limits.Memory != nil && exec.Limits.Memory != nil {
-			node.Limits.Memory.Sub(*exec.Limits.Memory)
+		session, err := beaker.Session(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		if session.State.Started == nil || session.State.Exited != nil || session.State.Finalized != nil {
+			return fmt.Errorf("session %s is not running", session.ID)
 		}
+
+		var gpus []string
+		if session.Limits != nil {
+			gpus = session.Limits.GPUs
+		}
+		return streamContainerStats(statsTarget{
+			kind:  "session",
+			id:    session.ID,
+			node:  session.Node,
+			label: sessionContainerLabel,
+			gpus:  gpus,
+		}, !noStream, printStreamedStats())
 	}
+	return cmd
+}
 
-	sessions, err := beaker.ListSessions(ctx, &client.ListSessionOpts{
-		Cluster:   api.StringPtr(session.Cluster),
-		Finalized: api.BoolPtr(false),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("couldn't list cluster sessions: %w", err)
+func newSessionStopCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop a pending or running session",
+		Args:  cobra.ExactArgs(1),
 	}
 
-	// Subtract each running session from its node's capacity.
-	// TODO allenai/beaker-service#1426: This is duplicative of executions above.
-	for _, sess := range sessions {
-		node, ok := nodesByID[session.Node]
-		if !ok || node.Limits == nil {
-			continue
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		patch := api.SessionPatch{
+			State: &api.ExecStatusUpdate{Canceled: true},
 		}
 
-		// Ignore sessions which haven't fully scheduled yet, including the one we're starting.
-		if sess.ID == session.ID || sess.Limits == nil {
-			continue
+		session, err := beaker.Session(args[0]).Patch(ctx, patch)
+		if err != nil {
+			return err
 		}
+		return printSessions([]api.Session{*session})
+	}
+	return cmd
+}
+
+// awaitSessionSchedule waits for a session to be scheduled. If the session is
+// unlikely to start soon, suggestions are printed unless it's queued.
+func awaitSessionSchedule(session api.Session, queued bool) (*api.Session, error) {
+	s := beaker.Session(session.ID)
 
-		node.Limits.CPUCount -= sess.Limits.CPUCount
-		node.Limits.GPUCount -= len(sess.Limits.GPUs)
-		if node.Limits.Memory != nil && sess.Limits.Memory != nil {
-			node.Limits.Memory.Sub(*sess.Limits.Memory)
+	utilization, err := getClusterUtilization(session.Cluster, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each node's limits are replaced with its free capacity.
+	nodesByID := make(map[string]*api.Node, len(utilization.Nodes))
+	for _, nodeUtil := range utilization.Nodes {
+		node := nodeUtil.Node
+		if node.Limits != nil {
+			free := nodeUtil.Free
+			node.Limits = &free
 		}
+		nodesByID[node.ID] = &node
 	}
 
 	var capacityErr string
@@ -524,7 +1070,9 @@ func awaitSessionSchedule(session api.Session) (*api.Session, error) {
 			hosts = append(hosts, node.Hostname)
 		}
 
-		if !quiet {
+		if queued && !quiet {
+			fmt.Printf("Queued because %s. You'll be notified when the session is scheduled.\n\n", capacityErr)
+		} else if !quiet {
 			fmt.Printf("This session is unlikely to to start because %s.\n", capacityErr)
 			fmt.Println("You may continue waiting to hold your place in the queue.")
 			if len(hosts) == 0 {
@@ -600,6 +1148,81 @@ func checkNodeCapacity(node *api.Node, request *api.ResourceRequest) error {
 	}
 }
 
+// streamSession streams IO for an attached session container. If the
+// connection drops while the container is still running, the container is
+// reattached and output written while disconnected is replayed. Output is
+// also written to the recorder, if there is one.
+func streamSession(container *docker.Container, resp types.HijackedResponse, recorder *castRecorder) error {
+	for reconnects := 0; ; reconnects++ {
+		err := container.Stream(ctx, recordOutput(resp, recorder))
+		resp.Close()
+		if err == nil || ctx.Err() != nil || strings.HasPrefix(err.Error(), "exited with code ") {
+			return handleAttachErr(err)
+		}
+		if reconnects == maxReconnects {
+			return fmt.Errorf("connection lost %d times; giving up: %w", reconnects+1, err)
+		}
+
+		disconnected := time.Now()
+		if resp, err = reattach(container, err); err != nil {
+			return err
+		}
+		if err := replayLogs(container, disconnected, time.Now(), sessionOutput(recorder)); err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't replay output:", err)
+		}
+	}
+}
+
+// Maximum number of attempts to reattach to a container after a dropped
+// connection. Attempts back off linearly by reattachDelay. A session which
+// drops more than maxReconnects times is given up on.
+const (
+	maxReattachAttempts = 5
+	reattachDelay       = 2 * time.Second
+	maxReconnects       = 10
+)
+
+// reattach reconnects to a container after a stream fails with cause. It
+// gives up if the container is no longer running.
+func reattach(container *docker.Container, cause error) (types.HijackedResponse, error) {
+	fmt.Fprintf(os.Stderr, "Connection lost: %v\n", cause)
+	for attempt := 1; attempt <= maxReattachAttempts; attempt++ {
+		fmt.Fprintf(os.Stderr, "Reconnecting (attempt %d of %d)...\n", attempt, maxReattachAttempts)
+		select {
+		case <-ctx.Done():
+			return types.HijackedResponse{}, ctx.Err()
+		case <-time.After(time.Duration(attempt) * reattachDelay):
+		}
+
+		info, err := container.Info(ctx)
+		if err != nil {
+			continue // The daemon may be unreachable; try again.
+		}
+		if info.Status != runtime.StatusRunning {
+			return types.HijackedResponse{}, errors.New("session ended while disconnected")
+		}
+
+		resp, err := container.Attach(ctx)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "Reconnected.")
+		return resp, nil
+	}
+	return types.HijackedResponse{}, fmt.Errorf("couldn't reconnect: %w", cause)
+}
+
+// replayLogs writes a container's output from the given time range to out.
+func replayLogs(container runtime.Container, since, until time.Time, out io.Writer) error {
+	return readSessionLogs(container, since, func(t time.Time, line string) bool {
+		if t.After(until) {
+			return false
+		}
+		fmt.Fprint(out, line)
+		return true
+	})
+}
+
 func handleAttachErr(err error) error {
 	if err != nil && strings.HasPrefix(err.Error(), "exited with code ") {
 		// Ignore errors coming from the container.
@@ -624,8 +1247,13 @@ func findRunningContainer(session string) (runtime.Container, error) {
 	if info.State.Finalized != nil {
 		return nil, fmt.Errorf("session already finalized")
 	}
+	return findSessionContainer(info.ID)
+}
 
-	rt, err := docker.NewRuntime()
+// findSessionContainer finds the container of a session on this node, whether
+// or not it's running.
+func findSessionContainer(session string) (runtime.Container, error) {
+	rt, err := newContainerRuntime()
 	if err != nil {
 		return nil, err
 	}
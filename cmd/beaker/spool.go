@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// submission is a set of experiments to create from an expanded spec, along
+// with the group to put them in. It's saved to the spool if Beaker can't be
+// reached, and records its progress so an interrupted submission can resume
+// without creating experiments twice.
+type submission struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Spooled   time.Time `json:"spooled"`
+	Source    string    `json:"source"`
+	Workspace string    `json:"workspace"`
+	Name      string    `json:"name,omitempty"`
+	Group     string    `json:"group,omitempty"`
+
+	// Whether the spec's references still need to be checked.
+	Verify bool `json:"verify,omitempty"`
+
+	// Notification targets of each experiment.
+	Notify []string `json:"notify,omitempty"`
+
+	// Created experiments with fallback chains.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+
+	// Number of experiments in the whole submission, used to name them.
+	Total int `json:"total"`
+
+	// Experiments not yet created, and IDs of those which were.
+	Runs    []submissionRun `json:"runs,omitempty"`
+	Created []string        `json:"created,omitempty"`
+}
+
+// submissionRun is one experiment of a submission.
+type submissionRun struct {
+	Index    int               `json:"index"`
+	Name     string            `json:"name,omitempty"`
+	Names    []string          `json:"names,omitempty"`
+	Values   map[string]string `json:"values,omitempty"`
+	Spec     string            `json:"spec"`
+	LogSinks []logSink         `json:"logSinks,omitempty"`
+	Fallback *fallbackChain    `json:"fallback,omitempty"`
+}
+
+func newSubmission(source, workspace, name, group string, runs []sweepRun, logSinks [][]logSink) *submission {
+	s := &submission{
+		Address:   beaker.Address(),
+		Source:    source,
+		Workspace: workspace,
+		Name:      name,
+		Group:     group,
+		Total:     len(runs),
+	}
+	for i, run := range runs {
+		s.Runs = append(s.Runs, submissionRun{
+			Index:    i,
+			Names:    run.Point.names,
+			Values:   run.Point.values,
+			Spec:     string(run.Spec),
+			LogSinks: logSinks[i],
+		})
+	}
+	return s
+}
+
+// sweepRuns returns the experiments not yet created.
+func (s *submission) sweepRuns() []sweepRun {
+	runs := make([]sweepRun, len(s.Runs))
+	for i, run := range s.Runs {
+		runs[i] = sweepRun{
+			Point: sweepPoint{names: run.Names, values: run.Values},
+			Spec:  []byte(run.Spec),
+		}
+	}
+	return runs
+}
+
+// submit creates the remaining experiments, then the group. Progress is kept
+// in the submission so that it can be resumed after an error.
+func (s *submission) submit() error {
+	for len(s.Runs) != 0 {
+		run := s.Runs[0]
+		expName := run.Name
+		if expName == "" {
+			expName = s.Name
+			if s.Name != "" && s.Total > 1 {
+				expName = fmt.Sprintf("%s-%d", s.Name, run.Index)
+			}
+		}
+
+		experiment, err := beaker.Workspace(s.Workspace).CreateExperimentRaw(
+			ctx,
+			"application/x-yaml",
+			strings.NewReader(run.Spec),
+			&client.ExperimentOpts{Name: expName})
+		if err != nil {
+			return err
+		}
+		s.Runs = s.Runs[1:]
+		s.Created = append(s.Created, experiment.ID)
+		recordRecent(recentExperiment, experiment.ID)
+		if len(run.LogSinks) != 0 {
+			if err := writeLogSinks(experiment.ID, run.LogSinks); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: couldn't save log sinks of %s: %v\n", experiment.ID, err)
+			}
+		}
+		if len(s.Notify) != 0 {
+			if err := writeNotificationTargets(experiment.ID, s.Notify); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: couldn't save notification targets of %s: %v\n", experiment.ID, err)
+			}
+		}
+		if run.Fallback != nil {
+			err := writeFallbackState(experiment.ID, &fallbackState{
+				Workspace: s.Workspace,
+				Name:      expName,
+				Spec:      run.Spec,
+				Chain:     *run.Fallback,
+				Submitted: time.Now(),
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: couldn't save the fallback chain of %s: %v\n", experiment.ID, err)
+			} else {
+				s.Fallbacks = append(s.Fallbacks, experiment.ID)
+			}
+		}
+
+		point := sweepPoint{names: run.Names, values: run.Values}
+		if quiet {
+			fmt.Println(experiment.ID)
+		} else if len(point.names) != 0 {
+			fmt.Printf("Experiment %s (%s) submitted. See progress at %s/ex/%s\n",
+				color.BlueString(experiment.ID), point, beaker.Address(), experiment.ID)
+		} else {
+			fmt.Printf("Experiment %s submitted. See progress at %s/ex/%s\n",
+				color.BlueString(experiment.ID), beaker.Address(), experiment.ID)
+		}
+		if !quiet && len(run.LogSinks) != 0 {
+			fmt.Printf("Forward its logs to %d sink(s) with 'beaker experiment log-sinks %s --forward'\n",
+				len(run.LogSinks), experiment.ID)
+		}
+	}
+
+	if s.Group != "" {
+		created, err := beaker.CreateGroup(ctx, api.GroupSpec{
+			Workspace:   s.Workspace,
+			Name:        s.Group,
+			Experiments: s.Created,
+		})
+		if err != nil {
+			return err
+		}
+		s.Group = ""
+		if !quiet {
+			fmt.Printf("Group %s created with %d experiments\n", color.BlueString(created.Ref()), len(s.Created))
+		}
+	}
+	return nil
+}
+
+// printWatchHint tells how to watch the created experiments when nothing is
+// watching them.
+func (s *submission) printWatchHint() {
+	if quiet || len(s.Created) == 0 {
+		return
+	}
+	if len(s.Notify) != 0 {
+		fmt.Printf("Notifications are only sent while experiments are watched; run 'beaker notification watch %s'\n",
+			strings.Join(s.Created, " "))
+	} else if len(s.Fallbacks) != 0 {
+		fmt.Printf("Experiments only fall back while they're watched; run 'beaker experiment fallback %s'\n",
+			strings.Join(s.Fallbacks, " "))
+	}
+}
+
+// isUnreachable returns whether an error means Beaker couldn't be reached,
+// as opposed to Beaker rejecting a request.
+func isUnreachable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr api.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// spoolDir returns the directory where submissions are spooled.
+func spoolDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".beaker", "spool"), nil
+}
+
+// writeSpool saves a submission to the spool, assigning it an ID if it has none.
+func writeSpool(s *submission) error {
+	dir, err := spoolDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if s.ID == "" {
+		s.Spooled = time.Now()
+		s.ID = s.Spooled.UTC().Format("20060102-150405.000")
+	}
+
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	// Write atomically so an interrupted write can't lose a submission.
+	tmp := filepath.Join(dir, s.ID+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, s.ID+".json"))
+}
+
+// readSpool returns spooled submissions, oldest first.
+func readSpool() ([]*submission, error) {
+	dir, err := spoolDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var spooled []*submission
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var s submission
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, fmt.Errorf("invalid spooled submission %s: %w", file, err)
+		}
+		spooled = append(spooled, &s)
+	}
+	sort.Slice(spooled, func(i, j int) bool {
+		return spooled[i].Spooled.Before(spooled[j].Spooled)
+	})
+	return spooled, nil
+}
+
+// removeSpool deletes a submission from the spool.
+func removeSpool(id string) error {
+	if strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid submission ID %q", id)
+	}
+	dir, err := spoolDir()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, id+".json"))
+}
+
+func newSpoolCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spool <command>",
+		Short: "Manage experiment submissions queued while Beaker was unreachable",
+		Long: `Manage experiment submissions queued while Beaker was unreachable
+
+'beaker experiment create --spool' saves a submission here instead of failing
+if Beaker can't be reached. Submit spooled experiments with 'beaker spool flush'.`,
+	}
+	cmd.AddCommand(newSpoolFlushCommand())
+	cmd.AddCommand(newSpoolListCommand())
+	cmd.AddCommand(newSpoolRemoveCommand())
+	return cmd
+}
+
+func newSpoolFlushCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "flush",
+		Short: "Submit spooled experiments",
+		Long: `Submit spooled experiments, oldest first.
+
+Specs are checked as with 'beaker experiment validate' unless they were spooled
+with --skip-verify. Submissions which fail are kept, along with their progress,
+so flushing again resumes them. Flushing stops if Beaker is still unreachable.
+Submissions spooled for another Beaker address are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spooled, err := readSpool()
+			if err != nil {
+				return err
+			}
+			if len(spooled) == 0 {
+				if !quiet {
+					fmt.Println("Nothing to submit.")
+				}
+				return nil
+			}
+
+			var failed int
+			for _, s := range spooled {
+				if s.Address != beaker.Address() {
+					fmt.Fprintf(os.Stderr, "Skipping %s; it was spooled for %s\n", s.ID, s.Address)
+					continue
+				}
+				if !quiet {
+					fmt.Printf("Submitting %s from %s\n", color.BlueString(s.ID), s.Source)
+				}
+
+				err := flushSubmission(s)
+				if err == nil {
+					s.printWatchHint()
+					if err := removeSpool(s.ID); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := writeSpool(s); err != nil {
+					return err
+				}
+				if isUnreachable(err) {
+					return fmt.Errorf("still can't reach Beaker; %s and later submissions remain spooled: %w", s.ID, err)
+				}
+				fmt.Fprintf(os.Stderr, "%s couldn't submit %s: %v\n", color.RedString("Error:"), s.ID, err)
+				failed++
+			}
+			if failed != 0 {
+				return fmt.Errorf("%d submission(s) failed and remain spooled", failed)
+			}
+			return nil
+		},
+	}
+}
+
+// flushSubmission checks and submits a spooled submission.
+func flushSubmission(s *submission) error {
+	workspace, err := resolveWorkspace(s.Workspace, api.Write)
+	if err != nil {
+		return err
+	}
+	s.Workspace = workspace
+
+	if s.Verify {
+		problems, err := validateRuns(s.sweepRuns(), s.Workspace)
+		if err != nil {
+			return err
+		}
+		if problems != 0 {
+			return fmt.Errorf("spec has %d problem(s); nothing was created", problems)
+		}
+		s.Verify = false
+	}
+	return s.submit()
+}
+
+func newSpoolListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List spooled submissions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spooled, err := readSpool()
+			if err != nil {
+				return err
+			}
+			return printSubmissions(spooled)
+		},
+	}
+}
+
+func newSpoolRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <submission>",
+		Short: "Discard a spooled submission without submitting it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := removeSpool(args[0]); err != nil {
+				if os.IsNotExist(err) {
+					return fmt.Errorf("no spooled submission %q", args[0])
+				}
+				return err
+			}
+			if !quiet {
+				fmt.Printf("Removed %s\n", color.BlueString(args[0]))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Port on which SSH servers listen within session containers. It's reachable
+// from the session's node at the container's address.
+const sessionSSHPort = 2222
+
+// sshSetupScript starts an SSH server in a container, installing OpenSSH first
+// if the image doesn't include it. Running it again while the server is up has
+// no effect. It's run as root and configured by environment variables.
+const sshSetupScript = `set -e
+pidfile=/run/beaker-sshd.pid
+if [ -f "$pidfile" ] && kill -0 "$(cat "$pidfile")" 2>/dev/null; then
+	exit 0
+fi
+
+if ! command -v sshd >/dev/null 2>&1 && [ ! -x /usr/sbin/sshd ]; then
+	if command -v apt-get >/dev/null 2>&1; then
+		export DEBIAN_FRONTEND=noninteractive
+		apt-get update -qq >/dev/null
+		apt-get install -y -qq openssh-server >/dev/null
+	elif command -v yum >/dev/null 2>&1; then
+		yum install -y -q openssh-server >/dev/null
+	elif command -v apk >/dev/null 2>&1; then
+		apk add -q openssh-server >/dev/null
+	else
+		echo "openssh-server isn't installed and there's no package manager to install it" >&2
+		exit 1
+	fi
+fi
+
+# sshd refuses users without an entry in /etc/passwd.
+if ! grep -q "^[^:]*:[^:]*:$SSH_UID:" /etc/passwd; then
+	echo "$SSH_USER:x:$SSH_UID:$SSH_GID::$SSH_HOME:/bin/bash" >> /etc/passwd
+fi
+
+mkdir -p /run/sshd /etc/ssh/beaker
+printf '%s\n' "$SSH_KEYS" > /etc/ssh/beaker/authorized_keys
+chmod 644 /etc/ssh/beaker/authorized_keys
+ssh-keygen -A >/dev/null
+
+sshd=$(command -v sshd || echo /usr/sbin/sshd)
+"$sshd" -p "$SSH_PORT" \
+	-o PidFile="$pidfile" \
+	-o PasswordAuthentication=no \
+	-o PermitRootLogin=no \
+	-o AuthorizedKeysFile=/etc/ssh/beaker/authorized_keys
+`
+
+// readSSHKeys returns public keys which may log in to a session. If keyFile
+// is empty, the invoking user's authorized keys and default public keys are
+// used, so any key which can reach the node can also reach the session.
+func readSSHKeys(keyFile string) (string, error) {
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	var keys []string
+	for _, name := range []string{"authorized_keys", "id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+		b, err := ioutil.ReadFile(filepath.Join(home, ".ssh", name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if key := strings.TrimSpace(string(b)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no SSH keys found in %s; use --ssh-key", filepath.Join(home, ".ssh"))
+	}
+	return strings.Join(keys, "\n"), nil
+}
+
+// startSessionSSH starts an SSH server in a session's container which accepts
+// the given keys for the invoking user.
+func startSessionSSH(containerName string, keys string) error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	client, err := newContainerClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	exec, err := client.ContainerExecCreate(ctx, containerName, types.ExecConfig{
+		User:         "root",
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", sshSetupScript},
+		Env: []string{
+			"SSH_USER=" + u.Username,
+			"SSH_UID=" + u.Uid,
+			"SSH_GID=" + u.Gid,
+			"SSH_HOME=" + u.HomeDir,
+			"SSH_KEYS=" + keys,
+			fmt.Sprintf("SSH_PORT=%d", sessionSSHPort),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, resp.Reader); err != nil {
+		return err
+	}
+
+	result, err := client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("couldn't start SSH server: %s", strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// printSSHInstructions prints an SSH config entry which reaches a session's
+// container by jumping through its node.
+func printSSHInstructions(sessionID, nodeHostname, address string) error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	host := "beaker-" + strings.ToLower(sessionID)
+	fmt.Printf(`To connect with SSH or a remote IDE, add this to ~/.ssh/config on your machine:
+
+    Host %s
+        HostName %s
+        Port %d
+        User %s
+        ProxyJump %s
+
+Then run "ssh %s" or select %s in your IDE.
+`, host, address, sessionSSHPort, u.Username, nodeHostname, host, host)
+	return nil
+}
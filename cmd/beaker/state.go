@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// statePath returns where local state of a kind, such as an experiment's log
+// sinks, is kept by ID. Unlike the cache, state is kept in ~/.beaker and isn't
+// removed by 'beaker cache clear'.
+func statePath(kind, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid ID %q", id)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".beaker", kind, id+".json"), nil
+}
+
+// readState reads state into v. It returns false if there's none.
+func readState(kind, id string, v interface{}) (bool, error) {
+	filePath, err := statePath(kind, id)
+	if err != nil {
+		return false, err
+	}
+	b, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("invalid state %s: %w", filePath, err)
+	}
+	return true, nil
+}
+
+// writeState stores state, replacing any already stored with the same ID.
+func writeState(kind, id string, v interface{}) error {
+	filePath, err := statePath(kind, id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// Write atomically so an interrupted write can't lose state.
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Label containing the execution ID on containers the executor creates.
+const executionContainerLabel = "beaker.org/execution"
+
+// containerStats is a sample of a container's resource usage.
+type containerStats struct {
+	Time        time.Time  `json:"time"`
+	CPUPercent  float64    `json:"cpuPercent"`
+	RSS         int64      `json:"rss"`
+	MemoryLimit int64      `json:"memoryLimit,omitempty"`
+	GPUs        []gpuStats `json:"gpus,omitempty"`
+}
+
+// gpuStats is a sample of a GPU's usage.
+type gpuStats struct {
+	ID          string  `json:"id"`
+	Utilization float64 `json:"utilization"`
+	MemoryUsed  int64   `json:"memoryUsed"`
+	MemoryTotal int64   `json:"memoryTotal"`
+}
+
+// statsTarget identifies the container of a session or execution.
+type statsTarget struct {
+	kind  string // "session" or "execution"
+	id    string
+	node  string
+	label string
+	gpus  []string // Indices or UUIDs of assigned GPUs.
+}
+
+// streamContainerStats samples a container's resource usage about once a
+// second until it stops or, if stream is false, once. Docker has to be
+// reachable, so it must run on the container's node.
+func streamContainerStats(target statsTarget, stream bool, handle func(containerStats) error) error {
+	if node, err := getCurrentNode(); err == nil && target.node != "" && node != target.node {
+		return fmt.Errorf("%s %s is running on node %s; run this command there", target.kind, target.id, target.node)
+	}
+	if len(target.gpus) != 0 {
+		if _, err := exec.LookPath("nvidia-smi"); err != nil {
+			return fmt.Errorf("nvidia-smi is required to report GPU usage: %w", err)
+		}
+	}
+
+	client, err := newContainerClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", target.label+"="+target.id)),
+	})
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no running container found for %s %s", target.kind, target.id)
+	}
+
+	resp, err := client.ContainerStats(ctx, containers[0].ID, stream)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var raw types.StatsJSON
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// The first streamed sample has nothing to measure CPU usage against.
+		if raw.PreRead.IsZero() && stream {
+			continue
+		}
+
+		stats := containerStats{
+			Time:        raw.Read,
+			CPUPercent:  cpuPercent(&raw),
+			RSS:         int64(rss(&raw.MemoryStats)),
+			MemoryLimit: int64(raw.MemoryStats.Limit),
+		}
+		if len(target.gpus) != 0 {
+			if stats.GPUs, err = queryGPUStats(target.gpus); err != nil {
+				return err
+			}
+		}
+		if err := handle(stats); err != nil {
+			return err
+		}
+		if !stream {
+			return nil
+		}
+	}
+}
+
+// cpuPercent computes CPU usage in the same way as 'docker stats', where 100%
+// is one CPU fully used.
+func cpuPercent(stats *types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// rss returns a container's resident memory, excluding the page cache.
+func rss(stats *types.MemoryStats) uint64 {
+	if rss, ok := stats.Stats["rss"]; ok { // cgroup v1
+		return rss
+	}
+	if anon, ok := stats.Stats["anon"]; ok { // cgroup v2
+		return anon
+	}
+	if cache := stats.Stats["cache"]; cache < stats.Usage {
+		return stats.Usage - cache
+	}
+	return stats.Usage
+}
+
+// queryGPUStats reads the usage of GPUs, given by index or UUID, from nvidia-smi.
+func queryGPUStats(ids []string) ([]gpuStats, error) {
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=index,uuid,utilization.gpu,memory.used,memory.total",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query GPUs: %w", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var gpus []gpuStats
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		index, uuid := fields[0], fields[1]
+		if !wanted[index] && !wanted[uuid] {
+			continue
+		}
+
+		// Values may be "[N/A]" on GPUs which don't report them.
+		utilization, _ := strconv.ParseFloat(fields[2], 64)
+		used, _ := strconv.ParseInt(fields[3], 10, 64)
+		total, _ := strconv.ParseInt(fields[4], 10, 64)
+		gpus = append(gpus, gpuStats{
+			ID:          index,
+			Utilization: utilization,
+			MemoryUsed:  used << 20, // nvidia-smi reports MiB.
+			MemoryTotal: total << 20,
+		})
+	}
+	return gpus, scanner.Err()
+}
+
+// Widths of the stats table's columns when streamed, so rows printed one at a
+// time line up: TIME, CPU %, RSS, MEMORY LIMIT, and GPU %.
+var streamedStatsWidths = []int{8, 7, 12, 12, 5}
+
+// printStreamedStats returns a handler which prints each sample of a stream
+// as it arrives.
+func printStreamedStats() func(containerStats) error {
+	tableOut.minWidths = streamedStatsWidths
+	var printed bool
+	return func(stats containerStats) error {
+		if format == formatNDJSON {
+			return ndjsonOut.Encode(stats)
+		}
+		if err := printContainerStats(stats, !printed); err != nil {
+			return err
+		}
+		printed = true
+		return tableOut.Flush()
+	}
+}
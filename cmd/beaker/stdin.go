@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// stdinLine is a line read from stdin, or the error which ended reading.
+type stdinLine struct {
+	text string
+	err  error
+}
+
+// stdin reads lines on behalf of prompts. Reads can't be interrupted, so a
+// prompt which stops waiting, such as when a browser delivers a token, leaves
+// its read running; the line it reads goes to the next prompt instead of being
+// lost, and no more than one read is ever outstanding.
+var stdin struct {
+	once      sync.Once
+	mu        sync.Mutex
+	requested bool
+	requests  chan struct{}
+	lines     chan stdinLine
+}
+
+// stdinLines returns a channel which receives the next line of stdin, with
+// its line break.
+func stdinLines() <-chan stdinLine {
+	stdin.once.Do(func() {
+		stdin.requests = make(chan struct{}, 1)
+		stdin.lines = make(chan stdinLine, 1)
+		go func() {
+			reader := bufio.NewReader(os.Stdin)
+			for range stdin.requests {
+				text, err := reader.ReadString('\n')
+				stdin.mu.Lock()
+				stdin.lines <- stdinLine{text, err}
+				stdin.requested = false
+				stdin.mu.Unlock()
+			}
+		}()
+	})
+
+	stdin.mu.Lock()
+	defer stdin.mu.Unlock()
+	if !stdin.requested && len(stdin.lines) == 0 {
+		stdin.requested = true
+		stdin.requests <- struct{}{}
+	}
+	return stdin.lines
+}
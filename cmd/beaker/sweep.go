@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/allenai/beaker/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// sweepPoint is a single combination of values from a parameter sweep.
+type sweepPoint struct {
+	// Parameter names in the order they were declared.
+	names []string
+
+	values map[string]string
+}
+
+func (p sweepPoint) String() string {
+	pairs := make([]string, len(p.names))
+	for i, name := range p.names {
+		pairs[i] = name + "=" + p.values[name]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sweepRun is a fully rendered experiment spec for one point of a sweep.
+type sweepRun struct {
+	Point sweepPoint
+	Spec  []byte
+}
+
+// expandSpec renders an experiment spec template and expands its optional
+// "sweep" section into the cross-product of all parameters. Each parameter is
+// exposed to the template as {{.Sweep.<name>}} and injected into every task as
+// an environment variable of the same name.
+//
+// Template arguments, such as those passed with --arg, are exposed as
+// {{.Args.<name>}} along with sweep parameters. Every argument the template
+// uses must be given, and every argument given must be used.
+//
+// By default each point in the sweep becomes its own experiment. If mergeTasks
+// is set, all points are combined into a single experiment whose task names
+// are suffixed with the index of the point that produced them.
+func expandSpec(text string, args map[string]string, mergeTasks bool) ([]sweepRun, error) {
+	specTemplate, err := template.New("spec").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := renderSpec(specTemplate, args, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse spec")
+	}
+	points, err := parseSweep(documentRoot(&doc))
+	if err != nil {
+		return nil, err
+	}
+	var sweepNames []string
+	if points != nil {
+		sweepNames = points[0].names
+	}
+	if err := checkTemplateArgs(specTemplate, args, sweepNames); err != nil {
+		return nil, err
+	}
+	if points == nil {
+		return []sweepRun{{Spec: spec}}, nil
+	}
+
+	var runs []sweepRun
+	var docs []*yaml.Node
+	for _, point := range points {
+		spec, err := renderSpec(specTemplate, args, point.values)
+		if err != nil {
+			return nil, err
+		}
+
+		root, err := applySweep(spec, point)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "sweep %s", point)
+		}
+		if mergeTasks {
+			docs = append(docs, root)
+			continue
+		}
+
+		if spec, err = yaml.Marshal(root); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		runs = append(runs, sweepRun{Point: point, Spec: spec})
+	}
+
+	if mergeTasks {
+		spec, err := yaml.Marshal(mergeSweepTasks(docs))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		runs = []sweepRun{{Spec: spec}}
+	}
+	return runs, nil
+}
+
+// renderSpec executes an experiment spec template.
+func renderSpec(specTemplate *template.Template, args, sweep map[string]string) ([]byte, error) {
+	envVars := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		envVars[parts[0]] = parts[1]
+	}
+
+	type templateParams struct {
+		Args  map[string]string
+		Env   map[string]string
+		Sweep map[string]string
+	}
+	buf := &bytes.Buffer{}
+	if err := specTemplate.Execute(buf, templateParams{
+		Args:  mergeTemplateArgs(args, sweep),
+		Env:   envVars,
+		Sweep: sweep,
+	}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseTemplateArgs parses template arguments written as name=value.
+func parseTemplateArgs(pairs []string) (map[string]string, error) {
+	args := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid argument %q; must be written as name=value", pair)
+		}
+		if _, ok := args[parts[0]]; ok {
+			return nil, errors.Errorf("argument %q is given more than once", parts[0])
+		}
+		args[parts[0]] = parts[1]
+	}
+	return args, nil
+}
+
+// mergeTemplateArgs combines template arguments with a point's sweep
+// parameters, which may not share names.
+func mergeTemplateArgs(args, sweep map[string]string) map[string]string {
+	merged := make(map[string]string, len(args)+len(sweep))
+	for name, value := range args {
+		merged[name] = value
+	}
+	for name, value := range sweep {
+		merged[name] = value
+	}
+	return merged
+}
+
+// checkTemplateArgs returns an error if a spec template uses arguments which
+// weren't given, or if arguments were given which it doesn't use.
+func checkTemplateArgs(specTemplate *template.Template, args map[string]string, sweepNames []string) error {
+	swept := make(map[string]bool, len(sweepNames))
+	for _, name := range sweepNames {
+		if _, ok := args[name]; ok {
+			return errors.Errorf("argument %q is also a sweep parameter", name)
+		}
+		swept[name] = true
+	}
+
+	used, usesAll := templateArgs(specTemplate)
+	var missing, unused []string
+	for name := range used {
+		if _, ok := args[name]; !ok && !swept[name] {
+			missing = append(missing, name)
+		}
+	}
+	for name := range args {
+		if !used[name] && !usesAll {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unused)
+
+	switch {
+	case len(missing) != 0:
+		return errors.Errorf("spec uses arguments which weren't given: %s; pass them with --arg <name>=<value>",
+			strings.Join(missing, ", "))
+	case len(unused) != 0:
+		return errors.Errorf("arguments aren't used by the spec: %s", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// templateArgs returns the names of arguments a template refers to, as in
+// {{.Args.lr}}, {{$.Args.lr}}, or {{index .Args "lr"}}. It also returns
+// whether the template uses .Args as a whole, such as in a range. Templates it
+// defines are included.
+func templateArgs(t *template.Template) (map[string]bool, bool) {
+	used := map[string]bool{}
+	var usesAll bool
+	visitArgs := func(idents []string) {
+		switch {
+		case len(idents) == 0 || idents[0] != "Args":
+		case len(idents) == 1:
+			usesAll = true
+		default:
+			used[idents[1]] = true
+		}
+	}
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			// {{index .Args "name"}} uses a single argument.
+			if len(n.Args) >= 3 {
+				ident, isIdent := n.Args[0].(*parse.IdentifierNode)
+				field, isField := n.Args[1].(*parse.FieldNode)
+				key, isString := n.Args[2].(*parse.StringNode)
+				if isIdent && ident.Ident == "index" && isField && isString &&
+					len(field.Ident) == 1 && field.Ident[0] == "Args" {
+					used[key.Text] = true
+					for _, arg := range n.Args[3:] {
+						walk(arg)
+					}
+					return
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			visitArgs(n.Ident)
+		case *parse.VariableNode:
+			if len(n.Ident) != 0 && n.Ident[0] == "$" {
+				visitArgs(n.Ident[1:])
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		}
+	}
+	for _, defined := range t.Templates() {
+		if defined.Tree != nil {
+			walk(defined.Tree.Root)
+		}
+	}
+	return used, usesAll
+}
+
+// parseSweep returns the cross-product of a spec's sweep parameters, or nil if
+// the spec has no sweep. Earlier parameters vary slowest.
+func parseSweep(root *yaml.Node) ([]sweepPoint, error) {
+	sweep := config.MappingValue(root, "sweep")
+	if sweep == nil {
+		return nil, nil
+	}
+	if sweep.Kind != yaml.MappingNode || len(sweep.Content) == 0 {
+		return nil, errors.New("sweep must map parameter names to lists of values")
+	}
+
+	points := []sweepPoint{{values: map[string]string{}}}
+	for i := 0; i < len(sweep.Content); i += 2 {
+		name, values := sweep.Content[i].Value, sweep.Content[i+1]
+		if values.Kind != yaml.SequenceNode || len(values.Content) == 0 {
+			return nil, errors.Errorf("sweep parameter %q must be a non-empty list", name)
+		}
+
+		var next []sweepPoint
+		for _, point := range points {
+			for _, value := range values.Content {
+				if value.Kind != yaml.ScalarNode {
+					return nil, errors.Errorf("sweep parameter %q must only contain scalar values", name)
+				}
+
+				p := sweepPoint{
+					names:  append(append([]string{}, point.names...), name),
+					values: map[string]string{name: value.Value},
+				}
+				for k, v := range point.values {
+					p.values[k] = v
+				}
+				next = append(next, p)
+			}
+		}
+		points = next
+	}
+	return points, nil
+}
+
+// applySweep parses a rendered spec, removes its sweep section, and sets each
+// parameter as an environment variable on every task.
+func applySweep(spec []byte, point sweepPoint) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse spec")
+	}
+	root := documentRoot(&doc)
+	deleteMappingKey(root, "sweep")
+
+	tasks := config.MappingValue(root, "tasks")
+	if tasks == nil || tasks.Kind != yaml.SequenceNode {
+		return nil, errors.New("spec must contain a list of tasks")
+	}
+
+	v2 := strings.HasPrefix(scalarValue(config.MappingValue(root, "version")), "v2")
+	for _, task := range tasks.Content {
+		if task.Kind != yaml.MappingNode {
+			return nil, errors.New("tasks must be objects")
+		}
+
+		for _, name := range point.names {
+			value := point.values[name]
+			if v2 {
+				setTaskEnvV2(task, name, value)
+			} else {
+				setTaskEnvV1(task, name, value)
+			}
+		}
+	}
+	return root, nil
+}
+
+// setTaskEnvV1 sets an environment variable in a v1 task's "spec.env" map.
+func setTaskEnvV1(task *yaml.Node, name, value string) {
+	spec := config.MappingValue(task, "spec")
+	if spec == nil {
+		spec = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(task, "spec", spec)
+	}
+	env := config.MappingValue(spec, "env")
+	if env == nil || env.Kind != yaml.MappingNode {
+		env = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(spec, "env", env)
+	}
+	setMappingValue(env, name, stringNode(value))
+}
+
+// setTaskEnvV2 sets an environment variable in a v2 task's "envVars" list.
+func setTaskEnvV2(task *yaml.Node, name, value string) {
+	envVars := config.MappingValue(task, "envVars")
+	if envVars == nil || envVars.Kind != yaml.SequenceNode {
+		envVars = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(task, "envVars", envVars)
+	}
+	for _, envVar := range envVars.Content {
+		if scalarValue(config.MappingValue(envVar, "name")) == name {
+			deleteMappingKey(envVar, "secret")
+			setMappingValue(envVar, "value", stringNode(value))
+			return
+		}
+	}
+
+	envVar := &yaml.Node{Kind: yaml.MappingNode}
+	setMappingValue(envVar, "name", stringNode(name))
+	setMappingValue(envVar, "value", stringNode(value))
+	envVars.Content = append(envVars.Content, envVar)
+}
+
+// mergeSweepTasks combines the tasks of several expanded specs into the first
+// spec. Named tasks are suffixed with the index of their spec, and references
+// between tasks of the same spec are renamed to match.
+func mergeSweepTasks(docs []*yaml.Node) *yaml.Node {
+	var merged []*yaml.Node
+	for i, doc := range docs {
+		suffix := fmt.Sprintf("-%d", i)
+		tasks := config.MappingValue(doc, "tasks")
+		for _, task := range tasks.Content {
+			rename := func(node *yaml.Node) {
+				if node != nil && node.Value != "" {
+					node.Value += suffix
+				}
+			}
+
+			rename(config.MappingValue(task, "name"))
+
+			// v1 dependencies
+			if deps := config.MappingValue(task, "dependsOn"); deps != nil {
+				for _, dep := range deps.Content {
+					rename(config.MappingValue(dep, "parentName"))
+				}
+			}
+
+			// v2 dependencies
+			if datasets := config.MappingValue(task, "datasets"); datasets != nil {
+				for _, dataset := range datasets.Content {
+					rename(config.MappingValue(config.MappingValue(dataset, "source"), "result"))
+				}
+			}
+		}
+		merged = append(merged, tasks.Content...)
+	}
+
+	config.MappingValue(docs[0], "tasks").Content = merged
+	return docs[0]
+}
+
+// documentRoot returns the top-level node of a parsed YAML document.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) != 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// setMappingValue sets or replaces the value for a key in a YAML mapping.
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, stringNode(key), value)
+}
+
+// deleteMappingKey removes a key from a YAML mapping, if present.
+func deleteMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+// stringNode creates a YAML node which is always interpreted as a string.
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
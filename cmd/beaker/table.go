@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/moby/term"
+)
+
+const (
+	// Spaces between table columns.
+	tablePadding = 2
+
+	// Columns aren't truncated narrower than this to fit the terminal.
+	minColumnWidth = 12
+
+	// Marks the end of a truncated cell.
+	ellipsis = "…"
+)
+
+// tableWriter aligns rows of cells into columns. Rows are buffered until
+// Flush, when columns are sized to fit their widest cell.
+//
+// Tables which start with Header show a chosen set of columns: those given by
+// the columns field if set, else the configured default for the table's
+// resource, else the resource's built-in default, else all columns.
+//
+// If maxWidth is set and the table is wider, the widest columns are narrowed
+// one at a time until it fits or every column is down to minColumnWidth.
+// Cells too long for their column keep their beginning and end with an
+// ellipsis, so the same value is always shortened the same way.
+type tableWriter struct {
+	out      io.Writer
+	maxWidth int // No limit if zero.
+	rows     [][]string
+
+	// Columns to show, by name, and the configured defaults by resource,
+	// both as comma-separated lists.
+	columns        string
+	columnDefaults map[string]string
+
+	// Whether to omit headers, and whether to show only the ID column.
+	noHeader bool
+	idsOnly  bool
+
+	// Indices of the columns shown in the current table, or nil for all.
+	selected []int
+
+	// Minimum width of each column. Tables flushed a row at a time stay
+	// aligned as long as their cells fit.
+	minWidths []int
+}
+
+func newTableWriter(out io.Writer) *tableWriter {
+	return &tableWriter{out: out}
+}
+
+// Header starts a table of a resource, such as "experiments", and chooses
+// which of its columns to show. Unknown column names are an error.
+func (t *tableWriter) Header(resource string, cells ...string) error {
+	t.selected = nil
+	names := make([]string, len(cells))
+	for i, cell := range cells {
+		names[i] = columnName(cell)
+	}
+
+	if t.idsOnly {
+		for i, name := range names {
+			if name == "id" {
+				t.selected = []int{i}
+				return nil
+			}
+		}
+		t.selected = []int{0}
+		return nil
+	}
+
+	spec := t.columns
+	if spec == "" {
+		spec = t.columnDefaults[resource]
+	}
+	if spec == "" {
+		spec = defaultColumns[resource]
+	}
+	if spec != "" {
+		for _, want := range strings.Split(spec, ",") {
+			i, err := findColumn(names, columnName(want))
+			if err != nil {
+				return err
+			}
+			t.selected = append(t.selected, i)
+		}
+	}
+	if !t.noHeader {
+		t.Row(cells...)
+	}
+	return nil
+}
+
+// columnName normalizes a column heading or requested column, e.g.
+// "GPU COUNT" to "gpu-count".
+func columnName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, "_", " ")), "-"))
+}
+
+// findColumn returns the index of a column by name or unambiguous prefix.
+func findColumn(names []string, want string) (int, error) {
+	if want == "" {
+		return 0, fmt.Errorf("unknown column %q; columns are %s", want, strings.Join(names, ", "))
+	}
+	match := -1
+	for i, name := range names {
+		if name == want {
+			return i, nil
+		}
+		if strings.HasPrefix(name, want) {
+			if match != -1 {
+				return 0, fmt.Errorf("column %q is ambiguous; columns are %s", want, strings.Join(names, ", "))
+			}
+			match = i
+		}
+	}
+	if match == -1 {
+		return 0, fmt.Errorf("unknown column %q; columns are %s", want, strings.Join(names, ", "))
+	}
+	return match, nil
+}
+
+// Row buffers a row of cells.
+func (t *tableWriter) Row(cells ...string) {
+	if t.selected != nil {
+		selected := make([]string, len(t.selected))
+		for i, column := range t.selected {
+			if column < len(cells) {
+				selected[i] = cells[column]
+			}
+		}
+		cells = selected
+	}
+	t.rows = append(t.rows, cells)
+}
+
+// Flush writes all buffered rows and ends the current table.
+func (t *tableWriter) Flush() error {
+	rows := t.rows
+	t.rows = nil
+	t.selected = nil
+	widths := t.columnWidths(rows)
+
+	var line strings.Builder
+	for _, row := range rows {
+		line.Reset()
+		for i, cell := range row {
+			cell = ellipsize(cell, widths[i])
+			line.WriteString(cell)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+tablePadding))
+			}
+		}
+		if _, err := fmt.Fprintln(t.out, line.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnWidths sizes each column to its widest cell or minimum width, then
+// narrows the widest
+// columns until the table fits in maxWidth.
+func (t *tableWriter) columnWidths(rows [][]string) []int {
+	widths := append([]int(nil), t.minWidths...)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if t.maxWidth == 0 || len(widths) == 0 {
+		return widths
+	}
+
+	total := tablePadding * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	for total > t.maxWidth {
+		// Narrow the rightmost of the widest columns, since identifying
+		// columns such as IDs tend to come first.
+		widest := -1
+		for i, width := range widths {
+			if width > minColumnWidth && (widest == -1 || width >= widths[widest]) {
+				widest = i
+			}
+		}
+		if widest == -1 {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// ellipsize shortens s to width characters by replacing its middle with an
+// ellipsis. Both ends are kept since names often differ only by a suffix.
+func ellipsize(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width <= 1 {
+		return ellipsis
+	}
+	head := (width - 1) / 2
+	tail := width - 1 - head
+	return string(runes[:head]) + ellipsis + string(runes[len(runes)-tail:])
+}
+
+// terminalWidth returns the width of the terminal on STDOUT, or zero if
+// STDOUT isn't a terminal. The COLUMNS environment variable overrides the
+// terminal's own width.
+func terminalWidth() int {
+	fd, isTerminal := term.GetFdInfo(os.Stdout)
+	if !isTerminal {
+		return 0
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	size, err := term.GetWinsize(fd)
+	if err != nil {
+		return 0
+	}
+	return int(size.Width)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTableColumns(t *testing.T) {
+	header := []string{"ID", "WORKSPACE", "AUTHOR", "CREATED", "STATUS", "GPU COUNT", "GPU TYPE"}
+	row := []string{"ex1", "ai2/test", "alice", "1h ago", "running", "2", "a100"}
+
+	tests := []struct {
+		name     string
+		resource string
+		columns  string
+		defaults map[string]string
+		noHeader bool
+		idsOnly  bool
+		want     string
+		err      string
+	}{
+		{
+			name:     "all columns",
+			resource: "other",
+			want: "ID   WORKSPACE  AUTHOR  CREATED  STATUS   GPU COUNT  GPU TYPE\n" +
+				"ex1  ai2/test   alice   1h ago   running  2          a100\n",
+		},
+		{
+			name:     "built-in default",
+			resource: "experiments",
+			want:     "ID   WORKSPACE  AUTHOR  CREATED  STATUS\nex1  ai2/test   alice   1h ago   running\n",
+		},
+		{
+			name:     "configured default",
+			resource: "experiments",
+			defaults: map[string]string{"experiments": "author,id"},
+			want:     "AUTHOR  ID\nalice   ex1\n",
+		},
+		{
+			name:     "flag overrides defaults",
+			resource: "experiments",
+			columns:  "gpu-count",
+			defaults: map[string]string{"experiments": "author,id"},
+			want:     "GPU COUNT\n2\n",
+		},
+		{
+			name:     "unambiguous prefix",
+			resource: "other",
+			columns:  "work,gpu-t",
+			want:     "WORKSPACE  GPU TYPE\nai2/test   a100\n",
+		},
+		{
+			name:     "underscores and case",
+			resource: "other",
+			columns:  "GPU_COUNT",
+			want:     "GPU COUNT\n2\n",
+		},
+		{
+			name:     "no header",
+			resource: "other",
+			columns:  "id,author",
+			noHeader: true,
+			want:     "ex1  alice\n",
+		},
+		{
+			name:     "ids only",
+			resource: "other",
+			columns:  "author",
+			idsOnly:  true,
+			want:     "ex1\n",
+		},
+		{
+			name:     "ambiguous prefix",
+			resource: "other",
+			columns:  "gpu",
+			err:      `column "gpu" is ambiguous`,
+		},
+		{
+			name:     "unknown column",
+			resource: "other",
+			columns:  "cost",
+			err:      `unknown column "cost"`,
+		},
+		{
+			name:     "empty column",
+			resource: "other",
+			columns:  "id,",
+			err:      `unknown column ""`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			table := newTableWriter(&out)
+			table.columns = test.columns
+			table.columnDefaults = test.defaults
+			table.noHeader = test.noHeader
+			table.idsOnly = test.idsOnly
+
+			err := table.Header(test.resource, header...)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			table.Row(row...)
+			if err := table.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.want, out.String())
+			}
+		})
+	}
+}
+
+func TestTableMinWidths(t *testing.T) {
+	var out bytes.Buffer
+	table := newTableWriter(&out)
+	table.minWidths = []int{4, 6}
+
+	// Rows flushed one at a time stay aligned.
+	for _, row := range [][]string{{"A", "B", "C"}, {"AAA", "BBBBB", "C"}} {
+		table.Row(row...)
+		if err := table.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "A     B       C\nAAA   BBBBB   C\n"
+	if out.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
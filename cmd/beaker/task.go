@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beaker/client/api"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Environment variable set by the executor to identify a running task's execution.
+	executionIDEnv = "BEAKER_EXECUTION_ID"
+
+	// Path within a result dataset where 'task record-env' records the
+	// environment in which an execution ran.
+	taskEnvironmentPath = ".beaker/environment.json"
+
+	// Path within a result dataset where a task records metric series, one
+	// point per line of JSON.
+	metricSeriesPath = ".beaker/metrics.jsonl"
+)
+
+// taskEnvironment describes the host and image on which an execution ran.
+type taskEnvironment struct {
+	Execution string `json:"execution"`
+	Node      string `json:"node,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+
+	// Recorded by the task. Beaker images are recorded by ID, which unlike
+	// a name always refers to the same image.
+	DriverVersion string   `json:"driverVersion,omitempty"`
+	CUDAVersion   string   `json:"cudaVersion,omitempty"`
+	GPUModels     []string `json:"gpuModels,omitempty"`
+	Image         string   `json:"image,omitempty"`
+}
+
+// metricPoint is one value in a series of metrics recorded by a task.
+type metricPoint struct {
+	Name  string    `json:"name"`
+	Value float64   `json:"value"`
+	Step  *int64    `json:"step,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func newTaskCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "task <command>",
+		Short: "Manage tasks",
+	}
+	cmd.AddCommand(newTaskAnnotateCommand())
+	cmd.AddCommand(newTaskEnvCommand())
+	cmd.AddCommand(newTaskMetricsCommand())
+	cmd.AddCommand(newTaskPeekCommand())
+	cmd.AddCommand(newTaskRecordEnvCommand())
+	cmd.AddCommand(newTaskStatsCommand())
+	return cmd
+}
+
+func newTaskAnnotateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "annotate",
+		Short: "Report progress from within a running task",
+		Long: `Report progress from within a running task.
+
+The annotation is attached to the task's current execution and is shown
+alongside its status. When run inside a task, the execution is read from the
+` + executionIDEnv + ` environment variable.`,
+		Args: cobra.NoArgs,
+	}
+
+	var execution string
+	var progress float64
+	var note string
+	cmd.Flags().StringVar(&execution, "execution", "", "Execution to annotate. Defaults to $"+executionIDEnv)
+	cmd.Flags().Float64Var(&progress, "progress", 0, "Fraction of work completed, from 0 to 1")
+	cmd.Flags().StringVar(&note, "note", "", "Short human-readable note, e.g. \"epoch 12/30\"")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if execution == "" {
+			if execution = os.Getenv(executionIDEnv); execution == "" {
+				return fmt.Errorf("not running in a Beaker task; use --execution flag")
+			}
+		}
+
+		var parts []string
+		if cmd.Flag("progress").Changed {
+			if progress < 0 || progress > 1 {
+				return fmt.Errorf("progress must be between 0 and 1")
+			}
+			parts = append(parts, fmt.Sprintf("%.0f%%", progress*100))
+		}
+		if note != "" {
+			parts = append(parts, note)
+		}
+		if len(parts) == 0 {
+			return fmt.Errorf("nothing to annotate; use --progress or --note")
+		}
+
+		message := strings.Join(parts, " ")
+		return beaker.Execution(execution).PostStatus(ctx, api.ExecStatusUpdate{
+			Message: &message,
+		})
+	}
+	return cmd
+}
+
+func newTaskEnvCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "env <task>",
+		Short: "Display the environment in which a task's latest execution ran",
+		Long: `Display the environment in which a task's latest execution ran.
+
+The driver and CUDA versions, GPU models, and image are shown as recorded in
+` + taskEnvironmentPath + ` within the result dataset by 'beaker task
+record-env'. Without a record, only what Beaker reports is shown.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			task, err := beaker.Task(args[0]).Get(ctx)
+			if err != nil {
+				return err
+			}
+			if len(task.Executions) == 0 {
+				return fmt.Errorf("task %s has no executions", task.ID)
+			}
+			execution := task.Executions[len(task.Executions)-1]
+
+			env := taskEnvironment{Execution: execution.ID, Node: execution.Node}
+			if execution.Node != "" {
+				node, err := beaker.Node(execution.Node).Get(ctx)
+				if err != nil {
+					return err
+				}
+				env.Hostname = node.Hostname
+			}
+
+			recorded, err := readTaskEnvironment(execution.Result.Beaker)
+			if err != nil {
+				return err
+			}
+			if recorded != nil {
+				recorded.Execution, recorded.Node, recorded.Hostname = env.Execution, env.Node, env.Hostname
+				env = *recorded
+			} else {
+				// Fall back to what the execution's spec tells us.
+				if execution.Spec.Image.Beaker != "" {
+					env.Image = "beaker://" + execution.Spec.Image.Beaker
+				} else if execution.Spec.Image.Docker != "" {
+					env.Image = "docker://" + execution.Spec.Image.Docker
+				}
+				if !quiet {
+					fmt.Fprintln(os.Stderr, color.YellowString("Warning:"),
+						"no environment was recorded for this execution")
+				}
+			}
+			return printTaskEnvironment(env)
+		},
+	}
+}
+
+func newTaskRecordEnvCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "record-env <result-dir>",
+		Short: "Record the environment of a running task in its results",
+		Long: `Record the environment of a running task in its results.
+
+Run this within a task, e.g. before its main command, passing the task's result
+directory. The node's NVIDIA driver and CUDA versions, GPU models, and the
+task's image are written to ` + taskEnvironmentPath + ` in that directory, from
+where 'beaker task env' shows them. The execution is read from the
+` + executionIDEnv + ` environment variable.`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var execution string
+	cmd.Flags().StringVar(&execution, "execution", "", "Execution to record. Defaults to $"+executionIDEnv)
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if execution == "" {
+			if execution = os.Getenv(executionIDEnv); execution == "" {
+				return fmt.Errorf("not running in a Beaker task; use --execution flag")
+			}
+		}
+
+		env, err := recordTaskEnvironment(execution)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(args[0], filepath.FromSlash(taskEnvironmentPath))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filename, b); err != nil {
+			return err
+		}
+		if !quiet {
+			fmt.Println("Recorded environment in", filename)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// Matches the CUDA version in nvidia-smi's summary.
+var cudaVersionPattern = regexp.MustCompile(`CUDA Version:\s*([0-9.]+)`)
+
+// recordTaskEnvironment describes the environment of a running execution. GPU
+// details are left empty if nvidia-smi isn't available.
+func recordTaskEnvironment(executionID string) (*taskEnvironment, error) {
+	execution, err := beaker.Execution(executionID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &taskEnvironment{Execution: execution.ID}
+	if ref := execution.Spec.Image.Beaker; ref != "" {
+		image, err := beaker.Image(ref).Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		env.Image = "beaker://" + image.ID
+	} else if tag := execution.Spec.Image.Docker; tag != "" {
+		env.Image = "docker://" + tag
+	}
+
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return env, nil
+	}
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=driver_version,name", "--format=csv,noheader").Output()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query GPUs: %w", commandError(err))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, ",", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		env.DriverVersion = strings.TrimSpace(fields[0])
+		env.GPUModels = append(env.GPUModels, strings.TrimSpace(fields[1]))
+	}
+
+	// The CUDA version is only shown in the summary.
+	if out, err := exec.CommandContext(ctx, "nvidia-smi").Output(); err == nil {
+		if m := cudaVersionPattern.FindSubmatch(out); m != nil {
+			env.CUDAVersion = string(m[1])
+		}
+	}
+	return env, nil
+}
+
+// readTaskEnvironment reads the environment recorded in a result dataset.
+// Returns nil if nothing was recorded.
+func readTaskEnvironment(dataset string) (*taskEnvironment, error) {
+	if dataset == "" {
+		return nil, nil
+	}
+
+	storage, _, err := beaker.Dataset(dataset).Storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := storage.ReadFile(ctx, taskEnvironmentPath)
+	if err == fileheap.ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var env taskEnvironment
+	if err := json.NewDecoder(r).Decode(&env); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", taskEnvironmentPath, err)
+	}
+	return &env, nil
+}
+
+func newTaskMetricsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "metrics <task>",
+		Short: "Display metric series recorded by a task",
+		Long: `Display metric series recorded by a task's latest execution.
+
+Tasks record points by appending lines of JSON to ` + metricSeriesPath + `
+within their result directory, for example:
+
+    {"name": "loss", "value": 0.42, "step": 1000, "time": "2021-07-01T12:00:00Z"}
+
+Step is optional. Points are shown in the order they were recorded. Use
+--format csv or tsv to export them, e.g. to plot learning curves.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{delimitedFormats: ""},
+	}
+
+	var series []string
+	cmd.Flags().StringSliceVar(&series, "series", nil, "Only show these metrics, may be repeated or comma-separated")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		task, err := beaker.Task(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		if len(task.Executions) == 0 {
+			return fmt.Errorf("task %s has no executions", task.ID)
+		}
+		execution := task.Executions[len(task.Executions)-1]
+
+		points, err := readMetricSeries(execution.Result.Beaker)
+		if err != nil {
+			return err
+		}
+		if len(series) != 0 {
+			include := make(map[string]bool, len(series))
+			for _, name := range series {
+				include[name] = true
+			}
+			var filtered []metricPoint
+			for _, point := range points {
+				if include[point.Name] {
+					filtered = append(filtered, point)
+				}
+			}
+			points = filtered
+		}
+		return printMetricSeries(points)
+	}
+	return cmd
+}
+
+// readMetricSeries reads the metric points recorded in a result dataset.
+func readMetricSeries(dataset string) ([]metricPoint, error) {
+	if dataset == "" {
+		return nil, nil
+	}
+
+	storage, _, err := beaker.Dataset(dataset).Storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := storage.ReadFile(ctx, metricSeriesPath)
+	if err == fileheap.ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var points []metricPoint
+	dec := json.NewDecoder(r)
+	for {
+		var point metricPoint
+		err := dec.Decode(&point)
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", metricSeriesPath, err)
+		}
+		points = append(points, point)
+	}
+}
+
+func newTaskPeekCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peek <task> <file>",
+		Short: "Print a file from a task's results while it runs",
+		Long: `Print a file from a task's results while it runs.
+
+Files under a task's live outputs path are periodically synced to its result
+dataset before the task completes, so logs and checkpoints can be inspected
+early. With --follow, new data is printed as it's synced until the task's
+latest execution finishes.`,
+		Args: cobra.ExactArgs(2),
+	}
+
+	var follow bool
+	var interval time.Duration
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Print new data as it's synced")
+	cmd.Flags().DurationVar(&interval, "interval", 10*time.Second, "How often to check for new data with --follow")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		task, err := beaker.Task(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		if len(task.Executions) == 0 {
+			return fmt.Errorf("task %s has no executions", task.ID)
+		}
+		execution := task.Executions[len(task.Executions)-1]
+		if execution.Result.Beaker == "" {
+			return fmt.Errorf("execution %s has no result dataset", execution.ID)
+		}
+
+		storage, _, err := beaker.Dataset(execution.Result.Beaker).Storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		var offset int64
+		for {
+			// Check whether the execution finished before reading so that
+			// data synced just before it finished is still printed.
+			done := execution.State.Finalized != nil
+			if follow && !done {
+				info, err := beaker.Execution(execution.ID).Get(ctx)
+				if err != nil {
+					return err
+				}
+				done = info.State.Finalized != nil
+			}
+
+			offset, err = peekFile(storage, args[1], offset)
+			if err == fileheap.ErrFileNotFound && follow && !done {
+				// The file may not have been synced yet.
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			if !follow || done {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+	return cmd
+}
+
+func newTaskStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats <task>",
+		Short: "Stream resource usage of a task's running execution",
+		Long: `Stream CPU, memory, and GPU usage of the container running a task's
+latest execution, about once a second, until it stops.
+
+Usage is read from Docker and nvidia-smi, so this must run on the execution's
+node. CPU usage is as in 'docker stats', where 100% is one CPU fully used.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
+	}
+
+	var noStream bool
+	cmd.Flags().BoolVar(&noStream, "no-stream", false, "Print one sample and exit")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if !noStream && (format == formatJSON || format == formatYAML) {
+			return fmt.Errorf("format %q requires --no-stream; use %q to stream", format, formatNDJSON)
+		}
+
+		task, err := beaker.Task(args[0]).Get(ctx)
+		if err != nil {
+			return err
+		}
+		if len(task.Executions) == 0 {
+			return fmt.Errorf("task %s has no executions", task.ID)
+		}
+		execution := task.Executions[len(task.Executions)-1]
+		if execution.State.Started == nil || execution.State.Exited != nil || execution.State.Finalized != nil {
+			return fmt.Errorf("execution %s is not running", execution.ID)
+		}
+
+		return streamContainerStats(statsTarget{
+			kind:  "execution",
+			id:    execution.ID,
+			node:  execution.Node,
+			label: executionContainerLabel,
+			gpus:  execution.Limits.GPUs,
+		}, !noStream, printStreamedStats())
+	}
+	return cmd
+}
+
+// peekFile prints a file from a dataset starting at offset and returns the
+// offset of the end of the file. If the file has shrunk, it's assumed to have
+// been rewritten and is printed from the start.
+func peekFile(storage *fileheap.DatasetRef, filePath string, offset int64) (int64, error) {
+	info, err := storage.FileInfo(ctx, filePath)
+	if err != nil {
+		return offset, err
+	}
+
+	if info.Size < offset {
+		offset = 0
+	}
+	if info.Size == offset {
+		return offset, nil
+	}
+
+	r, err := storage.ReadFileRange(ctx, filePath, offset, info.Size-offset)
+	if err != nil {
+		return offset, err
+	}
+	defer r.Close()
+
+	n, err := io.Copy(os.Stdout, r)
+	return offset + n, err
+}
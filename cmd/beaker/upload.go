@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/beaker/client/api"
+	fileheapAPI "github.com/beaker/fileheap/api"
+	"github.com/beaker/fileheap/async"
+	"github.com/beaker/fileheap/cli"
+	fileheap "github.com/beaker/fileheap/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Number of largest files to include in an upload estimate.
+	largestFileCount = 5
+
+	// Size of each file uploaded to measure bandwidth. One file is uploaded
+	// per concurrent upload to match the throughput of a real upload.
+	probeFileSize = 4 << 20
+)
+
+// uploadEstimate describes the files which an upload would transfer.
+type uploadEstimate struct {
+	Files   int64          `json:"files"`
+	Bytes   int64          `json:"bytes"`
+	Largest []uploadedFile `json:"largest"`
+
+	// Paths which won't be uploaded because they aren't regular files.
+	Ignored []string `json:"ignored,omitempty"`
+
+	// Measured upload bandwidth and the expected duration at that rate.
+	BytesPerSecond float64       `json:"bytesPerSecond"`
+	Duration       time.Duration `json:"duration"`
+}
+
+type uploadedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// scanUpload walks a file or directory as an upload would.
+func scanUpload(source string) (*uploadEstimate, error) {
+	var estimate uploadEstimate
+	var files []uploadedFile
+	err := filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(source, filePath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			relPath = info.Name()
+		}
+
+		if !info.Mode().IsRegular() {
+			estimate.Ignored = append(estimate.Ignored, relPath)
+			return nil
+		}
+		estimate.Files++
+		estimate.Bytes += info.Size()
+		files = append(files, uploadedFile{Path: relPath, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > largestFileCount {
+		files = files[:largestFileCount]
+	}
+	estimate.Largest = files
+	return &estimate, nil
+}
+
+// measureUploadRate uploads a small throwaway dataset to a workspace and
+// returns the observed bandwidth in bytes per second.
+func measureUploadRate(workspace string, concurrency int) (float64, error) {
+	dir, err := ioutil.TempDir("", "beaker-probe-")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each file gets its own random content so that storage can't
+	// deduplicate them and make the upload look faster than it is.
+	probe := make([]byte, probeFileSize)
+	for i := 0; i < concurrency; i++ {
+		if _, err := rand.Read(probe); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		name := filepath.Join(dir, fmt.Sprintf("probe-%d", i))
+		if err := ioutil.WriteFile(name, probe, 0644); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	dataset, err := beaker.CreateDataset(ctx, api.DatasetSpec{
+		Description: "Temporary dataset for measuring upload bandwidth",
+		Workspace:   workspace,
+		FileHeap:    true,
+	}, "")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = dataset.Delete(ctx) }()
+
+	storage, _, err := dataset.Storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if err := cli.Upload(ctx, dir, storage, "", cli.NoTracker, concurrency); err != nil {
+		return 0, err
+	}
+	return float64(probeFileSize*concurrency) / time.Since(start).Seconds(), nil
+}
+
+// syncPlan lists the changes needed to make a dataset match a local directory.
+type syncPlan struct {
+	Upload    []uploadedFile `json:"upload"`
+	Delete    []string       `json:"delete"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// planSync compares the files under source to a dataset's manifest. Files are
+// assumed unchanged if their size matches and they haven't been modified since
+// they were uploaded; otherwise they're compared by digest. With checksum,
+// every file of matching size is compared by digest.
+func planSync(source string, manifest []fileheapAPI.FileInfo, checksum bool) (*syncPlan, error) {
+	remote := make(map[string]*fileheapAPI.FileInfo, len(manifest))
+	for i := range manifest {
+		remote[manifest[i].Path] = &manifest[i]
+	}
+
+	var plan syncPlan
+	local := make(map[string]bool)
+	err := filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(source, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		local[relPath] = true
+
+		file := uploadedFile{Path: relPath, Size: info.Size()}
+		remoteInfo, ok := remote[relPath]
+		switch {
+		case !ok || remoteInfo.Size != info.Size():
+			plan.Upload = append(plan.Upload, file)
+			return nil
+		case !checksum && !info.ModTime().After(remoteInfo.Updated):
+			plan.Unchanged++
+			return nil
+		}
+
+		unchanged, err := fileMatchesDigest(filePath, remoteInfo)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			plan.Unchanged++
+		} else {
+			plan.Upload = append(plan.Upload, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, info := range manifest {
+		if !local[info.Path] {
+			plan.Delete = append(plan.Delete, info.Path)
+		}
+	}
+	return &plan, nil
+}
+
+// manifestSummary identifies the contents of a dataset.
+type manifestSummary struct {
+	Files int64
+	Bytes int64
+
+	// SHA-256 of every file's path, size, and digest, in path order.
+	Digest string
+}
+
+func summarizeManifest(manifest []fileheapAPI.FileInfo) *manifestSummary {
+	sorted := append([]fileheapAPI.FileInfo(nil), manifest...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	var summary manifestSummary
+	hash := sha256.New()
+	for _, info := range sorted {
+		summary.Files++
+		summary.Bytes += info.Size
+		fmt.Fprintf(hash, "%s\t%d\t%s\n", info.Path, info.Size, base64.StdEncoding.EncodeToString(info.Digest))
+	}
+	summary.Digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	return &summary
+}
+
+// matches returns whether a dataset has the same number and size of files as
+// a local upload.
+func (s *manifestSummary) matches(local *uploadEstimate) bool {
+	return local != nil && s.Files == local.Files && s.Bytes == local.Bytes
+}
+
+// uploadFiles uploads files from a local directory to the same paths within a
+// dataset, replacing any existing files.
+func uploadFiles(
+	storage *fileheap.DatasetRef,
+	source string,
+	files []uploadedFile,
+	tracker cli.ProgressTracker,
+	concurrency int,
+) error {
+	if concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	asyncErr := async.Error{}
+	limiter := async.NewLimiter(concurrency)
+	upload := func(batch *fileheap.UploadBatch) {
+		length := int64(batch.Length())
+		size := batch.Size()
+		tracker.Update(&cli.ProgressUpdate{FilesPending: length, BytesPending: size})
+
+		if err := batch.Upload(ctx); err != nil {
+			tracker.Update(&cli.ProgressUpdate{FilesPending: -length, BytesPending: -size})
+			asyncErr.Report(err)
+			cancel()
+			return
+		}
+
+		tracker.Update(&cli.ProgressUpdate{
+			FilesWritten: length,
+			FilesPending: -length,
+			BytesWritten: size,
+			BytesPending: -size,
+		})
+	}
+
+	batch := storage.NewUploadBatch()
+	for _, file := range files {
+		if err := asyncErr.Err(); err != nil {
+			break
+		}
+		if !batch.HasCapacity(file.Size) {
+			full := batch
+			limiter.Go(func() { upload(full) })
+			batch = storage.NewUploadBatch()
+		}
+
+		localPath := filepath.Join(source, filepath.FromSlash(file.Path))
+		var reader io.Reader
+		if file.Size < fileheapAPI.PutFileSizeLimit {
+			// Read small files into memory so that at most concurrency files
+			// are open at once.
+			buf, err := ioutil.ReadFile(localPath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			reader = bytes.NewReader(buf)
+		} else {
+			f, err := os.Open(localPath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			reader = f
+		}
+		if err := batch.AddFile(file.Path, reader, file.Size); err != nil {
+			return err
+		}
+	}
+	limiter.Go(func() { upload(batch) })
+	limiter.Wait()
+	if err := asyncErr.Err(); err != nil {
+		return err
+	}
+	return tracker.Close()
+}
+
+// deleteFiles deletes files from a dataset.
+func deleteFiles(storage *fileheap.DatasetRef, paths []string) error {
+	batch := storage.NewDeleteBatch()
+	for _, p := range paths {
+		if !batch.HasCapacity() {
+			if err := batch.Delete(ctx); err != nil {
+				return err
+			}
+			batch = storage.NewDeleteBatch()
+		}
+		if err := batch.AddFile(p); err != nil {
+			return err
+		}
+	}
+	return batch.Delete(ctx)
+}
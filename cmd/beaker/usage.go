@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beaker/client/api"
+	"github.com/beaker/client/client"
+	"github.com/spf13/cobra"
+)
+
+// Time windows by which usage can be broken down.
+const (
+	windowNone  = "none"
+	windowDay   = "day"
+	windowWeek  = "week"
+	windowMonth = "month"
+)
+
+// usageRow is the usage attributed to one key within one time window.
+type usageRow struct {
+	Key       string     `json:"key"`
+	Window    *time.Time `json:"window,omitempty"`
+	GPUHours  float64    `json:"gpuHours"`
+	NodeHours float64    `json:"nodeHours"`
+
+	// Cost is estimated in USD from each cluster's node cost. It's only set if
+	// some usage was on a cluster with a known cost.
+	Cost *float64 `json:"cost,omitempty"`
+}
+
+// usageOptions are the flags shared by all usage commands.
+type usageOptions struct {
+	since  string
+	until  string
+	window string
+}
+
+func newUsageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "usage <command>",
+		Short: "Report compute usage and cost",
+		Long: `Report compute usage and cost
+
+Usage is computed from the executions of every experiment in an account's
+workspaces, plus sessions on the account's clusters. An execution's node-hours
+are its share of a node's GPUs, or of its CPUs if the node has no GPUs. Cost
+is estimated from node-hours for clusters which have a node cost, such as
+cloud clusters.`,
+	}
+	cmd.AddCommand(newUsageCommandBy("users", "user", func(u usageRecord) string { return u.user }))
+	cmd.AddCommand(newUsageCommandBy("workspaces", "workspace", func(u usageRecord) string { return u.workspace }))
+	cmd.AddCommand(newUsageCommandBy("clusters", "cluster", func(u usageRecord) string { return u.cluster }))
+	return cmd
+}
+
+func newUsageCommandBy(use, noun string, key func(usageRecord) string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         use + " [account]",
+		Short:       fmt.Sprintf("Report usage by %s", noun),
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{delimitedFormats: ""},
+	}
+
+	var opts usageOptions
+	cmd.Flags().StringVar(&opts.since, "since", "720h",
+		"Start of the reporting period as a date, RFC 3339 time, or duration ago")
+	cmd.Flags().StringVar(&opts.until, "until", "",
+		"End of the reporting period as a date, RFC 3339 time, or duration ago (default now)")
+	cmd.Flags().StringVar(&opts.window, "window", windowNone,
+		"Break usage down by time window: none, day, week, or month")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		now := time.Now()
+		since, err := parseTimeFlag(opts.since, now)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		until := now
+		if opts.until != "" {
+			if until, err = parseTimeFlag(opts.until, now); err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+		}
+		if !since.Before(until) {
+			return fmt.Errorf("--since must be before --until")
+		}
+		switch opts.window {
+		case windowNone, windowDay, windowWeek, windowMonth:
+		default:
+			return fmt.Errorf("invalid --window %q; must be one of none, day, week, or month", opts.window)
+		}
+
+		var account string
+		switch {
+		case len(args) != 0:
+			account = args[0]
+		case beakerConfig.DefaultOrg != "":
+			account = beakerConfig.DefaultOrg
+		default:
+			user, err := beaker.WhoAmI(ctx)
+			if err != nil {
+				return err
+			}
+			account = user.Name
+		}
+
+		records, err := collectUsage(account, since, until)
+		if err != nil {
+			return err
+		}
+		return printUsage(noun, summarizeUsage(records, key, opts.window))
+	}
+	return cmd
+}
+
+// parseTimeFlag parses a time given as a date, an RFC 3339 time, or a duration
+// before now. Durations may use a "d" suffix for days.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q isn't a date, time, or duration", value)
+	}
+	return now.Add(-d), nil
+}
+
+// usageRecord is the usage of one execution or session, clipped to the
+// reporting period.
+type usageRecord struct {
+	user      string
+	workspace string
+	cluster   string
+	start     time.Time
+	end       time.Time
+
+	gpus int
+
+	// Share of a node occupied, from 0 to 1.
+	nodeShare float64
+
+	// Node cost in USD per hour, if known.
+	nodeCost *float64
+}
+
+// usageClusters caches cluster lookups while collecting usage.
+type usageClusters map[string]*api.Cluster
+
+func (c usageClusters) get(ref string) *api.Cluster {
+	if cluster, ok := c[ref]; ok {
+		return cluster
+	}
+	// Usage on clusters which can't be read is still counted, but without cost.
+	cluster, err := beaker.Cluster(ref).Get(ctx)
+	if err != nil {
+		cluster = nil
+	}
+	c[ref] = cluster
+	return cluster
+}
+
+// collectUsage gathers usage of executions in an account's workspaces and
+// sessions on the account's clusters which overlaps the given period.
+func collectUsage(account string, since, until time.Time) ([]usageRecord, error) {
+	clusters := usageClusters{}
+	var records []usageRecord
+	add := func(user, workspace, cluster string, state api.ExecutionState, limits *api.ResourceLimits) {
+		start, end, ok := usageInterval(state, since, until)
+		if !ok {
+			return
+		}
+		record := usageRecord{
+			user:      user,
+			workspace: workspace,
+			cluster:   cluster,
+			start:     start,
+			end:       end,
+			nodeShare: 1,
+		}
+		if limits != nil {
+			record.gpus = len(limits.GPUs)
+		}
+		if c := clusters.get(cluster); c != nil {
+			record.nodeShare = nodeShare(c, limits)
+			if c.NodeCost != nil {
+				cost, _ := c.NodeCost.Float64()
+				record.nodeCost = &cost
+			}
+		}
+		records = append(records, record)
+	}
+
+	var workspaces []api.Workspace
+	var cursor string
+	for {
+		page, next, err := beaker.ListWorkspaces(ctx, account, &client.ListWorkspaceOptions{
+			Cursor: cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, page...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	for _, workspace := range workspaces {
+		cursor = ""
+		for {
+			experiments, next, err := beaker.Workspace(workspace.FullName).Experiments(ctx,
+				&client.ListExperimentOptions{Cursor: cursor})
+			if err != nil {
+				return nil, fmt.Errorf("couldn't list experiments in %s: %w", workspace.FullName, err)
+			}
+			for _, experiment := range experiments {
+				for _, execution := range experiment.Executions {
+					add(execution.Author.Name, workspace.FullName, execution.Spec.Context.Cluster,
+						execution.State, &execution.Limits)
+				}
+			}
+			if cursor = next; cursor == "" {
+				break
+			}
+		}
+	}
+
+	sessions, err := beaker.ListSessions(ctx, &client.ListSessionOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.Account != account {
+			continue
+		}
+		add(session.Author.Name, "", session.Cluster, session.State, session.Limits)
+	}
+	return records, nil
+}
+
+// usageInterval returns the part of a workload's run time within the given
+// period. Workloads which are still running are counted up to now.
+func usageInterval(state api.ExecutionState, since, until time.Time) (time.Time, time.Time, bool) {
+	if state.Started == nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	start := *state.Started
+	end := time.Now()
+	switch {
+	case state.Exited != nil:
+		end = *state.Exited
+	case state.Finalized != nil:
+		end = *state.Finalized
+	}
+
+	if start.Before(since) {
+		start = since
+	}
+	if end.After(until) {
+		end = until
+	}
+	return start, end, start.Before(end)
+}
+
+// nodeShare returns the share of a node used by a workload with the given
+// limits: its share of the node's GPUs, or of its CPUs if the node has none.
+func nodeShare(cluster *api.Cluster, limits *api.ResourceLimits) float64 {
+	shape := cluster.NodeShape
+	if shape == nil {
+		shape = &cluster.NodeSpec
+	}
+	if limits == nil {
+		return 1
+	}
+
+	var share float64
+	switch {
+	case shape.GPUCount != 0:
+		share = float64(len(limits.GPUs)) / float64(shape.GPUCount)
+	case shape.CPUCount != 0 && limits.CPUCount != 0:
+		share = limits.CPUCount / shape.CPUCount
+	default:
+		return 1
+	}
+	if share > 1 {
+		share = 1
+	}
+	return share
+}
+
+// summarizeUsage totals usage by key and time window. Rows are sorted by
+// window, then by descending GPU-hours.
+func summarizeUsage(records []usageRecord, key func(usageRecord) string, window string) []usageRow {
+	type rowKey struct {
+		key    string
+		window time.Time
+	}
+	rows := map[rowKey]*usageRow{}
+
+	for _, record := range records {
+		k := key(record)
+		if k == "" {
+			k = "-"
+		}
+		for _, span := range splitByWindow(record.start, record.end, window) {
+			id := rowKey{key: k, window: span.window}
+			row, ok := rows[id]
+			if !ok {
+				row = &usageRow{Key: k}
+				if window != windowNone {
+					w := span.window
+					row.Window = &w
+				}
+				rows[id] = row
+			}
+
+			hours := span.end.Sub(span.start).Hours()
+			nodeHours := hours * record.nodeShare
+			row.GPUHours += hours * float64(record.gpus)
+			row.NodeHours += nodeHours
+			if record.nodeCost != nil {
+				cost := nodeHours * *record.nodeCost
+				if row.Cost != nil {
+					cost += *row.Cost
+				}
+				row.Cost = &cost
+			}
+		}
+	}
+
+	result := make([]usageRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Window != nil && !result[i].Window.Equal(*result[j].Window) {
+			return result[i].Window.Before(*result[j].Window)
+		}
+		if result[i].GPUHours != result[j].GPUHours {
+			return result[i].GPUHours > result[j].GPUHours
+		}
+		if result[i].NodeHours != result[j].NodeHours {
+			return result[i].NodeHours > result[j].NodeHours
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+type windowSpan struct {
+	window     time.Time
+	start, end time.Time
+}
+
+// splitByWindow splits an interval at the boundaries of calendar days, weeks
+// (starting Monday), or months in local time.
+func splitByWindow(start, end time.Time, window string) []windowSpan {
+	if window == windowNone {
+		return []windowSpan{{start: start, end: end}}
+	}
+
+	var spans []windowSpan
+	for w := windowStart(start, window); w.Before(end); {
+		next := nextWindow(w, window)
+		span := windowSpan{window: w, start: w, end: next}
+		if span.start.Before(start) {
+			span.start = start
+		}
+		if span.end.After(end) {
+			span.end = end
+		}
+		spans = append(spans, span)
+		w = next
+	}
+	return spans
+}
+
+func windowStart(t time.Time, window string) time.Time {
+	t = t.Local()
+	year, month, day := t.Date()
+	switch window {
+	case windowWeek:
+		// Go weeks start on Sunday; these start on Monday.
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, time.Local)
+	case windowMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	}
+}
+
+func nextWindow(t time.Time, window string) time.Time {
+	switch window {
+	case windowWeek:
+		return t.AddDate(0, 0, 7)
+	case windowMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/allenai/bytefmt"
+	"github.com/beaker/client/api"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// specRef is a reference from a spec to another object, along with where it
+// appears in the spec, such as "tasks[0].datasets[1].source.beaker".
+type specRef struct {
+	Path string
+	Ref  string
+}
+
+// resultsSpec holds result commit policies and named results, which Beaker
+// doesn't support. It's parsed so specs using it can be rejected.
+type resultsSpec struct {
+	Commit string      `yaml:"commit"`
+	Mounts []yaml.Node `yaml:"mounts"`
+}
+
+// validateTask describes the parts of a task which refer to other objects,
+// normalized across spec versions.
+type validateTask struct {
+	Name       string
+	Path       string
+	Image      specRef // Beaker image; Docker images aren't resolved.
+	HasImage   bool
+	ResultPath string
+	HasTimeout bool
+	Datasets   []specRef // Beaker datasets.
+	Results    []specRef // Names of tasks whose results are used.
+	Secrets    []specRef
+	Cluster    specRef
+	Requests   *api.ResourceRequest
+
+	// Image of either kind, written as "beaker://<image>" or "docker://<tag>".
+	ImageURL specRef
+
+	// Scratch space requested by the task, if any, and its path in the spec.
+	Scratch     *scratchSpec
+	ScratchPath string
+
+	// Cloud identity attached to the task, if any, and its path in the spec.
+	Identity     *identitySpec
+	IdentityPath string
+
+	// Result commit policy and named results, if set, and their path in the
+	// spec.
+	ResultOptions     *resultsSpec
+	ResultOptionsPath string
+
+	// Labels required of the task's node, and their path in the spec.
+	Constraints     map[string]string
+	ConstraintsPath string
+
+	// Set for spec versions in which every task must name a cluster.
+	RequireCluster bool
+}
+
+// specExtensions holds the fields of a spec's tasks which this CLI adds to the
+// client's spec types. In v1 specs, all but constraints are under spec, and
+// timeouts may be set in either place.
+type specExtensions struct {
+	Tasks []taskExtensions `yaml:"tasks"`
+}
+
+type taskExtensions struct {
+	Scratch     *scratchSpec      `yaml:"scratch"`
+	Identity    *identitySpec     `yaml:"identity"`
+	Results     *resultsSpec      `yaml:"results"`
+	Constraints map[string]string `yaml:"constraints"`
+	Timeout     *yaml.Node        `yaml:"timeout"`
+	Spec        struct {
+		Scratch  *scratchSpec  `yaml:"scratch"`
+		Identity *identitySpec `yaml:"identity"`
+		Results  *resultsSpec  `yaml:"results"`
+		Timeout  *yaml.Node    `yaml:"timeout"`
+	} `yaml:"spec"`
+}
+
+// parseValidateTasks parses a rendered v1 or v2 spec.
+func parseValidateTasks(spec []byte) ([]validateTask, error) {
+	var header struct {
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(spec, &header); err != nil {
+		return nil, errors.Wrap(err, "failed to parse spec")
+	}
+
+	// Scratch space, identities, commit policies, and constraints aren't part
+	// of the client's spec types, so they're read separately.
+	var extensions specExtensions
+	if err := yaml.Unmarshal(spec, &extensions); err != nil {
+		return nil, errors.Wrap(err, "failed to parse spec")
+	}
+
+	var tasks []validateTask
+	switch {
+	case header.Version == "" || header.Version == "v1":
+		var specV1 api.ExperimentSpecV1
+		if err := yaml.Unmarshal(spec, &specV1); err != nil {
+			return nil, errors.Wrap(err, "failed to parse spec")
+		}
+		for i, task := range specV1.Tasks {
+			path := fmt.Sprintf("tasks[%d]", i)
+			t := validateTask{
+				Name:       task.Name,
+				Path:       path,
+				Image:      specRef{path + ".spec.image", task.Spec.Image},
+				HasImage:   task.Spec.Image != "" || task.Spec.DockerImage != "",
+				ResultPath: task.Spec.ResultPath,
+				HasTimeout: extensions.Tasks[i].Timeout != nil || extensions.Tasks[i].Spec.Timeout != nil,
+				Cluster:    specRef{path + ".cluster", task.Cluster},
+
+				ImageURL: imageURL(path+".spec.image", task.Spec.Image, path+".spec.dockerImage", task.Spec.DockerImage),
+
+				Scratch:     extensions.Tasks[i].Spec.Scratch,
+				ScratchPath: path + ".spec.scratch",
+
+				Identity:     extensions.Tasks[i].Spec.Identity,
+				IdentityPath: path + ".spec.identity",
+
+				ResultOptions:     extensions.Tasks[i].Spec.Results,
+				ResultOptionsPath: path + ".spec.results",
+
+				Constraints:     extensions.Tasks[i].Constraints,
+				ConstraintsPath: path + ".constraints",
+			}
+			for j, mount := range task.Spec.Mounts {
+				t.Datasets = append(t.Datasets,
+					specRef{fmt.Sprintf("%s.spec.datasetMounts[%d].datasetId", path, j), mount.Dataset})
+			}
+			for j, dep := range task.DependsOn {
+				t.Results = append(t.Results,
+					specRef{fmt.Sprintf("%s.dependsOn[%d].parentName", path, j), dep.ParentName})
+			}
+
+			req := task.Spec.Requirements
+			t.Requests = &api.ResourceRequest{CPUCount: req.CPU, GPUCount: req.GPUCount}
+			if req.MemoryHuman != "" {
+				memory, err := bytefmt.Parse(req.MemoryHuman)
+				if err != nil {
+					return nil, errors.Errorf("%s.spec.requirements.memory: invalid memory %q", path, req.MemoryHuman)
+				}
+				t.Requests.Memory = memory
+			}
+			tasks = append(tasks, t)
+		}
+
+	case strings.HasPrefix(header.Version, "v2"):
+		var specV2 api.ExperimentSpecV2
+		if err := yaml.Unmarshal(spec, &specV2); err != nil {
+			return nil, errors.Wrap(err, "failed to parse spec")
+		}
+		for i, task := range specV2.Tasks {
+			path := fmt.Sprintf("tasks[%d]", i)
+			t := validateTask{
+				Name:       task.Name,
+				Path:       path,
+				Image:      specRef{path + ".image.beaker", task.Image.Beaker},
+				HasImage:   task.Image.Beaker != "" || task.Image.Docker != "",
+				ResultPath: task.Result.Path,
+				HasTimeout: extensions.Tasks[i].Timeout != nil,
+				Cluster:    specRef{path + ".context.cluster", task.Context.Cluster},
+				Requests:   task.Resources,
+
+				ImageURL: imageURL(path+".image.beaker", task.Image.Beaker, path+".image.docker", task.Image.Docker),
+
+				Scratch:     extensions.Tasks[i].Scratch,
+				ScratchPath: path + ".scratch",
+
+				Identity:     extensions.Tasks[i].Identity,
+				IdentityPath: path + ".identity",
+
+				ResultOptions:     extensions.Tasks[i].Results,
+				ResultOptionsPath: path + ".results",
+
+				Constraints:     extensions.Tasks[i].Constraints,
+				ConstraintsPath: path + ".constraints",
+
+				RequireCluster: true,
+			}
+			for j, mount := range task.Datasets {
+				source := fmt.Sprintf("%s.datasets[%d].source", path, j)
+				if mount.Source.Beaker != "" {
+					t.Datasets = append(t.Datasets, specRef{source + ".beaker", mount.Source.Beaker})
+				}
+				if mount.Source.Result != "" {
+					t.Results = append(t.Results, specRef{source + ".result", mount.Source.Result})
+				}
+				if mount.Source.Secret != "" {
+					t.Secrets = append(t.Secrets, specRef{source + ".secret", mount.Source.Secret})
+				}
+			}
+			for j, env := range task.EnvVars {
+				if env.Secret != "" {
+					t.Secrets = append(t.Secrets,
+						specRef{fmt.Sprintf("%s.envVars[%d].secret", path, j), env.Secret})
+				}
+			}
+			tasks = append(tasks, t)
+		}
+
+	default:
+		return nil, errors.Errorf("unsupported spec version %q", header.Version)
+	}
+
+	if len(tasks) == 0 {
+		return nil, errors.New("spec must contain at least one task")
+	}
+	for i := range tasks {
+		if tasks[i].Name == "" {
+			tasks[i].Name = "#" + strconv.Itoa(i+1)
+		}
+	}
+	return tasks, nil
+}
+
+// imageURL returns a task's image as a URL with the path of whichever of its
+// Beaker or Docker image is set.
+func imageURL(beakerPath, beakerImage, dockerPath, dockerImage string) specRef {
+	switch {
+	case beakerImage != "":
+		return specRef{beakerPath, "beaker://" + beakerImage}
+	case dockerImage != "":
+		return specRef{dockerPath, "docker://" + dockerImage}
+	}
+	return specRef{}
+}
+
+// specValidator checks that objects referenced by specs exist and are
+// accessible. Lookups are cached since sweeps often repeat references.
+type specValidator struct {
+	// Workspace in which secrets are resolved. Secrets aren't checked if unset.
+	workspace string
+
+	images      map[string]error
+	datasets    map[string]error
+	secrets     map[string]error
+	clusters    map[string]*api.Cluster
+	clusterErrs map[string]error
+}
+
+func newSpecValidator(workspace string) *specValidator {
+	return &specValidator{
+		workspace:   workspace,
+		images:      map[string]error{},
+		datasets:    map[string]error{},
+		secrets:     map[string]error{},
+		clusters:    map[string]*api.Cluster{},
+		clusterErrs: map[string]error{},
+	}
+}
+
+// validate returns a description of each problem with a spec's tasks, each
+// prefixed with the task's name and the path of the problem within the spec.
+func (v *specValidator) validate(tasks []validateTask) []string {
+	names := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		names[task.Name] = true
+	}
+
+	var problems []string
+	report := func(task validateTask, path, format string, args ...interface{}) {
+		problems = append(problems, task.Name+": "+path+": "+fmt.Sprintf(format, args...))
+	}
+
+	seen := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if seen[task.Name] {
+			report(task, task.Path+".name", "task name is not unique")
+		}
+		seen[task.Name] = true
+
+		if !task.HasImage {
+			report(task, task.Path, "no image is set")
+		} else if task.Image.Ref != "" {
+			if err := v.checkImage(task.Image.Ref); err != nil {
+				report(task, task.Image.Path, "image %s: %v", task.Image.Ref, err)
+			}
+		}
+		if task.ResultPath == "" {
+			report(task, task.Path, "no result path is set")
+		}
+		if task.Scratch != nil {
+			// Executions run on executors outside this CLI's control, so
+			// scratch space can only be allocated for sessions.
+			report(task, task.ScratchPath, "scratch space is only supported by sessions; use 'beaker session create --scratch'")
+		}
+		if task.Identity != nil {
+			// Beaker doesn't attach identities to executions.
+			report(task, task.IdentityPath, "cloud identities are only supported by sessions; use 'beaker session create --identity'")
+		}
+		if task.ResultOptions != nil {
+			// Beaker commits results when a task finishes and doesn't keep
+			// result names.
+			if task.ResultOptions.Commit != "" {
+				report(task, task.ResultOptionsPath+".commit", "result commit policies are not supported by Beaker")
+			}
+			if len(task.ResultOptions.Mounts) != 0 {
+				report(task, task.ResultOptionsPath+".mounts", "named results are not supported by Beaker")
+			}
+		}
+
+		if len(task.Constraints) != 0 {
+			// Beaker doesn't schedule experiments by node labels yet.
+			report(task, task.ConstraintsPath, "constraints are only supported by sessions")
+		}
+
+		for _, dataset := range task.Datasets {
+			if err := v.checkDataset(dataset.Ref); err != nil {
+				report(task, dataset.Path, "dataset %s: %v", dataset.Ref, err)
+			}
+		}
+		for _, result := range task.Results {
+			if !names[result.Ref] {
+				report(task, result.Path, "uses results of unknown task %q", result.Ref)
+			}
+		}
+		for _, secret := range task.Secrets {
+			if err := v.checkSecret(secret.Ref); err != nil {
+				report(task, secret.Path, "secret %s: %v", secret.Ref, err)
+			}
+		}
+
+		if task.Cluster.Ref == "" {
+			if task.RequireCluster {
+				report(task, task.Path, "no cluster is set")
+			}
+			continue
+		}
+		cluster, err := v.getCluster(task.Cluster.Ref)
+		if err != nil {
+			report(task, task.Cluster.Path, "cluster %s: %v", task.Cluster.Ref, err)
+			continue
+		}
+		if err := checkNodeShape(cluster, task.Requests); err != nil {
+			report(task, task.Cluster.Path, "cluster %s: %v", task.Cluster.Ref, err)
+		}
+	}
+	return problems
+}
+
+func (v *specValidator) checkImage(ref string) error {
+	err, ok := v.images[ref]
+	if !ok {
+		_, err = beaker.Image(ref).Get(ctx)
+		v.images[ref] = err
+	}
+	return err
+}
+
+// checkDataset checks that a dataset exists and is committed. Executions can't
+// start until every dataset they use is committed.
+func (v *specValidator) checkDataset(ref string) error {
+	err, ok := v.datasets[ref]
+	if !ok {
+		var dataset *api.Dataset
+		if dataset, err = beaker.Dataset(ref).Get(ctx); err == nil && dataset.Committed.IsZero() {
+			err = errors.New("not committed")
+		}
+		v.datasets[ref] = err
+	}
+	return err
+}
+
+func (v *specValidator) checkSecret(name string) error {
+	if v.workspace == "" {
+		return nil
+	}
+	err, ok := v.secrets[name]
+	if !ok {
+		_, err = beaker.Workspace(v.workspace).GetSecret(ctx, name)
+		if apiErr, isAPIErr := err.(api.Error); isAPIErr && apiErr.Code == http.StatusNotFound {
+			err = fmt.Errorf("not found in workspace %s", v.workspace)
+		}
+		v.secrets[name] = err
+	}
+	return err
+}
+
+func (v *specValidator) getCluster(ref string) (*api.Cluster, error) {
+	if err, ok := v.clusterErrs[ref]; ok {
+		return nil, err
+	}
+	if cluster, ok := v.clusters[ref]; ok {
+		return cluster, nil
+	}
+
+	cluster, err := beaker.Cluster(ref).Get(ctx)
+	if err != nil {
+		v.clusterErrs[ref] = err
+		return nil, err
+	}
+	v.clusters[ref] = cluster
+	return cluster, nil
+}
+
+// checkNodeShape returns an error if a request can't fit on any node of a
+// cluster. Clusters without a known node shape accept any request.
+func checkNodeShape(cluster *api.Cluster, request *api.ResourceRequest) error {
+	if request == nil {
+		return nil
+	}
+
+	shape := cluster.NodeShape
+	if shape == nil {
+		shape = &cluster.NodeSpec
+	}
+	if shape.CPUCount == 0 && shape.GPUCount == 0 && shape.Memory == nil {
+		return nil
+	}
+
+	switch {
+	case shape.CPUCount != 0 && request.CPUCount > shape.CPUCount:
+		return fmt.Errorf("requests %v CPUs but nodes have %v", request.CPUCount, shape.CPUCount)
+	case request.GPUCount > shape.GPUCount:
+		return fmt.Errorf("requests %d GPUs but nodes have %d", request.GPUCount, shape.GPUCount)
+	case shape.Memory != nil && request.Memory != nil && request.Memory.Cmp(*shape.Memory) > 0:
+		return fmt.Errorf("requests %v memory but nodes have %v", request.Memory, shape.Memory)
+	default:
+		return nil
+	}
+}
+
+// validateRuns checks every run of an expanded spec, printing each problem.
+// Secrets are resolved in the given workspace. Returns the number of problems found.
+func validateRuns(runs []sweepRun, workspace string) (int, error) {
+	policy, err := readImagePolicy()
+	if err != nil {
+		return 0, err
+	}
+
+	v := newSpecValidator(workspace)
+	names := newPolicyNames()
+	var count int
+	for _, run := range runs {
+		tasks, err := parseValidateTasks(run.Spec)
+		if err != nil {
+			return 0, err
+		}
+
+		problems := append(v.validate(tasks), checkImagePolicy(policy, names, tasks, nil)...)
+		for _, problem := range problems {
+			count++
+			if len(run.Point.names) != 0 {
+				fmt.Printf("(%s) %s\n", run.Point, problem)
+			} else {
+				fmt.Println(problem)
+			}
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateSpecExtensions(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{
+			name: "v1 without extensions",
+			spec: `
+tasks:
+- name: train
+  spec:
+    dockerImage: ubuntu
+    resultPath: /output
+`,
+		},
+		{
+			name: "v1 extensions under spec",
+			spec: `
+tasks:
+- name: train
+  spec:
+    dockerImage: ubuntu
+    resultPath: /output
+    scratch: {size: 10GiB}
+    identity: {gcp: trainer@project.iam.gserviceaccount.com}
+    results: {commit: manual, mounts: [{name: checkpoints, path: /ckpt}]}
+  constraints: {gpu: a100}
+`,
+			want: []string{
+				"train: tasks[0].spec.scratch: scratch space is only supported by sessions; use 'beaker session create --scratch'",
+				"train: tasks[0].spec.identity: cloud identities are only supported by sessions; use 'beaker session create --identity'",
+				"train: tasks[0].spec.results.commit: result commit policies are not supported by Beaker",
+				"train: tasks[0].spec.results.mounts: named results are not supported by Beaker",
+				"train: tasks[0].constraints: constraints are only supported by sessions",
+			},
+		},
+		{
+			name: "v1 extensions beside spec are ignored",
+			spec: `
+tasks:
+- name: train
+  scratch: {size: 10GiB}
+  spec:
+    dockerImage: ubuntu
+    resultPath: /output
+`,
+		},
+		{
+			name: "v2 extensions",
+			spec: `
+version: v2
+tasks:
+- name: train
+  image: {docker: ubuntu}
+  result: {path: /output}
+  scratch: {size: 10GiB, path: /scratch}
+  identity: {aws: arn:aws:iam::123456789012:role/trainer}
+  results: {commit: manual}
+  constraints: {zone: us-west1}
+`,
+			want: []string{
+				"train: tasks[0].scratch: scratch space is only supported by sessions; use 'beaker session create --scratch'",
+				"train: tasks[0].identity: cloud identities are only supported by sessions; use 'beaker session create --identity'",
+				"train: tasks[0].results.commit: result commit policies are not supported by Beaker",
+				"train: tasks[0].constraints: constraints are only supported by sessions",
+				"train: tasks[0]: no cluster is set",
+			},
+		},
+		{
+			name: "v2 empty results",
+			spec: `
+version: v2
+tasks:
+- name: train
+  image: {docker: ubuntu}
+  result: {path: /output}
+  results: {}
+`,
+			want: []string{"train: tasks[0]: no cluster is set"},
+		},
+		{
+			name: "unnamed tasks",
+			spec: `
+tasks:
+- spec: {dockerImage: ubuntu, resultPath: /output, scratch: {size: 1GiB}}
+- spec: {dockerImage: ubuntu}
+`,
+			want: []string{
+				"#1: tasks[0].spec.scratch: scratch space is only supported by sessions; use 'beaker session create --scratch'",
+				"#2: tasks[1]: no result path is set",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tasks, err := parseValidateTasks([]byte(test.spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// No references need to be looked up, so the validator never
+			// reaches Beaker.
+			problems := newSpecValidator("").validate(tasks)
+			if !reflect.DeepEqual(problems, test.want) {
+				t.Errorf("expected problems:\n%q\ngot:\n%q", test.want, problems)
+			}
+		})
+	}
+}
+
+func TestParseValidateTasksErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{name: "no tasks", spec: "version: v2\ntasks: []\n"},
+		{name: "unsupported version", spec: "version: v3\ntasks: [{name: a}]\n"},
+		{name: "invalid memory", spec: "tasks: [{spec: {requirements: {memory: lots}}}]\n"},
+		{name: "not yaml", spec: "tasks: [\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseValidateTasks([]byte(test.spec)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
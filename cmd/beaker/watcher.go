@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Time between status checks of watched experiments.
+const defaultWatchInterval = 30 * time.Second
+
+// watchExperiments checks experiments every interval until nothing is left to
+// do for any of them. With fallBack, queued experiments are moved along their
+// fallback chains, and the experiments which replace them are watched in
+// their place. With notify, notifications are sent once experiments finish.
+func watchExperiments(experimentIDs []string, interval time.Duration, fallBack, notify bool) error {
+	pending := append([]string{}, experimentIDs...)
+	var failures int
+	delay := time.NewTimer(0) // When to poll experiment status.
+	for len(pending) != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-delay.C:
+			var stillPending []string
+			for _, name := range pending {
+				experiment, err := beaker.Experiment(name).Get(ctx)
+				if err != nil {
+					// Try again on the next check unless the experiment is gone.
+					fmt.Fprintf(os.Stderr, "%s experiment %s: %v\n", color.RedString("Error:"), name, err)
+					if isNotFound(err) {
+						failures++
+					} else {
+						stillPending = append(stillPending, name)
+					}
+					continue
+				}
+
+				var waiting bool
+				if fallBack {
+					moved, queued, err := fallBackIfQueued(experiment)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s experiment %s: %v\n", color.RedString("Error:"), experiment.ID, err)
+						failures++
+						continue
+					}
+					if moved != "" {
+						stillPending = append(stillPending, moved)
+						continue
+					}
+					waiting = queued
+				}
+				if notify {
+					done, err := notifyIfFinished(experiment)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s experiment %s: %v\n", color.RedString("Error:"), experiment.ID, err)
+						failures++
+						continue
+					}
+					waiting = waiting || !done
+				}
+				if waiting {
+					stillPending = append(stillPending, name)
+				}
+			}
+			pending = stillPending
+			delay.Reset(interval)
+		}
+	}
+
+	if failures != 0 {
+		return fmt.Errorf("%d experiment(s) couldn't be watched; see the errors above", failures)
+	}
+	return nil
+}
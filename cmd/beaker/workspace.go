@@ -82,9 +82,10 @@ func newWorkspaceCreateCommand() *cobra.Command {
 
 func newWorkspaceDatasetsCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "datasets <workspace>",
-		Short: "List datasets in a workspace",
-		Args:  cobra.ExactArgs(1),
+		Use:         "datasets [workspace]",
+		Short:       "List datasets in a workspace",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var all bool
@@ -97,11 +98,19 @@ func newWorkspaceDatasetsCommand() *cobra.Command {
 	cmd.Flags().BoolVar(&uncommitted, "uncommitted", false, "Show only uncommitted datasets")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		workspace := beaker.Workspace(args[0])
+		var workspaceRef string
+		if len(args) != 0 {
+			workspaceRef = args[0]
+		}
+		workspaceRef, err := resolveWorkspace(workspaceRef, api.Read)
+		if err != nil {
+			return err
+		}
+		workspace := beaker.Workspace(workspaceRef)
 
 		var datasets []api.Dataset
 		var cursor string
-		for {
+		return printPages(&datasets, func() (interface{}, bool, error) {
 			opts := &client.ListDatasetOptions{
 				Cursor: cursor,
 				Text:   text,
@@ -116,102 +125,123 @@ func newWorkspaceDatasetsCommand() *cobra.Command {
 			var err error
 			page, cursor, err = workspace.Datasets(ctx, opts)
 			if err != nil {
-				return err
-			}
-			datasets = append(datasets, page...)
-			if cursor == "" {
-				break
+				return nil, false, err
 			}
-		}
-		return printDatasets(datasets)
+			return page, cursor != "", nil
+		}, func() error {
+			return printDatasets(datasets)
+		})
 	}
 	return cmd
 }
 
 func newWorkspaceExperimentsCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "experiments <workspace>",
-		Short: "List experiments in a workspace",
-		Args:  cobra.ExactArgs(1),
+		Use:         "experiments [workspace]",
+		Short:       "List experiments in a workspace",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var text string
 	cmd.Flags().StringVar(&text, "text", "", "Only show experiments matching the text")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		workspace := beaker.Workspace(args[0])
+		var workspaceRef string
+		if len(args) != 0 {
+			workspaceRef = args[0]
+		}
+		workspaceRef, err := resolveWorkspace(workspaceRef, api.Read)
+		if err != nil {
+			return err
+		}
+		workspace := beaker.Workspace(workspaceRef)
 
 		var experiments []api.Experiment
 		var cursor string
-		for {
+		return printPages(&experiments, func() (interface{}, bool, error) {
 			var page []api.Experiment
 			var err error
 			if page, cursor, err = workspace.Experiments(ctx, &client.ListExperimentOptions{
 				Cursor: cursor,
 				Text:   text,
 			}); err != nil {
-				return err
-			}
-			experiments = append(experiments, page...)
-			if cursor == "" {
-				break
+				return nil, false, err
 			}
-		}
-		return printExperiments(experiments)
+			return page, cursor != "", nil
+		}, func() error {
+			return printExperiments(experiments)
+		})
 	}
 	return cmd
 }
 
 func newWorkspaceGroupsCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "groups <workspace>",
-		Short: "List groups in a workspace",
-		Args:  cobra.ExactArgs(1),
+		Use:         "groups [workspace]",
+		Short:       "List groups in a workspace",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var text string
 	cmd.Flags().StringVar(&text, "text", "", "Only show groups matching the text")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		workspace := beaker.Workspace(args[0])
+		var workspaceRef string
+		if len(args) != 0 {
+			workspaceRef = args[0]
+		}
+		workspaceRef, err := resolveWorkspace(workspaceRef, api.Read)
+		if err != nil {
+			return err
+		}
+		workspace := beaker.Workspace(workspaceRef)
 
 		var groups []api.Group
 		var cursor string
-		for {
+		return printPages(&groups, func() (interface{}, bool, error) {
 			var page []api.Group
 			var err error
 			if page, cursor, err = workspace.Groups(ctx, &client.ListGroupOptions{
 				Cursor: cursor,
 				Text:   text,
 			}); err != nil {
-				return err
+				return nil, false, err
 			}
-			groups = append(groups, page...)
-			if cursor == "" {
-				break
-			}
-		}
-		return printGroups(groups)
+			return page, cursor != "", nil
+		}, func() error {
+			return printGroups(groups)
+		})
 	}
 	return cmd
 }
 
 func newWorkspaceImagesCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "images <workspace>",
-		Short: "List images in a workspace",
-		Args:  cobra.ExactArgs(1),
+		Use:         "images [workspace]",
+		Short:       "List images in a workspace",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var text string
 	cmd.Flags().StringVar(&text, "text", "", "Only show images matching the text")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		workspace := beaker.Workspace(args[0])
+		var workspaceRef string
+		if len(args) != 0 {
+			workspaceRef = args[0]
+		}
+		workspaceRef, err := resolveWorkspace(workspaceRef, api.Read)
+		if err != nil {
+			return err
+		}
+		workspace := beaker.Workspace(workspaceRef)
 
 		var images []api.Image
 		var cursor string
-		for {
+		return printPages(&images, func() (interface{}, bool, error) {
 			opts := &client.ListImageOptions{
 				Cursor: cursor,
 				Text:   text,
@@ -221,25 +251,34 @@ func newWorkspaceImagesCommand() *cobra.Command {
 			var err error
 			page, cursor, err = workspace.Images(ctx, opts)
 			if err != nil {
-				return err
-			}
-			images = append(images, page...)
-			if cursor == "" {
-				break
+				return nil, false, err
 			}
-		}
-		return printImages(images)
+			return page, cursor != "", nil
+		}, func() error {
+			return printImages(images)
+		})
 	}
 	return cmd
 }
 
 func newWorkspaceGetCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:     "get <workspace...>",
+		Use:     "get [workspace...]",
 		Aliases: []string{"inspect"},
 		Short:   "Display detailed information about one or more workspaces",
-		Args:    cobra.MinimumNArgs(1),
+		Long: `Display detailed information about one or more workspaces.
+
+If no workspace is given, shows the configured default_workspace.`,
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				workspaceRef, err := resolveWorkspace("", api.Read)
+				if err != nil {
+					return err
+				}
+				args = []string{workspaceRef}
+			}
+
 			var workspaces []api.Workspace
 			for _, name := range args {
 				workspace, err := beaker.Workspace(name).Get(ctx)
@@ -256,9 +295,14 @@ func newWorkspaceGetCommand() *cobra.Command {
 
 func newWorkspaceListCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "list <account>",
+		Use:   "list [account]",
 		Short: "List workspaces in an account",
-		Args:  cobra.ExactArgs(1),
+		Long: `List workspaces in an account.
+
+If no account is given, lists workspaces in the configured default_org or,
+if that is unset, the workspaces of the current user.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{streamedFormats: ""},
 	}
 
 	var archived bool
@@ -267,25 +311,37 @@ func newWorkspaceListCommand() *cobra.Command {
 	cmd.Flags().StringVar(&text, "text", "", "Only show workspaces matching the text")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var account string
+		switch {
+		case len(args) != 0:
+			account = args[0]
+		case beakerConfig.DefaultOrg != "":
+			account = beakerConfig.DefaultOrg
+		default:
+			user, err := beaker.WhoAmI(ctx)
+			if err != nil {
+				return err
+			}
+			account = user.Name
+		}
+
 		var workspaces []api.Workspace
 		var cursor string
-		for {
+		return printPages(&workspaces, func() (interface{}, bool, error) {
 			var page []api.Workspace
 			var err error
-			page, cursor, err = beaker.ListWorkspaces(ctx, args[0], &client.ListWorkspaceOptions{
+			page, cursor, err = beaker.ListWorkspaces(ctx, account, &client.ListWorkspaceOptions{
 				Cursor:   cursor,
 				Archived: &archived,
 				Text:     text,
 			})
 			if err != nil {
-				return err
+				return nil, false, err
 			}
-			workspaces = append(workspaces, page...)
-			if cursor == "" {
-				break
-			}
-		}
-		return printWorkspaces(workspaces)
+			return page, cursor != "", nil
+		}, func() error {
+			return printWorkspaces(workspaces)
+		})
 	}
 	return cmd
 }
@@ -12,11 +12,125 @@ import (
 
 // Config is a structured representation of a Beaker config file.
 type Config struct {
-	// Client settings
-	BeakerAddress    string `yaml:"agent_address"` // TODO: Find a better name than "agent_address"
+	// Version of the config schema; see CurrentVersion.
+	Version int `yaml:"version"`
+
+	// Client settings. The address keeps its original key so that older
+	// clients sharing the file still find it.
+	BeakerAddress    string `yaml:"agent_address"`
 	UserToken        string `yaml:"user_token"`
 	DefaultOrg       string `yaml:"default_org"`
 	DefaultWorkspace string `yaml:"default_workspace"`
+
+	// Number of times to retry requests which fail with transient errors.
+	// Stored as a string so it can be managed with "beaker config set".
+	Retries string `yaml:"retries"`
+
+	// Most requests per second to send to Beaker, slowing further when
+	// throttled. Unlimited if empty or zero.
+	MaxRPS string `yaml:"max_rps"`
+
+	// How long responses to reads, such as "30s", are reused without asking
+	// Beaker again. Older responses are revalidated with their ETag or
+	// modification time. Responses aren't cached if empty.
+	CacheTTL string `yaml:"cache_ttl"`
+
+	// Where user tokens are kept: "file" or "keychain". Tokens are kept in the
+	// config file if unset or if the OS credential store is unavailable.
+	CredentialStore string `yaml:"credential_store"`
+
+	// Template for the names of created experiments when --name isn't given,
+	// such as "{{.File}}-{{.Date}}". See "beaker experiment create --help".
+	ExperimentName string `yaml:"experiment_name"`
+
+	// Columns shown in tables by default, keyed by resource such as
+	// "experiments", as comma-separated names. Set with
+	// "beaker config set columns.<resource> <columns>".
+	Columns map[string]string `yaml:"columns,omitempty"`
+
+	// Flag values used when a flag isn't given, keyed by command path such as
+	// "experiment create" and then by flag name. A parent command's defaults
+	// apply to its subcommands, and those under "*" apply to every command.
+	Defaults map[string]map[string]FlagValues `yaml:"defaults,omitempty"`
+
+	// Named connection settings for other Beaker deployments.
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+
+	// Name of the profile used by default, or empty to use the settings above.
+	// Set with "beaker config use-context".
+	CurrentContext string `yaml:"current_context"`
+
+	// Name of the profile in use, if any.
+	context string
+
+	// Descriptions of deprecated settings which were migrated on load.
+	deprecations []string
+}
+
+// Deprecations describes deprecated settings in the config file which were
+// migrated when it was read. Run "beaker config migrate" to update the file.
+func (c *Config) Deprecations() []string {
+	return c.deprecations
+}
+
+// Context returns the name of the profile whose connection settings are in
+// use, or an empty string if none is.
+func (c *Config) Context() string {
+	return c.context
+}
+
+// Profile holds connection settings for a named Beaker deployment.
+type Profile struct {
+	BeakerAddress    string `yaml:"address"`
+	UserToken        string `yaml:"user_token"`
+	DefaultWorkspace string `yaml:"default_workspace,omitempty"`
+}
+
+// FlagValues are the values of a flag. Flags which may be repeated can have
+// several; in the config file, a single value may be written without a list.
+type FlagValues []string
+
+// UnmarshalYAML accepts a single value or a list of values.
+func (v *FlagValues) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = FlagValues{node.Value}
+		return nil
+	}
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*v = values
+	return nil
+}
+
+// MarshalYAML writes a single value without a list.
+func (v FlagValues) MarshalYAML() (interface{}, error) {
+	if len(v) == 1 {
+		return v[0], nil
+	}
+	return []string(v), nil
+}
+
+// Profile returns a copy of the config using the connection settings of a named profile.
+func (c *Config) Profile(name string) (*Config, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, errors.Errorf("profile %q is not configured", name)
+	}
+	if profile.BeakerAddress == "" {
+		return nil, errors.Errorf("profile %q has no address", name)
+	}
+
+	config := *c
+	config.BeakerAddress = profile.BeakerAddress
+	config.UserToken = profile.UserToken
+	if config.UserToken == "" {
+		config.UserToken = c.storedToken(profile.BeakerAddress)
+	}
+	config.DefaultWorkspace = profile.DefaultWorkspace
+	config.context = name
+	return &config, nil
 }
 
 const (
@@ -28,14 +142,23 @@ const (
 	beakerConfigFile    = "config.yml"
 )
 
+// DefaultContext names the connection settings at the top level of the config
+// file, as opposed to those of a profile.
+const DefaultContext = "default"
+
 var beakerConfigDir = filepath.Join(os.Getenv("HOME"), ".beaker")
 
 // New reads environment and configuration files and returns the resulting Beaker configuration.
 func New() (*Config, error) {
+	return NewContext("")
+}
+
+// NewContext is like New, but uses the connection settings of the named
+// profile instead of the current context. An empty name uses the current
+// context, and DefaultContext uses the top-level settings.
+func NewContext(name string) (*Config, error) {
 	// Set up default config before doing anything.
-	config := Config{
-		BeakerAddress: defaultAddress,
-	}
+	config := defaultConfig()
 
 	r, err := findConfig()
 	if err != nil {
@@ -44,15 +167,37 @@ func New() (*Config, error) {
 	if r != nil {
 		defer r.Close()
 
-		if err := yaml.NewDecoder(r).Decode(&config); err != nil {
-			return nil, errors.Wrap(err, "failed to read config")
+		if err := decodeConfig(r, &config); err != nil {
+			return nil, err
+		}
+	}
+	if config.BeakerAddress == "" {
+		// Files may set the address empty, such as after it's unset.
+		config.BeakerAddress = defaultAddress
+	}
+
+	if _, err := config.Credentials(); err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name != "" && name != DefaultContext {
+		profile, err := config.Profile(name)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid context")
 		}
+		config = *profile
 	}
 
 	// Environment variables override config.
 	if env, ok := os.LookupEnv(addressKey); ok {
 		config.BeakerAddress = env
 	}
+	if config.UserToken == "" {
+		config.UserToken = config.storedToken(config.BeakerAddress)
+	}
 	if env, ok := os.LookupEnv(tokenKey); ok {
 		config.UserToken = env
 	}
@@ -60,6 +205,14 @@ func New() (*Config, error) {
 	return &config, nil
 }
 
+// defaultConfig returns the settings used where the config file sets nothing.
+func defaultConfig() Config {
+	return Config{
+		Version:       CurrentVersion,
+		BeakerAddress: defaultAddress,
+	}
+}
+
 func GetFilePath() string {
 	// Check the path override first.
 	if env, ok := os.LookupEnv(configPathKey); ok {
@@ -79,14 +232,35 @@ func ReadConfigFromFile(path string) (*Config, error) {
 	defer r.Close()
 
 	config := Config{}
-	d := yaml.NewDecoder(r)
-	if err := d.Decode(&config); err != nil {
-		return nil, errors.Wrap(err, "failed to read config")
+	if err := decodeConfig(r, &config); err != nil {
+		return nil, err
 	}
-
 	return &config, nil
 }
 
+// decodeConfig reads a config file into config, migrating it to the current
+// version first.
+func decodeConfig(r io.Reader, config *Config) error {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			// The file is empty.
+			return nil
+		}
+		return errors.Wrap(err, "failed to read config")
+	}
+
+	deprecations, err := Migrate(&doc)
+	if err != nil {
+		return err
+	}
+	if err := doc.Decode(config); err != nil {
+		return errors.Wrap(err, "failed to read config")
+	}
+	config.deprecations = deprecations
+	return nil
+}
+
 func WriteConfig(config *Config, filePath string) error {
 	bytes, err := yaml.Marshal(config)
 	if err != nil {
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Values of the credential_store setting.
+const (
+	// Keep tokens in the config file. This is the default.
+	CredentialStoreFile = "file"
+
+	// Keep tokens in the OS credential store: Keychain on macOS or the Secret
+	// Service on Linux.
+	CredentialStoreKeychain = "keychain"
+)
+
+// Name under which tokens are filed in OS credential stores.
+const credentialService = "beaker"
+
+// ErrCredentialNotFound is returned when a credential store has no token for an address.
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// CredentialStore keeps user tokens outside of the config file, keyed by the
+// address of the Beaker deployment they belong to.
+type CredentialStore interface {
+	Get(address string) (string, error)
+	Set(address, token string) error
+	Delete(address string) error
+}
+
+// Credentials returns the credential store selected by the config, or nil if
+// tokens are kept in the config file. The config file is also used if the OS
+// credential store isn't available, such as over SSH without a desktop session.
+func (c *Config) Credentials() (CredentialStore, error) {
+	switch c.CredentialStore {
+	case "", CredentialStoreFile:
+		return nil, nil
+	case CredentialStoreKeychain:
+		return osCredentialStore(), nil
+	default:
+		return nil, errors.Errorf("invalid credential_store %q; must be %q or %q",
+			c.CredentialStore, CredentialStoreFile, CredentialStoreKeychain)
+	}
+}
+
+// storedToken looks up the token for an address in the config's credential
+// store. Failures fall back to the config file, so they're treated as no token.
+func (c *Config) storedToken(address string) string {
+	store, err := c.Credentials()
+	if err != nil || store == nil {
+		return ""
+	}
+	token, err := store.Get(address)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// SaveUserToken saves the user token for a Beaker address. The token is kept
+// in the credential store selected by the config file if it's available, and
+// in the config file otherwise, either at the top level or in the named profile.
+func SaveUserToken(filePath, profile, address, token string) error {
+	config, err := ReadConfigFromFile(filePath)
+	if os.IsNotExist(err) {
+		defaults := defaultConfig()
+		config, err = &defaults, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	store, err := config.Credentials()
+	if err != nil {
+		return err
+	}
+	if store != nil && store.Set(address, token) == nil {
+		// Don't leave a plaintext copy behind.
+		token = ""
+	}
+
+	if profile == "" {
+		if config.UserToken == token {
+			return nil
+		}
+		config.UserToken = token
+		return WriteConfig(config, filePath)
+	}
+
+	p, ok := config.Profiles[profile]
+	if !ok {
+		return errors.Errorf("profile %q is not configured", profile)
+	}
+	if p.UserToken == token {
+		return nil
+	}
+	p.UserToken = token
+	config.Profiles[profile] = p
+	return WriteConfig(config, filePath)
+}
+
+// DeleteUserToken removes the user token for a Beaker address from both the
+// credential store and the config file, at the top level or in the named
+// profile. It returns whether a token was removed.
+func DeleteUserToken(filePath, profile, address string) (bool, error) {
+	config, err := ReadConfigFromFile(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var deleted bool
+	store, err := config.Credentials()
+	if err != nil {
+		return false, err
+	}
+	if store != nil {
+		switch err := store.Delete(address); err {
+		case nil:
+			deleted = true
+		case ErrCredentialNotFound:
+		default:
+			return false, err
+		}
+	}
+
+	if profile == "" {
+		if config.UserToken == "" {
+			return deleted, nil
+		}
+		config.UserToken = ""
+	} else {
+		p, ok := config.Profiles[profile]
+		if !ok || p.UserToken == "" {
+			return deleted, nil
+		}
+		p.UserToken = ""
+		config.Profiles[profile] = p
+	}
+	if err := WriteConfig(config, filePath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
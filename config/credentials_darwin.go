@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keychain stores tokens in the macOS login keychain using the security tool.
+type keychain struct{}
+
+func osCredentialStore() CredentialStore {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil
+	}
+	return keychain{}
+}
+
+// Exit code of the security tool when an item doesn't exist.
+const errSecItemNotFound = 44
+
+func (keychain) Get(address string) (string, error) {
+	out, err := runSecurity("find-generic-password", "-s", credentialService, "-a", address, "-w")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (keychain) Set(address, token string) error {
+	if strings.ContainsAny(token, "\r\n") {
+		return errors.New("keychain: token must not contain line breaks")
+	}
+	// The token is written to the tool's stdin rather than passed as an
+	// argument, where other users could see it in the process list. -U
+	// updates the item if it already exists.
+	return runSecurityInteractive("add-generic-password", "-U", "-s", credentialService, "-a", address, "-w", token)
+}
+
+func (keychain) Delete(address string) error {
+	_, err := runSecurity("delete-generic-password", "-s", credentialService, "-a", address)
+	return err
+}
+
+func runSecurity(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("security", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == errSecItemNotFound {
+			return "", ErrCredentialNotFound
+		}
+		return "", errors.Errorf("keychain: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// runSecurityInteractive runs one command through the security tool's
+// interactive mode, which reads it from stdin. Errors are only reported on
+// stderr in this mode.
+func runSecurityInteractive(args ...string) error {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(strings.Join(quoted, " ") + "\n")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); err != nil || msg != "" {
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.Errorf("keychain: %s", msg)
+	}
+	return nil
+}
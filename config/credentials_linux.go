@@ -0,0 +1,71 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretService stores tokens with the freedesktop Secret Service, such as
+// GNOME Keyring or KWallet, using libsecret's secret-tool.
+type secretService struct{}
+
+func osCredentialStore() CredentialStore {
+	// The Secret Service is reached over the session bus, which headless
+	// machines usually lack.
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil
+	}
+	return secretService{}
+}
+
+func (secretService) Get(address string) (string, error) {
+	out, err := runSecretTool(nil, "lookup", "service", credentialService, "address", address)
+	if err != nil {
+		return "", err
+	}
+	// Lookups of missing items succeed or fail without output, depending on the version.
+	token := strings.TrimSpace(out)
+	if token == "" {
+		return "", ErrCredentialNotFound
+	}
+	return token, nil
+}
+
+func (secretService) Set(address, token string) error {
+	// Pass the token on stdin so that it isn't visible in the process list.
+	_, err := runSecretTool(strings.NewReader(token),
+		"store", "--label=Beaker token for "+address, "service", credentialService, "address", address)
+	return err
+}
+
+func (s secretService) Delete(address string) error {
+	if _, err := s.Get(address); err != nil {
+		return err
+	}
+	_, err := runSecretTool(nil, "clear", "service", credentialService, "address", address)
+	return err
+}
+
+func runSecretTool(stdin *strings.Reader, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() == 0 && stdout.Len() == 0 {
+			return "", ErrCredentialNotFound
+		}
+		return "", errors.Errorf("secret service: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
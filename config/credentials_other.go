@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package config
+
+// osCredentialStore returns nil because this OS has no supported credential store.
+func osCredentialStore() CredentialStore {
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the version of the config schema written by this client.
+// Configs without a version predate versioning and are treated as version 0.
+const CurrentVersion = 1
+
+// migrations[i] upgrades a config document from version i to version i+1 in
+// place. Each returns a description of every change it made.
+var migrations = []func(root *yaml.Node) []string{
+	migrateV0,
+}
+
+// renamedKeys maps deprecated keys to their replacements. Keys read by older
+// clients, such as agent_address, must never be renamed: those clients would
+// silently lose the setting once the file is migrated.
+var renamedKeys = map[string]string{}
+
+// ReplacementKey returns the key which replaces a deprecated key.
+func ReplacementKey(key string) (string, bool) {
+	replacement, ok := renamedKeys[key]
+	return replacement, ok
+}
+
+// migrateV0 renames deprecated keys, including within profiles.
+func migrateV0(root *yaml.Node) []string {
+	changes := renameKeys(root, "")
+	if profiles := MappingValue(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			prefix := "profiles." + profiles.Content[i].Value + "."
+			changes = append(changes, renameKeys(profiles.Content[i+1], prefix)...)
+		}
+	}
+	return changes
+}
+
+func renameKeys(node *yaml.Node, prefix string) []string {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var changes []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		replacement, ok := renamedKeys[key.Value]
+		if !ok {
+			continue
+		}
+		if MappingValue(node, replacement) != nil {
+			// Both keys are set, so the current key wins.
+			changes = append(changes, fmt.Sprintf("removed %s%s, which is overridden by %s%s",
+				prefix, key.Value, prefix, replacement))
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			i -= 2
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("renamed %s%s to %s%s", prefix, key.Value, prefix, replacement))
+		key.Value = replacement
+	}
+	return changes
+}
+
+// Migrate upgrades a parsed config document to CurrentVersion in place,
+// preserving comments and ordering. It returns a description of each deprecated
+// setting which was changed, not including the version itself.
+func Migrate(doc *yaml.Node) ([]string, error) {
+	root := doc
+	if doc.Kind == 0 {
+		// The file is empty.
+		return nil, nil
+	}
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("config must be a mapping")
+	}
+
+	version := 0
+	if node := MappingValue(root, "version"); node != nil {
+		v, err := strconv.Atoi(node.Value)
+		if err != nil {
+			return nil, errors.Errorf("invalid config version %q", node.Value)
+		}
+		version = v
+	}
+	if version > CurrentVersion {
+		return nil, errors.Errorf("config version %d is newer than this client supports; upgrade Beaker", version)
+	}
+
+	var changes []string
+	for ; version < CurrentVersion; version++ {
+		changes = append(changes, migrations[version](root)...)
+	}
+
+	versionNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentVersion)}
+	if node := MappingValue(root, "version"); node != nil {
+		node.Tag, node.Value = versionNode.Tag, versionNode.Value
+	} else {
+		// Put the version first so it's easy to find.
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: "version"}
+		if len(root.Content) != 0 {
+			// Keep any comment at the top of the file at the top.
+			key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{key, versionNode}, root.Content...)
+	}
+	return changes, nil
+}
+
+// MappingValue returns the value for a key in a YAML mapping, or nil if absent.
+func MappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrate(t *testing.T) {
+	// No keys are deprecated yet, so test renames with a stand-in.
+	defer func(keys map[string]string) { renamedKeys = keys }(renamedKeys)
+	renamedKeys = map[string]string{"old_key": "new_key"}
+
+	tests := []struct {
+		name    string
+		in      string
+		out     string
+		changes []string
+		err     string
+	}{
+		{
+			name: "unversioned",
+			in:   "agent_address: https://beaker.org\nuser_token: abc\n",
+			out:  "version: 1\nagent_address: https://beaker.org\nuser_token: abc\n",
+		},
+		{
+			name: "keeps top comment",
+			in:   "# Beaker config\nuser_token: abc\n",
+			out:  "# Beaker config\nversion: 1\nuser_token: abc\n",
+		},
+		{
+			name: "current",
+			in:   "version: 1\nagent_address: https://beaker.org\n",
+			out:  "version: 1\nagent_address: https://beaker.org\n",
+		},
+		{
+			name:    "renamed",
+			in:      "old_key: a\nuser_token: abc\n",
+			out:     "version: 1\nnew_key: a\nuser_token: abc\n",
+			changes: []string{"renamed old_key to new_key"},
+		},
+		{
+			name:    "overridden",
+			in:      "old_key: a\nnew_key: b\n",
+			out:     "version: 1\nnew_key: b\n",
+			changes: []string{"removed old_key, which is overridden by new_key"},
+		},
+		{
+			name:    "renamed in profile",
+			in:      "profiles:\n  staging:\n    old_key: a\n",
+			out:     "version: 1\nprofiles:\n    staging:\n        new_key: a\n",
+			changes: []string{"renamed profiles.staging.old_key to profiles.staging.new_key"},
+		},
+		{
+			name: "current version isn't migrated",
+			in:   "version: 1\nold_key: a\n",
+			out:  "version: 1\nold_key: a\n",
+		},
+		{
+			name: "newer version",
+			in:   "version: 2\n",
+			err:  "newer than this client supports",
+		},
+		{
+			name: "invalid version",
+			in:   "version: one\n",
+			err:  `invalid config version "one"`,
+		},
+		{
+			name: "not a mapping",
+			in:   "- a\n",
+			err:  "config must be a mapping",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(test.in), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := Migrate(&doc)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("expected changes %q, got %q", test.changes, changes)
+			}
+
+			out, err := yaml.Marshal(&doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.out {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.out, out)
+			}
+		})
+	}
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(nil, &doc); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Migrate(&doc)
+	if err != nil || changes != nil {
+		t.Errorf("expected no changes, got %q, %v", changes, err)
+	}
+}
@@ -11,6 +11,8 @@ require (
 	github.com/docker/docker v20.10.7+incompatible
 	github.com/fatih/color v1.12.0
 	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/hanwen/go-fuse/v2 v2.1.0
+	github.com/moby/term v0.0.0-20201216013528-df9cb8a40635
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.2.0
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect